@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wte/internal/config"
+)
+
+// MaskedValue replaces secret values in audit entries
+const MaskedValue = "********"
+
+// Entry represents a single audit log record
+type Entry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Action   string    `json:"action"`
+	Key      string    `json:"key,omitempty"`
+	OldValue string    `json:"old_value,omitempty"`
+	NewValue string    `json:"new_value,omitempty"`
+}
+
+// Record appends an entry to the audit log. Values of secret keys are masked.
+func Record(action, key, oldValue, newValue string) error {
+	if IsSecretKey(key) {
+		oldValue = Mask(oldValue)
+		newValue = Mask(newValue)
+	}
+
+	entry := Entry{
+		Time:     time.Now(),
+		User:     CurrentUser(),
+		Action:   action,
+		Key:      key,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(config.AuditLogFile), 0755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(config.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Read returns the last n entries of the audit log (all entries if n <= 0)
+func Read(n int) ([]Entry, error) {
+	file, err := os.Open(config.AuditLogFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip malformed lines
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	return entries, nil
+}
+
+// IsSecretKey reports whether a configuration key holds a secret
+func IsSecretKey(key string) bool {
+	return strings.Contains(strings.ToLower(key), "password")
+}
+
+// Mask hides a secret value while keeping empty values recognizable
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	return MaskedValue
+}
+
+// CurrentUser returns the effective user behind the command, preferring
+// the invoking user when run through sudo
+func CurrentUser() string {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		return sudoUser
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if envUser := os.Getenv("USER"); envUser != "" {
+		return envUser
+	}
+	return fmt.Sprintf("uid:%d", os.Geteuid())
+}
@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/ui"
+)
+
+var aclDeny bool
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Manage per-service IP allow and deny lists",
+	Long: `Restrict which client IPs may use a proxy service.
+
+Each service (http, https, shadowsocks) has an allow-list and a deny-list of
+IP addresses and CIDR ranges. With an allow-list only the listed clients are
+accepted; a client on the deny-list is always rejected, even when it is also
+allowed. The lists apply on top of security.allow, so a client must pass
+both. Changes are applied right away.
+
+GOST enforces the lists. With firewall.acl set the firewall also accepts a
+service's port only from its allow-list, and the rules are updated when the
+allow-list changes and firewall.auto_configure is set.
+
+Examples:
+  wte acl add http 203.0.113.0/24           # Only accept clients from 203.0.113.0/24
+  wte acl add shadowsocks 198.51.100.7 --deny
+  wte acl remove http 203.0.113.0/24
+  wte acl list`,
+}
+
+var aclListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the allow and deny lists of every service",
+	Args:  cobra.NoArgs,
+	RunE:  runACLList,
+}
+
+var aclAddCmd = &cobra.Command{
+	Use:               "add <service> <ip/cidr>[,<ip/cidr>...]",
+	Short:             "Add addresses to a service's allow-list (or deny-list with --deny)",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeACLServices,
+	RunE:              runACLAdd,
+}
+
+var aclRemoveCmd = &cobra.Command{
+	Use:               "remove <service> <ip/cidr>[,<ip/cidr>...]",
+	Short:             "Remove addresses from a service's allow-list (or deny-list with --deny)",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeACLServices,
+	RunE:              runACLRemove,
+}
+
+func init() {
+	aclAddCmd.Flags().BoolVar(&aclDeny, "deny", false, "Change the deny-list instead of the allow-list")
+	aclRemoveCmd.Flags().BoolVar(&aclDeny, "deny", false, "Change the deny-list instead of the allow-list")
+
+	aclCmd.AddCommand(aclListCmd)
+	aclCmd.AddCommand(aclAddCmd)
+	aclCmd.AddCommand(aclRemoveCmd)
+	rootCmd.AddCommand(aclCmd)
+}
+
+func runACLList(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	if ui.JSON {
+		acls := make(map[string]config.ACLConfig)
+		for _, service := range config.ACLServices {
+			acl, _ := cfg.ServiceACL(service)
+			if acl.Allow == nil {
+				acl.Allow = []string{}
+			}
+			if acl.Deny == nil {
+				acl.Deny = []string{}
+			}
+			acls[service] = acl
+		}
+		return ui.PrintJSON(acls)
+	}
+
+	fmt.Printf("%-12s %-6s %s\n", "SERVICE", "LIST", "ADDRESSES")
+	for _, service := range config.ACLServices {
+		acl, _ := cfg.ServiceACL(service)
+		fmt.Printf("%-12s %-6s %s\n", service, "allow", aclAddresses(acl.Allow, "everyone"))
+		fmt.Printf("%-12s %-6s %s\n", service, "deny", aclAddresses(acl.Deny, "-"))
+	}
+
+	return nil
+}
+
+func runACLAdd(cmd *cobra.Command, args []string) error {
+	return updateACL("acl add", args[0], args[1], func(current, items []string) []string {
+		for _, item := range items {
+			if !containsString(current, item) {
+				current = append(current, item)
+			}
+		}
+		return current
+	})
+}
+
+func runACLRemove(cmd *cobra.Command, args []string) error {
+	return updateACL("acl remove", args[0], args[1], func(current, items []string) []string {
+		kept := []string{}
+		for _, entry := range current {
+			if !containsString(items, entry) {
+				kept = append(kept, entry)
+			}
+		}
+		return kept
+	})
+}
+
+// updateACL replaces the allow- or deny-list of service with the result of
+// change, saves and applies the configuration and syncs the firewall
+func updateACL(action, service, value string, change func(current, items []string) []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	acl, ok := config.Get().ServiceACL(service)
+	if !ok {
+		return fmt.Errorf("unknown service %q, use one of: %s", service, strings.Join(config.ACLServices, ", "))
+	}
+
+	list, current := "allow", acl.Allow
+	if aclDeny {
+		list, current = "deny", acl.Deny
+	}
+	key := service + ".acl." + list
+
+	items, err := config.ParseList(key, value)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no addresses given")
+	}
+
+	updated := change(append([]string{}, current...), items)
+	if len(updated) == len(current) {
+		ui.Info("%s is unchanged", key)
+		return nil
+	}
+
+	rules := firewallRules(config.Get())
+
+	candidate, err := config.WithValue(key, updated)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	if err := gost.NewConfigGenerator(candidate).Validate(); err != nil {
+		return err
+	}
+	if err := config.Set(key, updated); err != nil {
+		return fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	recordAudit(action, key, current, updated)
+
+	ui.Success("%s: %s", key, aclAddresses(updated, "everyone"))
+
+	if err := applyConfig(); err != nil {
+		return err
+	}
+
+	syncFirewallPorts(diffPorts(rules, firewallRules(config.Get())))
+
+	return nil
+}
+
+// aclAddresses formats an ACL list, or returns empty for an empty list
+func aclAddresses(addresses []string, empty string) string {
+	if len(addresses) == 0 {
+		return empty
+	}
+	return strings.Join(addresses, ", ")
+}
+
+// completeACLServices completes the services that have an ACL
+func completeACLServices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return config.ACLServices, cobra.ShellCompDirectiveNoFileComp
+}
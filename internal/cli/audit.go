@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/audit"
+	"wte/internal/config"
+	"wte/internal/ui"
+)
+
+var auditLines int
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show configuration change history",
+	Long: `Show the audit log of configuration changes.
+
+Every mutating command (config set, config reset, credentials --regenerate,
+install, uninstall) records who changed what and when. Secret values are
+masked in the log.
+
+Examples:
+  wte audit              # Show last 50 entries
+  wte audit -n 200       # Show last 200 entries
+  wte audit -n 0         # Show all entries`,
+	RunE: runAudit,
+}
+
+func init() {
+	auditCmd.Flags().IntVarP(&auditLines, "lines", "n", 50, "Number of entries to show (0 for all)")
+
+	rootCmd.AddCommand(auditCmd)
+}
+
+func runAudit(cmd *cobra.Command, args []string) error {
+	entries, err := audit.Read(auditLines)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		ui.Info("No audit entries found in %s", config.AuditLogFile)
+		return nil
+	}
+
+	table := ui.NewTable([]string{"Time", "User", "Action", "Key", "Old", "New"})
+	for _, entry := range entries {
+		table.Append([]string{
+			entry.Time.Format("2006-01-02 15:04:05"),
+			entry.User,
+			entry.Action,
+			entry.Key,
+			entry.OldValue,
+			entry.NewValue,
+		})
+	}
+	table.Render()
+
+	return nil
+}
+
+// recordAudit writes an audit entry, warning instead of failing on error
+func recordAudit(action, key string, oldValue, newValue interface{}) {
+	if err := audit.Record(action, key, auditValue(oldValue), auditValue(newValue)); err != nil {
+		ui.Warning("Could not write audit log: %v", err)
+	}
+}
+
+// auditValue converts a configuration value to its audit representation
+func auditValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
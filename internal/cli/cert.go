@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/security"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// certRenewDays is how close to expiry a certificate must be before
+// 'wte cert renew' replaces it without --force
+const certRenewDays = 30
+
+var certRenewForce bool
+
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage the HTTPS proxy certificate",
+	Long: `Manage the TLS certificate used by the HTTPS proxy.
+
+Examples:
+  wte cert renew           # Renew if the certificate expires within 30 days
+  wte cert renew --force   # Renew now`,
+}
+
+var certRenewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Renew the HTTPS certificate and restart the service",
+	Long: `Renew the HTTPS proxy certificate and restart the service.
+
+Certificates obtained from Let's Encrypt (https.acme with https.domain) are
+issued again. Self-signed certificates are regenerated with the same names
+and IP addresses as the current one.
+
+The certificate is only renewed when it expires within 30 days, unless
+--force is passed.`,
+	RunE: runCertRenew,
+}
+
+func init() {
+	certRenewCmd.Flags().BoolVar(&certRenewForce, "force", false, "Renew even if the certificate is not close to expiry")
+
+	certCmd.AddCommand(certRenewCmd)
+	rootCmd.AddCommand(certCmd)
+}
+
+func runCertRenew(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	cfg := config.Get()
+
+	if !cfg.HTTPS.Enabled {
+		return fmt.Errorf("HTTPS is not enabled")
+	}
+
+	info, err := security.GetCertificateInfo(cfg.HTTPS.CertPath)
+	switch {
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return err
+	case err != nil:
+		ui.Warning("No certificate found at %s, creating one", cfg.HTTPS.CertPath)
+	case info.DaysLeft > certRenewDays && !certRenewForce:
+		ui.Info("Certificate is valid until %s (%d days left)", info.NotAfter.Format("2006-01-02"), info.DaysLeft)
+		ui.Detail("Use --force to renew it anyway")
+		return nil
+	}
+
+	if cfg.HTTPS.Domain != "" && cfg.HTTPS.ACME {
+		ui.Action("Requesting certificate for %s from Let's Encrypt...", cfg.HTTPS.Domain)
+		if err := issueACMECertificate(cfg); err != nil {
+			return fmt.Errorf("failed to obtain certificate: %w", err)
+		}
+	} else {
+		ui.Action("Generating self-signed certificate...")
+
+		var certOpts *security.CertificateOptions
+		if info != nil {
+			// Keep the names clients already connect with
+			certOpts = security.DefaultCertificateOptions(info.Subject)
+			certOpts.IPAddresses = info.IPAddresses
+			certOpts.DNSNames = info.DNSNames
+		} else {
+			publicIP, err := system.GetPublicIP()
+			if err != nil {
+				return fmt.Errorf("failed to detect public IP: %w", err)
+			}
+			certOpts = security.DefaultCertificateOptions(publicIP)
+		}
+		certOpts.CertPath = cfg.HTTPS.CertPath
+		certOpts.KeyPath = cfg.HTTPS.KeyPath
+
+		if err := security.GenerateSelfSignedCert(certOpts); err != nil {
+			return fmt.Errorf("failed to generate certificate: %w", err)
+		}
+	}
+
+	renewed, err := security.GetCertificateInfo(cfg.HTTPS.CertPath)
+	if err != nil {
+		return err
+	}
+
+	ui.Success("Certificate renewed, valid until %s", renewed.NotAfter.Format("2006-01-02"))
+
+	service := system.NewServiceManager()
+	if !service.IsInstalled() {
+		return nil
+	}
+
+	// The service user must be able to read the new files
+	if err := gost.PrepareServiceUser(cfg); err != nil {
+		return err
+	}
+
+	ui.Action("Restarting service...")
+	if err := service.Restart(); err != nil {
+		return fmt.Errorf("failed to restart service: %w", err)
+	}
+
+	ui.Success("Service restarted")
+	return nil
+}
+
+// issueACMECertificate obtains the HTTPS certificate via ACME, opening port
+// 80 for the HTTP-01 challenge while it runs. A port 80 rule that existed
+// before is left alone.
+func issueACMECertificate(cfg *config.Config) error {
+	firewall := system.NewFirewallManager()
+	if cfg.Firewall.AutoConfigure && !firewall.IsPortAllowed(80, "tcp") {
+		if err := firewall.OpenPort(80, "tcp"); err != nil {
+			ui.Warning("Could not open port 80 for the ACME challenge: %v", err)
+		} else {
+			defer func() {
+				if err := firewall.ClosePort(80, "tcp"); err != nil {
+					ui.Warning("Could not close port 80: %v", err)
+				}
+			}()
+		}
+	}
+
+	opts := security.DefaultACMEOptions(cfg.HTTPS.Domain)
+	opts.DirectoryURL = cfg.HTTPS.ACMEDirectory
+	opts.AccountKeyPath = config.ACMEAccountKeyFile
+	opts.CertPath = cfg.HTTPS.CertPath
+	opts.KeyPath = cfg.HTTPS.KeyPath
+
+	return security.ObtainACMECertificate(opts)
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/logging"
+	"wte/internal/security"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish|powershell]",
+	Short: "Generate a shell completion script",
+	Long: `Print a completion script for bash, zsh, fish or PowerShell.
+
+The script completes commands, aliases such as 'creds', flags, config keys
+for 'wte config set' and their values, and Shadowsocks methods.
+
+Examples:
+  # Bash, for all users (needs the bash-completion package)
+  wte completion bash | sudo tee /etc/bash_completion.d/wte > /dev/null
+
+  # Zsh
+  wte completion zsh > "${fpath[1]}/_wte"
+
+  # Fish
+  wte completion fish > ~/.config/fish/completions/wte.fish
+
+  # PowerShell
+  wte completion powershell | Out-String | Invoke-Expression`,
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		}
+		return fmt.Errorf("unsupported shell %q", args[0])
+	},
+}
+
+func init() {
+	// Replace Cobra's default command with the one above
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(completionCmd)
+}
+
+// completeWords completes a flag value from a fixed list
+func completeWords(words []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return words, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeConfigSet completes the key of 'config set', then the values
+// the key takes where they are a fixed set
+func completeConfigSet(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return completionKeys(), cobra.ShellCompDirectiveNoFileComp
+	case 1:
+		key := args[0]
+		switch {
+		case key == "shadowsocks.method":
+			return config.ShadowsocksMethods, cobra.ShellCompDirectiveNoFileComp
+		case key == "security.password_charset":
+			return security.PasswordCharsets, cobra.ShellCompDirectiveNoFileComp
+		case key == "logging.level":
+			return logging.Levels, cobra.ShellCompDirectiveNoFileComp
+		case key == "gost.version":
+			return []string{config.GOSTVersionLatest}, cobra.ShellCompDirectiveNoFileComp
+		case strings.HasSuffix(key, ".protocol"):
+			return []string{"tcp", "udp"}, cobra.ShellCompDirectiveNoFileComp
+		}
+		if value, ok := config.GetValue(key).(bool); ok {
+			return []string{strconv.FormatBool(!value), strconv.FormatBool(value)}, cobra.ShellCompDirectiveNoFileComp
+		}
+		// Paths like logging.file complete from the file system
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionKeys returns the config keys, with the indices of the
+// configured relays in place of <n>
+func completionKeys() []string {
+	var keys []string
+	for _, key := range config.Keys() {
+		if !strings.Contains(key, "<n>") {
+			keys = append(keys, key)
+			continue
+		}
+		for i := range config.Get().Relay.Entries {
+			keys = append(keys, strings.Replace(key, "<n>", strconv.Itoa(i), 1))
+		}
+	}
+	return keys
+}
+
+// completeListKeys completes the keys 'config list-add' and 'list-remove' take
+func completeListKeys(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var keys []string
+	for key := range config.ListKeys {
+		keys = append(keys, key)
+	}
+	return keys, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRelayPorts completes the listen ports of the configured relays
+func completeRelayPorts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var ports []string
+	for _, relay := range config.Get().Relay.Entries {
+		ports = append(ports, fmt.Sprintf("%d\t%s", relay.ListenPort, relay))
+	}
+	return ports, cobra.ShellCompDirectiveNoFileComp
+}
@@ -1,20 +1,36 @@
 package cli
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 
 	"wte/internal/config"
 	"wte/internal/gost"
+	"wte/internal/logging"
+	"wte/internal/security"
 	"wte/internal/system"
 	"wte/internal/ui"
 )
 
+var (
+	configSetYes       bool
+	configSetForce     bool
+	configSetFromFile  string
+	configApplyTimeout time.Duration
+	configApplyRestart bool
+)
+
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Manage configuration",
@@ -24,7 +40,11 @@ Subcommands:
   show     Show current configuration
   edit     Open configuration in editor
   set      Set a configuration value
+  list-add     Add a value to a list key
+  list-remove  Remove a value from a list key
   reset    Reset configuration to defaults
+  diff     Show what 'config apply' would change
+  migrate  Upgrade an old configuration file
 
 Examples:
   wte config show
@@ -33,13 +53,35 @@ Examples:
   wte config set http.auth.enabled false`,
 }
 
+var (
+	configShowRedact  bool
+	configShowSecrets bool
+)
+
 var configShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show current configuration",
+	Long: `Show the current WTE configuration.
+
+Passwords are replaced by ******** when the output is not a terminal, such
+as when it is piped or redirected to a file, and when not run as root, so
+the configuration can be pasted into an issue safely. --redact hides them
+on a root terminal too, --show-secrets shows them anyway.
+
+Examples:
+  sudo wte config show
+  sudo wte config show --redact
+  sudo wte config show --show-secrets > config-backup.yaml`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg := config.Get()
 
-		ui.Header("Current Configuration")
+		redact := configShowRedact || !system.IsRoot() || !ui.IsTerminal()
+		if configShowSecrets {
+			redact = false
+		}
+		if redact {
+			cfg = cfg.Redacted()
+		}
 
 		// Display as YAML
 		data, err := yaml.Marshal(cfg)
@@ -47,15 +89,31 @@ var configShowCmd = &cobra.Command{
 			return fmt.Errorf("failed to marshal config: %w", err)
 		}
 
+		if ui.JSON {
+			// Go through the YAML form so the JSON keys match the config file
+			var doc map[string]interface{}
+			if err := yaml.Unmarshal(data, &doc); err != nil {
+				return fmt.Errorf("failed to convert config: %w", err)
+			}
+			return ui.PrintJSON(doc)
+		}
+
+		ui.Header("Current Configuration")
+
 		fmt.Println(string(data))
 
 		ui.Println()
 		ui.Detail("Config file: %s", config.GetConfigPath())
+		if redact {
+			ui.Detail("Passwords are hidden, use --show-secrets to show them")
+		}
 
 		return nil
 	},
 }
 
+var configEditApply bool
+
 var configEditCmd = &cobra.Command{
 	Use:   "edit",
 	Short: "Open configuration in editor",
@@ -66,8 +124,14 @@ The editor is determined by:
 2. $VISUAL environment variable
 3. Fallback to 'nano' or 'vi'
 
-After saving, you should restart the service:
-  wte restart`,
+After the editor exits, the file is checked. If it does not parse or the
+GOST configuration generated from it is invalid, the error is shown and the
+editor can be reopened to fix it. A valid change is applied as with
+'wte config apply' when confirmed, or right away with --apply.
+
+Examples:
+  sudo wte config edit
+  sudo wte config edit --apply`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := checkRoot(); err != nil {
 			return err
@@ -104,90 +168,652 @@ After saving, you should restart the service:
 			return fmt.Errorf("no editor found. Set $EDITOR environment variable")
 		}
 
-		ui.Info("Opening %s with %s...", configPath, editor)
+		before, _ := os.ReadFile(configPath)
+
+		// Reopen the editor until the file is valid, a broken config would
+		// leave the service crash-looping on the next apply
+		for {
+			ui.Info("Opening %s with %s...", configPath, editor)
 
-		editCmd := exec.Command(editor, configPath)
-		editCmd.Stdin = os.Stdin
-		editCmd.Stdout = os.Stdout
-		editCmd.Stderr = os.Stderr
+			editCmd := exec.Command(editor, configPath)
+			editCmd.Stdin = os.Stdin
+			editCmd.Stdout = os.Stdout
+			editCmd.Stderr = os.Stderr
+
+			if err := editCmd.Run(); err != nil {
+				return fmt.Errorf("editor exited with error: %w", err)
+			}
 
-		if err := editCmd.Run(); err != nil {
-			return fmt.Errorf("editor exited with error: %w", err)
+			err := validateConfigFile(configPath)
+			if err == nil {
+				break
+			}
+
+			ui.Println()
+			ui.Error("Invalid configuration: %v", err)
+			if !ui.Confirm("Open the editor again?") {
+				ui.Detail("Fix %s before running 'wte config apply'", configPath)
+				return fmt.Errorf("%s was saved with an invalid configuration", configPath)
+			}
 		}
 
 		ui.Println()
+		if after, err := os.ReadFile(configPath); err == nil && bytes.Equal(before, after) {
+			ui.Info("No changes made")
+			return nil
+		}
 		ui.Success("Configuration saved")
-		ui.Info("Run 'wte restart' to apply changes")
 
-		return nil
+		if !configEditApply && !ui.Confirm("Apply the changes now?") {
+			ui.Info("Run 'wte config apply' to apply changes")
+			return nil
+		}
+
+		if err := config.Reload(); err != nil {
+			return fmt.Errorf("failed to reload configuration: %w", err)
+		}
+		return runConfigApply(config.Get(), gost.ApplyOptions{Timeout: gost.DefaultApplyTimeout})
 	},
 }
 
+// validateConfigFile loads the WTE config at path and checks the GOST
+// configuration generated from it
+func validateConfigFile(path string) error {
+	edited, err := config.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	return gost.NewConfigGenerator(edited).Validate()
+}
+
 var configSetCmd = &cobra.Command{
 	Use:   "set <key> <value>",
 	Short: "Set a configuration value",
 	Long: `Set a configuration value.
 
 Available keys:
+  server.name           Server name shown in credentials and client exports
+
   http.enabled          Enable/disable HTTP proxy (true/false)
   http.port             HTTP proxy port
+  http.bind_address     Address the HTTP proxy listens on (empty for all
+                        interfaces); https., shadowsocks. and relay.
+                        likewise
   http.auth.enabled     Enable/disable HTTP authentication (true/false)
   http.auth.username    HTTP proxy username
   http.auth.password    HTTP proxy password
 
   https.enabled         Enable/disable HTTPS proxy (true/false)
   https.port            HTTPS proxy port
+  https.domain          Domain name of the HTTPS proxy
+  https.acme            Use a Let's Encrypt certificate for https.domain
 
   shadowsocks.enabled   Enable/disable Shadowsocks (true/false)
   shadowsocks.port      Shadowsocks port
-  shadowsocks.method    Shadowsocks encryption method
+  shadowsocks.method    Shadowsocks encryption method: aes-128-gcm,
+                        aes-256-gcm, chacha20-ietf-poly1305,
+                        2022-blake3-aes-256-gcm, ... (2022 methods need
+                        a matching base64 key as password)
   shadowsocks.password  Shadowsocks password
+  shadowsocks.udp       Relay UDP on the Shadowsocks port (true/false)
+  shadowsocks.ports     Extra Shadowsocks ports (comma-separated, empty
+                        to clear), see 'wte ss'
+  shadowsocks.plugin.name  Obfuscation plugin clients use: v2ray-plugin,
+                           obfs-local (empty for none)
+  shadowsocks.plugin.opts  Client plugin options, e.g. obfs=tls or
+                           path=/ws;host=example.com
+
+  http.limiter.in       Bandwidth limit for client uploads, e.g. 10mbps
+  http.limiter.out      Bandwidth limit for client downloads, e.g. 10mbps
+  http.limiter.max_connections  Maximum concurrent connections (0 for
+                        no limit)
+                        The https. and shadowsocks. keys work the same.
+                        Rates take bps, kbps, mbps, gbps (bits/s) or B,
+                        KB, MB, GB (bytes/s); empty means no limit.
+
+  relay.entries.<n>.remote_host  Target of the relay at index n of
+                        'wte relay list' (from 0); listen_port,
+                        remote_port and protocol likewise
+
+  http.acl.allow        Only accept these IPs/CIDRs on the HTTP proxy
+                        (comma-separated, empty to clear), see 'wte acl'
+  http.acl.deny         Reject these IPs/CIDRs, even when allowed
+                        The https. and shadowsocks. keys work the same.
 
   firewall.auto_configure  Auto-configure firewall (true/false)
+  firewall.acl          Only open a service's port to its ACL allow-list
+                        (true/false)
+
+  security.allow        Only accept clients from these IPs/CIDRs
+                        (comma-separated, empty to clear)
+  security.allow_open_proxy  Allow unauthenticated public HTTP/HTTPS (true/false)
+  security.password_charset  Charset of generated passwords (alphanumeric,
+                             symbols)
+
+  gost.version          GOST version ('latest' for newest release),
+                        offers to install the new binary
+  gost.run_as_user      User the service runs as ('root' to run as root)
+  gost.memory_max       Memory limit for the service, e.g. 256M (systemd,
+                        empty for no limit)
+  gost.cpu_quota        CPU limit for the service, e.g. 50% (systemd,
+                        empty for no limit)
+  gost.download_mirror  Download GOST from this URL instead of GitHub,
+                        laid out as <mirror>/v<version>/<file>
+  gost.extra_services   YAML list of GOST services added after WTE's own
+                        (empty to clear)
+  gost.raw_append       File appended to the GOST configuration, for
+                        top-level sections such as chains (empty to clear)
+
+  logging.file          Append a structured log of actions to this file
+                        (empty to disable)
+  logging.level         Log file level: debug, info, warn, error
+
+  watchdog.enabled      Restart the service when its ports stop accepting
+                        connections (true/false), see 'wte watchdog'
+  watchdog.interval     Time between watchdog checks, e.g. 1m
+  watchdog.failures     Consecutive failed checks before a restart
+  watchdog.cooldown     Minimum time between watchdog restarts, e.g. 10m
+
+  credentials.path      Credentials file (default /root/proxy-credentials.txt,
+                        ~ is the home directory)
+  credentials.store     How the credentials file is saved: plaintext or
+                        encrypted (with a passphrase, needs gpg)
+
+  debug.pprof.enabled       Enable/disable GOST profiling (true/false)
+  debug.pprof.port          Profiling port (default 6060)
+  debug.pprof.bind_address  Profiling address (default 127.0.0.1)
+
+Any key of the configuration file can be set, nested keys are joined with
+dots. Switches take true/false (or yes/no, 1/0), ports and counts take
+numbers. Unknown keys are refused with a list of the valid ones.
+
+Ports must be between 1 and 65535. Ports below 1024 and ports already in
+use by another process are accepted with a warning.
+
+Risky changes (disabling authentication, changing the port of an enabled
+service, exposing pprof to the network) ask for confirmation unless --yes
+is given. Disabling every service is not allowed.
+
+Disabling authentication on a publicly bound HTTP/HTTPS proxy without
+security.allow creates an open proxy. This is refused unless --yes is
+given and the warning is acknowledged.
+
+With --from-file, key=value pairs are read from a file ("-" for stdin), one
+per line; blank lines and lines starting with # are skipped, and values may
+be quoted. Every value is checked first and the configuration is saved
+once: if any pair is invalid nothing is changed. Confirmations read from
+the terminal, so pass --yes when the pairs come from stdin.
+
+Passwords need at least 8 characters with a lowercase letter, an uppercase
+letter and a digit. Weaker passwords are refused unless --force is given.
+Shadowsocks 2022 keys are checked against the method instead.
 
 Examples:
   wte config set http.port 3128
   wte config set http.auth.enabled false
-  wte config set shadowsocks.enabled true`,
-	Args: cobra.ExactArgs(2),
+  wte config set shadowsocks.enabled true
+  wte config set gost.memory_max 256M
+  wte config set http.limiter.in 10mbps
+  wte config set http.bind_address 10.0.0.5
+  wte config set relay.entries.0.remote_port 8080
+  wte config set http.auth.enabled false --yes
+  wte config set --from-file keys.env
+  printf 'http.port=3128\nhttp.auth.enabled=false\n' | wte config set --from-file - --yes`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if configSetFromFile != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
+	ValidArgsFunction: completeConfigSet,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := checkRoot(); err != nil {
 			return err
 		}
 
+		if configSetFromFile != "" {
+			return runConfigSetBatch(configSetFromFile)
+		}
+
 		key := args[0]
-		value := args[1]
+		parsedValue, err := parseConfigSetValue(key, args[1])
+		if err != nil {
+			return err
+		}
 
-		// Parse value based on key
-		var parsedValue interface{}
-		switch {
-		case strings.HasSuffix(key, ".enabled"):
-			parsedValue = value == "true" || value == "1" || value == "yes"
-		case strings.HasSuffix(key, ".port"):
-			var port int
-			if _, err := fmt.Sscanf(value, "%d", &port); err != nil {
-				return fmt.Errorf("invalid port number: %s", value)
+		return setConfigValue(key, parsedValue)
+	},
+}
+
+// parseConfigSetValue converts value to the type of key and checks it
+func parseConfigSetValue(key, value string) (interface{}, error) {
+	// Convert the value to the type of the key, then check it
+	parsedValue, err := config.ParseValue(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(key, ".port"), strings.HasSuffix(key, ".listen_port"):
+		port := parsedValue.(int)
+		if err := config.ValidatePort(key, port); err != nil {
+			return nil, err
+		}
+		warnPort(key, port)
+	case strings.HasSuffix(key, ".bind_address"):
+		if err := system.CheckBindAddress(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	case key == "security.password_charset":
+		if err := security.ValidatePasswordCharset(value); err != nil {
+			return nil, err
+		}
+	case key == "gost.memory_max":
+		if err := config.ValidateMemoryMax(value); err != nil {
+			return nil, err
+		}
+	case key == "gost.cpu_quota":
+		if err := config.ValidateCPUQuota(value); err != nil {
+			return nil, err
+		}
+	case strings.HasSuffix(key, ".limiter.in"), strings.HasSuffix(key, ".limiter.out"):
+		if value != "" {
+			if _, err := config.ParseRate(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
 			}
-			parsedValue = port
-		default:
-			parsedValue = value
 		}
+	case strings.HasSuffix(key, ".limiter.max_connections"):
+		if parsedValue.(int) < 0 {
+			return nil, fmt.Errorf("invalid %s: %q is not a number of connections", key, value)
+		}
+	case key == "http.auth.password", key == "https.auth.password", key == "shadowsocks.password":
+		if key != "shadowsocks.password" || !security.IsSS2022Method(config.Get().Shadowsocks.Method) {
+			if err := checkPasswordStrength(key, value); err != nil {
+				return nil, err
+			}
+		}
+	case key == "logging.level":
+		if _, err := logging.ParseLevel(value); err != nil {
+			return nil, err
+		}
+	case key == "credentials.store":
+		if err := gost.CheckCredentialsStore(value); err != nil {
+			return nil, err
+		}
+	case key == "server.name":
+		if err := config.ValidateServerName(value); err != nil {
+			return nil, err
+		}
+	case key == "credentials.path":
+		if err := config.ValidateCredentialsPath(value); err != nil {
+			return nil, err
+		}
+	case key == "gost.download_mirror":
+		if err := config.ValidateDownloadMirror(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
 
-		if err := config.Set(key, parsedValue); err != nil {
-			return fmt.Errorf("failed to set configuration: %w", err)
+	// Record a concrete version rather than "latest"
+	if key == "gost.version" && value == config.GOSTVersionLatest {
+		ui.Action("Resolving latest GOST version...")
+		version, err := gost.NewInstaller(config.Get(), nil).GetLatestVersion()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve latest GOST version: %w", err)
 		}
+		parsedValue = version
+	}
 
-		if err := config.Save(); err != nil {
-			return fmt.Errorf("failed to save configuration: %w", err)
+	return parsedValue, nil
+}
+
+// runConfigSetBatch sets the key=value pairs of path, or of stdin for "-",
+// in one change
+func runConfigSetBatch(path string) error {
+	input := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
 		}
+		defer file.Close()
+		input = file
+	}
 
-		ui.Success("Configuration updated: %s = %v", key, parsedValue)
-		ui.Info("Run 'wte restart' to apply changes")
+	changes, err := readConfigChanges(input)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("no key=value pairs found")
+	}
+
+	return setConfigValues(changes)
+}
+
+// readConfigChanges parses and checks key=value lines, skipping blank lines
+// and comments. All invalid lines are reported together.
+func readConfigChanges(r io.Reader) ([]config.Change, error) {
+	var changes []config.Change
+	var problems []string
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			problems = append(problems, fmt.Sprintf("line %d: expected key=value", line))
+			continue
+		}
+		if previous, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("line %d: %s is already set on line %d", line, key, previous))
+			continue
+		}
+		seen[key] = line
+
+		parsedValue, err := parseConfigSetValue(key, unquote(strings.TrimSpace(value)))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		changes = append(changes, config.Change{Key: key, Value: parsedValue})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key=value pairs: %w", err)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("nothing was changed, fix these lines:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return changes, nil
+}
+
+// unquote strips matching single or double quotes around a value
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
 
+// checkPasswordStrength warns about a weak password and refuses it unless
+// --force is given
+func checkPasswordStrength(key, password string) error {
+	strong, failed := security.IsStrongPassword(password)
+	if strong {
 		return nil
+	}
+
+	ui.Warning("Weak password for %s: %s", key, strings.Join(failed, ", "))
+	if !configSetForce {
+		return fmt.Errorf("refusing weak password for %s, use --force to set it anyway", key)
+	}
+	return nil
+}
+
+var configListAddCmd = &cobra.Command{
+	Use:   "list-add <key> <value>",
+	Short: "Add a value to a list configuration key",
+	Long: `Add one or more comma-separated values to a list configuration key.
+
+Values already in the list are skipped.
+
+Examples:
+  wte config list-add security.allow 203.0.113.0/24
+  wte config list-add security.allow 198.51.100.7,198.51.100.8`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeListKeys,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkRoot(); err != nil {
+			return err
+		}
+
+		key := args[0]
+
+		items, err := config.ParseList(key, args[1])
+		if err != nil {
+			return err
+		}
+
+		list := config.GetStringSlice(key)
+		for _, item := range items {
+			if containsString(list, item) {
+				ui.Info("%s already contains %s", key, item)
+				continue
+			}
+			list = append(list, item)
+		}
+
+		return setConfigValue(key, list)
+	},
+}
+
+var configListRemoveCmd = &cobra.Command{
+	Use:   "list-remove <key> <value>",
+	Short: "Remove a value from a list configuration key",
+	Long: `Remove one or more comma-separated values from a list configuration key.
+
+Examples:
+  wte config list-remove security.allow 203.0.113.0/24`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeListKeys,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkRoot(); err != nil {
+			return err
+		}
+
+		key := args[0]
+		if !config.IsListKey(key) {
+			return fmt.Errorf("%s is not a list", key)
+		}
+
+		var remove []string
+		for _, item := range strings.Split(args[1], ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				remove = append(remove, item)
+			}
+		}
+
+		list := []string{}
+		for _, item := range config.GetStringSlice(key) {
+			if !containsString(remove, item) {
+				list = append(list, item)
+			}
+		}
+
+		for _, item := range remove {
+			if !containsString(config.GetStringSlice(key), item) {
+				ui.Info("%s does not contain %s", key, item)
+			}
+		}
+
+		return setConfigValue(key, list)
 	},
 }
 
+// setConfigValue validates a change against a copy of the configuration,
+// asks for confirmation of risky changes, then saves and audits it
+func setConfigValue(key string, parsedValue interface{}) error {
+	return setConfigValues([]config.Change{{Key: key, Value: parsedValue}})
+}
+
+// setConfigValues makes changes like setConfigValue, all or none of them.
+// The configuration is validated with every change made and saved once.
+func setConfigValues(changes []config.Change) error {
+	// Check the changes against a copy before touching the saved configuration
+	candidate, err := config.WithValues(changes)
+	if err != nil {
+		return fmt.Errorf("failed to set configuration: %w", err)
+	}
+
+	candidateGen := gost.NewConfigGenerator(candidate)
+	for _, change := range changes {
+		if err := validateConfigChange(candidateGen, candidate, change.Key); err != nil {
+			return fmt.Errorf("cannot set %s: %w", change.Key, err)
+		}
+	}
+
+	keys := make([]string, len(changes))
+	for i, change := range changes {
+		keys[i] = change.Key
+	}
+
+	// An unauthenticated public proxy without an allow-list needs an explicit acknowledgement
+	acknowledgeOpenProxy := false
+	if err := candidateGen.ValidateOpenProxy(); err != nil {
+		if !configSetYes {
+			return fmt.Errorf("cannot set %s: %w (or pass --yes to run an open proxy anyway)", strings.Join(keys, ", "), err)
+		}
+
+		ui.Warning("%s proxy will be an OPEN PROXY: anyone on the internet can use it",
+			strings.Join(candidateGen.OpenProxyServices(), " and "))
+		ui.Detail("Open proxies are found and abused for spam and attacks within minutes")
+		ui.Detail("Restrict clients instead with: wte config set security.allow <CIDR>")
+		if !ui.Confirm("I understand the risk, run an open proxy?") {
+			ui.Info("Change cancelled")
+			return nil
+		}
+		acknowledgeOpenProxy = true
+	}
+
+	if warning := riskyChangeWarning(config.Get(), candidate); warning != "" && !configSetYes {
+		ui.Warning("%s", warning)
+		if !ui.Confirm("Continue?") {
+			ui.Info("Change cancelled")
+			return nil
+		}
+	}
+
+	oldValues := make([]interface{}, len(changes))
+	for i, change := range changes {
+		oldValues[i] = config.GetValue(change.Key)
+		if err := config.Set(change.Key, change.Value); err != nil {
+			return fmt.Errorf("failed to set configuration: %w", err)
+		}
+	}
+
+	if acknowledgeOpenProxy {
+		if err := config.Set("security.allow_open_proxy", true); err != nil {
+			return fmt.Errorf("failed to set configuration: %w", err)
+		}
+	}
+
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	for i, change := range changes {
+		recordAudit("config set", change.Key, oldValues[i], change.Value)
+	}
+	if acknowledgeOpenProxy {
+		recordAudit("config set", "security.allow_open_proxy", false, true)
+	}
+
+	for _, change := range changes {
+		ui.Success("Configuration updated: %s = %v", change.Key, change.Value)
+	}
+
+	if containsString(keys, "gost.version") {
+		return offerGOSTUpgrade(config.Get(), configSetYes)
+	}
+
+	if containsString(keys, "credentials.store") || containsString(keys, "credentials.path") {
+		ui.Info("Run 'wte credentials --save' to save the credentials file in the new place")
+		if containsString(keys, "credentials.path") {
+			ui.Detail("The file at the previous path is left in place")
+		}
+		if len(keys) == 1 {
+			return nil
+		}
+	}
+
+	ui.Info("Run 'wte restart' to apply changes")
+
+	return nil
+}
+
+// validateConfigChange runs the checks of candidate that a change of key
+// can break
+func validateConfigChange(candidateGen *gost.ConfigGenerator, candidate *config.Config, key string) error {
+	if err := candidateGen.ValidateServices(); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(key, ".port") || strings.HasSuffix(key, ".enabled") || key == "shadowsocks.udp" || key == "shadowsocks.ports" {
+		if err := candidateGen.ValidatePorts(); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(key, "relay.") {
+		if err := candidateGen.ValidateRelays(); err != nil {
+			return err
+		}
+		if err := candidateGen.ValidatePorts(); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(key, "shadowsocks.") {
+		if err := candidateGen.ValidateShadowsocksKey(); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(key, "shadowsocks.") || key == "https.enabled" {
+		if err := candidateGen.ValidateShadowsocksPlugin(); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(key, "watchdog.") {
+		if _, err := gost.ParseWatchdogSettings(candidate.Watchdog); err != nil {
+			return err
+		}
+	}
+
+	if key == "gost.extra_services" || key == "gost.raw_append" {
+		if err := candidateGen.ValidateExtras(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// warnPort warns about a port that GOST may fail to listen on. The change
+// is not blocked: the port may be freed before the config is applied.
+func warnPort(key string, port int) {
+	if port <= config.PrivilegedPortMax {
+		ui.Warning("Port %d is below 1024, GOST needs root privileges to listen on it", port)
+	}
+
+	if current, ok := config.GetValue(key).(int); ok && current == port {
+		return
+	}
+	if !system.IsPortAvailable(port) {
+		ui.Warning("Port %d is already in use by another process", port)
+		ui.Detail("Check with: ss -tlnp | grep :%d", port)
+	}
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 var configResetCmd = &cobra.Command{
 	Use:   "reset",
 	Short: "Reset configuration to defaults",
@@ -216,6 +842,8 @@ Examples:
 			return fmt.Errorf("failed to save configuration: %w", err)
 		}
 
+		recordAudit("config reset", "", nil, nil)
+
 		ui.Success("Configuration reset to defaults")
 		ui.Info("Run 'wte restart' to apply changes")
 
@@ -226,47 +854,240 @@ Examples:
 var configApplyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Apply configuration changes",
-	Long: `Regenerate GOST configuration from WTE config and restart service.
+	Long: `Regenerate GOST configuration from WTE config and reload the service.
 
 This command:
 1. Reads current WTE configuration
 2. Regenerates GOST config.yaml
-3. Restarts the GOST service
+3. Reloads the GOST service, which keeps client connections open
+4. Rolls back to the previous GOST config if the service doesn't come up
+
+The service is restarted instead, dropping client connections, when a port,
+bind address or listener changed, when the service definition changed or
+when --restart is given.
 
 Examples:
-  wte config apply`,
+  wte config apply
+  wte config apply --restart
+  wte config apply --timeout 30s`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := checkRoot(); err != nil {
 			return err
 		}
 
+		opts := gost.ApplyOptions{Timeout: configApplyTimeout, Restart: configApplyRestart}
+		return runConfigApply(config.Get(), opts)
+	},
+}
+
+// runConfigApply applies cfg to the service and brings the helper services
+// in line with it
+func runConfigApply(cfg *config.Config, opts gost.ApplyOptions) error {
+	if err := gost.ApplyTransactionWithOptions(cfg, opts); err != nil {
+		if errors.Is(err, gost.ErrRolledBack) {
+			ui.Detail("Fix the WTE config and run 'wte config apply' again")
+		}
+		return err
+	}
+
+	if err := config.RecordApplied(); err != nil {
+		ui.Warning("Could not record applied configuration: %v", err)
+	}
+
+	service := system.NewServiceManager()
+	if err := syncLockoutService(cfg, service); err != nil {
+		ui.Warning("Could not update auth lockout watcher: %v", err)
+	}
+	if err := syncWatchdogService(cfg, service); err != nil {
+		ui.Warning("Could not update watchdog: %v", err)
+	}
+
+	return nil
+}
+
+var configDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what 'config apply' would change",
+	Long: `Generate the GOST configuration from the WTE config without writing it and
+show a unified diff against the GOST config file on disk.
+
+Lines marked + are what 'config apply' would write, lines marked - are in
+the file now and would be lost, such as manual edits of the GOST config.
+
+Examples:
+  sudo wte config diff`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		cfg := config.Get()
 
-		ui.Action("Regenerating GOST configuration...")
+		rendered, err := gost.NewConfigGenerator(cfg).Render()
+		if err != nil {
+			return err
+		}
+
+		current, err := os.ReadFile(cfg.GOST.ConfigFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read GOST config: %w", err)
+		}
+
+		var currentLines []string
+		if len(current) > 0 {
+			currentLines = difflib.SplitLines(string(current))
+		}
+
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        withoutGeneratedLine(currentLines),
+			B:        withoutGeneratedLine(difflib.SplitLines(string(rendered))),
+			FromFile: cfg.GOST.ConfigFile,
+			ToFile:   "generated",
+			Context:  3,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to compare configurations: %w", err)
+		}
+
+		if diff == "" {
+			ui.Success("%s is up to date", cfg.GOST.ConfigFile)
+			return nil
+		}
+
+		printDiff(diff)
+
+		return nil
+	},
+}
 
-		configGen := gost.NewConfigGenerator(cfg)
-		if err := configGen.Generate(); err != nil {
-			return fmt.Errorf("failed to generate configuration: %w", err)
+// withoutGeneratedLine drops the "# Generated:" header line, which holds
+// the time the GOST config was rendered and so always differs
+func withoutGeneratedLine(lines []string) []string {
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# Generated:") {
+			continue
 		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+// printDiff prints a unified diff, additions in green and removals in red
+func printDiff(diff string) {
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			ui.White.Println(line)
+		case strings.HasPrefix(line, "@@"):
+			ui.Cyan.Println(line)
+		case strings.HasPrefix(line, "+"):
+			ui.Green.Println(line)
+		case strings.HasPrefix(line, "-"):
+			ui.Red.Println(line)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
 
-		ui.Success("Configuration regenerated")
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the configuration file to the current version",
+	Long: `Upgrade a configuration file written by an older version of WTE.
 
-		ui.Action("Restarting service...")
-		systemd := system.NewSystemdManager()
-		if err := systemd.Restart(); err != nil {
-			return fmt.Errorf("failed to restart service: %w", err)
+The file is backed up next to itself, settings added since it was written
+are filled in with their defaults and the version field is updated. Other
+commands only upgrade the settings in memory and leave the file alone,
+unless they change the configuration and save it anyway.
+
+Examples:
+  wte config migrate`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkRoot(); err != nil {
+			return err
 		}
 
-		ui.Success("Service restarted")
+		if !config.Exists() && config.GetConfigPath() == config.WTEConfigFile {
+			return fmt.Errorf("no configuration file at %s", config.WTEConfigFile)
+		}
+
+		result, err := config.Migrate()
+		if err != nil {
+			return err
+		}
+
+		if result == nil {
+			ui.Success("Configuration is up to date (version %d)", config.FileVersion())
+			return nil
+		}
+
+		recordAudit("config migrate", "version", result.From, result.To)
+
+		ui.Success("Configuration migrated from version %d to %d", result.From, result.To)
+		ui.Detail("Backup: %s", result.BackupPath)
+		ui.Info("Run 'wte config apply' to apply the new defaults")
 
 		return nil
 	},
 }
 
+// riskyChangeWarning describes why moving from current to candidate is risky,
+// or returns an empty string if the change is safe
+func riskyChangeWarning(current, candidate *config.Config) string {
+	if current.HTTP.Enabled && current.HTTP.Auth.Enabled && !candidate.HTTP.Auth.Enabled {
+		return "Disabling HTTP auth will allow unauthenticated access"
+	}
+
+	if current.HTTPS.Enabled && current.HTTPS.Auth.Enabled && !candidate.HTTPS.Auth.Enabled {
+		return "Disabling HTTPS auth will allow unauthenticated access"
+	}
+
+	pprof := candidate.Debug.Pprof
+	if pprof.Enabled && !config.IsLoopback(pprof.BindAddress) &&
+		(!current.Debug.Pprof.Enabled || config.IsLoopback(current.Debug.Pprof.BindAddress)) {
+		return fmt.Sprintf("The pprof endpoint on %s will be reachable from the network and exposes process internals without authentication",
+			config.ListenAddr(pprof.BindAddress, pprof.Port))
+	}
+
+	portChanges := []struct {
+		service  string
+		enabled  bool
+		from, to int
+	}{
+		{"HTTP proxy", current.HTTP.Enabled, current.HTTP.Port, candidate.HTTP.Port},
+		{"HTTPS proxy", current.HTTPS.Enabled, current.HTTPS.Port, candidate.HTTPS.Port},
+		{"Shadowsocks", current.Shadowsocks.Enabled, current.Shadowsocks.Port, candidate.Shadowsocks.Port},
+	}
+
+	for _, change := range portChanges {
+		if change.enabled && change.from != change.to {
+			return fmt.Sprintf("Changing the %s port from %d to %d will disconnect clients connected through it",
+				change.service, change.from, change.to)
+		}
+	}
+
+	return ""
+}
+
 func init() {
+	for _, cmd := range []*cobra.Command{configSetCmd, configListAddCmd, configListRemoveCmd} {
+		cmd.Flags().BoolVarP(&configSetYes, "yes", "y", false, "Skip confirmation for risky changes")
+	}
+	configSetCmd.Flags().BoolVar(&configSetForce, "force", false, "Accept a password that fails the strength check")
+	configSetCmd.Flags().StringVar(&configSetFromFile, "from-file", "", "Set the key=value pairs of this file (\"-\" for stdin) at once")
+
+	configShowCmd.Flags().BoolVar(&configShowRedact, "redact", false, "Replace passwords by ********")
+	configShowCmd.Flags().BoolVar(&configShowSecrets, "show-secrets", false, "Show passwords even when the output is not a terminal")
+	configShowCmd.MarkFlagsMutuallyExclusive("redact", "show-secrets")
 	configCmd.AddCommand(configShowCmd)
+	configEditCmd.Flags().BoolVar(&configEditApply, "apply", false, "Apply the changes without asking")
 	configCmd.AddCommand(configEditCmd)
 	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configListAddCmd)
+	configCmd.AddCommand(configListRemoveCmd)
 	configCmd.AddCommand(configResetCmd)
+	configApplyCmd.Flags().DurationVar(&configApplyTimeout, "timeout", gost.DefaultApplyTimeout, "How long to wait for the service before rolling back")
+	configApplyCmd.Flags().BoolVar(&configApplyRestart, "restart", false, "Restart the service even if a reload would do")
 	configCmd.AddCommand(configApplyCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configMigrateCmd)
 }
@@ -2,6 +2,8 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -15,6 +17,10 @@ import (
 var (
 	credsRegenerate bool
 	credsShowURI    bool
+	credsQR         bool
+	credsFormat     string
+	credsSave       bool
+	credsShowSaved  bool
 )
 
 var credentialsCmd = &cobra.Command{
@@ -29,22 +35,79 @@ This command shows:
   - Shadowsocks connection details (if enabled)
   - Shadowsocks URI for mobile clients
 
+The credentials are also saved to a file when WTE installs or changes the
+passwords: credentials.path (default /root/proxy-credentials.txt), readable
+only by its owner. With credentials.store set to 'encrypted' the file is
+encrypted with a passphrase instead, with ".gpg" appended to its name
+(needs gpg). The passphrase is asked for, or taken from
+WTE_CREDENTIALS_PASSPHRASE. --save rewrites the file, e.g. after changing
+credentials.path or credentials.store, and --show-saved prints it,
+decrypting it when needed.
+
+With --qr, the Shadowsocks URI is also printed as a QR code that mobile
+clients can scan. Only the URI is printed with --no-color, with --quiet,
+or when the terminal is too narrow for the code.
+
+Formats:
+  box     Decorated overview (default)
+  plain   Client URL of each enabled service, one per line
+  env     Shell variables such as WTE_HTTP_USER and WTE_SS_PASSWORD,
+          for eval in scripts
+  json    JSON document, like --json
+
 Examples:
   wte credentials              # Show credentials
   wte creds                    # Short alias
   wte credentials --regenerate # Generate new passwords
-  wte credentials --uri        # Show Shadowsocks URI only`,
+  wte credentials --uri        # Show Shadowsocks URI only
+  wte credentials --uri --qr   # Show Shadowsocks URI as a QR code
+  wte credentials --json       # Machine-readable credentials
+  wte credentials --save       # Rewrite the credentials file
+  wte credentials --show-saved # Print the credentials file
+  eval "$(wte credentials --format env)"`,
 	RunE: runCredentials,
 }
 
 func init() {
 	credentialsCmd.Flags().BoolVarP(&credsRegenerate, "regenerate", "r", false, "Regenerate passwords")
 	credentialsCmd.Flags().BoolVar(&credsShowURI, "uri", false, "Show Shadowsocks URI only")
+	credentialsCmd.Flags().BoolVar(&credsQR, "qr", false, "Print the Shadowsocks URI as a QR code")
+	credentialsCmd.Flags().StringVar(&credsFormat, "format", gost.CredentialsFormatBox,
+		fmt.Sprintf("Output format (%s)", strings.Join(gost.CredentialsFormats, ", ")))
+	credentialsCmd.Flags().BoolVar(&credsSave, "save", false, "Save the credentials file again, e.g. after changing credentials.store")
+	credentialsCmd.Flags().BoolVar(&credsShowSaved, "show-saved", false, "Print the saved credentials file, decrypting it when encrypted")
+	credentialsCmd.MarkFlagsMutuallyExclusive("format", "uri")
+	credentialsCmd.MarkFlagsMutuallyExclusive("format", "qr")
+	credentialsCmd.MarkFlagsMutuallyExclusive("show-saved", "regenerate", "save")
 }
 
 func runCredentials(cmd *cobra.Command, args []string) error {
+	// The passwords are in the config, the defaults would show none
+	if config.Protected() {
+		return fmt.Errorf("cannot read %s, run 'sudo wte credentials'", config.GetConfigPath())
+	}
+
 	cfg := config.Get()
 
+	if credsShowSaved {
+		return showSavedCredentials(cfg)
+	}
+
+	format := credsFormat
+	if ui.JSON {
+		format = gost.CredentialsFormatJSON
+	}
+	switch format {
+	case gost.CredentialsFormatBox, gost.CredentialsFormatPlain, gost.CredentialsFormatEnv, gost.CredentialsFormatJSON:
+	default:
+		return fmt.Errorf("unknown format %q (supported: %s)", format, strings.Join(gost.CredentialsFormats, ", "))
+	}
+
+	// Like --json, machine-readable output is all that goes to stdout
+	if format != gost.CredentialsFormatBox {
+		ui.SetQuiet(true)
+	}
+
 	// Get public IP
 	publicIP, err := system.GetPublicIP()
 	if err != nil {
@@ -60,9 +123,13 @@ func runCredentials(cmd *cobra.Command, args []string) error {
 
 		ui.Action("Regenerating passwords...")
 
+		oldHTTPPassword := cfg.HTTP.Auth.Password
+		oldHTTPSPassword := cfg.HTTPS.Auth.Password
+		oldSSPassword := cfg.Shadowsocks.Password
+
 		// Generate new HTTP password
 		if cfg.HTTP.Auth.Enabled {
-			pass, err := security.GeneratePassword(16)
+			pass, err := security.GeneratePasswordWithCharset(security.DefaultPasswordLength, cfg.Security.PasswordCharset)
 			if err != nil {
 				return fmt.Errorf("failed to generate HTTP password: %w", err)
 			}
@@ -72,22 +139,36 @@ func runCredentials(cmd *cobra.Command, args []string) error {
 
 		// Generate new Shadowsocks password
 		if cfg.Shadowsocks.Enabled {
-			pass, err := security.GeneratePassword(16)
+			pass, err := security.GenerateSSPassword(cfg.Shadowsocks.Method, security.DefaultPasswordLength, cfg.Security.PasswordCharset)
 			if err != nil {
 				return fmt.Errorf("failed to generate Shadowsocks password: %w", err)
 			}
 			cfg.Shadowsocks.Password = pass
 		}
 
+		// Apply the new passwords first, so a rollback leaves the saved
+		// configuration and GOST on the old ones
+		if err := gost.ApplyTransaction(cfg, gost.DefaultApplyTimeout); err != nil {
+			cfg.HTTP.Auth.Password = oldHTTPPassword
+			cfg.HTTPS.Auth.Password = oldHTTPSPassword
+			cfg.Shadowsocks.Password = oldSSPassword
+			return err
+		}
+
 		// Save configuration
 		if err := config.Save(); err != nil {
-			return fmt.Errorf("failed to save configuration: %w", err)
+			return fmt.Errorf("GOST uses the new passwords, but saving the configuration failed: %w", err)
+		}
+
+		if cfg.HTTP.Auth.Enabled {
+			recordAudit("credentials regenerate", "http.auth.password", "", cfg.HTTP.Auth.Password)
+		}
+		if cfg.Shadowsocks.Enabled {
+			recordAudit("credentials regenerate", "shadowsocks.password", "", cfg.Shadowsocks.Password)
 		}
 
-		// Regenerate GOST config
-		configGen := gost.NewConfigGenerator(cfg)
-		if err := configGen.Generate(); err != nil {
-			return fmt.Errorf("failed to regenerate GOST config: %w", err)
+		if err := config.RecordApplied(); err != nil {
+			ui.Warning("Could not record applied configuration: %v", err)
 		}
 
 		// Save credentials file
@@ -96,30 +177,97 @@ func runCredentials(cmd *cobra.Command, args []string) error {
 			ui.Warning("Could not save credentials file: %v", err)
 		}
 
-		// Restart service
-		ui.Action("Restarting service...")
-		systemd := system.NewSystemdManager()
-		if err := systemd.Restart(); err != nil {
-			return fmt.Errorf("failed to restart service: %w", err)
+		ui.Success("Passwords regenerated and service restarted")
+		ui.Println()
+	} else if credsSave {
+		if err := checkRoot(); err != nil {
+			return err
 		}
 
-		ui.Success("Passwords regenerated and service restarted")
+		credsMgr := gost.NewCredentialsManager(cfg, publicIP)
+		if err := credsMgr.Save(); err != nil {
+			return fmt.Errorf("could not save credentials file: %w", err)
+		}
+
+		ui.Success("Credentials saved to: %s", credsMgr.GetPath())
 		ui.Println()
 	}
 
+	switch format {
+	case gost.CredentialsFormatJSON:
+		return ui.PrintJSON(gost.NewCredentialsManager(cfg, publicIP).Info())
+	case gost.CredentialsFormatEnv:
+		fmt.Print(gost.NewCredentialsManager(cfg, publicIP).Info().Env())
+		return nil
+	case gost.CredentialsFormatPlain:
+		fmt.Print(gost.NewCredentialsManager(cfg, publicIP).Info().Plain())
+		return nil
+	}
+
 	// Show Shadowsocks URI only
 	if credsShowURI {
 		if !cfg.Shadowsocks.Enabled {
 			return fmt.Errorf("Shadowsocks is not enabled")
 		}
 
-		configGen := gost.NewConfigGenerator(cfg)
-		uri := configGen.GetShadowsocksURI(publicIP)
-		fmt.Println(uri)
+		printShadowsocksURIs(cfg, publicIP, credsQR)
 		return nil
 	}
 
 	// Print full credentials
 	credsMgr := gost.NewCredentialsManager(cfg, publicIP)
-	return credsMgr.Print()
+	if err := credsMgr.Print(); err != nil {
+		return err
+	}
+
+	if credsQR {
+		if !cfg.Shadowsocks.Enabled {
+			ui.Warning("Shadowsocks is not enabled, no QR code to show")
+			return nil
+		}
+
+		printShadowsocksURIs(cfg, publicIP, true)
+	}
+
+	return nil
+}
+
+// showSavedCredentials prints the saved credentials file, which may hold
+// older passwords than the config when it was not saved since
+func showSavedCredentials(cfg *config.Config) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	data, err := gost.NewCredentialsManager(cfg, "").Load()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// printShadowsocksURIs prints the URI of each Shadowsocks port, as QR
+// codes with qr. Through an SSH tunnel only the main port is reachable.
+func printShadowsocksURIs(cfg *config.Config, publicIP string, qr bool) {
+	configGen := gost.NewConfigGenerator(cfg)
+	host := gost.ClientHost(cfg, publicIP)
+
+	uris := configGen.GetShadowsocksURIs(host)
+	if cfg.LocalhostOnly() {
+		uris = uris[:1]
+	}
+
+	for i, uri := range uris {
+		if !qr {
+			fmt.Println(uri)
+			continue
+		}
+		title := "Shadowsocks"
+		if i > 0 {
+			title = fmt.Sprintf("Shadowsocks :%d", cfg.Shadowsocks.Ports[i-1])
+		}
+		ui.PrintQRCode(title, uri)
+	}
 }
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/ui"
+)
+
+var (
+	debugPprofCPUProfile string
+	debugPprofSeconds    int
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "Diagnostic tools",
+}
+
+var debugPprofCmd = &cobra.Command{
+	Use:   "pprof",
+	Short: "Show the GOST pprof URL or fetch a CPU profile",
+	Long: `Show the GOST pprof endpoint or fetch a CPU profile from it.
+
+Profiling is disabled by default. Enable it (bound to localhost) with:
+  wte config set debug.pprof.enabled true
+  wte config apply
+
+The endpoint has no authentication. Keep it bound to localhost and reach it
+through an SSH tunnel if you need it from another machine.
+
+Examples:
+  wte debug pprof                                # Print the pprof URL
+  wte debug pprof --cpu-profile gost.cpu         # Fetch a 30s CPU profile
+  wte debug pprof --cpu-profile gost.cpu --seconds 60
+  go tool pprof gost.cpu                         # Analyze the profile`,
+	RunE: runDebugPprof,
+}
+
+func init() {
+	debugPprofCmd.Flags().StringVar(&debugPprofCPUProfile, "cpu-profile", "", "Fetch a CPU profile and write it to this file")
+	debugPprofCmd.Flags().IntVar(&debugPprofSeconds, "seconds", 30, "CPU profile duration in seconds")
+
+	debugCmd.AddCommand(debugPprofCmd)
+
+	rootCmd.AddCommand(debugCmd)
+}
+
+func runDebugPprof(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+	pprof := cfg.Debug.Pprof
+
+	if !pprof.Enabled {
+		return fmt.Errorf("profiling is disabled, enable it with 'wte config set debug.pprof.enabled true' and 'wte config apply'")
+	}
+
+	if !config.IsLoopback(pprof.BindAddress) {
+		ui.Warning("The pprof endpoint is reachable from the network on %s", config.ListenAddr(pprof.BindAddress, pprof.Port))
+		ui.Detail("It exposes process internals without authentication")
+		ui.Detail("Bind it to localhost: wte config set debug.pprof.bind_address %s", config.LocalhostBindAddress)
+	}
+
+	baseURL := pprofURL(pprof)
+
+	if debugPprofCPUProfile == "" {
+		ui.Info("GOST pprof endpoint:")
+		fmt.Println(baseURL)
+		return nil
+	}
+
+	if debugPprofSeconds <= 0 {
+		return fmt.Errorf("invalid profile duration: %d", debugPprofSeconds)
+	}
+
+	ui.Action("Collecting CPU profile for %ds...", debugPprofSeconds)
+
+	client := &http.Client{
+		Timeout: time.Duration(debugPprofSeconds)*time.Second + 30*time.Second,
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%sprofile?seconds=%d", baseURL, debugPprofSeconds))
+	if err != nil {
+		return fmt.Errorf("failed to fetch CPU profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch CPU profile: HTTP %d", resp.StatusCode)
+	}
+
+	file, err := os.Create(debugPprofCPUProfile)
+	if err != nil {
+		return fmt.Errorf("failed to create profile file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+
+	ui.Success("CPU profile saved to: %s", debugPprofCPUProfile)
+	ui.Detail("Analyze it with: go tool pprof %s", debugPprofCPUProfile)
+
+	return nil
+}
+
+// pprofURL returns the local URL of the pprof index page
+func pprofURL(pprof config.PprofConfig) string {
+	host := pprof.BindAddress
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = config.LocalhostBindAddress
+	}
+	return fmt.Sprintf("http://%s/debug/pprof/", config.ListenAddr(host, pprof.Port))
+}
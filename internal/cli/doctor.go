@@ -0,0 +1,340 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/security"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// Outcomes of doctor checks and service tests
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// doctorPortTimeout is how long the doctor waits for each port
+const doctorPortTimeout = 3 * time.Second
+
+// checkResult is the outcome of one doctor check or service test, as
+// printed by --json
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// doctorReport is the JSON document of 'wte doctor --json'. OK is false
+// when a check failed.
+type doctorReport struct {
+	OK     bool          `json:"ok"`
+	Checks []checkResult `json:"checks"`
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common installation problems",
+	Long: `Check the installation for common problems and print a checklist.
+
+The checks cover the operating system, the GOST binary, the configuration
+file, the service, the proxy ports, the HTTPS certificate, the firewall and
+the public IP address. Warnings point at likely problems, failures at
+problems that stop clients from connecting.
+
+The command exits with an error if any check fails, so it can be used in
+scripts and monitoring. With --json the checks are printed as a JSON
+document with the name, status (pass, warn, fail) and detail of each
+check and an overall "ok"; the exit status is the same.
+
+Examples:
+  wte doctor          # Run all checks
+  wte doctor --json   # Machine-readable results
+  sudo wte doctor     # Also read the firewall rules, which needs root`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	ui.Header("WTE Doctor")
+
+	var checks []checkResult
+	checks = append(checks, doctorSystem(cfg)...)
+	checks = append(checks, doctorConfig(cfg)...)
+	checks = append(checks, doctorService()...)
+	checks = append(checks, doctorPorts(cfg)...)
+	checks = append(checks, doctorCertificate(cfg)...)
+	checks = append(checks, doctorFirewall(cfg)...)
+	checks = append(checks, doctorNetwork(cfg)...)
+
+	failed, warned := 0, 0
+	for _, check := range checks {
+		switch check.Status {
+		case checkFail:
+			failed++
+		case checkWarn:
+			warned++
+		}
+	}
+
+	passed := len(checks) - failed - warned
+	summary := fmt.Sprintf("%d passed, %d warnings, %d failed", passed, warned, failed)
+
+	if ui.JSON {
+		if err := ui.PrintJSON(doctorReport{OK: failed == 0, Checks: checks}); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("doctor found problems: %s", summary)
+		}
+		return nil
+	}
+
+	for _, check := range checks {
+		printDoctorCheck(check)
+	}
+	ui.Println()
+
+	switch {
+	case failed > 0:
+		return fmt.Errorf("doctor found problems: %s", summary)
+	case warned > 0:
+		ui.Warning("%s", summary)
+	default:
+		ui.Success("All checks passed")
+	}
+
+	return nil
+}
+
+// printDoctorCheck prints one line of the checklist
+func printDoctorCheck(check checkResult) {
+	switch check.Status {
+	case checkPass:
+		ui.Green.Printf("  %s  ", ui.SymbolSuccess)
+	case checkWarn:
+		ui.Yellow.Printf("  %s  ", ui.SymbolWarning)
+	default:
+		ui.Red.Printf("  %s  ", ui.SymbolFailed)
+	}
+	fmt.Printf("%-22s %s\n", check.Name, check.Detail)
+}
+
+// doctorSystem checks the operating system and the GOST binary
+func doctorSystem(cfg *config.Config) []checkResult {
+	osInfo, err := system.DetectOS()
+	if err != nil {
+		return []checkResult{{"Operating system", checkFail, err.Error()}}
+	}
+
+	var checks []checkResult
+
+	name := fmt.Sprintf("%s %s (%s)", osInfo.OS, osInfo.Version, osInfo.Arch)
+	switch {
+	case osInfo.Fallback:
+		checks = append(checks, checkResult{"Operating system", checkWarn, name + ", no OS metadata found"})
+	case !osInfo.IsSupported:
+		checks = append(checks, checkResult{"Operating system", checkWarn, name + ", not officially tested"})
+	default:
+		checks = append(checks, checkResult{"Operating system", checkPass, name})
+	}
+
+	installer := gost.NewInstaller(cfg, osInfo)
+	installed, err := installer.GetInstalledVersion()
+	switch {
+	case !installer.IsInstalled():
+		checks = append(checks, checkResult{"GOST binary", checkFail,
+			fmt.Sprintf("%s not found, run 'wte install'", cfg.GOST.BinaryPath)})
+	case err != nil:
+		checks = append(checks, checkResult{"GOST binary", checkFail,
+			fmt.Sprintf("%s does not run: %v", cfg.GOST.BinaryPath, err)})
+	case installed != cfg.GOST.Version:
+		checks = append(checks, checkResult{"GOST binary", checkWarn,
+			fmt.Sprintf("version %s, configured %s", installed, cfg.GOST.Version)})
+	default:
+		checks = append(checks, checkResult{"GOST binary", checkPass, "version " + installed})
+	}
+
+	return checks
+}
+
+// doctorConfig checks that the WTE configuration parses, is valid and has
+// been applied
+func doctorConfig(cfg *config.Config) []checkResult {
+	path := config.GetConfigPath()
+
+	if err := config.Check(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []checkResult{{"Configuration", checkFail, path + " not found, run 'wte install'"}}
+		}
+		return []checkResult{{"Configuration", checkFail, fmt.Sprintf("%s: %v", path, err)}}
+	}
+
+	if err := gost.NewConfigGenerator(cfg).Validate(); err != nil {
+		return []checkResult{{"Configuration", checkFail, err.Error()}}
+	}
+
+	checks := []checkResult{{"Configuration", checkPass, path}}
+
+	if stale, err := config.IsApplyStale(); err == nil && stale {
+		checks = append(checks, checkResult{"Applied configuration", checkWarn,
+			"changed since the last apply, run 'wte config apply'"})
+	}
+
+	return checks
+}
+
+// doctorService checks that the service is installed, running and enabled
+func doctorService() []checkResult {
+	service := system.NewServiceManager()
+
+	if !service.IsInstalled() {
+		return []checkResult{{"Service", checkFail, service.DefinitionPath() + " not found, run 'wte install'"}}
+	}
+
+	status, err := service.Status()
+	if err != nil {
+		return []checkResult{{"Service", checkFail, err.Error()}}
+	}
+
+	var checks []checkResult
+
+	if status.IsActive {
+		checks = append(checks, checkResult{"Service", checkPass, "active (PID " + status.MainPID + ")"})
+	} else {
+		checks = append(checks, checkResult{"Service", checkFail,
+			fmt.Sprintf("%s (%s), see 'wte logs'", status.ActiveState, status.SubState)})
+	}
+
+	if status.IsEnabled {
+		checks = append(checks, checkResult{"Autostart", checkPass, "enabled"})
+	} else {
+		checks = append(checks, checkResult{"Autostart", checkWarn, "disabled, the proxy won't start after a reboot"})
+	}
+
+	return checks
+}
+
+// doctorPorts checks that every TCP port accepts connections. UDP gives no
+// answer to probe.
+func doctorPorts(cfg *config.Config) []checkResult {
+	var checks []checkResult
+
+	for _, port := range cfg.GetRequiredPorts() {
+		if port.Protocol != "tcp" {
+			continue
+		}
+
+		name := fmt.Sprintf("Port %d/tcp", port.Port)
+		if err := system.TestTCPPort(config.DialHost(port.BindAddress), port.Port, doctorPortTimeout); err != nil {
+			checks = append(checks, checkResult{name, checkFail, fmt.Sprintf("%s is not listening", port.Service)})
+			continue
+		}
+		checks = append(checks, checkResult{name, checkPass, port.Service + " listening"})
+	}
+
+	return checks
+}
+
+// doctorCertificate checks the HTTPS certificate
+func doctorCertificate(cfg *config.Config) []checkResult {
+	if !cfg.HTTPS.Enabled {
+		return nil
+	}
+
+	info, err := security.GetCertificateInfo(cfg.HTTPS.CertPath)
+	switch {
+	case err != nil:
+		return []checkResult{{"Certificate", checkFail, fmt.Sprintf("%s: %v", cfg.HTTPS.CertPath, err)}}
+	case info.IsExpired:
+		return []checkResult{{"Certificate", checkFail,
+			fmt.Sprintf("expired on %s, run 'wte cert renew'", info.NotAfter.Format("2006-01-02"))}}
+	case info.DaysLeft <= certRenewDays:
+		return []checkResult{{"Certificate", checkWarn,
+			fmt.Sprintf("expires in %d days, run 'wte cert renew'", info.DaysLeft)}}
+	}
+
+	return []checkResult{{"Certificate", checkPass, fmt.Sprintf("valid for %d days", info.DaysLeft)}}
+}
+
+// doctorFirewall checks that the firewall lets clients reach the ports
+func doctorFirewall(cfg *config.Config) []checkResult {
+	firewall := system.NewFirewallManager()
+
+	switch firewall.GetType() {
+	case system.FirewallNone:
+		return []checkResult{{"Firewall", checkWarn, "none detected, all ports are reachable"}}
+	case system.FirewallUFW, system.FirewallFirewalld:
+		if !firewall.IsEnabled() {
+			return []checkResult{{"Firewall", checkWarn, fmt.Sprintf("%s is installed but inactive", firewall.GetType())}}
+		}
+	}
+
+	// Rules added by hand may live where WTE doesn't look, such as another
+	// nftables table or a custom chain
+	if !cfg.Firewall.AutoConfigure {
+		return []checkResult{{"Firewall", checkPass, string(firewall.GetType()) + ", firewall.auto_configure is off, rules not checked"}}
+	}
+
+	checks := []checkResult{{"Firewall", checkPass, string(firewall.GetType())}}
+
+	for _, port := range cfg.FirewallPorts() {
+		if config.IsLoopback(port.BindAddress) {
+			continue
+		}
+
+		name := fmt.Sprintf("Firewall %d/%s", port.Port, port.Protocol)
+		if port.Source != "" {
+			name += " from " + port.Source
+		}
+		if firewall.IsPortAllowedFrom(port.Port, port.Protocol, port.Source) {
+			checks = append(checks, checkResult{name, checkPass, "open"})
+		} else {
+			checks = append(checks, checkResult{name, checkWarn, "no rule found, clients may not be able to connect"})
+		}
+	}
+
+	return checks
+}
+
+// doctorNetwork checks that the public IP can be detected and reaches the
+// proxy ports
+func doctorNetwork(cfg *config.Config) []checkResult {
+	publicIP, err := system.GetPublicIP()
+	if err != nil {
+		return []checkResult{{"Public IP", checkWarn, fmt.Sprintf("could not detect: %v", err)}}
+	}
+
+	checks := []checkResult{{"Public IP", checkPass, publicIP}}
+
+	// Some providers don't route a server's own public IP back to it, so a
+	// failure here is only a warning
+	for _, port := range cfg.GetRequiredPorts() {
+		if port.Protocol != "tcp" || config.IsLoopback(port.BindAddress) {
+			continue
+		}
+
+		name := fmt.Sprintf("Public %d/tcp", port.Port)
+		if err := system.TestTCPPort(publicIP, port.Port, doctorPortTimeout); err != nil {
+			checks = append(checks, checkResult{name, checkWarn, "not reachable through the public IP, check the firewall/NAT"})
+			continue
+		}
+		checks = append(checks, checkResult{name, checkPass, "reachable"})
+	}
+
+	return checks
+}
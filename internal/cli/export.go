@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+var (
+	exportFormat string
+	exportQR     bool
+	exportOutput string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export client configuration",
+	Long: `Export a ready-made client configuration for the enabled services.
+
+Formats:
+  clash        Clash proxies and a proxy group
+  clash-meta   Clash.Meta (mihomo), adds Shadowsocks 2022 ciphers
+  base64-sub   Base64 subscription of the import URIs, for v2rayN and
+               other v2ray-style clients
+
+With --qr, a QR code of each enabled service's import URI is printed
+instead, for scanning with a phone.
+
+Examples:
+  wte export                      # Clash configuration
+  wte export --format clash-meta  # Clash.Meta configuration
+  wte export -o wte-clash.yaml    # Save to a file
+  wte export --format base64-sub  # Subscription for v2rayN
+  wte export --qr                 # QR codes for all services`,
+	RunE: runExport,
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", gost.ExportFormatClash,
+		fmt.Sprintf("Export format (%s)", strings.Join(gost.ExportFormats, ", ")))
+	exportCmd.Flags().BoolVar(&exportQR, "qr", false, "Print a QR code for each service's import URI")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Write the configuration to this file instead of stdout")
+	exportCmd.MarkFlagsMutuallyExclusive("qr", "output")
+
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	publicIP, err := system.GetPublicIP()
+	if err != nil {
+		ui.Warning("Could not detect public IP: %v", err)
+		publicIP = "YOUR_SERVER_IP"
+	}
+
+	exporter := gost.NewExporter(cfg, gost.ClientHost(cfg, publicIP))
+
+	if exportQR {
+		uris := exporter.ImportURIs()
+		if len(uris) == 0 {
+			return fmt.Errorf("no enabled services to export")
+		}
+
+		for _, uri := range uris {
+			if uri.URI == "" {
+				ui.Info("%s: no standard import URI, skipping", uri.Service)
+				continue
+			}
+			ui.PrintQRCode(uri.Service, uri.URI)
+		}
+		return nil
+	}
+
+	data, err := exporter.Export(exportFormat)
+	if err != nil {
+		return err
+	}
+
+	if exportOutput == "" {
+		fmt.Print(string(data))
+		return nil
+	}
+
+	// The export holds the proxy passwords
+	if err := os.WriteFile(exportOutput, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", exportOutput, err)
+	}
+
+	ui.Success("Client configuration written to %s", exportOutput)
+	return nil
+}
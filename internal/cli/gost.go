@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/state"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+var (
+	gostUpdateVersion    string
+	gostUpdateCheck      bool
+	gostUpdatePrerelease bool
+	gostUpdateYes        bool
+	gostUpdateSkipSum    bool
+)
+
+var gostCmd = &cobra.Command{
+	Use:   "gost",
+	Short: "Manage the GOST binary",
+}
+
+var gostUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Upgrade the GOST binary in place",
+	Long: `Upgrade the installed GOST binary to the latest release, or to the release
+given with --version.
+
+The new release is downloaded and verified while the service keeps running.
+The service is then stopped, the binary swapped and the service started
+again. If the new binary does not come up, the previous one is restored.
+On success gost.version is updated.
+
+Examples:
+  wte gost update --check              # Only report whether an update exists
+  sudo wte gost update                 # Upgrade to the latest release
+  sudo wte gost update --version 3.0.0 # Install a specific release`,
+	Args: cobra.NoArgs,
+	RunE: runGOSTUpdate,
+}
+
+func init() {
+	gostUpdateCmd.Flags().StringVar(&gostUpdateVersion, "version", "", "Install this GOST release instead of the latest")
+	gostUpdateCmd.Flags().BoolVar(&gostUpdateCheck, "check", false, "Only check for an update, don't install it")
+	gostUpdateCmd.Flags().BoolVar(&gostUpdatePrerelease, "prerelease", false, "Consider prereleases when looking for the latest release")
+	gostUpdateCmd.Flags().BoolVarP(&gostUpdateYes, "yes", "y", false, "Skip the confirmation prompt")
+	gostUpdateCmd.Flags().BoolVar(&gostUpdateSkipSum, "skip-checksum", false, "Install the release even if its checksums can't be fetched from GitHub")
+
+	gostCmd.AddCommand(gostUpdateCmd)
+	rootCmd.AddCommand(gostCmd)
+}
+
+func runGOSTUpdate(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	osInfo, err := system.DetectOS()
+	if err != nil {
+		return fmt.Errorf("failed to detect OS: %w", err)
+	}
+
+	installer := gost.NewInstaller(cfg, osInfo)
+	if !installer.IsInstalled() {
+		return fmt.Errorf("GOST is not installed at %s, run 'wte install'", cfg.GOST.BinaryPath)
+	}
+
+	installed, err := installer.GetInstalledVersion()
+	if err != nil {
+		ui.Warning("Installed GOST binary does not run: %v", err)
+	}
+
+	target := strings.TrimPrefix(gostUpdateVersion, "v")
+	if target == "" {
+		ui.Action("Checking for GOST updates...")
+		installer.SetIncludePrerelease(gostUpdatePrerelease)
+		if target, err = installer.GetLatestVersion(); err != nil {
+			return fmt.Errorf("failed to check for GOST updates: %w", err)
+		}
+	}
+
+	if target == installed {
+		ui.Success("GOST is up to date (v%s)", installed)
+		return nil
+	}
+
+	if installed != "" {
+		ui.Info("GOST v%s is available (installed: v%s)", target, installed)
+	} else {
+		ui.Info("GOST v%s is available", target)
+	}
+
+	if gostUpdateCheck {
+		ui.Detail("Run 'wte gost update' to install it")
+		return nil
+	}
+
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	if !gostUpdateYes && !ui.Confirm(fmt.Sprintf("Install GOST v%s now?", target)) {
+		ui.Info("Update cancelled")
+		return nil
+	}
+
+	updated := *cfg
+	updated.GOST.Version = target
+	if err := replaceGOSTBinary(&updated, osInfo, gostUpdateSkipSum); err != nil {
+		if errors.Is(err, gost.ErrChecksumsUnavailable) {
+			ui.Detail("Pass --skip-checksum to install without verifying the download")
+		}
+		return err
+	}
+
+	if err := config.Set("gost.version", target); err != nil {
+		return fmt.Errorf("failed to update gost.version: %w", err)
+	}
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	recordAudit("gost update", "gost.version", installed, target)
+
+	ui.Success("GOST updated to v%s", target)
+
+	return nil
+}
+
+// offerGOSTUpgrade compares the configured GOST version with the installed
+// binary and offers to install the configured version
+func offerGOSTUpgrade(cfg *config.Config, assumeYes bool) error {
+	osInfo, err := system.DetectOS()
+	if err != nil {
+		return fmt.Errorf("failed to detect OS: %w", err)
+	}
+
+	installer := gost.NewInstaller(cfg, osInfo)
+	if !installer.IsInstalled() {
+		return nil
+	}
+
+	installed, err := installer.GetInstalledVersion()
+	if err == nil && installed == cfg.GOST.Version {
+		return nil
+	}
+
+	ui.Println()
+	ui.Warning("The installed GOST binary (%s) does not match gost.version (%s)", installed, cfg.GOST.Version)
+
+	if !assumeYes && !ui.Confirm(fmt.Sprintf("Install GOST v%s now?", cfg.GOST.Version)) {
+		ui.Info("The GOST binary is unchanged until it is reinstalled")
+		ui.Detail("Run 'wte config set gost.version %s' again to install it", cfg.GOST.Version)
+		return nil
+	}
+
+	if err := replaceGOSTBinary(cfg, osInfo, false); err != nil {
+		if errors.Is(err, gost.ErrChecksumsUnavailable) {
+			ui.Detail("To install without verifying the download: wte gost update --version %s --skip-checksum", cfg.GOST.Version)
+		}
+		return err
+	}
+	return nil
+}
+
+// replaceGOSTBinary installs the GOST version of cfg. The release is
+// installed next to the current binary while the service keeps running;
+// the service is only stopped for the swap. If the service does not come
+// back up with the new binary, the previous one is restored. skipChecksum
+// allows a release whose checksums can't be fetched.
+func replaceGOSTBinary(cfg *config.Config, osInfo *system.OSInfo, skipChecksum bool) error {
+	binaryPath := cfg.GOST.BinaryPath
+	previous, _ := gost.NewInstaller(cfg, osInfo).GetInstalledVersion()
+
+	staged := *cfg
+	staged.GOST.BinaryPath = binaryPath + ".new"
+	defer os.Remove(staged.GOST.BinaryPath)
+
+	installer := gost.NewInstaller(&staged, osInfo)
+	installer.SetSkipChecksum(skipChecksum)
+	if err := installer.Install(); err != nil {
+		return fmt.Errorf("failed to install GOST: %w", err)
+	}
+
+	service := system.NewServiceManager()
+
+	wasActive := false
+	if status, err := service.Status(); err == nil && status.IsActive {
+		wasActive = true
+		ui.Action("Stopping service...")
+		if err := service.Stop(); err != nil {
+			return fmt.Errorf("failed to stop service: %w", err)
+		}
+	}
+
+	// Swap, keeping the previous binary until the new one is running
+	backup := binaryPath + ".backup"
+	hasBackup := system.FileExists(binaryPath)
+	if hasBackup {
+		if err := os.Rename(binaryPath, backup); err != nil {
+			return fmt.Errorf("failed to back up GOST binary: %w", err)
+		}
+	}
+	if err := os.Rename(staged.GOST.BinaryPath, binaryPath); err != nil {
+		if hasBackup {
+			_ = os.Rename(backup, binaryPath)
+		}
+		return fmt.Errorf("failed to replace GOST binary: %w", err)
+	}
+	ui.Success("GOST binary replaced: %s", binaryPath)
+
+	if wasActive {
+		ui.Action("Starting service...")
+		err := service.Start()
+		if err == nil {
+			err = gost.WaitForReady(service, cfg, gost.DefaultApplyTimeout)
+		}
+		if err != nil {
+			ui.Error("Service did not start with GOST v%s: %v", cfg.GOST.Version, err)
+			if !hasBackup {
+				return fmt.Errorf("service failed to start: %w", err)
+			}
+
+			ui.Action("Restoring previous GOST binary...")
+			if restoreErr := os.Rename(backup, binaryPath); restoreErr != nil {
+				return fmt.Errorf("GOST update failed and the previous binary could not be restored from %s: %w", backup, restoreErr)
+			}
+			if restartErr := service.Restart(); restartErr != nil {
+				return fmt.Errorf("GOST update failed and the service did not restart after rollback: %w", restartErr)
+			}
+			ui.Warning("Update rolled back, the service runs the previous GOST binary")
+			return fmt.Errorf("GOST v%s did not start: %w", cfg.GOST.Version, err)
+		}
+		ui.Success("Service started")
+	}
+
+	if hasBackup {
+		_ = os.Remove(backup)
+	}
+
+	if previous != "" && previous != cfg.GOST.Version {
+		if err := state.Update(func(s *state.State) { s.PreviousGOSTVersion = previous }); err != nil {
+			ui.Warning("Could not update state file: %v", err)
+		}
+	}
+
+	return nil
+}
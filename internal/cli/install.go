@@ -1,37 +1,112 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"wte/internal/config"
+	"wte/internal/fsutil"
 	"wte/internal/gost"
+	"wte/internal/logging"
 	"wte/internal/security"
+	"wte/internal/state"
 	"wte/internal/system"
 	"wte/internal/ui"
 )
 
+// installTestTimeout bounds the proxy test at the end of an install, so a
+// slow network doesn't hold it up
+const installTestTimeout = 5 * time.Second
+
 var (
+	installName           string
+	installCredsFile      string
 	installHTTPPort       int
 	installHTTPUser       string
 	installHTTPPass       string
 	installHTTPNoAuth     bool
 	installSSEnabled      bool
+	installSSUDP          bool
 	installSSPort         int
 	installSSPassword     string
 	installSSMethod       string
+	installSSPreset       string
 	installHTTPSEnabled   bool
 	installHTTPSPort      int
+	installHTTPSDomain    string
+	installHTTPSACME      bool
 	installGOSTVersion    string
+	installGOSTPrerelease bool
+	installSkipChecksum   bool
 	installSkipFirewall   bool
+	installLocalhostOnly  bool
+	installAllow          []string
+	installAllowOpenProxy bool
+	installSkipDownload   bool
+	installForceDownload  bool
+	installRunAsRoot      bool
+	installMemoryMax      string
+	installCPUQuota       string
+	installPassCharset    string
+	installForce          bool
+	installGOSTMirror     string
+	installGOSTArchive    string
+	installGOSTBinary     string
+	installLimitIn        string
+	installLimitOut       string
+	installMaxConns       int
+	installHTTPBind       string
+	installHTTPSBind      string
+	installSSBind         string
+	installFromConfig     string
+	installYes            bool
+	installReinstall      bool
+	installDryConfig      bool
 )
 
+// installFromConfigFlags are the flags that still apply with --from-config.
+// They control how WTE installs, not what it configures.
+var installFromConfigFlags = map[string]bool{
+	"from-config":        true,
+	"dry-config":         true,
+	"yes":                true,
+	"skip-firewall":      true,
+	"force":              true,
+	"gost-prerelease":    true,
+	"skip-gost-download": true,
+	"force-download":     true,
+	"gost-archive":       true,
+	"gost-binary":        true,
+	"skip-checksum":      true,
+}
+
 var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Install and configure GOST proxy server",
 	Long: `Install and configure GOST proxy server with HTTP, HTTPS, and Shadowsocks support.
 
+Once the service runs, a request is made through the HTTP (or HTTPS) proxy
+to check that it relays traffic. A failed test is reported with a warning,
+the credentials are printed either way.
+
+With --reinstall the existing WTE configuration is the starting point
+instead of the defaults: ports, passwords and other settings are kept and
+only the flags given on the command line change them.
+
+With --dry-config nothing is installed: the GOST configuration the install
+would write is printed to stdout, followed by the result of its
+validation, and the command fails if it is invalid. It needs no root.
+Passwords that are not given are generated for the output only.
+
 Examples:
   # Basic installation with defaults
   wte install
@@ -39,52 +114,145 @@ Examples:
   # Custom HTTP proxy port and user
   wte install --http-port 3128 --http-user admin
 
-  # Disable HTTP authentication
-  wte install --http-no-auth
+  # Disable HTTP authentication, only for clients from one network
+  wte install --http-no-auth --allow 203.0.113.0/24
 
   # HTTP proxy only (no Shadowsocks)
   wte install --ss-enabled=false
 
+  # Let WTE pick a strong Shadowsocks method and key
+  wte install --ss-preset secure
+
   # Enable HTTPS proxy
-  wte install --https-enabled`,
-	RunE: runInstall,
+  wte install --https-enabled
+
+  # HTTPS proxy for a domain pointing at this server
+  wte install --https-domain proxy.example.com
+
+  # Install the newest GOST release
+  wte install --gost-version latest
+
+  # Keep a GOST binary installed by a package manager
+  wte install --skip-gost-download
+
+  # Listen on 127.0.0.1 only, for access through an SSH tunnel to 127.0.0.1
+  wte install --localhost-only
+
+  # Run GOST as root instead of the dedicated gost user
+  wte install --run-as-root
+
+  # Cap GOST at 256 MB of memory and half a CPU
+  wte install --memory-max 256M --cpu-quota 50%
+
+  # Unattended install of a complete WTE config file
+  wte install --from-config /root/wte.yaml --skip-firewall --yes
+
+  # Print the GOST configuration these flags produce, for a bug report or CI
+  wte install --dry-config --http-port 3128 --ss-enabled=false
+
+  # Redeploy keeping the existing configuration, only moving the HTTP port
+  wte install --reinstall --http-port 3128`,
+	Annotations: map[string]string{dryRunAnnotation: "true"},
+	RunE:        runInstall,
 }
 
 func init() {
+	installCmd.Flags().StringVar(&installName, "name", "", "Server name shown in credentials and client exports (default: hostname)")
+	installCmd.Flags().StringVar(&installCredsFile, "creds-file", config.CredentialsFile, "File the credentials are saved to (~ is the home directory)")
+
 	// HTTP flags
 	installCmd.Flags().IntVar(&installHTTPPort, "http-port", config.DefaultHTTPPort, "HTTP proxy port")
+	installCmd.Flags().StringVar(&installHTTPBind, "http-bind", "", "Address the HTTP proxy listens on (default: all interfaces)")
 	installCmd.Flags().StringVar(&installHTTPUser, "http-user", config.DefaultUsername, "HTTP proxy username")
 	installCmd.Flags().StringVar(&installHTTPPass, "http-pass", "", "HTTP proxy password (auto-generated if empty)")
 	installCmd.Flags().BoolVar(&installHTTPNoAuth, "http-no-auth", false, "Disable HTTP proxy authentication")
+	installCmd.Flags().StringVar(&installPassCharset, "password-charset", config.DefaultPasswordCharset,
+		"Charset of generated passwords: "+strings.Join(security.PasswordCharsets, ", "))
 
 	// Shadowsocks flags
 	installCmd.Flags().BoolVar(&installSSEnabled, "ss-enabled", true, "Enable Shadowsocks")
 	installCmd.Flags().IntVar(&installSSPort, "ss-port", config.DefaultShadowsocksPort, "Shadowsocks port")
+	installCmd.Flags().BoolVar(&installSSUDP, "ss-udp", true, "Relay UDP over Shadowsocks, for QUIC and DNS")
+	installCmd.Flags().StringVar(&installSSBind, "ss-bind", "", "Address Shadowsocks listens on (default: all interfaces)")
 	installCmd.Flags().StringVar(&installSSPassword, "ss-password", "", "Shadowsocks password (auto-generated if empty)")
-	installCmd.Flags().StringVar(&installSSMethod, "ss-method", config.DefaultShadowsocksMethod, "Shadowsocks encryption method")
+	installCmd.Flags().StringVar(&installSSMethod, "ss-method", config.DefaultShadowsocksMethod, "Shadowsocks encryption method (2022-blake3-* methods need a base64 key)")
+	installCmd.Flags().StringVar(&installSSPreset, "ss-preset", "", "Pick the Shadowsocks method for you: "+strings.Join(security.SSPresetNames(), ", "))
+	installCmd.MarkFlagsMutuallyExclusive("ss-method", "ss-preset")
+	_ = installCmd.RegisterFlagCompletionFunc("ss-method", completeWords(config.ShadowsocksMethods))
+	_ = installCmd.RegisterFlagCompletionFunc("ss-preset", completeWords(security.SSPresetNames()))
 
 	// HTTPS flags
 	installCmd.Flags().BoolVar(&installHTTPSEnabled, "https-enabled", false, "Enable HTTPS proxy")
 	installCmd.Flags().IntVar(&installHTTPSPort, "https-port", config.DefaultHTTPSPort, "HTTPS proxy port")
+	installCmd.Flags().StringVar(&installHTTPSBind, "https-bind", "", "Address the HTTPS proxy listens on (default: all interfaces)")
+	installCmd.Flags().StringVar(&installHTTPSDomain, "https-domain", "", "Domain name for HTTPS (enables HTTPS)")
+	installCmd.Flags().BoolVar(&installHTTPSACME, "https-acme", true, "Obtain a Let's Encrypt certificate for --https-domain (falls back to self-signed)")
 
 	// Other flags
-	installCmd.Flags().StringVar(&installGOSTVersion, "gost-version", config.DefaultGOSTVersion, "GOST version to install")
+	installCmd.Flags().StringVar(&installGOSTVersion, "gost-version", config.DefaultGOSTVersion, "GOST version to install ('latest' for newest release)")
+	installCmd.Flags().BoolVar(&installGOSTPrerelease, "gost-prerelease", false, "Allow prereleases when resolving --gost-version latest")
+	installCmd.Flags().BoolVar(&installSkipChecksum, "skip-checksum", false, "Install a downloaded GOST release even if its checksums can't be fetched from GitHub")
+	installCmd.Flags().StringVar(&installGOSTMirror, "gost-mirror", "", "Download GOST from this mirror instead of GitHub (same layout: <mirror>/v<version>/<file>)")
+	installCmd.Flags().BoolVar(&installSkipDownload, "skip-gost-download", false, "Use the existing GOST binary even if its version differs")
+	installCmd.Flags().BoolVar(&installForceDownload, "force-download", false, "Download GOST even if the installed binary matches")
+	installCmd.Flags().StringVar(&installGOSTArchive, "gost-archive", "", "Install GOST from a local release archive (.tar.gz) instead of downloading it")
+	installCmd.Flags().StringVar(&installGOSTBinary, "gost-binary", "", "Install a local GOST binary instead of downloading a release")
+	installCmd.MarkFlagsMutuallyExclusive("skip-gost-download", "force-download")
+	installCmd.MarkFlagsMutuallyExclusive("gost-archive", "gost-binary", "skip-gost-download")
+	installCmd.MarkFlagsMutuallyExclusive("gost-archive", "gost-binary", "gost-mirror")
 	installCmd.Flags().BoolVar(&installSkipFirewall, "skip-firewall", false, "Skip firewall configuration")
+	installCmd.Flags().StringSliceVar(&installAllow, "allow", nil, "Only accept clients from these IPs/CIDRs (comma-separated)")
+	installCmd.Flags().BoolVar(&installAllowOpenProxy, "allow-open-proxy", false, "Allow --http-no-auth on a public address without --allow")
+	installCmd.Flags().BoolVar(&installLocalhostOnly, "localhost-only", false, "Bind all services to 127.0.0.1 (IPv4 only) for SSH tunnel access (skips firewall)")
+	for _, bind := range []string{"http-bind", "https-bind", "ss-bind"} {
+		installCmd.MarkFlagsMutuallyExclusive("localhost-only", bind)
+	}
+	installCmd.Flags().StringVar(&installMemoryMax, "memory-max", "", "Memory limit for the GOST service, e.g. 256M (systemd only)")
+	installCmd.Flags().StringVar(&installCPUQuota, "cpu-quota", "", "CPU limit for the GOST service, e.g. 50% (systemd only)")
+	installCmd.Flags().StringVar(&installLimitIn, "limit-in", "", "Bandwidth limit for client uploads per service, e.g. 10mbps")
+	installCmd.Flags().StringVar(&installLimitOut, "limit-out", "", "Bandwidth limit for client downloads per service, e.g. 10mbps")
+	installCmd.Flags().IntVar(&installMaxConns, "max-connections", 0, "Maximum concurrent connections per service (0 for no limit)")
+	installCmd.Flags().BoolVar(&installForce, "force", false, "Install even if a required port is already in use")
+	installCmd.Flags().BoolVar(&installRunAsRoot, "run-as-root", false, "Run GOST as root instead of the dedicated '"+config.DefaultGOSTUser+"' user")
+	installCmd.Flags().StringVar(&installFromConfig, "from-config", "", "Install the configuration in this WTE config file instead of the flags (implies --yes)")
+	installCmd.Flags().BoolVarP(&installYes, "yes", "y", false, "Answer yes to every question, for unattended installs")
+	installCmd.Flags().BoolVar(&installReinstall, "reinstall", false, "Keep the existing configuration, only the flags given change it")
+	installCmd.Flags().BoolVar(&installDryConfig, "dry-config", false, "Print the GOST configuration the install would write and validate it, without installing anything")
+	installCmd.MarkFlagsMutuallyExclusive("reinstall", "from-config")
 }
 
 func runInstall(cmd *cobra.Command, args []string) error {
-	// Check root
-	if err := checkRoot(); err != nil {
-		return err
+	if installDryConfig {
+		return runInstallDryConfig(cmd)
+	}
+
+	// Check root, a dry run only reads
+	if !dryRun {
+		if err := checkRoot(); err != nil {
+			return err
+		}
+	}
+
+	logging.Logger.Info("install started", "dry_run", dryRun, "from_config", installFromConfig)
+
+	if installYes || installFromConfig != "" {
+		ui.AssumeYes = true
 	}
 
 	// Print banner
 	ui.PrintBanner(Version)
 
+	if dryRun {
+		ui.Info("Dry run: showing what would be done, nothing will be changed")
+	}
+
 	// Total steps
 	totalSteps := 9
 	currentStep := 0
 
+	// A failed proxy test is reported again after the credentials
+	var proxyTestErr error
+
 	// Step 1: Detect OS
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Detecting operating system")
@@ -99,7 +267,10 @@ func runInstall(cmd *cobra.Command, args []string) error {
 	ui.Detail("Version: %s", osInfo.Version)
 	ui.Detail("Architecture: %s (%s)", osInfo.Arch, osInfo.GOSTArch)
 
-	if !osInfo.IsSupported {
+	if osInfo.Fallback {
+		ui.Warning("No /etc/os-release or /etc/redhat-release found, assuming generic Linux")
+		ui.Detail("Package manager: %s", osInfo.PackageManager)
+	} else if !osInfo.IsSupported {
 		ui.Warning("OS '%s' is not officially tested", osInfo.OS)
 	}
 
@@ -107,100 +278,157 @@ func runInstall(cmd *cobra.Command, args []string) error {
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Detecting public IP address")
 
-	publicIP, err := system.GetPublicIP()
+	publicIP := "YOUR_SERVER_IP"
+	detectPublicIPs := system.DetectPublicIPs
+	if dryRun {
+		// A dry run leaves the state file alone too
+		detectPublicIPs = system.LookupPublicIPs
+	}
+	publicIPs, err := detectPublicIPs()
 	if err != nil {
 		ui.Warning("Could not detect public IP: %v", err)
-		publicIP = "YOUR_SERVER_IP"
 	} else {
+		publicIP = publicIPs.Preferred()
 		ui.Success("Public IP detected: %s", publicIP)
+		if publicIPs.IPv4 != "" && publicIPs.IPv6 != "" {
+			ui.Detail("IPv6: %s", publicIPs.IPv6)
+		}
 	}
 
 	// Step 3: Prepare configuration
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Preparing configuration")
 
-	cfg := config.DefaultConfig()
+	cfg, err := installConfig(cmd)
+	if err != nil {
+		return err
+	}
 
-	// Apply command-line options
-	cfg.GOST.Version = installGOSTVersion
-	cfg.HTTP.Port = installHTTPPort
-	cfg.HTTP.Auth.Username = installHTTPUser
-	cfg.HTTP.Auth.Enabled = !installHTTPNoAuth
+	if installSkipFirewall {
+		cfg.Firewall.AutoConfigure = false
+	}
 
-	cfg.Shadowsocks.Enabled = installSSEnabled
-	cfg.Shadowsocks.Port = installSSPort
-	cfg.Shadowsocks.Method = installSSMethod
+	// Refuse an open proxy before anything is installed
+	prepareGen := gost.NewConfigGenerator(cfg)
+	if err := prepareGen.ValidateAllowList(); err != nil {
+		return err
+	}
+	if err := prepareGen.ValidateOpenProxy(); err != nil {
+		if installFromConfig != "" {
+			return fmt.Errorf("%w (set security.allow, bind to 127.0.0.1 or set security.allow_open_proxy)", err)
+		}
+		return fmt.Errorf("%w (use --allow, --localhost-only or --allow-open-proxy)", err)
+	}
+	if cfg.Security.AllowOpenProxy && len(prepareGen.OpenProxyServices()) > 0 {
+		ui.Warning("Running an OPEN PROXY: anyone on the internet can use it")
+	}
 
-	cfg.HTTPS.Enabled = installHTTPSEnabled
-	cfg.HTTPS.Port = installHTTPSPort
+	// Resolve the newest GOST release if requested
+	if cfg.GOST.Version == config.GOSTVersionLatest {
+		ui.Action("Resolving latest GOST version...")
 
-	cfg.Firewall.AutoConfigure = !installSkipFirewall
+		resolver := gost.NewInstaller(cfg, osInfo)
+		resolver.SetIncludePrerelease(installGOSTPrerelease)
+		resolver.SetNoStateWrites(dryRun)
 
-	// Generate passwords if needed
-	if cfg.HTTP.Auth.Enabled {
-		if installHTTPPass != "" {
-			cfg.HTTP.Auth.Password = installHTTPPass
-		} else {
-			pass, err := security.GeneratePassword(16)
-			if err != nil {
-				return fmt.Errorf("failed to generate HTTP password: %w", err)
-			}
-			cfg.HTTP.Auth.Password = pass
+		version, err := resolver.GetLatestVersion()
+		if err != nil {
+			return fmt.Errorf("failed to resolve latest GOST version: %w", err)
 		}
+
+		cfg.GOST.Version = version
+		ui.Success("Latest GOST version: %s", version)
 	}
 
-	if cfg.Shadowsocks.Enabled {
-		if installSSPassword != "" {
-			cfg.Shadowsocks.Password = installSSPassword
-		} else {
-			pass, err := security.GeneratePassword(16)
-			if err != nil {
-				return fmt.Errorf("failed to generate Shadowsocks password: %w", err)
-			}
-			cfg.Shadowsocks.Password = pass
-		}
+	if err := generateInstallPasswords(cfg); err != nil {
+		return err
 	}
 
-	// Use same password for HTTPS
-	cfg.HTTPS.Auth = cfg.HTTP.Auth
+	if installFromConfig != "" {
+		if err := gost.NewConfigGenerator(cfg).Validate(); err != nil {
+			return fmt.Errorf("invalid %s: %w", installFromConfig, err)
+		}
+	} else if err := prepareGen.ValidateShadowsocksKey(); err != nil {
+		return fmt.Errorf("%w (pass a matching --ss-password or omit it to generate one)", err)
+	}
 
 	ui.Success("Configuration prepared")
-	ui.Detail("HTTP Proxy: :%d (auth: %v)", cfg.HTTP.Port, cfg.HTTP.Auth.Enabled)
+	ui.Detail("HTTP Proxy: %s (auth: %v)", config.ListenAddr(cfg.HTTP.BindAddress, cfg.HTTP.Port), cfg.HTTP.Auth.Enabled)
 	if cfg.Shadowsocks.Enabled {
-		ui.Detail("Shadowsocks: :%d", cfg.Shadowsocks.Port)
+		ui.Detail("Shadowsocks: %s", config.ListenAddr(cfg.Shadowsocks.BindAddress, cfg.Shadowsocks.Port))
+		if len(cfg.Shadowsocks.Ports) > 0 {
+			ui.Detail("Shadowsocks ports: %s", cfg.Shadowsocks.PortList())
+		}
+		if installSSPreset != "" {
+			ui.Detail("Shadowsocks method: %s (preset '%s': %s)", cfg.Shadowsocks.Method,
+				installSSPreset, security.SSPresets[installSSPreset].Reason)
+		}
 	}
 	if cfg.HTTPS.Enabled {
-		ui.Detail("HTTPS Proxy: :%d", cfg.HTTPS.Port)
+		ui.Detail("HTTPS Proxy: %s", config.ListenAddr(cfg.HTTPS.BindAddress, cfg.HTTPS.Port))
+	}
+	if cfg.LocalhostOnly() {
+		ui.Detail("Access: localhost only, via SSH tunnel")
+	}
+
+	service := system.NewServiceManager()
+
+	// A gost service set up before WTE would be overwritten, and may hold
+	// the ports checked next
+	if err := takeOverForeignService(service); err != nil {
+		return err
+	}
+
+	// A port taken by another process leaves GOST crash-looping
+	if conflicts := portConflicts(cfg); len(conflicts) > 0 {
+		var list []string
+		for _, port := range conflicts {
+			list = append(list, fmt.Sprintf("%d/%s (%s)", port.Port, port.Protocol, port.Service))
+		}
+		if !installForce {
+			ui.Detail("Check with: ss -tulnp")
+			return fmt.Errorf("ports already in use: %s (free them, pick other ports or use --force)", strings.Join(list, ", "))
+		}
+		ui.Warning("Ports already in use: %s", strings.Join(list, ", "))
 	}
 
 	// Step 4: Check existing installation
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Checking existing installation")
 
-	systemd := system.NewSystemdManager()
 	installer := gost.NewInstaller(cfg, osInfo)
 
 	if installer.IsInstalled() {
 		ui.Warning("Existing GOST installation detected")
 
 		// Stop service if running
-		status, _ := systemd.Status()
+		status, _ := service.Status()
 		if status != nil && status.IsActive {
-			ui.Action("Stopping existing service...")
-			if err := systemd.Stop(); err != nil {
-				ui.Warning("Could not stop service: %v", err)
+			if dryRun {
+				planAction("stop the running service")
 			} else {
-				ui.Success("Service stopped")
+				ui.Action("Stopping existing service...")
+				if err := service.Stop(); err != nil {
+					ui.Warning("Could not stop service: %v", err)
+				} else {
+					ui.Success("Service stopped")
+				}
 			}
 		}
 
 		// Backup config
-		configGen := gost.NewConfigGenerator(cfg)
-		backupPath, err := configGen.Backup()
-		if err != nil {
-			ui.Warning("Could not backup configuration: %v", err)
-		} else if backupPath != "" {
-			ui.Success("Configuration backed up: %s", backupPath)
+		if dryRun {
+			if system.FileExists(cfg.GOST.ConfigFile) {
+				planAction("back up %s", cfg.GOST.ConfigFile)
+			}
+		} else {
+			configGen := gost.NewConfigGenerator(cfg)
+			backupPath, err := configGen.Backup()
+			if err != nil {
+				ui.Warning("Could not backup configuration: %v", err)
+			} else if backupPath != "" {
+				ui.Success("Configuration backed up: %s", backupPath)
+			}
 		}
 	} else {
 		ui.Success("No existing installation found")
@@ -210,87 +438,156 @@ func runInstall(cmd *cobra.Command, args []string) error {
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Installing GOST")
 
-	if err := installer.Install(); err != nil {
+	reuse, err := reuseExistingGOST(cfg, installer)
+	if err != nil {
+		return err
+	}
+
+	// A local file is installed as is, its version becomes gost.version
+	localGOST := installGOSTArchive != "" || installGOSTBinary != ""
+	installer.SetLocalArchive(installGOSTArchive)
+	installer.SetLocalBinary(installGOSTBinary)
+	installer.SetSkipChecksum(installSkipChecksum)
+
+	if reuse {
+		ui.Success("Using existing GOST binary: %s", cfg.GOST.BinaryPath)
+	} else if dryRun {
+		if localGOST {
+			planAction("install GOST from %s", installer.Source())
+		} else {
+			planAction("download %s", installer.Source())
+		}
+		planAction("install the GOST binary to %s", cfg.GOST.BinaryPath)
+	} else if err := installer.Install(); err != nil {
+		if errors.Is(err, gost.ErrChecksumsUnavailable) {
+			ui.Detail("Pass --skip-checksum to install without verifying the download")
+		}
 		return fmt.Errorf("failed to install GOST: %w", err)
+	} else if localGOST {
+		if version, err := installer.GetInstalledVersion(); err == nil {
+			cfg.GOST.Version = version
+		}
 	}
 
 	// Step 6: Generate TLS certificates (if HTTPS enabled)
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Generating TLS certificates")
 
-	if cfg.HTTPS.Enabled {
-		ui.Action("Generating self-signed certificate...")
-
-		certOpts := security.DefaultCertificateOptions(publicIP)
-		certOpts.CertPath = cfg.HTTPS.CertPath
-		certOpts.KeyPath = cfg.HTTPS.KeyPath
-
-		if err := security.GenerateSelfSignedCert(certOpts); err != nil {
-			return fmt.Errorf("failed to generate certificate: %w", err)
+	if cfg.HTTPS.Enabled && dryRun {
+		if cfg.HTTPS.Domain != "" && cfg.HTTPS.ACME {
+			planAction("request a certificate for %s from Let's Encrypt", cfg.HTTPS.Domain)
+		} else {
+			planAction("generate a self-signed certificate")
 		}
-
-		ui.Success("TLS certificate generated")
 		ui.Detail("Certificate: %s", cfg.HTTPS.CertPath)
 		ui.Detail("Private key: %s", cfg.HTTPS.KeyPath)
+	} else if cfg.HTTPS.Enabled {
+		if err := generateHTTPSCertificate(cfg, publicIP); err != nil {
+			return err
+		}
 	} else {
 		ui.Success("HTTPS disabled, skipping certificate generation")
 	}
 
-	// Step 7: Generate GOST configuration
+	// Step 7: Create service
 	currentStep++
-	ui.Step(currentStep, totalSteps, "Generating GOST configuration")
+	ui.Step(currentStep, totalSteps, "Creating service")
 
-	configGen := gost.NewConfigGenerator(cfg)
-
-	if err := configGen.Validate(); err != nil {
+	if err := gost.NewConfigGenerator(cfg).Validate(); err != nil {
 		return fmt.Errorf("configuration validation failed: %w", err)
 	}
 
-	if err := configGen.Generate(); err != nil {
-		return fmt.Errorf("failed to generate configuration: %w", err)
-	}
+	if dryRun {
+		if !cfg.GOST.RunsAsRoot() {
+			planAction("create the user %s and give it %s", cfg.GOST.RunAsUser, cfg.GOST.ConfigDir)
+		}
+		planAction("write the service definition %s", service.DefinitionPath())
+		planAction("enable the service for autostart")
+	} else {
+		if !cfg.GOST.RunsAsRoot() {
+			ui.Action("Preparing service user %s...", cfg.GOST.RunAsUser)
+			if err := gost.PrepareServiceUser(cfg); err != nil {
+				return fmt.Errorf("failed to prepare service user: %w", err)
+			}
+		}
 
-	// Save WTE configuration
-	if err := config.SaveTo(config.WTEConfigFile); err != nil {
-		ui.Warning("Could not save WTE configuration: %v", err)
-	}
+		if err := service.Create(cfg); err != nil {
+			return fmt.Errorf("failed to create service: %w", err)
+		}
 
-	// Step 8: Create and start systemd service
-	currentStep++
-	ui.Step(currentStep, totalSteps, "Creating systemd service")
+		ui.Success("Service created")
 
-	if err := systemd.CreateService(cfg); err != nil {
-		return fmt.Errorf("failed to create systemd service: %w", err)
+		ui.Action("Enabling service for autostart...")
+		if err := service.Enable(); err != nil {
+			return fmt.Errorf("failed to enable service: %w", err)
+		}
 	}
 
-	ui.Success("Systemd service created")
+	// Step 8: Apply GOST configuration and start the service
+	currentStep++
+	ui.Step(currentStep, totalSteps, "Applying configuration")
+
+	if dryRun {
+		planAction("write the WTE configuration %s", config.WTEConfigFile)
+		planAction("write the GOST configuration %s", cfg.GOST.ConfigFile)
+		planAction("restart the service and wait until it accepts connections")
+		if cfg.Security.AuthLockout.Enabled {
+			planAction("start the auth lockout watcher")
+		}
+		if cfg.Watchdog.Enabled {
+			planAction("start the watchdog")
+		}
+	} else {
+		// Save WTE configuration
+		config.SetConfig(cfg)
+		savedConfig := true
+		if err := config.SaveTo(config.WTEConfigFile); err != nil {
+			ui.Warning("Could not save WTE configuration: %v", err)
+			savedConfig = false
+		}
 
-	ui.Action("Reloading systemd daemon...")
-	if err := systemd.DaemonReload(); err != nil {
-		return fmt.Errorf("failed to reload systemd: %w", err)
-	}
+		recordAudit("install", "gost.version", nil, cfg.GOST.Version)
 
-	ui.Action("Enabling service for autostart...")
-	if err := systemd.Enable(); err != nil {
-		return fmt.Errorf("failed to enable service: %w", err)
-	}
+		if err := gost.ApplyTransaction(cfg, gost.DefaultApplyTimeout); err != nil {
+			return err
+		}
 
-	ui.Action("Starting service...")
-	if err := systemd.Start(); err != nil {
-		return fmt.Errorf("failed to start service: %w", err)
-	}
+		if savedConfig {
+			if err := config.RecordApplied(); err != nil {
+				ui.Warning("Could not record applied configuration: %v", err)
+			}
+		}
 
-	ui.Success("Service started")
+		if cfg.Security.AuthLockout.Enabled {
+			ui.Action("Starting auth lockout watcher...")
+			if err := syncLockoutService(cfg, service); err != nil {
+				ui.Warning("Could not start auth lockout watcher: %v", err)
+			} else {
+				ui.Success("Auth lockout watcher started")
+			}
+		}
 
-	// Verify service status
-	status, err := systemd.Status()
-	if err != nil {
-		ui.Warning("Could not get service status: %v", err)
-	} else if status.IsActive {
-		ui.Detail("PID: %s", status.MainPID)
-		if status.MemoryUsage != "" {
-			ui.Detail("Memory: %s", status.MemoryUsage)
+		if cfg.Watchdog.Enabled {
+			ui.Action("Starting watchdog...")
+			if err := syncWatchdogService(cfg, service); err != nil {
+				ui.Warning("Could not start watchdog: %v", err)
+			} else {
+				ui.Success("Watchdog started")
+			}
 		}
+
+		// Verify service status
+		status, err := service.Status()
+		if err != nil {
+			ui.Warning("Could not get service status: %v", err)
+		} else if status.IsActive {
+			ui.Detail("PID: %s", status.MainPID)
+			if status.MemoryUsage != "" {
+				ui.Detail("Memory: %s", status.MemoryUsage)
+			}
+		}
+
+		proxyTestErr = testInstalledProxy(cfg)
 	}
 
 	// Step 9: Configure firewall
@@ -302,33 +599,473 @@ func runInstall(cmd *cobra.Command, args []string) error {
 
 		ui.Action("Detected firewall: %s", firewall.GetType())
 
-		if err := firewall.OpenPorts(cfg); err != nil {
+		// Rules the firewall already has are not recorded, uninstall
+		// leaves them in place
+		var missing []state.Port
+		for _, port := range firewallRules(cfg) {
+			if !firewall.IsPortAllowedFrom(port.Port, port.Protocol, port.Source) {
+				missing = append(missing, port)
+			}
+		}
+
+		if dryRun {
+			for _, port := range firewallRules(cfg) {
+				planAction("open port %s", describePort(port))
+			}
+		} else if err := firewall.OpenPorts(cfg); err != nil {
 			ui.Warning("Failed to configure firewall: %v", err)
 			ui.Detail("Please manually open required ports")
 		} else {
 			ui.Success("Firewall configured")
-			for _, port := range cfg.GetRequiredPorts() {
-				ui.Detail("Port %d/%s opened", port.Port, port.Protocol)
+			for _, port := range firewallRules(cfg) {
+				ui.Detail("Port %s opened", describePort(port))
+			}
+			if err := recordOpenedPorts(missing, nil); err != nil {
+				ui.Warning("Could not record opened ports: %v", err)
 			}
 		}
+	} else if cfg.LocalhostOnly() {
+		ui.Success("Services listen on localhost only, firewall configuration skipped")
 	} else {
 		ui.Success("Firewall configuration skipped")
 	}
 
 	// Save credentials
 	credsMgr := gost.NewCredentialsManager(cfg, publicIP)
+
+	if dryRun {
+		planAction("save the credentials to %s", credsMgr.GetPath())
+		ui.Println()
+		ui.Success("Dry run finished, nothing was changed")
+		return nil
+	}
+
 	if err := credsMgr.Save(); err != nil {
 		ui.Warning("Could not save credentials file: %v", err)
 	} else {
 		ui.Success("Credentials saved to: %s", credsMgr.GetPath())
 	}
 
+	if err := state.Update(func(s *state.State) { s.InstalledAt = state.Now() }); err != nil {
+		ui.Warning("Could not update state file: %v", err)
+	}
+
+	var ports []string
+	for _, port := range cfg.GetRequiredPorts() {
+		ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+	}
+	logging.Logger.Info("install finished",
+		"gost_version", cfg.GOST.Version,
+		"public_ip", publicIP,
+		"ports", strings.Join(ports, ","))
+
 	// Print summary
 	printInstallSummary(cfg, publicIP)
 
+	// Repeat the failed proxy test below the credentials, where it is seen
+	if proxyTestErr != nil {
+		ui.Warning("The proxy did not relay a test request: %v", proxyTestErr)
+		ui.Detail("The credentials above may not work yet, check 'wte logs' and run 'wte test'")
+	}
+
+	return nil
+}
+
+// testInstalledProxy fetches a page through the first HTTP or HTTPS proxy
+// to check that the new service relays traffic. Shadowsocks-only installs
+// are left to the readiness check, there is no client to test with.
+func testInstalledProxy(cfg *config.Config) error {
+	for _, test := range serviceTests(cfg) {
+		if test.TCPOnly {
+			continue
+		}
+
+		ui.Action("Testing the %s proxy...", test.Name)
+		egressIP, err := runServiceTest(test, config.DialHost(test.Bind), installTestTimeout)
+		if err != nil {
+			ui.Warning("%s proxy test failed: %v", test.Name, err)
+			return fmt.Errorf("%s proxy: %w", test.Name, err)
+		}
+		ui.Success("%s proxy works, traffic leaves from %s", test.Name, egressIP)
+		return nil
+	}
+	return nil
+}
+
+// installConfig builds the configuration to install from --from-config,
+// the existing configuration with --reinstall, or the flags
+func installConfig(cmd *cobra.Command) (*config.Config, error) {
+	switch {
+	case installFromConfig != "":
+		return installConfigFromFile(cmd, installFromConfig)
+	case installReinstall:
+		return installConfigForReinstall(cmd)
+	}
+	return installConfigFromFlags(cmd, nil)
+}
+
+// generateInstallPasswords generates the passwords that weren't given
+func generateInstallPasswords(cfg *config.Config) error {
+	if cfg.HTTP.Auth.Enabled && cfg.HTTP.Auth.Password == "" {
+		pass, err := security.GeneratePasswordWithCharset(security.DefaultPasswordLength, cfg.Security.PasswordCharset)
+		if err != nil {
+			return fmt.Errorf("failed to generate HTTP password: %w", err)
+		}
+		cfg.HTTP.Auth.Password = pass
+	}
+
+	// HTTPS uses the HTTP password unless it has its own
+	if cfg.HTTPS.Auth.Enabled && cfg.HTTPS.Auth.Password == "" {
+		cfg.HTTPS.Auth.Password = cfg.HTTP.Auth.Password
+		if cfg.HTTPS.Auth.Password == "" {
+			pass, err := security.GeneratePasswordWithCharset(security.DefaultPasswordLength, cfg.Security.PasswordCharset)
+			if err != nil {
+				return fmt.Errorf("failed to generate HTTPS password: %w", err)
+			}
+			cfg.HTTPS.Auth.Password = pass
+		}
+	}
+
+	if cfg.Shadowsocks.Enabled && cfg.Shadowsocks.Password == "" {
+		pass, err := security.GenerateSSPassword(cfg.Shadowsocks.Method, security.DefaultPasswordLength, cfg.Security.PasswordCharset)
+		if err != nil {
+			return fmt.Errorf("failed to generate Shadowsocks password: %w", err)
+		}
+		cfg.Shadowsocks.Password = pass
+	}
+
 	return nil
 }
 
+// runInstallDryConfig prints the GOST configuration the install would
+// write, followed by the result of its validation. Nothing is written.
+func runInstallDryConfig(cmd *cobra.Command) error {
+	// Only the GOST configuration goes to stdout
+	ui.SetQuiet(true)
+
+	cfg, err := installConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := generateInstallPasswords(cfg); err != nil {
+		return err
+	}
+
+	gen := gost.NewConfigGenerator(cfg)
+	rendered, err := gen.Render()
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(rendered))
+
+	if err := gen.Validate(); err != nil {
+		fmt.Printf("\n# Validation failed: %v\n", err)
+		return fmt.Errorf("generated configuration is invalid: %w", err)
+	}
+	fmt.Println("\n# Validation: OK")
+
+	return nil
+}
+
+// installConfigForReinstall builds the configuration of a reinstall: the
+// existing configuration, changed only by the flags given
+func installConfigForReinstall(cmd *cobra.Command) (*config.Config, error) {
+	path := config.GetConfigPath()
+
+	existing, err := config.LoadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		ui.Warning("No existing configuration at %s, installing from the flags", path)
+		return installConfigFromFlags(cmd, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ui.Info("Reinstalling with the configuration in %s", path)
+	return installConfigFromFlags(cmd, existing)
+}
+
+// installConfigFromFlags builds the configuration to install from the
+// command-line flags. For a reinstall, existing is the configuration to
+// keep and only the flags given change it.
+func installConfigFromFlags(cmd *cobra.Command, existing *config.Config) (*config.Config, error) {
+	cfg := config.DefaultConfig()
+	if existing != nil {
+		cfg = existing
+	}
+
+	// set reports whether a setting is taken from its flags
+	set := func(flags ...string) bool {
+		if existing == nil {
+			return true
+		}
+		for _, flag := range flags {
+			if cmd.Flags().Changed(flag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := config.ValidateServerName(installName); err != nil {
+		return nil, fmt.Errorf("invalid --name: %w", err)
+	}
+	if installName != "" {
+		cfg.Server.Name = installName
+	}
+	if err := config.ValidateCredentialsPath(installCredsFile); err != nil {
+		return nil, fmt.Errorf("invalid --creds-file: %w", err)
+	}
+	if set("creds-file") {
+		cfg.Credentials.Path = installCredsFile
+	}
+	if set("gost-version") {
+		cfg.GOST.Version = installGOSTVersion
+	}
+	if err := config.ValidateDownloadMirror(installGOSTMirror); err != nil {
+		return nil, fmt.Errorf("invalid --gost-mirror: %w", err)
+	}
+	if set("gost-mirror") {
+		cfg.GOST.DownloadMirror = installGOSTMirror
+	}
+	for _, path := range []string{installGOSTArchive, installGOSTBinary} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("GOST file not usable: %w", err)
+		}
+	}
+	if installRunAsRoot {
+		cfg.GOST.RunAsUser = "root"
+	}
+	if err := config.ValidateMemoryMax(installMemoryMax); err != nil {
+		return nil, err
+	}
+	if err := config.ValidateCPUQuota(installCPUQuota); err != nil {
+		return nil, err
+	}
+	if set("memory-max") {
+		cfg.GOST.MemoryMax = installMemoryMax
+	}
+	if set("cpu-quota") {
+		cfg.GOST.CPUQuota = installCPUQuota
+	}
+	if set("http-port") {
+		cfg.HTTP.Port = installHTTPPort
+	}
+	if set("http-user") {
+		cfg.HTTP.Auth.Username = installHTTPUser
+	}
+	if set("http-no-auth") {
+		cfg.HTTP.Auth.Enabled = !installHTTPNoAuth
+	}
+	if set("http-pass") {
+		cfg.HTTP.Auth.Password = installHTTPPass
+	}
+	if set("http-user", "http-no-auth", "http-pass") {
+		cfg.HTTPS.Auth = cfg.HTTP.Auth
+	}
+
+	if err := security.ValidatePasswordCharset(installPassCharset); err != nil {
+		return nil, fmt.Errorf("invalid --password-charset: %w", err)
+	}
+	if set("password-charset") {
+		cfg.Security.PasswordCharset = installPassCharset
+	}
+
+	if set("ss-enabled") {
+		cfg.Shadowsocks.Enabled = installSSEnabled
+	}
+	if set("ss-port") {
+		cfg.Shadowsocks.Port = installSSPort
+	}
+	if set("ss-udp") {
+		cfg.Shadowsocks.UDP = installSSUDP
+	}
+	if set("ss-password") {
+		cfg.Shadowsocks.Password = installSSPassword
+	}
+	if set("ss-method") {
+		cfg.Shadowsocks.Method = installSSMethod
+	}
+	if installSSPreset != "" {
+		preset, ok := security.SSPresets[installSSPreset]
+		if !ok {
+			return nil, fmt.Errorf("unknown --ss-preset %q (valid: %s)", installSSPreset, strings.Join(security.SSPresetNames(), ", "))
+		}
+		cfg.Shadowsocks.Method = preset.Method
+	}
+	if err := config.ValidateShadowsocksMethod(cfg.Shadowsocks.Method); err != nil {
+		return nil, fmt.Errorf("invalid --ss-method: %w", err)
+	}
+
+	if set("https-enabled") {
+		cfg.HTTPS.Enabled = installHTTPSEnabled
+	}
+	if set("https-port") {
+		cfg.HTTPS.Port = installHTTPSPort
+	}
+
+	// A domain means HTTPS with a certificate for that name, no need for --https-enabled
+	if installHTTPSDomain != "" {
+		if err := config.ValidateHostname(installHTTPSDomain); err != nil {
+			return nil, fmt.Errorf("invalid --https-domain: %w", err)
+		}
+		cfg.HTTPS.Domain = strings.TrimSuffix(installHTTPSDomain, ".")
+		cfg.HTTPS.Enabled = true
+		cfg.HTTPS.ACME = installHTTPSACME
+	} else if cmd.Flags().Changed("https-acme") && installHTTPSACME {
+		return nil, fmt.Errorf("--https-acme requires --https-domain")
+	}
+
+	for flag, rate := range map[string]string{"--limit-in": installLimitIn, "--limit-out": installLimitOut} {
+		if _, err := config.ParseRate(rate); rate != "" && err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", flag, err)
+		}
+	}
+	if installMaxConns < 0 {
+		return nil, fmt.Errorf("invalid --max-connections: must not be negative")
+	}
+	for _, limiter := range []*config.LimiterConfig{&cfg.HTTP.Limiter, &cfg.HTTPS.Limiter, &cfg.Shadowsocks.Limiter} {
+		if set("limit-in") {
+			limiter.In = installLimitIn
+		}
+		if set("limit-out") {
+			limiter.Out = installLimitOut
+		}
+		if set("max-connections") {
+			limiter.MaxConnections = installMaxConns
+		}
+	}
+
+	if set("allow") {
+		cfg.Security.Allow = installAllow
+	}
+	if set("allow-open-proxy") {
+		cfg.Security.AllowOpenProxy = installAllowOpenProxy
+	}
+
+	for flag, bind := range map[string]string{"--http-bind": installHTTPBind, "--https-bind": installHTTPSBind, "--ss-bind": installSSBind} {
+		if err := system.CheckBindAddress(bind); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", flag, err)
+		}
+	}
+	if set("http-bind") {
+		cfg.HTTP.BindAddress = installHTTPBind
+	}
+	if set("https-bind") {
+		cfg.HTTPS.BindAddress = installHTTPSBind
+	}
+	if set("ss-bind") {
+		cfg.Shadowsocks.BindAddress = installSSBind
+	}
+
+	// Nothing is exposed publicly, so there are no ports to open
+	if installLocalhostOnly {
+		cfg.HTTP.BindAddress = config.LocalhostBindAddress
+		cfg.HTTPS.BindAddress = config.LocalhostBindAddress
+		cfg.Shadowsocks.BindAddress = config.LocalhostBindAddress
+		cfg.Relay.BindAddress = config.LocalhostBindAddress
+		cfg.Firewall.AutoConfigure = false
+	}
+
+	return cfg, nil
+}
+
+// installConfigFromFile loads the configuration to install from a WTE
+// config file. Empty passwords are generated later, like with the flags.
+func installConfigFromFile(cmd *cobra.Command, path string) (*config.Config, error) {
+	var ignored []string
+	cmd.NonInheritedFlags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed && !installFromConfigFlags[flag.Name] {
+			ignored = append(ignored, "--"+flag.Name)
+		}
+	})
+	if len(ignored) > 0 {
+		return nil, fmt.Errorf("%s can't be combined with --from-config, set it in %s instead", strings.Join(ignored, ", "), path)
+	}
+
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := security.ValidatePasswordCharset(cfg.Security.PasswordCharset); err != nil {
+		return nil, fmt.Errorf("invalid security.password_charset: %w", err)
+	}
+	if err := config.ValidateDownloadMirror(cfg.GOST.DownloadMirror); err != nil {
+		return nil, fmt.Errorf("invalid gost.download_mirror: %w", err)
+	}
+	if err := config.ValidateMemoryMax(cfg.GOST.MemoryMax); err != nil {
+		return nil, err
+	}
+	if err := config.ValidateCPUQuota(cfg.GOST.CPUQuota); err != nil {
+		return nil, err
+	}
+	if cfg.HTTPS.Domain != "" {
+		if err := config.ValidateHostname(cfg.HTTPS.Domain); err != nil {
+			return nil, fmt.Errorf("invalid https.domain: %w", err)
+		}
+	}
+
+	binds := map[string]string{
+		"http.bind_address":        cfg.HTTP.BindAddress,
+		"https.bind_address":       cfg.HTTPS.BindAddress,
+		"shadowsocks.bind_address": cfg.Shadowsocks.BindAddress,
+	}
+	for key, bind := range binds {
+		if err := system.CheckBindAddress(bind); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	ui.Success("Configuration loaded: %s", path)
+
+	return cfg, nil
+}
+
+// reuseExistingGOST decides whether install keeps the GOST binary already at
+// the configured path. A working binary of the configured version is reused
+// unless --force-download is given; --skip-gost-download reuses any working
+// binary and only warns about a version mismatch.
+func reuseExistingGOST(cfg *config.Config, installer *gost.Installer) (bool, error) {
+	if installForceDownload || installGOSTArchive != "" || installGOSTBinary != "" {
+		return false, nil
+	}
+
+	if !installer.IsInstalled() {
+		if installSkipDownload {
+			return false, fmt.Errorf("--skip-gost-download: no GOST binary at %s", cfg.GOST.BinaryPath)
+		}
+		return false, nil
+	}
+
+	installed, err := installer.GetInstalledVersion()
+	if err != nil {
+		if installSkipDownload {
+			return false, fmt.Errorf("--skip-gost-download: existing GOST binary does not work: %w", err)
+		}
+		ui.Warning("Existing GOST binary does not work, downloading a new one: %v", err)
+		return false, nil
+	}
+
+	ui.Detail("Installed GOST version: %s", installed)
+
+	if installed == cfg.GOST.Version {
+		return true, nil
+	}
+
+	if installSkipDownload {
+		ui.Warning("Existing GOST binary is v%s, but gost.version is %s", installed, cfg.GOST.Version)
+		ui.Detail("The configuration may use features this version does not support")
+		return true, nil
+	}
+
+	return false, nil
+}
+
 func printInstallSummary(cfg *config.Config, publicIP string) {
 	ui.Println()
 	ui.Green.Println("╔══════════════════════════════════════════════════════════════════════════════╗")
@@ -336,10 +1073,18 @@ func printInstallSummary(cfg *config.Config, publicIP string) {
 	ui.Green.Println("╚══════════════════════════════════════════════════════════════════════════════╝")
 	ui.Println()
 
+	host := gost.ClientHost(cfg, publicIP)
+
+	if cfg.LocalhostOnly() {
+		ui.White.Println("Accessible via SSH tunnel. Open it on your machine with:")
+		ui.Printf("  %s\n", gost.SSHTunnelCommand(cfg, publicIP))
+		ui.Println()
+	}
+
 	// HTTP Proxy
 	if cfg.HTTP.Enabled {
 		ui.PrintCredentialsBox("HTTP PROXY", map[string]string{
-			"Host":     publicIP,
+			"Host":     host,
 			"Port":     fmt.Sprintf("%d", cfg.HTTP.Port),
 			"Username": cfg.HTTP.Auth.Username,
 			"Password": cfg.HTTP.Auth.Password,
@@ -348,24 +1093,153 @@ func printInstallSummary(cfg *config.Config, publicIP string) {
 
 	// Shadowsocks
 	if cfg.Shadowsocks.Enabled {
-		ui.PrintCredentialsBox("SHADOWSOCKS", map[string]string{
-			"Server":   publicIP,
-			"Port":     fmt.Sprintf("%d", cfg.Shadowsocks.Port),
+		fields := map[string]string{
+			"Server":   host,
+			"Port":     cfg.Shadowsocks.PortList(),
 			"Password": cfg.Shadowsocks.Password,
 			"Method":   cfg.Shadowsocks.Method,
-		})
+		}
+		if cfg.Shadowsocks.Plugin.Name != "" {
+			fields["Plugin"] = cfg.Shadowsocks.Plugin.String()
+		}
+		ui.PrintCredentialsBox("SHADOWSOCKS", fields)
 	}
 
 	ui.Println()
 	ui.White.Println("Quick Commands:")
 	if cfg.HTTP.Auth.Enabled {
-		ui.Printf("  Test:    curl -x http://%s:%s@%s:%d https://ifconfig.me\n",
-			cfg.HTTP.Auth.Username, cfg.HTTP.Auth.Password, publicIP, cfg.HTTP.Port)
+		proxyURL := url.URL{
+			Scheme: "http",
+			User:   url.UserPassword(cfg.HTTP.Auth.Username, cfg.HTTP.Auth.Password),
+			Host:   net.JoinHostPort(host, strconv.Itoa(cfg.HTTP.Port)),
+		}
+		ui.Printf("  Test:    curl -x '%s' https://ifconfig.me\n", proxyURL.String())
 	} else {
-		ui.Printf("  Test:    curl -x http://%s:%d https://ifconfig.me\n",
-			publicIP, cfg.HTTP.Port)
+		ui.Printf("  Test:    curl -x http://%s https://ifconfig.me\n",
+			net.JoinHostPort(host, strconv.Itoa(cfg.HTTP.Port)))
 	}
 	ui.Printf("  Status:  wte status\n")
 	ui.Printf("  Logs:    wte logs -f\n")
 	ui.Println()
 }
+
+// generateHTTPSCertificate creates the certificate for the HTTPS proxy. With
+// a domain configured the certificate is issued for the domain, otherwise
+// for the server's IP address.
+func generateHTTPSCertificate(cfg *config.Config, publicIP string) error {
+	certOpts := security.DefaultCertificateOptions(publicIP)
+	certOpts.CertPath = cfg.HTTPS.CertPath
+	certOpts.KeyPath = cfg.HTTPS.KeyPath
+
+	if cfg.HTTPS.Domain != "" && cfg.HTTPS.ACME {
+		ui.Action("Requesting certificate for %s from Let's Encrypt...", cfg.HTTPS.Domain)
+
+		err := issueACMECertificate(cfg)
+		if err == nil {
+			ui.Success("TLS certificate issued for %s", cfg.HTTPS.Domain)
+			ui.Detail("Certificate: %s", cfg.HTTPS.CertPath)
+			ui.Detail("Private key: %s", cfg.HTTPS.KeyPath)
+			return nil
+		}
+
+		ui.Warning("Could not obtain a certificate: %v", err)
+		ui.Detail("Check that %s resolves to this server and port 80 is reachable", cfg.HTTPS.Domain)
+		ui.Detail("Falling back to a self-signed certificate")
+	}
+
+	if cfg.HTTPS.Domain != "" {
+		certOpts.CommonName = cfg.HTTPS.Domain
+		certOpts.DNSNames = append(certOpts.DNSNames, cfg.HTTPS.Domain)
+		ui.Action("Generating self-signed certificate for %s...", cfg.HTTPS.Domain)
+	} else {
+		ui.Action("Generating self-signed certificate...")
+	}
+
+	if err := security.GenerateSelfSignedCert(certOpts); err != nil {
+		return fmt.Errorf("failed to generate certificate: %w", err)
+	}
+
+	ui.Success("TLS certificate generated")
+	ui.Detail("Certificate: %s", cfg.HTTPS.CertPath)
+	ui.Detail("Private key: %s", cfg.HTTPS.KeyPath)
+
+	return nil
+}
+
+// takeOverForeignService checks for a gost service that WTE did not
+// create, such as one set up by hand. Installing replaces it, so the user
+// is asked first; taking over stops it and backs up its definition.
+func takeOverForeignService(service system.ServiceManager) error {
+	path := service.ForeignDefinition()
+	if path == "" {
+		return nil
+	}
+
+	ui.Warning("Found a gost service not created by WTE: %s", path)
+	ui.Detail("Installing replaces it with the WTE service and GOST configuration")
+	if !ui.Confirm("Take over the existing gost service?") {
+		return fmt.Errorf("installation aborted, the existing gost service was left as is")
+	}
+
+	status, _ := service.Status()
+	running := status != nil && status.IsActive
+	// A packaged unit outside /etc is overridden rather than overwritten
+	overwritten := path == service.DefinitionPath()
+
+	if dryRun {
+		if running {
+			planAction("stop the existing gost service")
+		}
+		if overwritten {
+			planAction("back up %s", path)
+		}
+		return nil
+	}
+
+	if running {
+		ui.Action("Stopping existing gost service...")
+		if err := service.Stop(); err != nil {
+			return fmt.Errorf("failed to stop the existing gost service: %w", err)
+		}
+		ui.Success("Service stopped")
+	}
+
+	if overwritten {
+		backupPath, err := fsutil.Backup(path)
+		if err != nil {
+			return fmt.Errorf("failed to back up the existing service: %w", err)
+		}
+		ui.Success("Service definition backed up: %s", backupPath)
+	}
+
+	return nil
+}
+
+// portConflicts returns the required ports of cfg that another process is
+// bound to. Ports of a running WTE installation are GOST's own and skipped,
+// as the service is restarted with the new configuration.
+func portConflicts(cfg *config.Config) []config.PortInfo {
+	owned := make(map[state.Port]bool)
+	if status, err := system.NewServiceManager().Status(); err == nil && status.IsActive {
+		for _, port := range config.Get().GetRequiredPorts() {
+			owned[state.Port{Port: port.Port, Protocol: port.Protocol}] = true
+		}
+	}
+
+	var conflicts []config.PortInfo
+	for _, port := range cfg.GetRequiredPorts() {
+		if owned[state.Port{Port: port.Port, Protocol: port.Protocol}] {
+			continue
+		}
+
+		available := system.IsPortAvailable(port.Port)
+		if port.Protocol == "udp" {
+			available = system.IsUDPPortAvailable(port.Port)
+		}
+		if !available {
+			conflicts = append(conflicts, port)
+		}
+	}
+
+	return conflicts
+}
@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+var lockoutCmd = &cobra.Command{
+	Use:   "lockout",
+	Short: "Manage clients blocked after failed authentication",
+	Long: `Manage the in-proxy auth lockout.
+
+When security.auth_lockout.enabled is true, GOST rejects clients listed in
+an admission file that WTE maintains. The 'wte-lockout' service watches the
+GOST logs and blocks a client after max_attempts failed authentications
+within the configured window, for ban_duration.
+
+Settings:
+  security.auth_lockout.enabled       Enable/disable lockout (true/false)
+  security.auth_lockout.max_attempts  Failed attempts before blocking (default 5)
+  security.auth_lockout.window        Window for counting failures (default 10m)
+  security.auth_lockout.ban_duration  How long a client stays blocked (default 1h)
+
+Examples:
+  wte config set security.auth_lockout.enabled true
+  wte config apply
+  wte lockout list
+  wte lockout unban 203.0.113.7`,
+}
+
+var lockoutListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List blocked clients",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := config.Get()
+
+		bans, err := gost.ReadBans(gost.LockoutFilePath(cfg))
+		if err != nil {
+			return err
+		}
+
+		if len(bans) == 0 {
+			ui.Info("No blocked clients")
+			return nil
+		}
+
+		table := ui.NewTable([]string{"Client", "Blocked Until"})
+		for _, ban := range bans {
+			table.Append([]string{ban.IP, ban.Until.Local().Format("2006-01-02 15:04:05")})
+		}
+		table.Render()
+
+		return nil
+	},
+}
+
+var lockoutUnbanCmd = &cobra.Command{
+	Use:   "unban <ip>",
+	Short: "Remove a client from the block list",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkRoot(); err != nil {
+			return err
+		}
+
+		cfg := config.Get()
+		path := gost.LockoutFilePath(cfg)
+
+		bans, err := gost.ReadBans(path)
+		if err != nil {
+			return err
+		}
+
+		var remaining []gost.Ban
+		for _, ban := range bans {
+			if ban.IP != args[0] {
+				remaining = append(remaining, ban)
+			}
+		}
+
+		if len(remaining) == len(bans) {
+			return fmt.Errorf("client %s is not blocked", args[0])
+		}
+
+		if err := gost.WriteBans(path, remaining); err != nil {
+			return err
+		}
+
+		ui.Success("Client %s unblocked", args[0])
+		ui.Detail("GOST picks up the change within a few seconds")
+		ui.Detail("Restart the watcher to also reset its failure counters: systemctl restart %s", system.LockoutServiceName)
+
+		return nil
+	},
+}
+
+var lockoutWatchCmd = &cobra.Command{
+	Use:    "watch",
+	Short:  "Watch GOST logs and block clients with repeated auth failures",
+	Hidden: true,
+	RunE:   runLockoutWatch,
+}
+
+func init() {
+	lockoutCmd.AddCommand(lockoutListCmd)
+	lockoutCmd.AddCommand(lockoutUnbanCmd)
+	lockoutCmd.AddCommand(lockoutWatchCmd)
+
+	rootCmd.AddCommand(lockoutCmd)
+}
+
+func runLockoutWatch(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	settings, err := gost.ParseLockoutSettings(cfg.Security.AuthLockout)
+	if err != nil {
+		return err
+	}
+
+	if err := gost.EnsureLockoutFile(cfg); err != nil {
+		return err
+	}
+
+	lockout := gost.NewLockout(settings, gost.LockoutFilePath(cfg))
+	if err := lockout.Load(); err != nil {
+		return err
+	}
+
+	journal := exec.Command("journalctl", "-u", "gost", "-f", "-n", "0", "-o", "cat", "--no-pager")
+	stdout, err := journal.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open log stream: %w", err)
+	}
+	if err := journal.Start(); err != nil {
+		return fmt.Errorf("failed to start log stream: %w", err)
+	}
+	defer func() { _ = journal.Process.Kill() }()
+
+	ui.Info("Auth lockout active: %d failures in %s blocks a client for %s",
+		settings.MaxAttempts, settings.Window, settings.BanDuration)
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return fmt.Errorf("log stream closed")
+			}
+
+			ip, ok := gost.ParseAuthFailure(line)
+			if !ok {
+				continue
+			}
+
+			if lockout.RecordFailure(ip, time.Now()) {
+				if err := lockout.Save(); err != nil {
+					ui.Error("%v", err)
+					continue
+				}
+				ui.Warning("lockout: blocked %s for %s after %d failed authentication attempts",
+					ip, settings.BanDuration, settings.MaxAttempts)
+			}
+
+		case now := <-ticker.C:
+			released := lockout.Expire(now)
+			if len(released) == 0 {
+				continue
+			}
+			if err := lockout.Save(); err != nil {
+				ui.Error("%v", err)
+				continue
+			}
+			for _, ip := range released {
+				ui.Info("lockout: unblocked %s", ip)
+			}
+
+		case <-sigChan:
+			return nil
+		}
+	}
+}
+
+// syncLockoutService installs or removes the lockout watcher to match the
+// config. The watcher reads the journal, so it is only available on systemd.
+func syncLockoutService(cfg *config.Config, service system.ServiceManager) error {
+	systemd, ok := service.(*system.SystemdManager)
+	if !ok {
+		if cfg.Security.AuthLockout.Enabled {
+			return fmt.Errorf("the auth lockout watcher requires systemd")
+		}
+		return nil
+	}
+
+	if !cfg.Security.AuthLockout.Enabled {
+		return systemd.RemoveLockoutService()
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	return systemd.CreateLockoutService(executable)
+}
@@ -1,14 +1,22 @@
 package cli
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"wte/internal/config"
 	"wte/internal/system"
 	"wte/internal/ui"
 )
@@ -16,39 +24,107 @@ import (
 var (
 	logsFollow bool
 	logsLines  int
+	logsLevel  string
+	logsSince  string
+	logsGrep   string
+	logsPretty bool
+	logsExport string
+	logsGzip   bool
 )
 
 var logsCmd = &cobra.Command{
 	Use:   "logs",
 	Short: "View service logs",
-	Long: `View GOST proxy service logs from journald.
+	Long: `View GOST proxy service logs from journald, or from
+/var/log/wte/gost.log on OpenRC hosts.
+
+--level reads the severity GOST writes in each log line, since journald
+records all of GOST's output at the same priority.
+
+--since takes "today", "yesterday", a span such as "1 hour ago", 30m or
+-2h, or a local time such as "2024-05-01 13:00". --grep keeps entries
+matching a regular expression, case-insensitive unless the pattern has an
+upper-case letter. The filters combine with each other and with --level.
+
+--pretty shows GOST's JSON log lines as aligned, colored text: the time,
+the level, the service and the message, followed by the other fields.
+Lines in another format and output with --no-color are shown as they are.
+
+--export writes the entries to a file instead of the terminal, to attach
+to a support ticket. The file starts with a header naming the WTE version,
+the operating system and the GOST version, and is compressed with --gzip.
 
 Examples:
-  wte logs              # Show last 50 lines
-  wte logs -n 100       # Show last 100 lines
-  wte logs -f           # Follow logs in real-time
-  wte logs -f -n 20     # Follow with 20 initial lines`,
+  wte logs                # Show last 50 lines
+  wte logs -n 100         # Show last 100 lines
+  wte logs -f             # Follow logs in real-time
+  wte logs -f -n 20       # Follow with 20 initial lines
+  wte logs --level error  # Show only errors
+  wte logs -f --pretty    # Follow logs as readable, colored text
+  wte logs --since "1 hour ago" --grep shadowsocks
+  wte logs -n 1000 --export /tmp/wte-logs.txt
+  wte logs --since today --export /tmp/wte-logs.txt.gz --gzip`,
 	RunE: runLogs,
 }
 
 func init() {
 	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Follow log output")
 	logsCmd.Flags().IntVarP(&logsLines, "lines", "n", 50, "Number of lines to show")
+	logsCmd.Flags().StringVar(&logsSince, "since", "", "Only show entries since this time (e.g. \"1 hour ago\", today)")
+	logsCmd.Flags().StringVar(&logsGrep, "grep", "", "Only show entries matching this regular expression")
+	logsCmd.Flags().BoolVar(&logsPretty, "pretty", false, "Show GOST log lines as aligned, colored text")
+	logsCmd.Flags().StringVar(&logsLevel, "level", "", "Only show entries at or above this level ("+strings.Join(system.LogLevelNames(), ", ")+")")
+	logsCmd.Flags().StringVar(&logsExport, "export", "", "Write the entries to this file instead of stdout")
+	logsCmd.Flags().BoolVar(&logsGzip, "gzip", false, "Compress the file written by --export")
+	logsCmd.MarkFlagsMutuallyExclusive("export", "follow")
+	logsCmd.MarkFlagsMutuallyExclusive("export", "pretty")
 }
 
 func runLogs(cmd *cobra.Command, args []string) error {
-	systemd := system.NewSystemdManager()
+	if logsGzip && logsExport == "" {
+		return fmt.Errorf("--gzip needs --export")
+	}
+
+	service := system.NewServiceManager()
 
-	if !systemd.IsInstalled() {
+	if !service.IsInstalled() {
 		return fmt.Errorf("service is not installed")
 	}
 
+	opts := system.LogOptions{Lines: logsLines, Level: logsLevel}
+	if logsLevel != "" {
+		if _, err := system.ParseLogLevel(logsLevel); err != nil {
+			return err
+		}
+	}
+	if logsSince != "" {
+		since, err := system.ParseLogSince(logsSince, time.Now())
+		if err != nil {
+			return err
+		}
+		opts.Since = since
+	}
+	if logsGrep != "" {
+		re, err := system.CompileLogGrep(logsGrep)
+		if err != nil {
+			return err
+		}
+		opts.Grep = re
+	}
+
+	pretty := logsPretty && !ui.NoColor
+	if pretty {
+		opts.Output = system.LineWriter(os.Stdout, func(line []byte) (string, bool) {
+			return prettyLogLine(string(line)), true
+		})
+	}
+
 	if logsFollow {
 		// Follow logs
 		ui.Info("Following logs... (press Ctrl+C to stop)")
 		ui.Println()
 
-		logCmd := systemd.FollowLogs()
+		logCmd := service.FollowLogs(opts)
 		if err := logCmd.Start(); err != nil {
 			return fmt.Errorf("failed to start log stream: %w", err)
 		}
@@ -73,7 +149,7 @@ func runLogs(cmd *cobra.Command, args []string) error {
 		}
 	} else {
 		// Show recent logs
-		logs, err := systemd.GetLogs(logsLines)
+		logs, err := service.Logs(opts)
 		if err != nil {
 			return fmt.Errorf("failed to get logs: %w", err)
 		}
@@ -83,8 +159,125 @@ func runLogs(cmd *cobra.Command, args []string) error {
 			return nil
 		}
 
+		if logsExport != "" {
+			if err := exportLogs(logsExport, logsGzip, logs); err != nil {
+				return err
+			}
+			ui.Success("Logs written to %s", logsExport)
+			return nil
+		}
+
+		if pretty {
+			for _, line := range strings.Split(strings.TrimSuffix(logs, "\n"), "\n") {
+				fmt.Println(prettyLogLine(line))
+			}
+			return nil
+		}
+
 		fmt.Print(logs)
 	}
 
 	return nil
 }
+
+// exportLogs writes logs to path, after a header describing the system,
+// gzip-compressed with compress. The file is only readable by its owner,
+// since the logs hold client addresses.
+func exportLogs(path string, compress bool, logs string) (err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %w", path, closeErr)
+		}
+	}()
+
+	var out io.Writer = file
+	if compress {
+		gzw := gzip.NewWriter(file)
+		defer func() {
+			if closeErr := gzw.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to write %s: %w", path, closeErr)
+			}
+		}()
+		out = gzw
+	}
+
+	if _, err := io.WriteString(out, logsExportHeader()+logs); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// logsExportHeader describes the system the logs come from, so an
+// exported file makes sense on its own
+func logsExportHeader() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# WTE logs exported %s\n", time.Now().Format(time.RFC3339))
+	for _, line := range systemSummary(config.Get()) {
+		fmt.Fprintf(&b, "# %s\n", line)
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// prettyLogFields are the fields of a GOST log line shown before the others
+var prettyLogFields = []string{"level", "time", "msg", "service"}
+
+// prettyLogLine formats a GOST JSON log line, as written to the log file or
+// prefixed by journalctl, as "time LEVEL [service] message key=value...",
+// colored by level. Other lines are returned unchanged.
+func prettyLogLine(line string) string {
+	start := strings.IndexByte(line, '{')
+	if start < 0 {
+		return line
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line[start:]))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return line
+	}
+
+	level, _ := fields["level"].(string)
+	message, _ := fields["msg"].(string)
+	timestamp, _ := fields["time"].(string)
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if level == "" || err != nil {
+		return line
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s  %-5s  ", t.Local().Format("2006-01-02 15:04:05"), strings.ToUpper(level))
+	if service, ok := fields["service"].(string); ok && service != "" {
+		fmt.Fprintf(&b, "[%s] ", service)
+	}
+	b.WriteString(message)
+
+	var keys []string
+	for key := range fields {
+		if !containsString(prettyLogFields, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, fields[key])
+	}
+
+	switch strings.ToLower(level) {
+	case "error", "fatal", "panic":
+		return ui.Red.Sprint(b.String())
+	case "warn", "warning":
+		return ui.Yellow.Sprint(b.String())
+	case "debug", "trace":
+		return ui.Gray.Sprint(b.String())
+	}
+	return b.String()
+}
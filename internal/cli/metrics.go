@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// metricsPortTimeout is how long a scrape waits for each proxy port
+const metricsPortTimeout = time.Second
+
+var metricsAddr string
+
+var serveMetricsCmd = &cobra.Command{
+	Use:   "serve-metrics",
+	Short: "Serve health and Prometheus metrics over HTTP",
+	Long: `Start an HTTP server exposing the health of the GOST service.
+
+Endpoints:
+  /healthz   200 if the service is active, 503 otherwise
+  /metrics   Prometheus text format:
+               wte_service_up          1 if the service is active
+               wte_port_up             1 per proxy port accepting connections
+               wte_gost_memory_bytes   memory used by the service
+
+The server only listens on --addr, which defaults to localhost. It stops on
+SIGINT or SIGTERM.
+
+Examples:
+  wte serve-metrics                  # Listen on 127.0.0.1:9090
+  wte serve-metrics --addr :9090     # Listen on all interfaces`,
+	RunE: runServeMetrics,
+}
+
+func init() {
+	serveMetricsCmd.Flags().StringVar(&metricsAddr, "addr", config.DefaultMetricsAddr, "Address to listen on")
+
+	rootCmd.AddCommand(serveMetricsCmd)
+}
+
+func runServeMetrics(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+	service := system.NewServiceManager()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status, err := service.Status()
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case !status.IsActive:
+			http.Error(w, "service is not active", http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, renderMetrics(cfg, service))
+	})
+
+	server := &http.Server{
+		Addr:              metricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	ui.Info("Serving metrics on http://%s/metrics (press Ctrl+C to stop)", metricsAddr)
+
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("metrics server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to stop metrics server: %w", err)
+	}
+
+	ui.Info("Metrics server stopped")
+	return nil
+}
+
+// renderMetrics returns the current metrics in Prometheus text format
+func renderMetrics(cfg *config.Config, service system.ServiceManager) string {
+	var b strings.Builder
+
+	up := 0
+	var memory int64
+	if status, err := service.Status(); err == nil {
+		if status.IsActive {
+			up = 1
+		}
+		memory = status.MemoryBytes
+	}
+
+	b.WriteString("# HELP wte_service_up Whether the GOST service is active.\n")
+	b.WriteString("# TYPE wte_service_up gauge\n")
+	fmt.Fprintf(&b, "wte_service_up %d\n", up)
+
+	b.WriteString("# HELP wte_port_up Whether a proxy port accepts connections.\n")
+	b.WriteString("# TYPE wte_port_up gauge\n")
+	for _, port := range cfg.GetRequiredPorts() {
+		if port.Protocol != "tcp" {
+			continue
+		}
+		portUp := 0
+		if system.TestTCPPort(config.DialHost(port.BindAddress), port.Port, metricsPortTimeout) == nil {
+			portUp = 1
+		}
+		fmt.Fprintf(&b, "wte_port_up{service=%q,port=\"%d\"} %d\n", port.Service, port.Port, portUp)
+	}
+
+	b.WriteString("# HELP wte_gost_memory_bytes Memory used by the GOST service.\n")
+	b.WriteString("# TYPE wte_gost_memory_bytes gauge\n")
+	fmt.Fprintf(&b, "wte_gost_memory_bytes %d\n", memory)
+
+	return b.String()
+}
@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/state"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// migratePortServices are the services migrate-port moves, by the
+// configuration section holding their port
+var migratePortServices = []string{"http", "https", "shadowsocks"}
+
+var migratePortCmd = &cobra.Command{
+	Use:   "migrate-port <service> <port>",
+	Short: "Move a proxy service to another port",
+	Long: `Move the port of a proxy service (http, https or shadowsocks) and the
+firewall rules that go with it.
+
+Changing the port with 'wte config set' and 'wte restart' leaves the old
+port open in the firewall and the new one closed. migrate-port checks that
+the new port is free, opens it in the firewall, applies the configuration
+and restarts the service, and only then closes the old port, if WTE
+opened it. If the service does not come up on the new port, the previous
+port and firewall rules are restored.
+
+Firewall rules are only changed when firewall.auto_configure is set.
+Clients must be updated to the new port, see 'wte credentials'.
+
+Examples:
+  wte migrate-port http 3128
+  wte migrate-port shadowsocks 8443`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeMigratePortServices,
+	RunE:              runMigratePort,
+}
+
+func init() {
+	rootCmd.AddCommand(migratePortCmd)
+}
+
+func runMigratePort(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	service := args[0]
+	if !containsString(migratePortServices, service) {
+		return fmt.Errorf("unknown service %q, use one of: %s", service, strings.Join(migratePortServices, ", "))
+	}
+	key := service + ".port"
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", args[1])
+	}
+	if err := config.ValidatePort(key, port); err != nil {
+		return err
+	}
+
+	cfg := config.Get()
+	oldPort, enabled := migratePortCurrent(cfg, service)
+	if !enabled {
+		return fmt.Errorf("%s is not enabled", service)
+	}
+	if port == oldPort {
+		return fmt.Errorf("%s already listens on port %d", service, port)
+	}
+
+	if !system.IsPortAvailable(port) {
+		return fmt.Errorf("port %d is already in use by another process", port)
+	}
+	if service == "shadowsocks" && cfg.Shadowsocks.UDP && !system.IsUDPPortAvailable(port) {
+		return fmt.Errorf("UDP port %d is already in use by another process", port)
+	}
+	if port <= config.PrivilegedPortMax {
+		ui.Warning("Port %d is below 1024, GOST needs root privileges to listen on it", port)
+	}
+
+	candidate, err := config.WithValue(key, port)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	if err := gost.NewConfigGenerator(candidate).Validate(); err != nil {
+		return err
+	}
+
+	added, removed := diffPorts(firewallRules(cfg), firewallRules(candidate))
+
+	// Open the new port before the service moves to it. Rules the firewall
+	// already has are left out, so a rollback does not remove them.
+	if cfg.Firewall.AutoConfigure {
+		firewall := system.NewFirewallManager()
+		var missing []state.Port
+		for _, rule := range added {
+			if !firewall.IsPortAllowedFrom(rule.Port, rule.Protocol, rule.Source) {
+				missing = append(missing, rule)
+			}
+		}
+		added = missing
+	}
+	syncFirewallPorts(added, nil)
+
+	if err := config.Set(key, port); err != nil {
+		return fmt.Errorf("failed to update %s: %w", key, err)
+	}
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	ui.Action("Moving %s from port %d to %d...", service, oldPort, port)
+
+	if err := applyConfig(); err != nil {
+		ui.Warning("Restoring port %d", oldPort)
+		if restoreErr := restoreMigratedPort(key, oldPort); restoreErr != nil {
+			return fmt.Errorf("%w, and restoring %s failed: %v", err, key, restoreErr)
+		}
+		syncFirewallPorts(nil, added)
+		return err
+	}
+	recordAudit("migrate-port", key, oldPort, port)
+
+	// Close the old port once nothing listens on it, if WTE opened it
+	syncFirewallPorts(nil, openedByWTE(removed))
+
+	ui.Success("%s moved from port %d to %d", service, oldPort, port)
+	if !cfg.Firewall.AutoConfigure && !cfg.LocalhostOnly() {
+		ui.Detail("firewall.auto_configure is off, open port %d and close port %d yourself", port, oldPort)
+	}
+	ui.Detail("Update your clients, see 'wte credentials'")
+
+	return nil
+}
+
+// openedByWTE returns the rules among ports that the state file records
+// WTE adding. Rules the firewall had before are left alone.
+func openedByWTE(ports []state.Port) []state.Port {
+	s, err := state.Load()
+	if err != nil {
+		ui.Warning("Could not read the opened ports: %v", err)
+		return nil
+	}
+
+	var owned []state.Port
+	for _, port := range ports {
+		if containsPort(s.OpenedPorts, port) {
+			owned = append(owned, port)
+		}
+	}
+	return owned
+}
+
+// migratePortCurrent returns the port of service and whether it is enabled
+func migratePortCurrent(cfg *config.Config, service string) (int, bool) {
+	switch service {
+	case "http":
+		return cfg.HTTP.Port, cfg.HTTP.Enabled
+	case "https":
+		return cfg.HTTPS.Port, cfg.HTTPS.Enabled
+	case "shadowsocks":
+		return cfg.Shadowsocks.Port, cfg.Shadowsocks.Enabled
+	}
+	return 0, false
+}
+
+// restoreMigratedPort puts back the previous port after a failed move. The
+// GOST configuration was already rolled back by the apply.
+func restoreMigratedPort(key string, port int) error {
+	if err := config.Set(key, port); err != nil {
+		return err
+	}
+	return config.Save()
+}
+
+// completeMigratePortServices completes the services migrate-port moves
+func completeMigratePortServices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return migratePortServices, cobra.ShellCompDirectiveNoFileComp
+}
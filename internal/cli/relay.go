@@ -0,0 +1,350 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/state"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+var relayProtocol string
+
+var relayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Manage port forwarding relays",
+	Long: `Forward ports on this server to remote hosts through GOST.
+
+Each relay listens on a local port and forwards TCP or UDP traffic to a
+remote host, which exposes internal services through the proxy server.
+Relays follow security.allow like the proxy services. Changes are applied
+right away and the firewall port is opened or closed when
+firewall.auto_configure is set.
+
+Examples:
+  wte relay add 8000 10.0.0.5:80            # Forward TCP 8000 to 10.0.0.5:80
+  wte relay add 5353 10.0.0.53:53 -p udp    # Forward UDP 5353 to a DNS server
+  wte relay list                            # Show all relays
+  wte relay remove 8000                     # Remove the relays on port 8000`,
+}
+
+var relayAddCmd = &cobra.Command{
+	Use:   "add <listen-port> <host:port>",
+	Short: "Forward a local port to a remote host",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runRelayAdd,
+}
+
+var relayListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List relays",
+	Args:  cobra.NoArgs,
+	RunE:  runRelayList,
+}
+
+var relayRemoveCmd = &cobra.Command{
+	Use:   "remove <listen-port>",
+	Short: "Remove the relays on a local port",
+	Long: `Remove the relays listening on a local port. Without --protocol both the
+TCP and the UDP relay on the port are removed.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeRelayPorts,
+	RunE:              runRelayRemove,
+}
+
+func init() {
+	relayAddCmd.Flags().StringVarP(&relayProtocol, "protocol", "p", "tcp", "Protocol to forward (tcp or udp)")
+	relayRemoveCmd.Flags().StringVarP(&relayProtocol, "protocol", "p", "", "Only remove the relay for this protocol (tcp or udp)")
+
+	relayCmd.AddCommand(relayAddCmd)
+	relayCmd.AddCommand(relayListCmd)
+	relayCmd.AddCommand(relayRemoveCmd)
+	rootCmd.AddCommand(relayCmd)
+}
+
+func runRelayAdd(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	listenPort, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid listen port: %s", args[0])
+	}
+
+	host, port, err := net.SplitHostPort(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid target %q: use host:port", args[1])
+	}
+	remotePort, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid target port: %s", port)
+	}
+
+	relay := config.RelayEntry{
+		ListenPort: listenPort,
+		RemoteHost: host,
+		RemotePort: remotePort,
+		Protocol:   relayProtocol,
+	}
+	if err := config.ValidateRelayEntry(relay); err != nil {
+		return err
+	}
+
+	entries := append(append([]config.RelayEntry{}, config.Get().Relay.Entries...), relay)
+	if err := updateRelays(entries); err != nil {
+		return err
+	}
+	recordAudit("relay add", "relay.entries", nil, relay)
+
+	ui.Success("Relay added: %s", relay)
+
+	syncRelayFirewall([]config.RelayEntry{relay}, nil)
+
+	return nil
+}
+
+func runRelayList(cmd *cobra.Command, args []string) error {
+	entries := config.Get().Relay.Entries
+
+	if ui.JSON {
+		if entries == nil {
+			entries = []config.RelayEntry{}
+		}
+		return ui.PrintJSON(entries)
+	}
+
+	if len(entries) == 0 {
+		ui.Info("No relays configured")
+		ui.Detail("Add one with: wte relay add <listen-port> <host:port>")
+		return nil
+	}
+
+	fmt.Printf("%-8s %-6s %s\n", "PORT", "PROTO", "TARGET")
+	for _, relay := range entries {
+		fmt.Printf("%-8d %-6s %s\n", relay.ListenPort, relay.Protocol, relay.Target())
+	}
+
+	return nil
+}
+
+func runRelayRemove(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	listenPort, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid listen port: %s", args[0])
+	}
+
+	var kept, removed []config.RelayEntry
+	for _, relay := range config.Get().Relay.Entries {
+		if relay.ListenPort == listenPort && (relayProtocol == "" || relay.Protocol == relayProtocol) {
+			removed = append(removed, relay)
+			continue
+		}
+		kept = append(kept, relay)
+	}
+
+	if len(removed) == 0 {
+		return fmt.Errorf("no relay on port %d", listenPort)
+	}
+
+	if kept == nil {
+		kept = []config.RelayEntry{}
+	}
+	if err := updateRelays(kept); err != nil {
+		return err
+	}
+	for _, relay := range removed {
+		recordAudit("relay remove", "relay.entries", relay, nil)
+		ui.Success("Relay removed: %s", relay)
+	}
+
+	syncRelayFirewall(nil, removed)
+
+	return nil
+}
+
+// updateRelays validates the configuration with entries as the relay list,
+// applies it and saves it. When the apply rolls back, the previous list is
+// put back and nothing is saved.
+func updateRelays(entries []config.RelayEntry) error {
+	candidate, err := config.WithValue("relay.entries", entries)
+	if err != nil {
+		return fmt.Errorf("failed to update relays: %w", err)
+	}
+
+	if err := gost.NewConfigGenerator(candidate).Validate(); err != nil {
+		return err
+	}
+
+	previous := config.Get().Relay.Entries
+	if previous == nil {
+		previous = []config.RelayEntry{}
+	}
+
+	if err := config.Set("relay.entries", entries); err != nil {
+		return fmt.Errorf("failed to update relays: %w", err)
+	}
+
+	if err := applyConfig(); err != nil {
+		if restoreErr := config.Set("relay.entries", previous); restoreErr != nil {
+			return fmt.Errorf("%w, and restoring relay.entries failed: %v", err, restoreErr)
+		}
+		return err
+	}
+
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	return nil
+}
+
+// applyConfig regenerates the GOST configuration and restarts the service
+func applyConfig() error {
+	if err := gost.ApplyTransaction(config.Get(), gost.DefaultApplyTimeout); err != nil {
+		return err
+	}
+
+	if err := config.RecordApplied(); err != nil {
+		ui.Warning("Could not record applied configuration: %v", err)
+	}
+
+	return nil
+}
+
+// syncRelayFirewall opens the ports of added relays and closes those of
+// removed relays, unless a remaining service still listens on them
+func syncRelayFirewall(added, removed []config.RelayEntry) {
+	ports := func(relays []config.RelayEntry) []state.Port {
+		var ports []state.Port
+		for _, relay := range relays {
+			ports = append(ports, state.Port{Port: relay.ListenPort, Protocol: relay.Protocol})
+		}
+		return ports
+	}
+
+	syncFirewallPorts(ports(added), ports(removed))
+}
+
+// syncFirewallPorts opens added ports and closes removed ports, unless a
+// remaining service still listens on them, and records the opened ports
+func syncFirewallPorts(added, removed []state.Port) {
+	cfg := config.Get()
+	if !cfg.Firewall.AutoConfigure {
+		return
+	}
+
+	firewall := system.NewFirewallManager()
+	inUse := make(map[state.Port]bool)
+	for _, port := range firewallRules(cfg) {
+		inUse[port] = true
+	}
+
+	var opened, closed []state.Port
+	for _, port := range added {
+		if err := firewall.OpenPortFrom(port.Port, port.Protocol, port.Source); err != nil {
+			ui.Warning("Could not open port %s: %v", describePort(port), err)
+			continue
+		}
+		opened = append(opened, port)
+	}
+	for _, port := range removed {
+		if inUse[port] {
+			continue
+		}
+		if err := firewall.ClosePortFrom(port.Port, port.Protocol, port.Source); err != nil {
+			ui.Warning("Could not close port %s: %v", describePort(port), err)
+			continue
+		}
+		closed = append(closed, port)
+	}
+
+	if len(opened) == 0 && len(closed) == 0 {
+		return
+	}
+
+	if err := firewall.Apply(); err != nil {
+		ui.Warning("Could not apply firewall changes: %v", err)
+		return
+	}
+
+	for _, port := range opened {
+		ui.Detail("Port %s opened", describePort(port))
+	}
+	for _, port := range closed {
+		ui.Detail("Port %s closed", describePort(port))
+	}
+
+	if err := recordOpenedPorts(opened, closed); err != nil {
+		ui.Warning("Could not record opened ports: %v", err)
+	}
+}
+
+// recordOpenedPorts updates the firewall rules WTE added, which uninstall
+// removes, in the state file
+func recordOpenedPorts(opened, closed []state.Port) error {
+	return state.Update(func(s *state.State) {
+		var ports []state.Port
+		for _, port := range s.OpenedPorts {
+			if !containsPort(closed, port) && !containsPort(opened, port) {
+				ports = append(ports, port)
+			}
+		}
+		s.OpenedPorts = append(ports, opened...)
+	})
+}
+
+// firewallRules returns the firewall rules cfg needs, see
+// config.FirewallPorts
+func firewallRules(cfg *config.Config) []state.Port {
+	var rules []state.Port
+	for _, port := range cfg.FirewallPorts() {
+		rules = append(rules, state.Port{Port: port.Port, Protocol: port.Protocol, Source: port.Source})
+	}
+	return rules
+}
+
+// diffPorts returns the ports of after missing from before and those of
+// before missing from after
+func diffPorts(before, after []state.Port) (added, removed []state.Port) {
+	for _, port := range after {
+		if !containsPort(before, port) {
+			added = append(added, port)
+		}
+	}
+	for _, port := range before {
+		if !containsPort(after, port) {
+			removed = append(removed, port)
+		}
+	}
+	return added, removed
+}
+
+// describePort formats a firewall rule as port/protocol, followed by the
+// source it is restricted to
+func describePort(port state.Port) string {
+	if port.Source == "" {
+		return fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+	}
+	return fmt.Sprintf("%d/%s from %s", port.Port, port.Protocol, port.Source)
+}
+
+// containsPort reports whether ports contains port
+func containsPort(ports []state.Port, port state.Port) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,16 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"runtime"
 
 	"github.com/spf13/cobra"
 
 	"wte/internal/config"
+	"wte/internal/logging"
+	"wte/internal/state"
 	"wte/internal/ui"
 )
 
@@ -19,11 +23,19 @@ var (
 
 var (
 	cfgFile   string
+	stateFile string
 	verbose   bool
 	quiet     bool
 	noColor   bool
+	jsonOut   bool
+	dryRun    bool
+	logFile   string
+	logLevel  string
 )
 
+// dryRunAnnotation marks commands that honor --dry-run
+const dryRunAnnotation = "dry-run"
+
 // rootCmd represents the base command
 var rootCmd = &cobra.Command{
 	Use:   "wte",
@@ -46,28 +58,75 @@ Examples:
 		ui.SetNoColor(noColor)
 		ui.SetQuiet(quiet)
 		ui.SetVerbose(verbose)
+		ui.SetJSON(jsonOut)
+
+		// Completion requests print candidates to stdout, nothing else
+		if cmd.Name() == cobra.ShellCompRequestCmd {
+			ui.SetQuiet(true)
+		}
+
+		// Other commands would silently make the changes a dry run promises not to
+		if dryRun && cmd.Annotations[dryRunAnnotation] == "" {
+			return fmt.Errorf("--dry-run is not supported by '%s'", cmd.CommandPath())
+		}
+
+		state.Path = stateFile
 
 		// Initialize configuration
 		if err := config.Init(cfgFile); err != nil {
-			// Only warn if config file doesn't exist - it's expected for new installs
-			ui.Debug("Config initialization: %v", err)
+			if errors.Is(err, config.ErrProtected) && os.Geteuid() != 0 {
+				// Read-only commands still work, without the settings
+				ui.Warning("Cannot read %s, run with sudo to read the protected config", config.GetConfigPath())
+			} else {
+				// Only warn if config file doesn't exist - it's expected for new installs
+				ui.Debug("Config initialization: %v", err)
+			}
 		}
 
+		// The flags override logging.file and logging.level
+		logCfg := config.Get().Logging
+		flags := cmd.Flags()
+		if flags.Changed("log-file") {
+			logCfg.File = logFile
+		}
+		if flags.Changed("log-level") {
+			logCfg.Level = logLevel
+		}
+		if err := logging.Setup(logCfg.File, logCfg.Level); err != nil {
+			if flags.Changed("log-file") || flags.Changed("log-level") {
+				return err
+			}
+			// A configured log file unwritable for non-root users
+			// shouldn't break read-only commands
+			ui.Debug("Logging disabled: %v", err)
+		}
+		logging.Logger.Info("command started", "command", cmd.CommandPath(), "version", Version, "uid", os.Geteuid())
+
 		return nil
 	},
 }
 
 // Execute runs the root command
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if err != nil {
+		logging.Logger.Error("command failed", "error", err)
+	}
+	return err
 }
 
 func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is /etc/wte/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&stateFile, "state-file", state.DefaultPath, "runtime state file")
+	_ = rootCmd.MarkPersistentFlagFilename("config", "yaml", "yml")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "quiet output (only errors)")
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colored output")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "show what install/uninstall would do without changing anything")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "append a structured log of actions to this file (default is logging.file)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log file level: debug, info, warn, error (default is logging.level)")
+	rootCmd.PersistentFlags().BoolVar(&jsonOut, "json", false, "JSON output (status, doctor, test, config show, credentials, rotate-password, relay list, acl list, version)")
 
 	// Add subcommands
 	rootCmd.AddCommand(versionCmd)
@@ -82,6 +141,11 @@ func init() {
 	rootCmd.AddCommand(credentialsCmd)
 }
 
+// planAction prints an action a dry run skips
+func planAction(format string, args ...interface{}) {
+	ui.Action("[dry run] Would "+format, args...)
+}
+
 // checkRoot ensures the command is run as root
 func checkRoot() error {
 	if os.Geteuid() != 0 {
@@ -94,11 +158,56 @@ func checkRoot() error {
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show version information",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("WTE v%s\n", Version)
+	Long: `Show the WTE version. With --verbose the build metadata is shown too,
+with --json it is printed as a JSON document for bug reports.
+
+A warning is shown when the binary carries no build time or git commit,
+which means it was not built by the release process.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		report := newVersionReport()
+
+		if ui.JSON {
+			return ui.PrintJSON(report)
+		}
+
+		fmt.Printf("WTE v%s\n", report.Version)
 		if verbose {
-			fmt.Printf("  Build Time: %s\n", BuildTime)
-			fmt.Printf("  Git Commit: %s\n", GitCommit)
+			fmt.Printf("  Build Time: %s\n", report.BuildTime)
+			fmt.Printf("  Git Commit: %s\n", report.GitCommit)
+			fmt.Printf("  Go Version: %s\n", report.GoVersion)
+			fmt.Printf("  Platform:   %s/%s\n", report.OS, report.Arch)
+		}
+		if !report.ReleaseBuild {
+			ui.Warning("Not a release build: the build time or git commit is unknown")
 		}
+		return nil
 	},
 }
+
+// versionReport is the JSON document of 'wte version --json'
+type versionReport struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time"`
+	GitCommit string `json:"git_commit"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+
+	// ReleaseBuild is false when the build metadata was not set at link
+	// time, as in a plain 'go build'
+	ReleaseBuild bool `json:"release_build"`
+}
+
+// newVersionReport collects the version and build metadata of the binary
+func newVersionReport() versionReport {
+	return versionReport{
+		Version:      Version,
+		BuildTime:    BuildTime,
+		GitCommit:    GitCommit,
+		GoVersion:    runtime.Version(),
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		ReleaseBuild: BuildTime != "unknown" && GitCommit != "unknown",
+	}
+}
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/security"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// Password rotation scopes
+const (
+	rotateScopeHTTP        = "http"
+	rotateScopeShadowsocks = "shadowsocks"
+	rotateScopeAll         = "all"
+)
+
+var rotateLength int
+
+var rotatePasswordCmd = &cobra.Command{
+	Use:   "rotate-password [http|shadowsocks|all]",
+	Short: "Rotate the password of one service",
+	Long: `Generate a new password for one service, regenerate the GOST
+configuration and restart the service.
+
+Unlike 'wte credentials --regenerate', only the named service's password
+changes, so clients of the other services keep working. The HTTP scope
+covers the HTTPS proxy too, as both share the same credentials. Without an
+argument every password is rotated.
+
+Only the changed credentials are printed.
+
+--length sets the length of generated passwords. Shadowsocks 2022 methods
+need a key of a fixed size and ignore it. The characters used are set by
+security.password_charset.
+
+Examples:
+  wte rotate-password shadowsocks   # Rotate a leaked Shadowsocks password
+  wte rotate-password http          # Rotate the HTTP/HTTPS proxy password
+  wte rotate-password --length 32   # Rotate everything with longer passwords`,
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{rotateScopeHTTP, rotateScopeShadowsocks, rotateScopeAll},
+	RunE:      runRotatePassword,
+}
+
+func init() {
+	rotatePasswordCmd.Flags().IntVar(&rotateLength, "length", security.DefaultPasswordLength, "Length of the generated passwords")
+
+	rootCmd.AddCommand(rotatePasswordCmd)
+}
+
+func runRotatePassword(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	if rotateLength < 8 {
+		return fmt.Errorf("--length must be at least 8")
+	}
+
+	scope := rotateScopeAll
+	if len(args) > 0 {
+		scope = args[0]
+	}
+
+	cfg := config.Get()
+
+	rotateHTTP := scope != rotateScopeShadowsocks && cfg.HTTP.Auth.Enabled
+	rotateSS := scope != rotateScopeHTTP && cfg.Shadowsocks.Enabled
+
+	switch {
+	case scope == rotateScopeHTTP && !rotateHTTP:
+		return fmt.Errorf("HTTP proxy authentication is not enabled, there is no password to rotate")
+	case scope == rotateScopeShadowsocks && !rotateSS:
+		return fmt.Errorf("Shadowsocks is not enabled")
+	case !rotateHTTP && !rotateSS:
+		return fmt.Errorf("no service has a password to rotate")
+	}
+
+	ui.Action("Rotating passwords...")
+
+	oldHTTP := cfg.HTTP.Auth.Password
+	oldHTTPS := cfg.HTTPS.Auth.Password
+	oldSS := cfg.Shadowsocks.Password
+
+	if rotateHTTP {
+		pass, err := security.GeneratePasswordWithCharset(rotateLength, cfg.Security.PasswordCharset)
+		if err != nil {
+			return fmt.Errorf("failed to generate HTTP password: %w", err)
+		}
+		cfg.HTTP.Auth.Password = pass
+		cfg.HTTPS.Auth.Password = pass
+	}
+
+	if rotateSS {
+		if size := security.SSKeySize(cfg.Shadowsocks.Method); size > 0 && cmd.Flags().Changed("length") {
+			ui.Detail("%s needs a %d-byte key, ignoring --length", cfg.Shadowsocks.Method, size)
+		}
+		pass, err := security.GenerateSSPassword(cfg.Shadowsocks.Method, rotateLength, cfg.Security.PasswordCharset)
+		if err != nil {
+			return fmt.Errorf("failed to generate Shadowsocks password: %w", err)
+		}
+		cfg.Shadowsocks.Password = pass
+	}
+
+	// Apply the new passwords first. If GOST rejects them the apply rolls
+	// back, and the saved configuration must keep the old ones too.
+	if err := gost.ApplyTransaction(cfg, gost.DefaultApplyTimeout); err != nil {
+		cfg.HTTP.Auth.Password = oldHTTP
+		cfg.HTTPS.Auth.Password = oldHTTPS
+		cfg.Shadowsocks.Password = oldSS
+		return err
+	}
+
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("GOST uses the new passwords, but saving the configuration failed: %w", err)
+	}
+
+	if rotateHTTP {
+		recordAudit("rotate-password", "http.auth.password", oldHTTP, cfg.HTTP.Auth.Password)
+	}
+	if rotateSS {
+		recordAudit("rotate-password", "shadowsocks.password", oldSS, cfg.Shadowsocks.Password)
+	}
+
+	if err := config.RecordApplied(); err != nil {
+		ui.Warning("Could not record applied configuration: %v", err)
+	}
+
+	publicIP, err := system.GetPublicIP()
+	if err != nil {
+		ui.Warning("Could not detect public IP: %v", err)
+		publicIP = "YOUR_SERVER_IP"
+	}
+
+	credsMgr := gost.NewCredentialsManager(cfg, publicIP)
+	if err := credsMgr.Save(); err != nil {
+		ui.Warning("Could not save credentials file: %v", err)
+	}
+
+	ui.Success("Passwords rotated and service restarted")
+
+	// Print only what changed
+	info := credsMgr.Info()
+	if !rotateHTTP {
+		info.HTTP = nil
+		info.HTTPS = nil
+	}
+	if !rotateSS {
+		info.Shadowsocks = nil
+	}
+
+	if ui.JSON {
+		return ui.PrintJSON(info)
+	}
+
+	printProxyCredentials("HTTP Proxy", info.HTTP)
+	printProxyCredentials("HTTPS Proxy", info.HTTPS)
+	if ss := info.Shadowsocks; ss != nil {
+		fmt.Println()
+		fmt.Println("Shadowsocks")
+		fmt.Printf("  Password:  %s\n", ss.Password)
+		fmt.Printf("  URI:       %s\n", ss.URI)
+	}
+
+	return nil
+}
+
+// printProxyCredentials prints the credentials of an HTTP or HTTPS proxy, if
+// the proxy is enabled
+func printProxyCredentials(title string, creds *gost.ProxyCredentials) {
+	if creds == nil {
+		return
+	}
+
+	fmt.Println()
+	fmt.Println(title)
+	fmt.Printf("  Username:  %s\n", creds.Username)
+	fmt.Printf("  Password:  %s\n", creds.Password)
+	fmt.Printf("  URL:       %s\n", creds.URL)
+}
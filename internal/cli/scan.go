@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// Risk levels reported by wte scan, from lowest to highest
+const (
+	riskOK     = "OK"
+	riskLow    = "LOW"
+	riskMedium = "MEDIUM"
+	riskHigh   = "HIGH"
+)
+
+var riskOrder = map[string]int{riskOK: 0, riskLow: 1, riskMedium: 2, riskHigh: 3}
+
+var scanSkipProbe bool
+
+// scanFinding is a single line of the scan report
+type scanFinding struct {
+	Check  string
+	Risk   string
+	Detail string
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Check whether the proxy is an open relay",
+	Long: `Audit the proxy's exposure and report a risk assessment.
+
+The scan checks the configuration for dangerous combinations (auth disabled
+on a public address without an allow-list, profiling exposed to the network)
+and then connects to each HTTP/HTTPS proxy through the server's public IP
+without credentials to see whether it relays traffic.
+
+The live probe runs from this server. Firewalls or cloud security groups
+can treat external clients differently, so a clean result here is not a
+guarantee.
+
+Examples:
+  wte scan              # Configuration checks and live probe
+  wte scan --no-probe   # Configuration checks only`,
+	RunE: runScan,
+}
+
+func init() {
+	scanCmd.Flags().BoolVar(&scanSkipProbe, "no-probe", false, "Only check the configuration, don't connect to the proxy")
+
+	rootCmd.AddCommand(scanCmd)
+}
+
+func runScan(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	ui.Header("WTE Security Scan")
+
+	findings := scanConfig(cfg)
+
+	if !scanSkipProbe {
+		ui.Action("Detecting public IP address...")
+		publicIP, err := system.GetPublicIP()
+		if err != nil {
+			findings = append(findings, scanFinding{"Live probe", riskLow,
+				fmt.Sprintf("skipped, could not detect public IP: %v", err)})
+		} else {
+			ui.Action("Probing proxies at %s without credentials...", publicIP)
+			findings = append(findings, scanProbe(cfg, publicIP)...)
+		}
+	}
+
+	table := ui.NewTable([]string{"Check", "Risk", "Detail"})
+	overall := riskOK
+	for _, finding := range findings {
+		table.Append([]string{finding.Check, finding.Risk, finding.Detail})
+		if riskOrder[finding.Risk] > riskOrder[overall] {
+			overall = finding.Risk
+		}
+	}
+
+	ui.Println()
+	table.Render()
+	ui.Println()
+
+	switch overall {
+	case riskHigh:
+		ui.Error("Risk: HIGH - this server can be used as an open relay")
+		ui.Detail("Enable auth (wte config set http.auth.enabled true) or restrict clients")
+		ui.Detail("(wte config set security.allow <CIDR>), then run 'wte config apply'")
+	case riskMedium:
+		ui.Warning("Risk: MEDIUM - review the findings above")
+	case riskLow:
+		ui.Success("Risk: LOW - no open relay found, see notes above")
+	default:
+		ui.Success("Risk: OK - no open relay found")
+	}
+
+	return nil
+}
+
+// scanConfig checks the configuration for risky combinations
+func scanConfig(cfg *config.Config) []scanFinding {
+	var findings []scanFinding
+
+	configGen := gost.NewConfigGenerator(cfg)
+	open := configGen.OpenProxyServices()
+
+	services := []struct {
+		name    string
+		enabled bool
+		auth    bool
+		bind    string
+	}{
+		{"HTTP", cfg.HTTP.Enabled, cfg.HTTP.Auth.Enabled, cfg.HTTP.BindAddress},
+		{"HTTPS", cfg.HTTPS.Enabled, httpsAuthEnabled(cfg), cfg.HTTPS.BindAddress},
+	}
+
+	for _, service := range services {
+		if !service.enabled {
+			continue
+		}
+
+		check := service.name + " auth"
+		switch {
+		case service.auth:
+			findings = append(findings, scanFinding{check, riskOK, "authentication required"})
+		case config.IsLoopback(service.bind):
+			findings = append(findings, scanFinding{check, riskOK, "auth disabled, localhost only"})
+		case len(cfg.Security.Allow) > 0:
+			findings = append(findings, scanFinding{check, riskLow,
+				"auth disabled, restricted to " + strings.Join(cfg.Security.Allow, ", ")})
+		default:
+			findings = append(findings, scanFinding{check, riskHigh,
+				"auth disabled on a public address with no allow-list"})
+		}
+	}
+
+	if len(open) > 0 && cfg.Security.AllowOpenProxy {
+		findings = append(findings, scanFinding{"Open proxy", riskHigh,
+			"explicitly allowed by security.allow_open_proxy"})
+	}
+
+	if cfg.Debug.Pprof.Enabled && !config.IsLoopback(cfg.Debug.Pprof.BindAddress) {
+		findings = append(findings, scanFinding{"pprof", riskMedium,
+			"profiling endpoint reachable from the network on " +
+				config.ListenAddr(cfg.Debug.Pprof.BindAddress, cfg.Debug.Pprof.Port)})
+	}
+
+	if (cfg.HTTP.Enabled || cfg.HTTPS.Enabled) && !cfg.Security.AuthLockout.Enabled && len(open) == 0 {
+		findings = append(findings, scanFinding{"Auth lockout", riskLow,
+			"disabled, password guessing is not throttled"})
+	}
+
+	return findings
+}
+
+// scanProbe connects to each enabled HTTP/HTTPS proxy without credentials
+func scanProbe(cfg *config.Config, publicIP string) []scanFinding {
+	var findings []scanFinding
+
+	probes := []struct {
+		name    string
+		enabled bool
+		port    int
+		useTLS  bool
+	}{
+		{"HTTP", cfg.HTTP.Enabled, cfg.HTTP.Port, false},
+		{"HTTPS", cfg.HTTPS.Enabled, cfg.HTTPS.Port, true},
+	}
+
+	for _, probe := range probes {
+		if !probe.enabled {
+			continue
+		}
+
+		check := fmt.Sprintf("%s probe (:%d)", probe.name, probe.port)
+
+		status, err := system.ProbeHTTPProxy(publicIP, probe.port, probe.useTLS)
+		switch {
+		case err != nil:
+			findings = append(findings, scanFinding{check, riskOK, "not reachable: " + err.Error()})
+		case status == http.StatusOK:
+			findings = append(findings, scanFinding{check, riskHigh,
+				"relayed a request to " + system.ProxyProbeTarget + " without credentials"})
+		case status == http.StatusProxyAuthRequired:
+			findings = append(findings, scanFinding{check, riskOK, "credentials required (407)"})
+		default:
+			findings = append(findings, scanFinding{check, riskOK, fmt.Sprintf("request refused (%d)", status)})
+		}
+	}
+
+	return findings
+}
+
+// httpsAuthEnabled reports whether the HTTPS proxy requires auth, taking
+// the fallback to the HTTP credentials into account
+func httpsAuthEnabled(cfg *config.Config) bool {
+	if cfg.HTTPS.Auth.Password == "" {
+		return cfg.HTTP.Auth.Enabled
+	}
+	return cfg.HTTPS.Auth.Enabled
+}
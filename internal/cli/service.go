@@ -2,10 +2,14 @@ package cli
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/security"
 	"wte/internal/system"
 	"wte/internal/ui"
 )
@@ -16,6 +20,9 @@ var startCmd = &cobra.Command{
 	Short: "Start the proxy service",
 	Long: `Start the GOST proxy service.
 
+The command waits up to 10 seconds for the service to be running. If it
+does not come up, the last lines of its log are shown.
+
 Examples:
   wte start`,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -23,27 +30,30 @@ Examples:
 			return err
 		}
 
-		systemd := system.NewSystemdManager()
+		service := system.NewServiceManager()
 
-		if !systemd.IsInstalled() {
+		if !service.IsInstalled() {
 			return fmt.Errorf("service is not installed. Run 'wte install' first")
 		}
 
-		status, err := systemd.Status()
+		status, err := service.Status()
 		if err == nil && status.IsActive {
 			ui.Info("Service is already running")
 			return nil
 		}
 
 		ui.Action("Starting service...")
-		if err := systemd.Start(); err != nil {
+		if err := service.Start(); err != nil {
 			return fmt.Errorf("failed to start service: %w", err)
 		}
+		if err := waitForService(service); err != nil {
+			return err
+		}
 
 		ui.Success("Service started")
 
 		// Show status
-		status, err = systemd.Status()
+		status, err = service.Status()
 		if err == nil {
 			ui.Detail("PID: %s", status.MainPID)
 		}
@@ -65,20 +75,20 @@ Examples:
 			return err
 		}
 
-		systemd := system.NewSystemdManager()
+		service := system.NewServiceManager()
 
-		if !systemd.IsInstalled() {
+		if !service.IsInstalled() {
 			return fmt.Errorf("service is not installed")
 		}
 
-		status, err := systemd.Status()
+		status, err := service.Status()
 		if err == nil && !status.IsActive {
 			ui.Info("Service is not running")
 			return nil
 		}
 
 		ui.Action("Stopping service...")
-		if err := systemd.Stop(); err != nil {
+		if err := service.Stop(); err != nil {
 			return fmt.Errorf("failed to stop service: %w", err)
 		}
 
@@ -93,6 +103,9 @@ var restartCmd = &cobra.Command{
 	Short: "Restart the proxy service",
 	Long: `Restart the GOST proxy service.
 
+The command waits up to 10 seconds for the service to be running again. If
+it does not come up, the last lines of its log are shown.
+
 Examples:
   wte restart`,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -100,21 +113,24 @@ Examples:
 			return err
 		}
 
-		systemd := system.NewSystemdManager()
+		service := system.NewServiceManager()
 
-		if !systemd.IsInstalled() {
+		if !service.IsInstalled() {
 			return fmt.Errorf("service is not installed. Run 'wte install' first")
 		}
 
 		ui.Action("Restarting service...")
-		if err := systemd.Restart(); err != nil {
+		if err := service.Restart(); err != nil {
 			return fmt.Errorf("failed to restart service: %w", err)
 		}
+		if err := waitForService(service); err != nil {
+			return err
+		}
 
 		ui.Success("Service restarted")
 
 		// Show status
-		status, err := systemd.Status()
+		status, err := service.Status()
 		if err == nil {
 			ui.Detail("PID: %s", status.MainPID)
 		}
@@ -123,6 +139,21 @@ Examples:
 	},
 }
 
+// serviceStartTimeout is how long start and restart wait for the service
+// to come up
+const serviceStartTimeout = 10 * time.Second
+
+// waitForService waits until a started service is running and shows the
+// recent logs if it does not come up
+func waitForService(service system.ServiceManager) error {
+	if err := service.WaitForActive(serviceStartTimeout); err != nil {
+		ui.Error("%v", err)
+		gost.PrintRecentLogs(service, 10)
+		return fmt.Errorf("service did not start, see 'wte logs'")
+	}
+	return nil
+}
+
 // statusCmd shows service status
 var statusCmd = &cobra.Command{
 	Use:   "status",
@@ -132,25 +163,34 @@ var statusCmd = &cobra.Command{
 This command displays:
   - Service status (running/stopped)
   - Process information
-  - Listening ports
+  - Listening ports and their active connections
+  - Ports that listen but serve the wrong thing, such as an HTTPS port
+    without TLS or with another certificate than https.cert_path
   - Configuration summary
 
+With --json, the status is printed as a JSON document for scripts.
+
 Examples:
-  wte status`,
+  wte status
+  wte status --json`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		systemd := system.NewSystemdManager()
+		service := system.NewServiceManager()
 		cfg := config.Get()
 
+		if ui.JSON {
+			return printStatusJSON(service, cfg)
+		}
+
 		ui.Header("WTE Proxy Status")
 
 		// Service status
-		if !systemd.IsInstalled() {
+		if !service.IsInstalled() {
 			ui.Warning("Service is not installed")
 			ui.Detail("Run 'wte install' to set up the proxy server")
 			return nil
 		}
 
-		status, err := systemd.Status()
+		status, err := service.Status()
 		if err != nil {
 			ui.Warning("Could not get service status: %v", err)
 		} else {
@@ -175,15 +215,33 @@ Examples:
 
 		ui.Println()
 
+		// The ports and settings come from the config
+		if config.Protected() {
+			ui.Info("Configuration:")
+			ui.Detail("Config file: %s (readable by root only)", config.GetConfigPath())
+			ui.Detail("Run 'sudo wte status' to see the ports and settings")
+			return nil
+		}
+
 		// Port status
 		ui.Info("Listening Ports:")
 
 		ports := cfg.GetRequiredPorts()
 		for _, port := range ports {
-			if system.IsPortOpen(port.Port) {
-				ui.Success("  %s: :%d (%s) - LISTENING", port.Service, port.Port, port.Protocol)
-			} else {
+			listening, warning := checkListener(cfg, port)
+			if !listening {
 				ui.Error("  %s: :%d (%s) - NOT LISTENING", port.Service, port.Port, port.Protocol)
+				continue
+			}
+			ui.Success("  %s: :%d (%s) - LISTENING", port.Service, port.Port, port.Protocol)
+			if warning != "" {
+				ui.Warning("    %s", warning)
+			}
+			if port.Protocol != "tcp" {
+				continue
+			}
+			if count, err := system.CountConnections(port.Port); err == nil {
+				ui.Detail("    Connections: %d", count)
 			}
 		}
 
@@ -198,17 +256,150 @@ Examples:
 			if cfg.HTTP.Auth.Enabled {
 				authStatus = fmt.Sprintf("user=%s", cfg.HTTP.Auth.Username)
 			}
-			ui.Detail("HTTP Proxy: :%d (%s)", cfg.HTTP.Port, authStatus)
+			ui.Detail("HTTP Proxy: %s (%s)", config.ListenAddr(cfg.HTTP.BindAddress, cfg.HTTP.Port), authStatus)
 		}
 
 		if cfg.HTTPS.Enabled {
-			ui.Detail("HTTPS Proxy: :%d", cfg.HTTPS.Port)
+			ui.Detail("HTTPS Proxy: %s", config.ListenAddr(cfg.HTTPS.BindAddress, cfg.HTTPS.Port))
 		}
 
 		if cfg.Shadowsocks.Enabled {
-			ui.Detail("Shadowsocks: :%d (method=%s)", cfg.Shadowsocks.Port, cfg.Shadowsocks.Method)
+			ui.Detail("Shadowsocks: %s (method=%s)",
+				config.ListenAddr(cfg.Shadowsocks.BindAddress, cfg.Shadowsocks.Port), cfg.Shadowsocks.Method)
+			if len(cfg.Shadowsocks.Ports) > 0 {
+				ui.Detail("Shadowsocks ports: %s", cfg.Shadowsocks.PortList())
+			}
+		}
+
+		if cfg.LocalhostOnly() {
+			ui.Detail("Access: localhost only, via SSH tunnel")
+		}
+
+		if stale, err := config.IsApplyStale(); err != nil {
+			ui.Warning("Could not check whether the configuration was applied: %v", err)
+		} else if stale {
+			ui.Println()
+			ui.Warning("WTE config changed since last apply - run 'wte config apply'")
 		}
 
 		return nil
 	},
 }
+
+// listenerCheckTimeout bounds each connection status makes to check what
+// a port serves
+const listenerCheckTimeout = 2 * time.Second
+
+// checkListener reports whether a service port is listening and, if it
+// is, describes a mismatch with the service: the HTTPS proxy must complete
+// a TLS handshake with the certificate in https.cert_path. UDP ports are
+// looked up in the socket table, as UDP has no handshake to test.
+func checkListener(cfg *config.Config, port config.PortInfo) (bool, string) {
+	if port.Protocol == "udp" {
+		return system.IsUDPPortListening(port.Port), ""
+	}
+	if !system.IsPortOpen(port.Port) {
+		return false, ""
+	}
+	if !cfg.HTTPS.Enabled || port.Port != cfg.HTTPS.Port {
+		return true, ""
+	}
+
+	served, err := system.TLSCertificate(config.DialHost(port.BindAddress), port.Port, listenerCheckTimeout)
+	if err != nil {
+		return true, fmt.Sprintf("HTTPS port open but not serving TLS: %v", err)
+	}
+
+	info, err := security.GetCertificateInfo(cfg.HTTPS.CertPath)
+	if err != nil {
+		return true, fmt.Sprintf("Could not compare the served certificate: %v", err)
+	}
+	if security.CertificateFingerprint(served) != info.Fingerprint {
+		return true, fmt.Sprintf("HTTPS port serves a certificate for %q, not the one in %s (run 'wte restart')",
+			served.Subject.CommonName, cfg.HTTPS.CertPath)
+	}
+
+	return true, ""
+}
+
+// statusReport is the JSON form of 'wte status'
+type statusReport struct {
+	Installed  bool         `json:"installed"`
+	Active     bool         `json:"active"`
+	Enabled    bool         `json:"enabled"`
+	State      string       `json:"state,omitempty"`
+	SubState   string       `json:"sub_state,omitempty"`
+	PID        int          `json:"pid,omitempty"`
+	Memory     string       `json:"memory,omitempty"`
+	Ports      []portReport `json:"ports"`
+	ConfigFile string       `json:"config_file"`
+	ApplyStale bool         `json:"apply_stale"`
+	// ConfigProtected is set when the config could not be read, Ports and
+	// ApplyStale are then left out
+	ConfigProtected bool `json:"config_protected,omitempty"`
+}
+
+// portReport is the listening state of one service port
+type portReport struct {
+	Service   string `json:"service"`
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	Listening bool   `json:"listening"`
+	// Warning describes a listening port that does not serve what the
+	// service should
+	Warning string `json:"warning,omitempty"`
+	// Connections is the number of established connections, TCP only
+	Connections *int `json:"connections,omitempty"`
+}
+
+// printStatusJSON prints the service status as JSON. Failing to query
+// the init system is returned as an error so scripts see a non-zero exit code.
+func printStatusJSON(service system.ServiceManager, cfg *config.Config) error {
+	report := statusReport{
+		Installed:  service.IsInstalled(),
+		Ports:      []portReport{},
+		ConfigFile: config.GetConfigPath(),
+	}
+
+	if report.Installed {
+		status, err := service.Status()
+		if err != nil {
+			return fmt.Errorf("could not get service status: %w", err)
+		}
+
+		report.Active = status.IsActive
+		report.Enabled = status.IsEnabled
+		report.State = status.ActiveState
+		report.SubState = status.SubState
+		report.PID, _ = strconv.Atoi(status.MainPID)
+		report.Memory = status.MemoryUsage
+	}
+
+	if config.Protected() {
+		report.ConfigProtected = true
+		return ui.PrintJSON(report)
+	}
+
+	for _, port := range cfg.GetRequiredPorts() {
+		entry := portReport{
+			Service:  port.Service,
+			Port:     port.Port,
+			Protocol: port.Protocol,
+		}
+		entry.Listening, entry.Warning = checkListener(cfg, port)
+		if entry.Listening && port.Protocol == "tcp" {
+			if count, err := system.CountConnections(port.Port); err == nil {
+				entry.Connections = &count
+			}
+		}
+		report.Ports = append(report.Ports, entry)
+	}
+
+	stale, err := config.IsApplyStale()
+	if err != nil {
+		return fmt.Errorf("could not check whether the configuration was applied: %w", err)
+	}
+	report.ApplyStale = stale
+
+	return ui.PrintJSON(report)
+}
@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/ui"
+)
+
+var ssCmd = &cobra.Command{
+	Use:   "ss",
+	Short: "Manage extra Shadowsocks ports",
+	Long: `Serve Shadowsocks on extra ports besides shadowsocks.port.
+
+Every port uses the same method and password, so clients that hop between
+ports to avoid throttling can switch freely. The extra ports are listed in
+shadowsocks.ports, relay UDP like the main port when shadowsocks.udp is set,
+and get their own import URIs in 'wte credentials' and 'wte export'.
+Changes are applied right away and the firewall ports are opened or closed
+when firewall.auto_configure is set.
+
+Examples:
+  wte ss add-port 8389            # Also serve Shadowsocks on 8389
+  wte ss remove-port 8389         # Stop serving it there`,
+}
+
+var ssAddPortCmd = &cobra.Command{
+	Use:   "add-port <port>",
+	Short: "Serve Shadowsocks on an extra port",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSSAddPort,
+}
+
+var ssRemovePortCmd = &cobra.Command{
+	Use:               "remove-port <port>",
+	Short:             "Stop serving Shadowsocks on an extra port",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeSSPorts,
+	RunE:              runSSRemovePort,
+}
+
+func init() {
+	ssCmd.AddCommand(ssAddPortCmd)
+	ssCmd.AddCommand(ssRemovePortCmd)
+	rootCmd.AddCommand(ssCmd)
+}
+
+func runSSAddPort(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	port, err := parseSSPort(args[0])
+	if err != nil {
+		return err
+	}
+
+	ss := config.Get().Shadowsocks
+	if !ss.Enabled {
+		return fmt.Errorf("Shadowsocks is not enabled")
+	}
+	for _, existing := range ss.AllPorts() {
+		if existing == port {
+			return fmt.Errorf("Shadowsocks already listens on port %d", port)
+		}
+	}
+	warnPort("shadowsocks.ports", port)
+
+	rules := firewallRules(config.Get())
+	ports := append(append([]int{}, ss.Ports...), port)
+	if err := updateSSPorts(ports); err != nil {
+		return err
+	}
+	recordAudit("ss add-port", "shadowsocks.ports", ss.Ports, ports)
+
+	ui.Success("Shadowsocks port added: %d", port)
+
+	syncFirewallPorts(diffPorts(rules, firewallRules(config.Get())))
+
+	return nil
+}
+
+func runSSRemovePort(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	port, err := parseSSPort(args[0])
+	if err != nil {
+		return err
+	}
+
+	ss := config.Get().Shadowsocks
+	if port == ss.Port {
+		return fmt.Errorf("port %d is the main Shadowsocks port, change it with 'wte config set shadowsocks.port'", port)
+	}
+
+	ports := []int{}
+	for _, existing := range ss.Ports {
+		if existing != port {
+			ports = append(ports, existing)
+		}
+	}
+	if len(ports) == len(ss.Ports) {
+		return fmt.Errorf("port %d is not an extra Shadowsocks port", port)
+	}
+
+	rules := firewallRules(config.Get())
+	if err := updateSSPorts(ports); err != nil {
+		return err
+	}
+	recordAudit("ss remove-port", "shadowsocks.ports", ss.Ports, ports)
+
+	ui.Success("Shadowsocks port removed: %d", port)
+
+	syncFirewallPorts(diffPorts(rules, firewallRules(config.Get())))
+
+	return nil
+}
+
+// parseSSPort parses a port argument
+func parseSSPort(arg string) (int, error) {
+	port, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port: %s", arg)
+	}
+	if err := config.ValidatePort("port", port); err != nil {
+		return 0, err
+	}
+	return port, nil
+}
+
+// updateSSPorts validates the configuration with ports as the extra
+// Shadowsocks ports, applies it and saves it. When the apply rolls back,
+// the previous ports are put back and nothing is saved.
+func updateSSPorts(ports []int) error {
+	candidate, err := config.WithValue("shadowsocks.ports", ports)
+	if err != nil {
+		return fmt.Errorf("failed to update Shadowsocks ports: %w", err)
+	}
+
+	if err := gost.NewConfigGenerator(candidate).Validate(); err != nil {
+		return err
+	}
+
+	previous := append([]int{}, config.Get().Shadowsocks.Ports...)
+
+	if err := config.Set("shadowsocks.ports", ports); err != nil {
+		return fmt.Errorf("failed to update Shadowsocks ports: %w", err)
+	}
+
+	if err := applyConfig(); err != nil {
+		if restoreErr := config.Set("shadowsocks.ports", previous); restoreErr != nil {
+			return fmt.Errorf("%w, and restoring shadowsocks.ports failed: %v", err, restoreErr)
+		}
+		return err
+	}
+
+	if err := config.Save(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	return nil
+}
+
+// completeSSPorts completes the extra Shadowsocks ports
+func completeSSPorts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var ports []string
+	for _, port := range config.Get().Shadowsocks.Ports {
+		ports = append(ports, strconv.Itoa(port))
+	}
+	return ports, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/state"
+	"wte/internal/ui"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect or reset WTE runtime state",
+	Long: `Inspect or reset the runtime state WTE keeps between runs.
+
+The state file holds data WTE detects or records itself, such as the cached
+public IP and latest GOST release, install and apply timestamps and the
+ports opened in the firewall. It is separate from the configuration and
+safe to reset, but 'wte uninstall' then leaves the firewall ports open.
+
+Examples:
+  wte state show     # Print the state as JSON
+  wte state reset    # Clear cached state, forcing re-detection`,
+}
+
+var stateShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the state file as JSON",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := state.Load()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal state: %w", err)
+		}
+
+		fmt.Println(string(data))
+		return nil
+	},
+}
+
+var stateResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Clear cached state without touching the configuration",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkRoot(); err != nil {
+			return err
+		}
+
+		if !ui.Confirm(fmt.Sprintf("Clear WTE state in %s?", state.Path)) {
+			ui.Info("Reset cancelled")
+			return nil
+		}
+
+		if err := state.Reset(); err != nil {
+			return err
+		}
+
+		ui.Success("State cleared")
+		ui.Detail("The public IP will be detected again on next use")
+
+		return nil
+	},
+}
+
+func init() {
+	stateCmd.AddCommand(stateShowCmd)
+	stateCmd.AddCommand(stateResetCmd)
+
+	rootCmd.AddCommand(stateCmd)
+}
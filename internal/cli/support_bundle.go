@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/security"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+var (
+	supportBundleOutput string
+	supportBundleLines  int
+)
+
+var supportBundleCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "Collect diagnostics into a tarball to share",
+	Long: `Collect what is needed to diagnose a problem into a single .tar.gz file:
+
+  system.txt          WTE, operating system and GOST versions
+  config.yaml         WTE configuration
+  gost-config.yaml    GOST configuration generated from it
+  logs.txt            Recent service logs
+  service-status.txt  systemctl status (rc-service status on OpenRC)
+  firewall.txt        Firewall status
+  certificate.txt     HTTPS certificate details, when HTTPS is enabled
+
+Passwords are replaced by ******** and removed from the logs, and the TLS
+private key is not included, so the bundle can be attached to a public
+issue. Review it before sharing anyway: it names this server's addresses
+and those of its clients.
+
+Examples:
+  wte support-bundle
+  wte support-bundle -o /tmp/wte-support.tar.gz
+  wte support-bundle --lines 2000`,
+	Args: cobra.NoArgs,
+	RunE: runSupportBundle,
+}
+
+func init() {
+	supportBundleCmd.Flags().StringVarP(&supportBundleOutput, "output", "o", "", "Write the bundle to this file (default wte-support-<time>.tar.gz)")
+	supportBundleCmd.Flags().IntVar(&supportBundleLines, "lines", 500, "Number of log lines to include")
+
+	rootCmd.AddCommand(supportBundleCmd)
+}
+
+// supportFile is a file of the support bundle
+type supportFile struct {
+	Name    string
+	Content string
+}
+
+func runSupportBundle(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	cfg := config.Get()
+	now := time.Now()
+
+	output := supportBundleOutput
+	if output == "" {
+		output = fmt.Sprintf("wte-support-%s.tar.gz", now.Format("20060102-150405"))
+	}
+
+	ui.Action("Collecting diagnostics...")
+
+	files := []supportFile{
+		{"system.txt", supportSystem(cfg, now)},
+		{"config.yaml", supportConfig(cfg)},
+		{"gost-config.yaml", supportGOSTConfig(cfg)},
+		{"logs.txt", supportLogs()},
+		{"service-status.txt", supportServiceStatus()},
+		{"firewall.txt", supportFirewall()},
+	}
+	if cfg.HTTPS.Enabled {
+		files = append(files, supportFile{"certificate.txt", supportCertificate(cfg)})
+	}
+
+	// The config is redacted already; the secrets may still appear in
+	// logs or command output
+	for i := range files {
+		files[i].Content = scrubSecrets(files[i].Content, cfg.Secrets())
+	}
+
+	dir := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(output), ".gz"), ".tar")
+	if err := writeSupportBundle(output, dir, files, now); err != nil {
+		return err
+	}
+
+	if abs, err := filepath.Abs(output); err == nil {
+		output = abs
+	}
+	ui.Success("Support bundle written to %s", output)
+	ui.Detail("Passwords are redacted and the TLS key is left out")
+	ui.Detail("It still names server and client addresses, review it before sharing")
+
+	return nil
+}
+
+// writeSupportBundle writes files into a gzip-compressed tarball at path,
+// under dir. The bundle is only readable by its owner.
+func writeSupportBundle(path, dir string, files []supportFile, modTime time.Time) (err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %w", path, closeErr)
+		}
+	}()
+
+	gzw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gzw)
+
+	for _, f := range files {
+		header := &tar.Header{
+			Name:    dir + "/" + f.Name,
+			Mode:    0600,
+			Size:    int64(len(f.Content)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if _, err := tw.Write([]byte(f.Content)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := gzw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// systemSummary describes the WTE build, the operating system and the GOST
+// binary, one "Name: value" line each
+func systemSummary(cfg *config.Config) []string {
+	lines := []string{fmt.Sprintf("WTE version: %s (commit %s, built %s)", Version, GitCommit, BuildTime)}
+
+	osInfo, err := system.DetectOS()
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("OS: unknown (%v)", err))
+	} else {
+		name := osInfo.PrettyName
+		if name == "" {
+			name = strings.TrimSpace(osInfo.OS + " " + osInfo.Version)
+		}
+		lines = append(lines, fmt.Sprintf("OS: %s (%s)", name, osInfo.Arch))
+	}
+
+	gostVersion, err := gost.NewInstaller(cfg, osInfo).GetVersion()
+	if err != nil {
+		gostVersion = "unknown (" + err.Error() + ")"
+	}
+	lines = append(lines, "GOST version: "+gostVersion)
+
+	return lines
+}
+
+// supportSystem describes the build and the host
+func supportSystem(cfg *config.Config, now time.Time) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Collected: %s\n", now.Format(time.RFC3339))
+	for _, line := range systemSummary(cfg) {
+		b.WriteString(line + "\n")
+	}
+
+	report := newVersionReport()
+	fmt.Fprintf(&b, "Go version: %s (%s/%s)\n", report.GoVersion, report.OS, report.Arch)
+
+	if osInfo, err := system.DetectOS(); err == nil {
+		fmt.Fprintf(&b, "OS ID: %s %s\n", osInfo.OS, osInfo.Version)
+		fmt.Fprintf(&b, "GOST architecture: %s\n", osInfo.GOSTArch)
+		fmt.Fprintf(&b, "Package manager: %s\n", osInfo.PackageManager)
+		fmt.Fprintf(&b, "Supported: %t\n", osInfo.IsSupported)
+		if osInfo.Fallback {
+			b.WriteString("No OS metadata found, generic Linux assumed\n")
+		}
+	}
+
+	initSystem := "systemd"
+	if _, ok := system.NewServiceManager().(*system.OpenRCManager); ok {
+		initSystem = "openrc"
+	}
+	fmt.Fprintf(&b, "Init system: %s\n", initSystem)
+	fmt.Fprintf(&b, "Config file: %s\n", config.GetConfigPath())
+
+	return b.String()
+}
+
+// supportConfig returns the WTE configuration without passwords
+func supportConfig(cfg *config.Config) string {
+	data, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v\n", err)
+	}
+	return string(data)
+}
+
+// supportGOSTConfig returns the GOST configuration generated from the WTE
+// configuration without passwords. The file on disk is not included, it
+// holds the passwords.
+func supportGOSTConfig(cfg *config.Config) string {
+	data, err := gost.NewConfigGenerator(cfg.Redacted()).Render()
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v\n", err)
+	}
+	return string(data)
+}
+
+// supportLogs returns the recent service logs
+func supportLogs() string {
+	service := system.NewServiceManager()
+	if !service.IsInstalled() {
+		return "unavailable: service is not installed\n"
+	}
+
+	logs, err := service.Logs(system.LogOptions{Lines: supportBundleLines})
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v\n", err)
+	}
+	if logs == "" {
+		return "no logs\n"
+	}
+	return logs
+}
+
+// supportServiceStatus returns the init system's view of the service
+func supportServiceStatus() string {
+	report, err := system.NewServiceManager().StatusReport()
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v\n", err)
+	}
+	return report
+}
+
+// supportFirewall returns the firewall status
+func supportFirewall() string {
+	firewall := system.NewFirewallManager()
+
+	status, err := firewall.Status()
+	if err != nil {
+		return fmt.Sprintf("Firewall: %s\nunavailable: %v\n", firewall.GetType(), err)
+	}
+	return fmt.Sprintf("Firewall: %s\n\n%s\n", firewall.GetType(), status)
+}
+
+// supportCertificate describes the HTTPS certificate
+func supportCertificate(cfg *config.Config) string {
+	info, err := security.GetCertificateInfo(cfg.HTTPS.CertPath)
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v\n", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Path: %s\n", cfg.HTTPS.CertPath)
+	fmt.Fprintf(&b, "Subject: %s\n", info.Subject)
+	fmt.Fprintf(&b, "Issuer: %s\n", info.Issuer)
+	fmt.Fprintf(&b, "Valid: %s to %s\n", info.NotBefore.Format(time.RFC3339), info.NotAfter.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Expired: %t (%d days left)\n", info.IsExpired, info.DaysLeft)
+	fmt.Fprintf(&b, "IP addresses: %s\n", strings.Join(info.IPAddresses, ", "))
+	fmt.Fprintf(&b, "DNS names: %s\n", strings.Join(info.DNSNames, ", "))
+	fmt.Fprintf(&b, "SHA-256 fingerprint: %s\n", info.Fingerprint)
+
+	return b.String()
+}
+
+// minScrubbedSecret is the length below which a secret is not scrubbed
+// from text, as it would match ordinary words
+const minScrubbedSecret = 4
+
+// scrubSecrets replaces each secret in text by config.RedactedSecret, also
+// where it appears percent-encoded as in proxy URLs
+func scrubSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if len(secret) < minScrubbedSecret {
+			continue
+		}
+		text = strings.ReplaceAll(text, secret, config.RedactedSecret)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			text = strings.ReplaceAll(text, escaped, config.RedactedSecret)
+		}
+		if escaped := url.PathEscape(secret); escaped != secret {
+			text = strings.ReplaceAll(text, escaped, config.RedactedSecret)
+		}
+	}
+	return text
+}
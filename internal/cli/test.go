@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+var (
+	testExternal bool
+	testTimeout  time.Duration
+)
+
+// serviceTest is a proxy service checked by wte test
+type serviceTest struct {
+	Name     string
+	Bind     string
+	Port     int
+	UseTLS   bool
+	Username string
+	Password string
+	// TCPOnly services can only be checked for reachability
+	TCPOnly bool
+}
+
+// serviceTestResult is the outcome of one service in 'wte test --json'.
+// Status is fail when the local or the external test failed.
+type serviceTestResult struct {
+	checkResult
+	Port int `json:"port"`
+	// EgressIP is the address traffic leaves the proxy from, for the
+	// services that can relay a request
+	EgressIP string `json:"egress_ip,omitempty"`
+	// External is the status of the test through the public IP, with
+	// --external
+	External string `json:"external,omitempty"`
+}
+
+// testReport is the JSON document of 'wte test --json'. OK is false when a
+// service failed.
+type testReport struct {
+	OK       bool                `json:"ok"`
+	PublicIP string              `json:"public_ip,omitempty"`
+	Services []serviceTestResult `json:"services"`
+}
+
+var testCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Test that the proxy services work end to end",
+	Long: `Connect to each enabled proxy service and check that it works.
+
+HTTP and HTTPS proxies are used with the configured credentials to fetch
+https://ifconfig.me, which also reports the IP address traffic leaves the
+proxy from. Shadowsocks is checked for reachability only.
+
+By default the services are tested on this server. With --external each
+service is also tested through the public IP, which exercises the firewall
+and NAT path clients use. A service that passes locally but fails externally
+is blocked by the network path, one that fails both ways is a service problem.
+
+Some providers don't route a server's traffic to its own public IP (NAT
+hairpinning). If only the external test fails, confirm from a client machine.
+
+With --json the results are printed as a JSON document: the name, status
+(pass or fail), detail, port and egress IP of each service, and an overall
+"ok". The command exits with an error when a service fails either way.
+
+Examples:
+  wte test               # Test the services locally
+  wte test --external    # Also test through the public IP
+  wte test --json        # Machine-readable results`,
+	RunE: runTest,
+}
+
+func init() {
+	testCmd.Flags().BoolVar(&testExternal, "external", false, "Also test through the public IP address")
+	testCmd.Flags().DurationVar(&testTimeout, "timeout", 10*time.Second, "Timeout for each connection")
+
+	rootCmd.AddCommand(testCmd)
+}
+
+func runTest(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	ui.Header("WTE Connection Test")
+
+	tests := serviceTests(cfg)
+	if len(tests) == 0 {
+		return fmt.Errorf("no proxy services are enabled")
+	}
+
+	publicIP := ""
+	if testExternal {
+		ui.Action("Detecting public IP address...")
+		ip, err := system.GetPublicIP()
+		if err != nil {
+			return fmt.Errorf("failed to detect public IP: %w", err)
+		}
+		publicIP = ip
+		ui.Detail("Public IP: %s", publicIP)
+	}
+
+	headers := []string{"Service", "Local", "Egress IP"}
+	if testExternal {
+		headers = append(headers, "External", "Diagnosis")
+	}
+	table := ui.NewTable(headers)
+
+	failed := 0
+	var problems []string
+	report := testReport{PublicIP: publicIP}
+
+	for _, test := range tests {
+		ui.Action("Testing %s...", test.Name)
+
+		egressIP, localErr := runServiceTest(test, config.DialHost(test.Bind), testTimeout)
+		row := []string{test.Name, testResult(localErr), egressResult(test, egressIP)}
+		result := serviceTestResult{
+			checkResult: checkResult{Name: test.Name, Status: checkPass, Detail: "OK"},
+			Port:        test.Port,
+			EgressIP:    egressIP,
+		}
+		if localErr != nil {
+			problems = append(problems, fmt.Sprintf("%s (local): %v", test.Name, localErr))
+			result.Status, result.Detail = checkFail, localErr.Error()
+		}
+
+		if !testExternal {
+			if localErr != nil {
+				failed++
+			}
+			table.Append(row)
+			report.Services = append(report.Services, result)
+			continue
+		}
+
+		if config.IsLoopback(test.Bind) {
+			row = append(row, "skipped", "localhost only, not exposed")
+			if localErr != nil {
+				failed++
+			}
+			table.Append(row)
+			result.External = "skipped"
+			report.Services = append(report.Services, result)
+			continue
+		}
+
+		_, externalErr := runServiceTest(test, publicIP, testTimeout)
+		result.External = checkPass
+		if externalErr != nil {
+			problems = append(problems, fmt.Sprintf("%s (external): %v", test.Name, externalErr))
+			result.External = checkFail
+		}
+
+		var diagnosis string
+		switch {
+		case localErr == nil && externalErr == nil:
+			diagnosis = "OK"
+		case localErr == nil:
+			diagnosis = fmt.Sprintf("network path: check firewall/NAT for port %d/tcp", test.Port)
+		case externalErr == nil:
+			diagnosis = "local connection failed, check the bind address"
+		default:
+			diagnosis = "service problem: check 'wte status' and 'wte logs'"
+		}
+		if localErr != nil || externalErr != nil {
+			failed++
+			result.Status, result.Detail = checkFail, diagnosis
+		}
+
+		table.Append(append(row, testResult(externalErr), diagnosis))
+		report.Services = append(report.Services, result)
+	}
+
+	if ui.JSON {
+		report.OK = failed == 0
+		if err := ui.PrintJSON(report); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d services failed the test", failed, len(tests))
+		}
+		return nil
+	}
+
+	ui.Println()
+	table.Render()
+	ui.Println()
+
+	for _, problem := range problems {
+		ui.Detail("%s", problem)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services failed the test", failed, len(tests))
+	}
+
+	ui.Success("All services passed")
+	return nil
+}
+
+// serviceTests lists the enabled services with the credentials to use
+func serviceTests(cfg *config.Config) []serviceTest {
+	var tests []serviceTest
+
+	if cfg.HTTP.Enabled {
+		test := serviceTest{Name: "HTTP", Bind: cfg.HTTP.BindAddress, Port: cfg.HTTP.Port}
+		if cfg.HTTP.Auth.Enabled {
+			test.Username = cfg.HTTP.Auth.Username
+			test.Password = cfg.HTTP.Auth.Password
+		}
+		tests = append(tests, test)
+	}
+
+	if cfg.HTTPS.Enabled {
+		auth := cfg.HTTPS.Auth
+		if auth.Password == "" {
+			auth = cfg.HTTP.Auth
+		}
+		test := serviceTest{Name: "HTTPS", Bind: cfg.HTTPS.BindAddress, Port: cfg.HTTPS.Port, UseTLS: true}
+		if auth.Enabled {
+			test.Username = auth.Username
+			test.Password = auth.Password
+		}
+		tests = append(tests, test)
+	}
+
+	if cfg.Shadowsocks.Enabled {
+		for i, port := range cfg.Shadowsocks.AllPorts() {
+			name := "Shadowsocks"
+			if i > 0 {
+				name = fmt.Sprintf("Shadowsocks :%d", port)
+			}
+			tests = append(tests, serviceTest{
+				Name:    name,
+				Bind:    cfg.Shadowsocks.BindAddress,
+				Port:    port,
+				TCPOnly: true,
+			})
+		}
+	}
+
+	return tests
+}
+
+// runServiceTest checks a single service through host and returns the
+// egress IP reported through it, if the service can relay a request
+func runServiceTest(test serviceTest, host string, timeout time.Duration) (string, error) {
+	ui.Debug("Connecting to %s", net.JoinHostPort(host, strconv.Itoa(test.Port)))
+
+	if test.TCPOnly {
+		return "", system.TestTCPPort(host, test.Port, timeout)
+	}
+	return system.TestHTTPProxyEgress(host, test.Port, test.UseTLS, test.Username, test.Password, timeout)
+}
+
+// egressResult formats the egress IP for the result table
+func egressResult(test serviceTest, ip string) string {
+	switch {
+	case test.TCPOnly:
+		return "n/a"
+	case ip == "":
+		return "-"
+	}
+	return ip
+}
+
+// testResult formats a test outcome for the result table
+func testResult(err error) string {
+	if err != nil {
+		return "FAIL"
+	}
+	return "PASS"
+}
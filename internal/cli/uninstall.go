@@ -2,12 +2,16 @@ package cli
 
 import (
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 
 	"wte/internal/config"
+	"wte/internal/fsutil"
 	"wte/internal/gost"
+	"wte/internal/logging"
 	"wte/internal/security"
+	"wte/internal/state"
 	"wte/internal/system"
 	"wte/internal/ui"
 )
@@ -15,6 +19,8 @@ import (
 var (
 	uninstallForce     bool
 	uninstallKeepCreds bool
+	uninstallPurgeLogs bool
+	uninstallPurgeBaks bool
 )
 
 var uninstallCmd = &cobra.Command{
@@ -25,34 +31,62 @@ var uninstallCmd = &cobra.Command{
 This command will:
   - Stop the GOST service
   - Disable autostart
-  - Remove the systemd service file
+  - Remove the service file
   - Remove the GOST binary
+  - Close the firewall ports WTE opened
   - Remove configuration files
   - Optionally keep credentials file
+  - Optionally remove the service logs and configuration backups
+
+--purge-logs vacuums the archived journal files on systemd hosts. journald
+can only drop whole files, so entries of other services are removed with
+GOST's, while entries still in the active files are kept. On OpenRC hosts
+the GOST log file is deleted.
+
+--purge-backups deletes the timestamped backups WTE made of its own
+configuration files (<file>.backup.<time>), nothing else.
 
 Examples:
   wte uninstall              # Uninstall with confirmation
   wte uninstall --force      # Uninstall without confirmation
-  wte uninstall --keep-creds # Keep credentials file`,
-	RunE: runUninstall,
+  wte uninstall --keep-creds # Keep credentials file
+  wte uninstall --purge-logs --purge-backups  # Leave nothing behind
+  wte uninstall --dry-run    # Show what would be removed`,
+	Annotations: map[string]string{dryRunAnnotation: "true"},
+	RunE:        runUninstall,
 }
 
 func init() {
 	uninstallCmd.Flags().BoolVarP(&uninstallForce, "force", "f", false, "Skip confirmation prompt")
 	uninstallCmd.Flags().BoolVar(&uninstallKeepCreds, "keep-creds", false, "Keep credentials file")
+	uninstallCmd.Flags().BoolVar(&uninstallPurgeLogs, "purge-logs", false, "Also delete the service logs (vacuums the journal)")
+	uninstallCmd.Flags().BoolVar(&uninstallPurgeBaks, "purge-backups", false, "Also delete WTE's timestamped configuration backups")
 }
 
 func runUninstall(cmd *cobra.Command, args []string) error {
-	// Check root
-	if err := checkRoot(); err != nil {
-		return err
+	// Check root, a dry run only reads
+	if !dryRun {
+		if err := checkRoot(); err != nil {
+			return err
+		}
 	}
 
+	logging.Logger.Info("uninstall started", "dry_run", dryRun, "keep_creds", uninstallKeepCreds)
+
 	ui.PrintBanner(Version)
 	ui.Header("Uninstalling WTE Proxy")
 
+	if dryRun {
+		ui.Info("Dry run: showing what would be done, nothing will be changed")
+	}
+
+	// Shown even without the confirmation, the logs can't be brought back
+	if uninstallPurgeLogs && system.IsSystemd() {
+		ui.Warning("--purge-logs deletes archived journal files, including other services' logs.")
+	}
+
 	// Confirmation
-	if !uninstallForce {
+	if !uninstallForce && !dryRun {
 		ui.Warning("This will completely remove the GOST proxy server installation.")
 		ui.Println()
 		if !ui.Confirm("Are you sure you want to continue?") {
@@ -62,7 +96,7 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 	}
 
 	cfg := config.Get()
-	systemd := system.NewSystemdManager()
+	service := system.NewServiceManager()
 	osInfo, _ := system.DetectOS()
 
 	var installer *gost.Installer
@@ -70,17 +104,19 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 		installer = gost.NewInstaller(cfg, osInfo)
 	}
 
-	totalSteps := 6
+	totalSteps := 7
 	currentStep := 0
 
 	// Step 1: Stop service
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Stopping service")
 
-	status, _ := systemd.Status()
-	if status != nil && status.IsActive {
+	status, _ := service.Status()
+	if status != nil && status.IsActive && dryRun {
+		planAction("stop the GOST service")
+	} else if status != nil && status.IsActive {
 		ui.Action("Stopping GOST service...")
-		if err := systemd.Stop(); err != nil {
+		if err := service.Stop(); err != nil {
 			ui.Warning("Could not stop service: %v", err)
 		} else {
 			ui.Success("Service stopped")
@@ -93,9 +129,11 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Disabling service")
 
-	if status != nil && status.IsEnabled {
+	if status != nil && status.IsEnabled && dryRun {
+		planAction("disable service autostart")
+	} else if status != nil && status.IsEnabled {
 		ui.Action("Disabling service autostart...")
-		if err := systemd.Disable(); err != nil {
+		if err := service.Disable(); err != nil {
 			ui.Warning("Could not disable service: %v", err)
 		} else {
 			ui.Success("Service disabled")
@@ -104,13 +142,37 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 		ui.Success("Service was not enabled")
 	}
 
-	// Step 3: Remove systemd service file
+	// Step 3: Remove service file
 	currentStep++
-	ui.Step(currentStep, totalSteps, "Removing systemd service")
+	ui.Step(currentStep, totalSteps, "Removing service")
 
-	if systemd.IsInstalled() {
+	if systemd, ok := service.(*system.SystemdManager); ok && systemd.IsLockoutInstalled() && dryRun {
+		planAction("remove the auth lockout watcher")
+	} else if ok && systemd.IsLockoutInstalled() {
+		ui.Action("Removing auth lockout watcher...")
+		if err := systemd.RemoveLockoutService(); err != nil {
+			ui.Warning("Could not remove auth lockout watcher: %v", err)
+		} else {
+			ui.Success("Auth lockout watcher removed")
+		}
+	}
+
+	if systemd, ok := service.(*system.SystemdManager); ok && systemd.IsWatchdogInstalled() && dryRun {
+		planAction("remove the watchdog")
+	} else if ok && systemd.IsWatchdogInstalled() {
+		ui.Action("Removing watchdog...")
+		if err := systemd.RemoveWatchdogService(); err != nil {
+			ui.Warning("Could not remove watchdog: %v", err)
+		} else {
+			ui.Success("Watchdog removed")
+		}
+	}
+
+	if service.IsInstalled() && dryRun {
+		planAction("remove %s", service.DefinitionPath())
+	} else if service.IsInstalled() {
 		ui.Action("Removing service file...")
-		if err := systemd.Remove(); err != nil {
+		if err := service.Remove(); err != nil {
 			ui.Warning("Could not remove service file: %v", err)
 		} else {
 			ui.Success("Service file removed")
@@ -123,7 +185,9 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Removing GOST binary")
 
-	if installer != nil && installer.IsInstalled() {
+	if installer != nil && installer.IsInstalled() && dryRun {
+		planAction("remove %s", cfg.GOST.BinaryPath)
+	} else if installer != nil && installer.IsInstalled() {
 		ui.Action("Removing binary...")
 		if err := installer.Uninstall(); err != nil {
 			ui.Warning("Could not remove binary: %v", err)
@@ -134,42 +198,71 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 		ui.Success("Binary not found")
 	}
 
-	// Step 5: Remove configuration
+	// Step 5: Close firewall ports, before the state file recording them
+	// is removed
+	currentStep++
+	ui.Step(currentStep, totalSteps, "Closing firewall ports")
+
+	closeOpenedPorts()
+
+	// Step 6: Remove configuration
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Removing configuration")
 
-	configGen := gost.NewConfigGenerator(cfg)
-	if err := configGen.Remove(); err != nil {
-		ui.Warning("Could not remove GOST configuration: %v", err)
-	} else {
-		ui.Success("GOST configuration removed")
+	// Backups first, so the GOST config directory ends up empty and is removed
+	if uninstallPurgeBaks {
+		purgeConfigBackups(cfg)
 	}
 
-	// Remove WTE config
-	if system.FileExists(config.WTEConfigFile) {
-		if err := os.Remove(config.WTEConfigFile); err != nil {
-			ui.Warning("Could not remove WTE configuration: %v", err)
+	if dryRun {
+		for _, path := range []string{cfg.GOST.ConfigFile, config.WTEConfigFile, state.Path} {
+			if system.FileExists(path) {
+				planAction("remove %s", path)
+			}
+		}
+		if security.CertificateExists(cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath) {
+			planAction("remove the TLS certificate %s and key %s", cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath)
+		}
+	} else {
+		configGen := gost.NewConfigGenerator(cfg)
+		if err := configGen.Remove(); err != nil {
+			ui.Warning("Could not remove GOST configuration: %v", err)
 		} else {
-			ui.Success("WTE configuration removed")
+			ui.Success("GOST configuration removed")
 		}
-	}
 
-	// Remove TLS certificates if they exist
-	if security.CertificateExists(cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath) {
-		if err := security.RemoveCertificates(cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath); err != nil {
-			ui.Warning("Could not remove certificates: %v", err)
-		} else {
-			ui.Success("TLS certificates removed")
+		// Remove WTE config
+		if system.FileExists(config.WTEConfigFile) {
+			if err := os.Remove(config.WTEConfigFile); err != nil {
+				ui.Warning("Could not remove WTE configuration: %v", err)
+			} else {
+				ui.Success("WTE configuration removed")
+			}
+		}
+
+		if err := state.Reset(); err != nil {
+			ui.Warning("Could not remove state file: %v", err)
+		}
+
+		// Remove TLS certificates if they exist
+		if security.CertificateExists(cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath) {
+			if err := security.RemoveCertificates(cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath); err != nil {
+				ui.Warning("Could not remove certificates: %v", err)
+			} else {
+				ui.Success("TLS certificates removed")
+			}
 		}
 	}
 
-	// Step 6: Remove credentials file
+	// Step 7: Remove credentials file
 	currentStep++
 	ui.Step(currentStep, totalSteps, "Cleaning up")
 
 	if !uninstallKeepCreds {
 		credsMgr := gost.NewCredentialsManager(cfg, "")
-		if credsMgr.Exists() {
+		if credsMgr.Exists() && dryRun {
+			planAction("remove %s", credsMgr.GetPath())
+		} else if credsMgr.Exists() {
 			if err := credsMgr.Remove(); err != nil {
 				ui.Warning("Could not remove credentials file: %v", err)
 			} else {
@@ -180,6 +273,26 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 		ui.Info("Keeping credentials file as requested")
 	}
 
+	if uninstallPurgeLogs && dryRun {
+		planAction("delete the service logs")
+	} else if uninstallPurgeLogs {
+		ui.Action("Purging service logs...")
+		if err := service.PurgeLogs(); err != nil {
+			ui.Warning("Could not purge service logs: %v", err)
+		} else {
+			ui.Success("Service logs purged")
+		}
+	}
+
+	if dryRun {
+		ui.Println()
+		ui.Success("Dry run finished, nothing was changed")
+		return nil
+	}
+
+	recordAudit("uninstall", "", nil, nil)
+	logging.Logger.Info("uninstall finished")
+
 	// Done
 	ui.Println()
 	ui.Green.Println("╔══════════════════════════════════════════════════════════════════════════════╗")
@@ -189,8 +302,86 @@ func runUninstall(cmd *cobra.Command, args []string) error {
 
 	ui.Info("GOST proxy server has been completely removed.")
 	if uninstallKeepCreds {
-		ui.Detail("Credentials file kept at: %s", config.CredentialsFile)
+		ui.Detail("Credentials file kept at: %s", gost.NewCredentialsManager(cfg, "").GetPath())
 	}
 
 	return nil
 }
+
+// closeOpenedPorts removes the firewall rules WTE recorded adding. Rules
+// the firewall had before WTE are not recorded and stay.
+func closeOpenedPorts() {
+	s, err := state.Load()
+	if err != nil {
+		ui.Warning("Could not read the opened ports: %v", err)
+		return
+	}
+	if len(s.OpenedPorts) == 0 {
+		ui.Success("No firewall ports to close")
+		return
+	}
+
+	if dryRun {
+		for _, port := range s.OpenedPorts {
+			planAction("close port %s", describePort(port))
+		}
+		return
+	}
+
+	firewall := system.NewFirewallManager()
+	var closed []state.Port
+	for _, port := range s.OpenedPorts {
+		if err := firewall.ClosePortFrom(port.Port, port.Protocol, port.Source); err != nil {
+			ui.Warning("Could not close port %s: %v", describePort(port), err)
+			continue
+		}
+		closed = append(closed, port)
+	}
+	if len(closed) == 0 {
+		return
+	}
+
+	if err := firewall.Apply(); err != nil {
+		ui.Warning("Could not apply firewall changes: %v", err)
+		return
+	}
+	for _, port := range closed {
+		ui.Detail("Port %s closed", describePort(port))
+	}
+}
+
+// purgeConfigBackups removes the timestamped backups of the GOST and WTE
+// configuration files and reports how many were removed
+func purgeConfigBackups(cfg *config.Config) {
+	var backups []string
+	for _, path := range []string{cfg.GOST.ConfigFile, config.WTEConfigFile} {
+		found, err := fsutil.Backups(path)
+		if err != nil {
+			ui.Warning("Could not list backups in %s: %v", filepath.Dir(path), err)
+			continue
+		}
+		backups = append(backups, found...)
+	}
+
+	if len(backups) == 0 {
+		ui.Success("No configuration backups found")
+		return
+	}
+
+	removed := 0
+	for _, backup := range backups {
+		if dryRun {
+			planAction("remove %s", backup)
+			continue
+		}
+		if err := os.Remove(backup); err != nil {
+			ui.Warning("Could not remove %s: %v", backup, err)
+			continue
+		}
+		removed++
+	}
+
+	if !dryRun {
+		ui.Success("Removed %d of %d configuration backups", removed, len(backups))
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"wte/internal/logging"
 	"wte/internal/ui"
 	"wte/internal/updater"
 )
@@ -24,6 +25,11 @@ This command will:
   - Download the appropriate binary for your platform
   - Replace the current binary with the new one
 
+Network and server errors of the GitHub API are retried. Without a token
+GitHub allows 60 API requests per hour, set GITHUB_TOKEN to raise the
+limit. When the API is rate limited or unavailable, the latest release is
+looked up on the GitHub release page instead, without its release notes.
+
 Examples:
   wte update              # Update to latest version
   wte update --check      # Only check for updates
@@ -96,10 +102,14 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 	ui.Println()
 	ui.Header("Updating WTE")
 
+	logging.Logger.Info("update started", "from", currentVersion, "to", latestVersion)
+
 	if err := upd.Update(release); err != nil {
 		return fmt.Errorf("update failed: %w", err)
 	}
 
+	logging.Logger.Info("update finished", "version", latestVersion)
+
 	ui.Println()
 	ui.Green.Println("╔══════════════════════════════════════════════════════════════════════════════╗")
 	ui.Green.Println("║                        ✓ UPDATE COMPLETED SUCCESSFULLY                      ║")
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"wte/internal/config"
+	"wte/internal/gost"
+	"wte/internal/logging"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// watchdogCheckTimeout is the timeout of each connection of a health check
+const watchdogCheckTimeout = 5 * time.Second
+
+var watchdogCmd = &cobra.Command{
+	Use:   "watchdog",
+	Short: "Restart the service when its ports stop accepting connections",
+	Long: `Check the proxy services periodically and restart the service when they
+stop accepting connections.
+
+systemd restarts GOST when it exits, but not when the process hangs without
+accepting connections. The watchdog connects to every enabled service
+each interval. After the configured number of consecutive failed checks it
+restarts the service, at most once per cooldown so a service that does not
+recover is not restarted in a loop. A stopped service is left alone.
+
+When watchdog.enabled is true, 'wte config apply' installs the watchdog as
+the 'wte-watchdog' service. Checks and restarts are written to the WTE log
+(logging.file) and the journal.
+
+Settings:
+  watchdog.enabled   Enable/disable the watchdog (true/false)
+  watchdog.interval  Time between checks (default 1m)
+  watchdog.failures  Consecutive failed checks before a restart (default 3)
+  watchdog.cooldown  Minimum time between restarts (default 10m)
+
+Examples:
+  wte config set watchdog.enabled true
+  wte config set watchdog.interval 30s
+  wte config apply`,
+	Args: cobra.NoArgs,
+	RunE: runWatchdog,
+}
+
+func init() {
+	rootCmd.AddCommand(watchdogCmd)
+}
+
+func runWatchdog(cmd *cobra.Command, args []string) error {
+	if err := checkRoot(); err != nil {
+		return err
+	}
+
+	cfg := config.Get()
+
+	settings, err := gost.ParseWatchdogSettings(cfg.Watchdog)
+	if err != nil {
+		return err
+	}
+
+	tests := serviceTests(cfg)
+	if len(tests) == 0 {
+		return fmt.Errorf("no proxy services are enabled")
+	}
+
+	service := system.NewServiceManager()
+	watchdog := gost.NewWatchdog(settings)
+
+	ui.Info("Watchdog active: checking every %s, %d failed checks restart the service, at most once every %s",
+		settings.Interval, settings.Failures, settings.Cooldown)
+	logging.Logger.Info("watchdog started",
+		"interval", settings.Interval, "failures", settings.Failures, "cooldown", settings.Cooldown)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			watchdogCheck(cfg, service, watchdog, tests, now)
+		case <-sigChan:
+			logging.Logger.Info("watchdog stopped")
+			return nil
+		}
+	}
+}
+
+// watchdogCheck runs one health check and restarts the service when the
+// watchdog decides to
+func watchdogCheck(cfg *config.Config, service system.ServiceManager, watchdog *gost.Watchdog, tests []serviceTest, now time.Time) {
+	status, err := service.Status()
+	if err != nil || !status.IsActive {
+		// Stopped on purpose, or systemd is already restarting it
+		logging.Logger.Debug("watchdog check skipped, service not active")
+		watchdog.Reset()
+		return
+	}
+
+	var failure error
+	for _, test := range tests {
+		if err := system.TestTCPPort(config.DialHost(test.Bind), test.Port, watchdogCheckTimeout); err != nil {
+			failure = fmt.Errorf("%s: %w", test.Name, err)
+			break
+		}
+	}
+
+	restart := watchdog.RecordCheck(failure == nil, now)
+	if failure == nil {
+		logging.Logger.Debug("watchdog check passed")
+		return
+	}
+
+	if !restart {
+		if watchdog.CoolingDown(now) && watchdog.Failures() >= cfg.Watchdog.Failures {
+			ui.Warning("watchdog: %v, restart skipped during the cooldown", failure)
+			return
+		}
+		ui.Warning("watchdog: %v (%d of %d failed checks)", failure, watchdog.Failures(), cfg.Watchdog.Failures)
+		return
+	}
+
+	ui.Warning("watchdog: %v, restarting the service after %d failed checks", failure, cfg.Watchdog.Failures)
+
+	if err := service.Restart(); err != nil {
+		ui.Error("watchdog: restart failed: %v", err)
+		return
+	}
+
+	if err := gost.WaitForReady(service, cfg, gost.DefaultApplyTimeout); err != nil {
+		ui.Error("watchdog: service not ready after restart: %v", err)
+		return
+	}
+
+	ui.Info("watchdog: service restarted and accepting connections")
+}
+
+// syncWatchdogService installs or removes the watchdog to match the config.
+// Its unit is a systemd service, so it is only available on systemd.
+func syncWatchdogService(cfg *config.Config, service system.ServiceManager) error {
+	systemd, ok := service.(*system.SystemdManager)
+	if !ok {
+		if cfg.Watchdog.Enabled {
+			return fmt.Errorf("the watchdog requires systemd")
+		}
+		return nil
+	}
+
+	if !cfg.Watchdog.Enabled {
+		return systemd.RemoveWatchdogService()
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	return systemd.CreateWatchdogService(executable)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"wte/internal/state"
+)
+
+// FileHash returns the SHA-256 hash of the active WTE config file
+func FileHash() (string, error) {
+	data, err := os.ReadFile(GetConfigPath())
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RecordApplied stores the hash of the WTE config the GOST config was
+// generated from
+func RecordApplied() error {
+	hash, err := FileHash()
+	if err != nil {
+		return err
+	}
+
+	return state.Update(func(s *state.State) {
+		s.AppliedConfigHash = hash
+		s.AppliedAt = state.Now()
+	})
+}
+
+// IsApplyStale reports whether the WTE config changed since it was last
+// applied. It returns false when no apply has been recorded yet.
+func IsApplyStale() (bool, error) {
+	s, err := state.Load()
+	if err != nil {
+		return false, err
+	}
+
+	if s.AppliedConfigHash == "" {
+		return false, nil
+	}
+
+	hash, err := FileHash()
+	if err != nil {
+		return false, err
+	}
+
+	return s.AppliedConfigHash != hash, nil
+}
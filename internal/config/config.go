@@ -1,21 +1,76 @@
 package config
 
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
 // Config represents the main application configuration
 type Config struct {
+	// Version is the layout of the config file, see CurrentVersion
+	Version int `yaml:"version" mapstructure:"version"`
+
+	Server      ServerConfig      `yaml:"server" mapstructure:"server"`
 	GOST        GOSTConfig        `yaml:"gost" mapstructure:"gost"`
 	HTTP        HTTPConfig        `yaml:"http" mapstructure:"http"`
 	HTTPS       HTTPSConfig       `yaml:"https" mapstructure:"https"`
 	Shadowsocks ShadowsocksConfig `yaml:"shadowsocks" mapstructure:"shadowsocks"`
+	Relay       RelayConfig       `yaml:"relay" mapstructure:"relay"`
 	Firewall    FirewallConfig    `yaml:"firewall" mapstructure:"firewall"`
+	Security    SecurityConfig    `yaml:"security" mapstructure:"security"`
+	Watchdog    WatchdogConfig    `yaml:"watchdog" mapstructure:"watchdog"`
+	Credentials CredentialsConfig `yaml:"credentials" mapstructure:"credentials"`
+	Debug       DebugConfig       `yaml:"debug" mapstructure:"debug"`
 	Logging     LoggingConfig     `yaml:"logging" mapstructure:"logging"`
 }
 
+// ServerConfig identifies this WTE instance
+type ServerConfig struct {
+	// Name labels the server in credentials, client exports and the
+	// systemd unit
+	Name string `yaml:"name" mapstructure:"name"`
+}
+
 // GOSTConfig holds GOST binary configuration
 type GOSTConfig struct {
 	Version    string `yaml:"version" mapstructure:"version"`
 	BinaryPath string `yaml:"binary_path" mapstructure:"binary_path"`
 	ConfigDir  string `yaml:"config_dir" mapstructure:"config_dir"`
 	ConfigFile string `yaml:"config_file" mapstructure:"config_file"`
+
+	// RunAsUser is the system user the service runs as, "root" or empty
+	// for root
+	RunAsUser string `yaml:"run_as_user" mapstructure:"run_as_user"`
+
+	// MemoryMax and CPUQuota limit the service's resources, in systemd
+	// notation (e.g. "256M", "50%"). Empty means no limit.
+	MemoryMax string `yaml:"memory_max" mapstructure:"memory_max"`
+	CPUQuota  string `yaml:"cpu_quota" mapstructure:"cpu_quota"`
+
+	// DownloadMirror replaces the GitHub release download URL. It must
+	// serve the same layout: <mirror>/v<version>/<archive>.
+	DownloadMirror string `yaml:"download_mirror" mapstructure:"download_mirror"`
+
+	// ExtraServices is a YAML list of GOST services added verbatim after
+	// the ones WTE generates
+	ExtraServices string `yaml:"extra_services" mapstructure:"extra_services"`
+
+	// RawAppend is a file whose contents are appended verbatim to the GOST
+	// configuration, for top-level sections such as chains or bypasses
+	RawAppend string `yaml:"raw_append" mapstructure:"raw_append"`
+}
+
+// RunsAsRoot reports whether the service runs as root
+func (g *GOSTConfig) RunsAsRoot() bool {
+	return g.RunAsUser == "" || g.RunAsUser == "root"
 }
 
 // AuthConfig holds authentication settings
@@ -25,38 +80,361 @@ type AuthConfig struct {
 	Password string `yaml:"password" mapstructure:"password"`
 }
 
+// LimiterConfig caps the throughput and connections of a service. Empty
+// rates and zero connections mean no limit.
+type LimiterConfig struct {
+	// In and Out are rates such as "10mbps" or "512KB", see ParseRate
+	In  string `yaml:"in" mapstructure:"in"`
+	Out string `yaml:"out" mapstructure:"out"`
+
+	MaxConnections int `yaml:"max_connections" mapstructure:"max_connections"`
+}
+
+// HasRate reports whether a bandwidth limit is set
+func (l LimiterConfig) HasRate() bool {
+	return l.In != "" || l.Out != ""
+}
+
+// ACLConfig restricts the clients of a service by IP address or CIDR
+// range. A client on Deny is refused even if it is on Allow; an empty Allow
+// admits every client not on Deny.
+type ACLConfig struct {
+	Allow []string `yaml:"allow" mapstructure:"allow"`
+	Deny  []string `yaml:"deny" mapstructure:"deny"`
+}
+
+// IsEmpty reports whether the ACL admits every client
+func (a ACLConfig) IsEmpty() bool {
+	return len(a.Allow) == 0 && len(a.Deny) == 0
+}
+
+// ACLServices are the configuration sections of the services with an ACL
+var ACLServices = []string{"http", "https", "shadowsocks"}
+
 // HTTPConfig holds HTTP proxy configuration
 type HTTPConfig struct {
-	Enabled bool       `yaml:"enabled" mapstructure:"enabled"`
-	Port    int        `yaml:"port" mapstructure:"port"`
-	Auth    AuthConfig `yaml:"auth" mapstructure:"auth"`
+	Enabled     bool          `yaml:"enabled" mapstructure:"enabled"`
+	Port        int           `yaml:"port" mapstructure:"port"`
+	BindAddress string        `yaml:"bind_address" mapstructure:"bind_address"`
+	Auth        AuthConfig    `yaml:"auth" mapstructure:"auth"`
+	Limiter     LimiterConfig `yaml:"limiter" mapstructure:"limiter"`
+	ACL         ACLConfig     `yaml:"acl" mapstructure:"acl"`
 }
 
 // HTTPSConfig holds HTTPS proxy configuration
 type HTTPSConfig struct {
-	Enabled  bool       `yaml:"enabled" mapstructure:"enabled"`
-	Port     int        `yaml:"port" mapstructure:"port"`
-	CertPath string     `yaml:"cert_path" mapstructure:"cert_path"`
-	KeyPath  string     `yaml:"key_path" mapstructure:"key_path"`
-	Auth     AuthConfig `yaml:"auth" mapstructure:"auth"`
+	Enabled     bool       `yaml:"enabled" mapstructure:"enabled"`
+	Port        int        `yaml:"port" mapstructure:"port"`
+	BindAddress string     `yaml:"bind_address" mapstructure:"bind_address"`
+	CertPath    string     `yaml:"cert_path" mapstructure:"cert_path"`
+	KeyPath     string     `yaml:"key_path" mapstructure:"key_path"`
+	Auth        AuthConfig `yaml:"auth" mapstructure:"auth"`
+
+	Limiter LimiterConfig `yaml:"limiter" mapstructure:"limiter"`
+	ACL     ACLConfig     `yaml:"acl" mapstructure:"acl"`
+
+	// Domain is the name clients use to reach the HTTPS proxy. Setting it
+	// at install time enables HTTPS with a certificate for the domain.
+	Domain string `yaml:"domain" mapstructure:"domain"`
+
+	// ACME obtains the certificate for Domain from an ACME CA instead of
+	// generating a self-signed one. ACMEDirectory defaults to Let's Encrypt.
+	ACME          bool   `yaml:"acme" mapstructure:"acme"`
+	ACMEDirectory string `yaml:"acme_directory" mapstructure:"acme_directory"`
 }
 
 // ShadowsocksConfig holds Shadowsocks configuration
 type ShadowsocksConfig struct {
-	Enabled  bool   `yaml:"enabled" mapstructure:"enabled"`
-	Port     int    `yaml:"port" mapstructure:"port"`
-	Method   string `yaml:"method" mapstructure:"method"`
-	Password string `yaml:"password" mapstructure:"password"`
+	Enabled     bool          `yaml:"enabled" mapstructure:"enabled"`
+	Port        int           `yaml:"port" mapstructure:"port"`
+	BindAddress string        `yaml:"bind_address" mapstructure:"bind_address"`
+	Method      string        `yaml:"method" mapstructure:"method"`
+	Password    string        `yaml:"password" mapstructure:"password"`
+	UDP         bool          `yaml:"udp" mapstructure:"udp"`
+	Limiter     LimiterConfig `yaml:"limiter" mapstructure:"limiter"`
+	ACL         ACLConfig     `yaml:"acl" mapstructure:"acl"`
+
+	// Ports are extra ports served with the same method and password, for
+	// clients that hop between ports
+	Ports []int `yaml:"ports,omitempty" mapstructure:"ports"`
+
+	// Plugin is the obfuscation plugin clients wrap the TCP connection in
+	Plugin ShadowsocksPluginConfig `yaml:"plugin" mapstructure:"plugin"`
+}
+
+// ShadowsocksPluginConfig is a SIP003 plugin on the client side, such as
+// v2ray-plugin. There is no plugin process on the server, GOST serves the
+// plugin's transport itself.
+type ShadowsocksPluginConfig struct {
+	// Name is the client plugin, empty for none
+	Name string `yaml:"name" mapstructure:"name"`
+	// Opts are the client plugin options, e.g. "obfs=tls;obfs-host=example.com"
+	Opts string `yaml:"opts" mapstructure:"opts"`
+}
+
+// Shadowsocks plugins WTE can serve
+const (
+	ShadowsocksPluginV2Ray = "v2ray-plugin"
+	ShadowsocksPluginObfs  = "obfs-local"
+)
+
+// ShadowsocksPlugins lists the Shadowsocks plugins WTE can serve
+var ShadowsocksPlugins = []string{ShadowsocksPluginV2Ray, ShadowsocksPluginObfs}
+
+// Options parses Opts: key=value pairs separated by ";". A key without a
+// value, such as tls, is a flag and maps to "".
+func (p ShadowsocksPluginConfig) Options() map[string]string {
+	options := make(map[string]string)
+	for _, option := range strings.Split(p.Opts, ";") {
+		if option = strings.TrimSpace(option); option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return options
+}
+
+// ClientOpts returns the options clients must use. v2ray-plugin
+// multiplexes connections by default, which GOST does not understand, so
+// mux=0 is added; obfs-local is given the obfs=http WTE serves by default.
+func (p ShadowsocksPluginConfig) ClientOpts() string {
+	opts := strings.Trim(strings.TrimSpace(p.Opts), ";")
+	add := func(key, option string) {
+		if _, ok := p.Options()[key]; ok {
+			return
+		}
+		if opts != "" {
+			opts += ";"
+		}
+		opts += option
+	}
+
+	switch p.Name {
+	case ShadowsocksPluginV2Ray:
+		add("mux", "mux=0")
+	case ShadowsocksPluginObfs:
+		add("obfs", "obfs=http")
+	}
+	return opts
+}
+
+// String returns the plugin as in the plugin parameter of ss:// URIs,
+// "name;opts"
+func (p ShadowsocksPluginConfig) String() string {
+	if opts := p.ClientOpts(); opts != "" {
+		return p.Name + ";" + opts
+	}
+	return p.Name
+}
+
+// ValidateShadowsocksPlugin checks that WTE can serve the plugin with its
+// options. Empty is valid and means no plugin.
+func ValidateShadowsocksPlugin(plugin ShadowsocksPluginConfig) error {
+	if plugin.Name == "" {
+		if strings.TrimSpace(plugin.Opts) != "" {
+			return fmt.Errorf("shadowsocks.plugin.opts is set without shadowsocks.plugin.name")
+		}
+		return nil
+	}
+
+	switch plugin.Name {
+	case ShadowsocksPluginV2Ray:
+		for key, value := range plugin.Options() {
+			switch key {
+			case "tls", "host":
+			case "path":
+				if !strings.HasPrefix(value, "/") {
+					return fmt.Errorf("invalid %s path %q: it must start with /", plugin.Name, value)
+				}
+			case "mode":
+				if value != "websocket" {
+					return fmt.Errorf("%s mode %q is not supported, only websocket", plugin.Name, value)
+				}
+			case "mux":
+				if value != "0" {
+					return fmt.Errorf("%s mux is not supported, use mux=0", plugin.Name)
+				}
+			default:
+				return fmt.Errorf("unsupported %s option %q (valid: tls, host, path, mode, mux)", plugin.Name, key)
+			}
+		}
+	case ShadowsocksPluginObfs:
+		for key, value := range plugin.Options() {
+			switch key {
+			case "obfs-host":
+			case "obfs":
+				if value != "http" && value != "tls" {
+					return fmt.Errorf("invalid %s obfs %q: use http or tls", plugin.Name, value)
+				}
+			default:
+				return fmt.Errorf("unsupported %s option %q (valid: obfs, obfs-host)", plugin.Name, key)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported Shadowsocks plugin %q (valid: %s)", plugin.Name, strings.Join(ShadowsocksPlugins, ", "))
+	}
+
+	return nil
+}
+
+// AllPorts returns Port followed by the extra Ports
+func (s ShadowsocksConfig) AllPorts() []int {
+	return append([]int{s.Port}, s.Ports...)
+}
+
+// PortList lists the ports Shadowsocks listens on, e.g. "8388, 8389"
+func (s ShadowsocksConfig) PortList() string {
+	ports := make([]string, 0, len(s.Ports)+1)
+	for _, port := range s.AllPorts() {
+		ports = append(ports, strconv.Itoa(port))
+	}
+	return strings.Join(ports, ", ")
+}
+
+// RelayConfig holds the port forwarding rules managed by 'wte relay'
+type RelayConfig struct {
+	// BindAddress is the address every relay listens on, empty for all
+	// interfaces
+	BindAddress string       `yaml:"bind_address" mapstructure:"bind_address"`
+	Entries     []RelayEntry `yaml:"entries" mapstructure:"entries"`
+}
+
+// RelayEntry forwards a local port to a remote host
+type RelayEntry struct {
+	ListenPort int    `yaml:"listen_port" mapstructure:"listen_port"`
+	RemoteHost string `yaml:"remote_host" mapstructure:"remote_host"`
+	RemotePort int    `yaml:"remote_port" mapstructure:"remote_port"`
+
+	// Protocol is "tcp" or "udp"
+	Protocol string `yaml:"protocol" mapstructure:"protocol"`
+}
+
+// Target returns the remote address the entry forwards to
+func (r RelayEntry) Target() string {
+	return net.JoinHostPort(r.RemoteHost, strconv.Itoa(r.RemotePort))
+}
+
+// String describes the entry, e.g. "8000/tcp -> 10.0.0.5:80"
+func (r RelayEntry) String() string {
+	return fmt.Sprintf("%d/%s -> %s", r.ListenPort, r.Protocol, r.Target())
 }
 
 // FirewallConfig holds firewall configuration
 type FirewallConfig struct {
 	AutoConfigure bool `yaml:"auto_configure" mapstructure:"auto_configure"`
+
+	// ACL opens the port of a service with an ACL allow-list only to the
+	// clients on the list, instead of to everyone
+	ACL bool `yaml:"acl" mapstructure:"acl"`
+}
+
+// SecurityConfig holds proxy-level security settings
+type SecurityConfig struct {
+	// Allow restricts all services to these client IPs/CIDRs when non-empty
+	Allow          []string          `yaml:"allow" mapstructure:"allow"`
+	AllowOpenProxy bool              `yaml:"allow_open_proxy" mapstructure:"allow_open_proxy"`
+	AuthLockout    AuthLockoutConfig `yaml:"auth_lockout" mapstructure:"auth_lockout"`
+
+	// PasswordCharset is the charset of generated passwords, "alphanumeric"
+	// or "symbols"
+	PasswordCharset string `yaml:"password_charset" mapstructure:"password_charset"`
+}
+
+// AuthLockoutConfig holds settings for blocking clients after failed authentication
+type AuthLockoutConfig struct {
+	Enabled     bool   `yaml:"enabled" mapstructure:"enabled"`
+	MaxAttempts int    `yaml:"max_attempts" mapstructure:"max_attempts"`
+	Window      string `yaml:"window" mapstructure:"window"`
+	BanDuration string `yaml:"ban_duration" mapstructure:"ban_duration"`
+}
+
+// WatchdogConfig holds settings for restarting a service that runs but no
+// longer accepts connections
+type WatchdogConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// Interval is the time between health checks, e.g. "1m"
+	Interval string `yaml:"interval" mapstructure:"interval"`
+	// Failures is the number of consecutive failed checks that restarts
+	// the service
+	Failures int `yaml:"failures" mapstructure:"failures"`
+	// Cooldown is the minimum time between two restarts
+	Cooldown string `yaml:"cooldown" mapstructure:"cooldown"`
+}
+
+// CredentialsConfig holds settings for the saved credentials file
+type CredentialsConfig struct {
+	// Path is the credentials file. A leading ~ is the home directory of
+	// the user running WTE; the encrypted store appends ".gpg".
+	Path string `yaml:"path" mapstructure:"path"`
+	// Store is how the credentials file is written, see CredentialsStores
+	Store string `yaml:"store" mapstructure:"store"`
+}
+
+// CredentialsPath returns the credentials file path with ~ expanded. An
+// empty path means the default one.
+func (c *Config) CredentialsPath() string {
+	if c.Credentials.Path == "" {
+		return CredentialsFile
+	}
+	path, err := ExpandHome(c.Credentials.Path)
+	if err != nil {
+		return c.Credentials.Path
+	}
+	return path
+}
+
+// ExpandHome replaces a leading ~ in path with the home directory of the
+// user running WTE
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot expand ~: %w", err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// ValidateCredentialsPath checks that path names a file by an absolute
+// path, after expanding ~
+func ValidateCredentialsPath(path string) error {
+	expanded, err := ExpandHome(path)
+	if err != nil {
+		return err
+	}
+	if expanded == "" || !filepath.IsAbs(expanded) {
+		return fmt.Errorf("credentials path %q must be absolute or start with ~/", path)
+	}
+	if strings.HasSuffix(path, "/") {
+		return fmt.Errorf("credentials path %q is a directory, name the file", path)
+	}
+	return nil
+}
+
+// CredentialsStores lists the supported credentials stores
+var CredentialsStores = []string{CredentialsStorePlaintext, CredentialsStoreEncrypted}
+
+// DebugConfig holds diagnostic settings
+type DebugConfig struct {
+	Pprof PprofConfig `yaml:"pprof" mapstructure:"pprof"`
+}
+
+// PprofConfig holds settings for GOST's pprof profiling endpoint
+type PprofConfig struct {
+	Enabled     bool   `yaml:"enabled" mapstructure:"enabled"`
+	Port        int    `yaml:"port" mapstructure:"port"`
+	BindAddress string `yaml:"bind_address" mapstructure:"bind_address"`
 }
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
 	Level string `yaml:"level" mapstructure:"level"`
+
+	// File receives a structured log of the actions WTE takes. Empty
+	// disables it.
+	File string `yaml:"file" mapstructure:"file"`
 }
 
 // GetRequiredPorts returns a list of ports that need to be opened
@@ -64,24 +442,386 @@ func (c *Config) GetRequiredPorts() []PortInfo {
 	var ports []PortInfo
 
 	if c.HTTP.Enabled {
-		ports = append(ports, PortInfo{Port: c.HTTP.Port, Protocol: "tcp", Service: "HTTP Proxy"})
+		ports = append(ports, PortInfo{Port: c.HTTP.Port, Protocol: "tcp", Service: "HTTP Proxy", BindAddress: c.HTTP.BindAddress, Allow: c.HTTP.ACL.Allow})
 	}
 
 	if c.HTTPS.Enabled {
-		ports = append(ports, PortInfo{Port: c.HTTPS.Port, Protocol: "tcp", Service: "HTTPS Proxy"})
+		ports = append(ports, PortInfo{Port: c.HTTPS.Port, Protocol: "tcp", Service: "HTTPS Proxy", BindAddress: c.HTTPS.BindAddress, Allow: c.HTTPS.ACL.Allow})
 	}
 
 	if c.Shadowsocks.Enabled {
-		ports = append(ports, PortInfo{Port: c.Shadowsocks.Port, Protocol: "tcp", Service: "Shadowsocks"})
-		ports = append(ports, PortInfo{Port: c.Shadowsocks.Port, Protocol: "udp", Service: "Shadowsocks"})
+		for _, port := range c.Shadowsocks.AllPorts() {
+			ports = append(ports, PortInfo{Port: port, Protocol: "tcp", Service: "Shadowsocks", BindAddress: c.Shadowsocks.BindAddress, Allow: c.Shadowsocks.ACL.Allow})
+			if c.Shadowsocks.UDP {
+				ports = append(ports, PortInfo{Port: port, Protocol: "udp", Service: "Shadowsocks", BindAddress: c.Shadowsocks.BindAddress, Allow: c.Shadowsocks.ACL.Allow})
+			}
+		}
+	}
+
+	for _, relay := range c.Relay.Entries {
+		ports = append(ports, PortInfo{Port: relay.ListenPort, Protocol: relay.Protocol, Service: "Relay to " + relay.Target(), BindAddress: c.Relay.BindAddress})
 	}
 
 	return ports
 }
 
+// FirewallPorts returns the firewall rules the required ports need. With
+// firewall.acl the port of a service with an ACL allow-list gets one rule
+// per allowed source instead of a rule for everyone.
+func (c *Config) FirewallPorts() []PortInfo {
+	var rules []PortInfo
+	for _, port := range c.GetRequiredPorts() {
+		if !c.Firewall.ACL || len(port.Allow) == 0 {
+			rules = append(rules, port)
+			continue
+		}
+		for _, source := range port.Allow {
+			rule := port
+			rule.Source = source
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// ServiceACL returns the ACL of a service named by its configuration
+// section, e.g. "http"
+func (c *Config) ServiceACL(service string) (ACLConfig, bool) {
+	switch service {
+	case "http":
+		return c.HTTP.ACL, true
+	case "https":
+		return c.HTTPS.ACL, true
+	case "shadowsocks":
+		return c.Shadowsocks.ACL, true
+	}
+	return ACLConfig{}, false
+}
+
+// NeedsPrivilegedPorts reports whether an enabled service listens on a
+// port below 1024
+func (c *Config) NeedsPrivilegedPorts() bool {
+	for _, port := range c.GetRequiredPorts() {
+		if port.Port <= PrivilegedPortMax {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerName returns the configured server name, falling back to the
+// hostname
+func (c *Config) ServerName() string {
+	if c.Server.Name != "" {
+		return c.Server.Name
+	}
+	return DefaultServerName()
+}
+
+// serverNameForbidden are characters that would break out of the quoted or
+// specifier-expanded service description the server name is written into
+const serverNameForbidden = "\"'`$\\%"
+
+// ValidateServerName checks that name is safe to embed in the systemd unit
+// and the OpenRC script: printable, without quotes, $, backticks,
+// backslashes or %
+func ValidateServerName(name string) error {
+	for _, r := range name {
+		if !unicode.IsPrint(r) || strings.ContainsRune(serverNameForbidden, r) {
+			return fmt.Errorf("server name %q contains %q, use printable characters without quotes, $, `, \\ or %%", name, r)
+		}
+	}
+	return nil
+}
+
+// DefaultServerName returns the hostname, or FallbackServerName if it
+// can't be determined
+func DefaultServerName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return FallbackServerName
+	}
+	return hostname
+}
+
+// LocalhostOnly reports whether every enabled service is bound to a loopback
+// address, i.e. the proxy is only reachable through an SSH tunnel
+func (c *Config) LocalhostOnly() bool {
+	var binds []string
+
+	if c.HTTP.Enabled {
+		binds = append(binds, c.HTTP.BindAddress)
+	}
+
+	if c.HTTPS.Enabled {
+		binds = append(binds, c.HTTPS.BindAddress)
+	}
+
+	if c.Shadowsocks.Enabled {
+		binds = append(binds, c.Shadowsocks.BindAddress)
+	}
+
+	if len(c.Relay.Entries) > 0 {
+		binds = append(binds, c.Relay.BindAddress)
+	}
+
+	if len(binds) == 0 {
+		return false
+	}
+
+	for _, bind := range binds {
+		if !IsLoopback(bind) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListenAddr returns the listen address for a bind address and port. An
+// empty bind address listens on all interfaces.
+func ListenAddr(bindAddress string, port int) string {
+	return net.JoinHostPort(bindAddress, strconv.Itoa(port))
+}
+
+// ValidateBindAddress checks that a bind address is empty, for all
+// interfaces, or an IP address
+func ValidateBindAddress(value string) error {
+	if value != "" && net.ParseIP(value) == nil {
+		return fmt.Errorf("%q is not an IP address", value)
+	}
+	return nil
+}
+
+// PrivilegedPortMax is the highest port that needs root privileges to listen on
+const PrivilegedPortMax = 1023
+
+// ValidatePort checks that port is a valid port number for key
+func ValidatePort(key string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid %s: %d is outside the port range 1-65535", key, port)
+	}
+	return nil
+}
+
+// memoryMaxPattern matches a systemd memory limit: bytes with an optional
+// K, M, G or T suffix, a percentage of physical memory, or "infinity"
+var memoryMaxPattern = regexp.MustCompile(`^([0-9]+[KMGT]?|[0-9]+(\.[0-9]+)?%|infinity)$`)
+
+// cpuQuotaPattern matches a systemd CPU quota, a percentage of one CPU
+var cpuQuotaPattern = regexp.MustCompile(`^[0-9]+%$`)
+
+// ValidateMemoryMax checks a gost.memory_max value. Empty is valid.
+func ValidateMemoryMax(value string) error {
+	if value != "" && !memoryMaxPattern.MatchString(value) {
+		return fmt.Errorf("invalid gost.memory_max: %q (use e.g. 256M, 1G or 50%%)", value)
+	}
+	return nil
+}
+
+// ValidateCPUQuota checks a gost.cpu_quota value. Empty is valid.
+func ValidateCPUQuota(value string) error {
+	if value != "" && !cpuQuotaPattern.MatchString(value) {
+		return fmt.Errorf("invalid gost.cpu_quota: %q (use a percentage of one CPU, e.g. 50%% or 200%%)", value)
+	}
+	return nil
+}
+
+// ValidateDownloadMirror checks a gost.download_mirror value. Empty is
+// valid and means GitHub.
+func ValidateDownloadMirror(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", value)
+	}
+	return nil
+}
+
+// ratePattern matches a rate: a whole number and a unit
+var ratePattern = regexp.MustCompile(`^([0-9]+)\s*([A-Za-z]+)$`)
+
+// rateUnits maps rate units to bits per second. Bit rates use powers of
+// 1000 like network links, byte rates powers of 1024 like GOST.
+var rateUnits = map[string]int64{
+	"bps":  1,
+	"kbps": 1000,
+	"mbps": 1000 * 1000,
+	"gbps": 1000 * 1000 * 1000,
+	"b":    8,
+	"kb":   8 * 1024,
+	"mb":   8 * 1024 * 1024,
+	"gb":   8 * 1024 * 1024 * 1024,
+}
+
+// RateUnits describes the units ParseRate accepts, for help and errors
+const RateUnits = "bps, kbps, mbps, gbps (bits per second) or B, KB, MB, GB (bytes per second)"
+
+// ParseRate parses a rate such as "10mbps" or "512KB" into bytes per
+// second. Units are case-insensitive.
+func ParseRate(value string) (int64, error) {
+	m := ratePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return 0, fmt.Errorf("invalid rate %q (use a number and one of %s)", value, RateUnits)
+	}
+
+	bits, ok := rateUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate unit %q (valid: %s)", m[2], RateUnits)
+	}
+
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid rate %q (must be a positive number)", value)
+	}
+
+	if n > math.MaxInt64/bits {
+		return 0, fmt.Errorf("rate %q is too large", value)
+	}
+
+	// GOST limits whole bytes per second
+	bytes := n * bits / 8
+	if bytes == 0 {
+		return 0, fmt.Errorf("rate %q is below 1 byte per second", value)
+	}
+
+	return bytes, nil
+}
+
+// ValidateLimiter checks the rates and connection limit of a service's
+// limiter. Empty values are valid.
+func ValidateLimiter(service string, limiter LimiterConfig) error {
+	for _, rate := range []string{limiter.In, limiter.Out} {
+		if rate == "" {
+			continue
+		}
+		if _, err := ParseRate(rate); err != nil {
+			return fmt.Errorf("%s limiter: %w", service, err)
+		}
+	}
+	if limiter.MaxConnections < 0 {
+		return fmt.Errorf("%s limiter: max_connections must not be negative", service)
+	}
+	return nil
+}
+
+// RelayProtocols lists the protocols a relay can forward
+var RelayProtocols = []string{"tcp", "udp"}
+
+// ValidateRelayEntry checks the ports, remote host and protocol of a relay
+func ValidateRelayEntry(relay RelayEntry) error {
+	if err := ValidatePort("relay listen port", relay.ListenPort); err != nil {
+		return err
+	}
+	if err := ValidatePort("relay remote port", relay.RemotePort); err != nil {
+		return err
+	}
+	if net.ParseIP(relay.RemoteHost) == nil && ValidateHostname(relay.RemoteHost) != nil {
+		return fmt.Errorf("invalid relay remote host: %q is not an IP address or hostname", relay.RemoteHost)
+	}
+	if relay.Protocol != "tcp" && relay.Protocol != "udp" {
+		return fmt.Errorf("invalid relay protocol %q (valid: %s)", relay.Protocol, strings.Join(RelayProtocols, ", "))
+	}
+	return nil
+}
+
+// ShadowsocksMethods lists the Shadowsocks methods GOST supports
+var ShadowsocksMethods = []string{
+	"aes-128-gcm",
+	"aes-192-gcm",
+	"aes-256-gcm",
+	"chacha20-ietf-poly1305",
+	"xchacha20-ietf-poly1305",
+	"2022-blake3-aes-128-gcm",
+	"2022-blake3-aes-256-gcm",
+	"2022-blake3-chacha20-poly1305",
+	"2022-blake3-chacha8-poly1305",
+}
+
+// ValidateShadowsocksMethod checks that method is a supported Shadowsocks
+// method
+func ValidateShadowsocksMethod(method string) error {
+	for _, known := range ShadowsocksMethods {
+		if method == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported Shadowsocks method %q (valid: %s)", method, strings.Join(ShadowsocksMethods, ", "))
+}
+
+// RedactedSecret replaces passwords in output meant to be shared
+const RedactedSecret = "********"
+
+// Redacted returns a copy of the config with the passwords replaced by
+// RedactedSecret, safe to share. Empty passwords stay empty, so a missing
+// password still shows.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, secret := range []*string{
+		&redacted.HTTP.Auth.Password,
+		&redacted.HTTPS.Auth.Password,
+		&redacted.Shadowsocks.Password,
+	} {
+		if *secret != "" {
+			*secret = RedactedSecret
+		}
+	}
+	return &redacted
+}
+
+// Secrets returns the passwords set in the config, to scrub them from text
+// such as logs
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, secret := range []string{c.HTTP.Auth.Password, c.HTTPS.Auth.Password, c.Shadowsocks.Password} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// ValidateCredentialsStore checks that store is a known credentials store
+func ValidateCredentialsStore(store string) error {
+	for _, known := range CredentialsStores {
+		if store == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown credentials store %q (valid: %s)", store, strings.Join(CredentialsStores, ", "))
+}
+
+// DialHost returns the host to connect to from this server to reach a
+// service bound to bindAddress
+func DialHost(bindAddress string) string {
+	ip := net.ParseIP(bindAddress)
+	if bindAddress == "" || (ip != nil && ip.IsUnspecified()) {
+		return LocalhostBindAddress
+	}
+	return bindAddress
+}
+
+// IsLoopback reports whether a bind address only accepts local connections
+func IsLoopback(bindAddress string) bool {
+	if bindAddress == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(bindAddress)
+	return ip != nil && ip.IsLoopback()
+}
+
 // PortInfo represents information about a network port
 type PortInfo struct {
-	Port     int
-	Protocol string
-	Service  string
+	Port        int
+	Protocol    string
+	Service     string
+	BindAddress string
+
+	// Allow is the ACL allow-list of the service, if it has one
+	Allow []string
+	// Source restricts a firewall rule to an IP or CIDR, see FirewallPorts
+	Source string
 }
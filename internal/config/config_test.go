@@ -0,0 +1,61 @@
+package config
+
+import "testing"
+
+func TestValidateServerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"", false},
+		{"vps-1.example.com", false},
+		{"Мой сервер (Amsterdam)", false},
+		{`say "hi"`, true},
+		{"it's", true},
+		{"$HOME", true},
+		{"`id`", true},
+		{`back\slash`, true},
+		{"100%", true},
+		{"line\nbreak", true},
+		{"tab\there", true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateServerName(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateServerName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"8bps", 1, false},
+		{"10mbps", 1250000, false},
+		{"1Gbps", 125000000, false},
+		{"512KB", 512 * 1024, false},
+		{" 2mb ", 2 * 1024 * 1024, false},
+		{"7bps", 0, true},
+		{"1bps", 0, true},
+		{"0mbps", 0, true},
+		{"10", 0, true},
+		{"10tbps", 0, true},
+		{"9223372036854775807GB", 0, true},
+		{"2000000000GB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRate(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,9 @@ const (
 	// DefaultGOSTVersion is the default GOST version to install
 	DefaultGOSTVersion = "3.0.0-rc10"
 
+	// GOSTVersionLatest resolves to the newest GOST release at install time
+	GOSTVersionLatest = "latest"
+
 	// DefaultGOSTBinaryPath is where GOST binary is installed
 	DefaultGOSTBinaryPath = "/usr/local/bin/gost"
 
@@ -16,6 +19,9 @@ const (
 	// DefaultGOSTConfigFile is the GOST configuration file path
 	DefaultGOSTConfigFile = "/etc/gost/config.yaml"
 
+	// DefaultGOSTUser is the system user GOST runs as
+	DefaultGOSTUser = "gost"
+
 	// DefaultHTTPPort is the default HTTP proxy port
 	DefaultHTTPPort = 8080
 
@@ -28,30 +34,97 @@ const (
 	// DefaultShadowsocksMethod is the default encryption method
 	DefaultShadowsocksMethod = "aes-128-gcm"
 
+	// LocalhostBindAddress binds a service to the loopback interface only
+	LocalhostBindAddress = "127.0.0.1"
+
+	// FallbackServerName names the server when the hostname is unknown
+	FallbackServerName = "WTE-Proxy"
+
 	// DefaultUsername is the default proxy username
 	DefaultUsername = "proxyuser"
 
+	// DefaultLockoutMaxAttempts is the number of failed auths before a client is blocked
+	DefaultLockoutMaxAttempts = 5
+
+	// DefaultLockoutWindow is the window in which failed auths are counted
+	DefaultLockoutWindow = "10m"
+
+	// DefaultLockoutBanDuration is how long a blocked client stays blocked
+	DefaultLockoutBanDuration = "1h"
+
+	// DefaultWatchdogInterval is the time between watchdog health checks
+	DefaultWatchdogInterval = "1m"
+
+	// DefaultWatchdogFailures is the number of consecutive failed checks
+	// that makes the watchdog restart the service
+	DefaultWatchdogFailures = 3
+
+	// DefaultWatchdogCooldown is the minimum time between watchdog restarts
+	DefaultWatchdogCooldown = "10m"
+
+	// CredentialsStorePlaintext saves the credentials file as plain text
+	CredentialsStorePlaintext = "plaintext"
+
+	// CredentialsStoreEncrypted saves the credentials file encrypted with
+	// a passphrase
+	CredentialsStoreEncrypted = "encrypted"
+
+	// DefaultPasswordCharset is the charset of generated passwords
+	DefaultPasswordCharset = "alphanumeric"
+
+	// DefaultMetricsAddr is where 'wte serve-metrics' listens by default
+	DefaultMetricsAddr = "127.0.0.1:9090"
+
+	// DefaultPprofPort is the default port of the GOST profiling endpoint
+	DefaultPprofPort = 6060
+
 	// DefaultLogLevel is the default logging level
 	DefaultLogLevel = "info"
 
-	// CredentialsFile is where credentials are saved
+	// CredentialsFile is where credentials are saved by default
 	CredentialsFile = "/root/proxy-credentials.txt"
 
+	// ACMEAccountKeyFile holds the ACME account key
+	ACMEAccountKeyFile = "/etc/wte/acme-account.key"
+
+	// NftablesRulesFile holds the persisted nftables rules of the wte table
+	NftablesRulesFile = "/etc/wte/nftables.nft"
+
 	// SystemdServiceFile is the systemd service file path
 	SystemdServiceFile = "/etc/systemd/system/gost.service"
 
+	// OpenRCServiceFile is the OpenRC init script path
+	OpenRCServiceFile = "/etc/init.d/gost"
+
+	// OpenRCLogFile receives GOST's output on OpenRC, which has no journal
+	OpenRCLogFile = "/var/log/wte/gost.log"
+
+	// LockoutServiceFile is the systemd unit for the auth lockout watcher
+	LockoutServiceFile = "/etc/systemd/system/wte-lockout.service"
+
+	// WatchdogServiceFile is the systemd unit for the health watchdog
+	WatchdogServiceFile = "/etc/systemd/system/wte-watchdog.service"
+
 	// WTEConfigFile is the main WTE configuration file
 	WTEConfigFile = "/etc/wte/config.yaml"
+
+	// AuditLogFile is where configuration changes are recorded
+	AuditLogFile = "/var/log/wte/audit.log"
 )
 
 // DefaultConfig returns a new Config with default values
 func DefaultConfig() *Config {
 	return &Config{
+		Version: CurrentVersion,
+		Server: ServerConfig{
+			Name: DefaultServerName(),
+		},
 		GOST: GOSTConfig{
 			Version:    DefaultGOSTVersion,
 			BinaryPath: DefaultGOSTBinaryPath,
 			ConfigDir:  DefaultGOSTConfigDir,
 			ConfigFile: DefaultGOSTConfigFile,
+			RunAsUser:  DefaultGOSTUser,
 		},
 		HTTP: HTTPConfig{
 			Enabled: true,
@@ -78,10 +151,37 @@ func DefaultConfig() *Config {
 			Port:     DefaultShadowsocksPort,
 			Method:   DefaultShadowsocksMethod,
 			Password: "", // Will be auto-generated
+			UDP:      true,
 		},
 		Firewall: FirewallConfig{
 			AutoConfigure: true,
 		},
+		Security: SecurityConfig{
+			PasswordCharset: DefaultPasswordCharset,
+			AuthLockout: AuthLockoutConfig{
+				Enabled:     false,
+				MaxAttempts: DefaultLockoutMaxAttempts,
+				Window:      DefaultLockoutWindow,
+				BanDuration: DefaultLockoutBanDuration,
+			},
+		},
+		Watchdog: WatchdogConfig{
+			Enabled:  false,
+			Interval: DefaultWatchdogInterval,
+			Failures: DefaultWatchdogFailures,
+			Cooldown: DefaultWatchdogCooldown,
+		},
+		Credentials: CredentialsConfig{
+			Path:  CredentialsFile,
+			Store: CredentialsStorePlaintext,
+		},
+		Debug: DebugConfig{
+			Pprof: PprofConfig{
+				Enabled:     false,
+				Port:        DefaultPprofPort,
+				BindAddress: LocalhostBindAddress,
+			},
+		},
 		Logging: LoggingConfig{
 			Level: DefaultLogLevel,
 		},
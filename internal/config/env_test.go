@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const testConfigYAML = `version: 1
+http:
+  port: 8080
+  auth:
+    enabled: true
+    password: filepass
+shadowsocks:
+  password: ssfilepass
+security:
+  allow:
+    - 192.0.2.0/24
+`
+
+// initTestConfig loads data as the config file, restoring the global
+// configuration when the test ends
+func initTestConfig(t *testing.T, data string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous, previousPath := cfg, ConfigPath
+	t.Cleanup(func() {
+		viper.Reset()
+		cfg, ConfigPath = previous, previousPath
+		stored = viper.New()
+	})
+
+	viper.Reset()
+	if err := Init(path); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+		got   func(c *Config) interface{}
+		want  interface{}
+	}{
+		{"WTE_HTTP_PORT", "3129", func(c *Config) interface{} { return c.HTTP.Port }, 3129},
+		{"WTE_HTTP_AUTH_ENABLED", "false", func(c *Config) interface{} { return c.HTTP.Auth.Enabled }, false},
+		{"WTE_HTTP_AUTH_PASSWORD", "envpass", func(c *Config) interface{} { return c.HTTP.Auth.Password }, "envpass"},
+		{"WTE_SHADOWSOCKS_PASSWORD", "ssenvpass", func(c *Config) interface{} { return c.Shadowsocks.Password }, "ssenvpass"},
+		{"WTE_HTTPS_ENABLED", "true", func(c *Config) interface{} { return c.HTTPS.Enabled }, true},
+		{"WTE_SERVER_NAME", "edge-1", func(c *Config) interface{} { return c.Server.Name }, "edge-1"},
+		{"WTE_SECURITY_AUTH_LOCKOUT_MAX_ATTEMPTS", "7", func(c *Config) interface{} { return c.Security.AuthLockout.MaxAttempts }, 7},
+		{"WTE_DEBUG_PPROF_BIND_ADDRESS", "0.0.0.0", func(c *Config) interface{} { return c.Debug.Pprof.BindAddress }, "0.0.0.0"},
+		{"WTE_SECURITY_ALLOW", "10.0.0.0/8,198.51.100.7", func(c *Config) interface{} { return c.Security.Allow }, []string{"10.0.0.0/8", "198.51.100.7"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			initTestConfig(t, testConfigYAML)
+
+			if got := tt.got(Get()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s=%s gives %#v, want %#v", tt.env, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvOverridesLeaveOtherKeys(t *testing.T) {
+	t.Setenv("WTE_HTTP_PORT", "3129")
+	initTestConfig(t, testConfigYAML)
+
+	c := Get()
+	if c.HTTP.Auth.Password != "filepass" {
+		t.Errorf("http.auth.password = %q, want the file's", c.HTTP.Auth.Password)
+	}
+	if c.Shadowsocks.Port != DefaultShadowsocksPort {
+		t.Errorf("shadowsocks.port = %d, want the default", c.Shadowsocks.Port)
+	}
+}
+
+func TestEnvVar(t *testing.T) {
+	tests := map[string]string{
+		"http.port":                          "WTE_HTTP_PORT",
+		"http.auth.enabled":                  "WTE_HTTP_AUTH_ENABLED",
+		"security.auth_lockout.max_attempts": "WTE_SECURITY_AUTH_LOCKOUT_MAX_ATTEMPTS",
+	}
+	for key, want := range tests {
+		if got := EnvVar(key); got != want {
+			t.Errorf("EnvVar(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestSaveLeavesEnvOverridesOut(t *testing.T) {
+	t.Setenv("WTE_HTTP_PORT", "3129")
+	t.Setenv("WTE_HTTP_AUTH_PASSWORD", "envpass")
+	t.Setenv("WTE_SHADOWSOCKS_PASSWORD", "ssenvpass")
+	t.Setenv("WTE_HTTPS_ENABLED", "true")
+	initTestConfig(t, testConfigYAML)
+
+	// Set wins over the environment and is saved
+	if err := Set("https.enabled", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set("http.auth.username", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	// So is a value changed in place, as rotate-password does
+	Get().Shadowsocks.Password = "rotated"
+
+	path := filepath.Join(t.TempDir(), "saved.yaml")
+	if err := SaveTo(path); err != nil {
+		t.Fatalf("SaveTo: %v", err)
+	}
+
+	saved, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+
+	if saved.HTTP.Port != 8080 {
+		t.Errorf("saved http.port = %d, want the file's 8080", saved.HTTP.Port)
+	}
+	if saved.HTTP.Auth.Password != "filepass" {
+		t.Errorf("saved http.auth.password = %q, want the file's", saved.HTTP.Auth.Password)
+	}
+	if saved.HTTPS.Enabled {
+		t.Error("saved https.enabled = true, want the value Set")
+	}
+	if saved.HTTP.Auth.Username != "bob" {
+		t.Errorf("saved http.auth.username = %q, want bob", saved.HTTP.Auth.Username)
+	}
+	if saved.Shadowsocks.Password != "rotated" {
+		t.Errorf("saved shadowsocks.password = %q, want rotated", saved.Shadowsocks.Password)
+	}
+
+	// The running configuration keeps the overrides
+	if Get().HTTP.Port != 3129 {
+		t.Errorf("active http.port = %d, want 3129", Get().HTTP.Port)
+	}
+}
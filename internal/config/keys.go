@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Keys returns every settable configuration key. Keys inside lists are
+// given with <n> for the element index, e.g. relay.entries.<n>.remote_port.
+func Keys() []string {
+	return keysOf(reflect.TypeOf(Config{}), "")
+}
+
+// keysOf returns the keys of the leaves of t under prefix
+func keysOf(t reflect.Type, prefix string) []string {
+	switch {
+	case t.Kind() == reflect.Struct:
+		var keys []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			keys = append(keys, keysOf(field.Type, joinKey(prefix, fieldKey(field)))...)
+		}
+		return keys
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+		return keysOf(t.Elem(), joinKey(prefix, "<n>"))
+	}
+	return []string{prefix}
+}
+
+// ParseValue converts the command line value of key to the type of the
+// configuration field it names: "true"/"false" (or yes/no, 1/0) for
+// switches, numbers for ports and counts, comma-separated values for lists
+func ParseValue(key, value string) (interface{}, error) {
+	field, err := lookupKey(reflect.ValueOf(*Get()), key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch field.Kind() {
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "true", "1", "yes", "on":
+			return true, nil
+		case "false", "0", "no", "off":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid %s: %q is not true or false", key, value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q is not a number", key, value)
+		}
+		return n, nil
+	case reflect.String:
+		return value, nil
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Int {
+			numbers := []int{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				n, err := strconv.Atoi(item)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s entry: %q is not a number", key, item)
+				}
+				numbers = append(numbers, n)
+			}
+			return numbers, nil
+		}
+		if field.Type().Elem().Kind() == reflect.String {
+			if IsListKey(key) {
+				return ParseList(key, value)
+			}
+			items := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items, nil
+		}
+		return nil, fmt.Errorf("%s is a list, set its entries with %s.<n>.<key>", key, key)
+	case reflect.Struct:
+		return nil, fmt.Errorf("%s is a section, set one of its keys: %s",
+			key, strings.Join(keysOf(field.Type(), key), ", "))
+	}
+
+	return nil, fmt.Errorf("%s cannot be set from the command line", key)
+}
+
+// lookupKey walks v along the dotted key, matching struct fields by their
+// mapstructure name and list elements by index
+func lookupKey(v reflect.Value, key string) (reflect.Value, error) {
+	path := ""
+	for _, part := range strings.Split(key, ".") {
+		switch v.Kind() {
+		case reflect.Struct:
+			next, ok := fieldByKey(v, part)
+			if !ok {
+				return reflect.Value{}, unknownKeyError(key, v.Type(), path)
+			}
+			v = next
+		case reflect.Slice:
+			if v.Type().Elem().Kind() != reflect.Struct {
+				return reflect.Value{}, fmt.Errorf("unknown configuration key %q: %s is a list", key, path)
+			}
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 {
+				return reflect.Value{}, fmt.Errorf("unknown configuration key %q: %q is not an index of %s", key, part, path)
+			}
+			if index >= v.Len() {
+				return reflect.Value{}, fmt.Errorf("unknown configuration key %q: %s has %d entries", key, path, v.Len())
+			}
+			v = v.Index(index)
+		default:
+			return reflect.Value{}, fmt.Errorf("unknown configuration key %q: %s has no keys", key, path)
+		}
+		path = joinKey(path, part)
+	}
+	return v, nil
+}
+
+// fieldByKey returns the field of struct v whose mapstructure name is name
+func fieldByKey(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if fieldKey(v.Type().Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// unknownKeyError lists the keys under the deepest part of key that exists
+func unknownKeyError(key string, t reflect.Type, prefix string) error {
+	scope := "valid keys"
+	if prefix != "" {
+		scope = "valid keys under " + prefix
+	}
+	return fmt.Errorf("unknown configuration key %q (%s: %s)", key, scope, strings.Join(keysOf(t, prefix), ", "))
+}
+
+// fieldKey returns the configuration key of a struct field
+func fieldKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// joinKey appends part to a dotted key
+func joinKey(prefix, part string) string {
+	if prefix == "" {
+		return part
+	}
+	return prefix + "." + part
+}
+
+// listElementKey splits a key that addresses a field of a list element,
+// e.g. relay.entries.0.remote_port, into the key of the list and the value
+// of the whole list with the field set. Viper can only set lists as a
+// whole. ok is false for other keys.
+func listElementKey(c *Config, key string, value interface{}) (listKey string, list interface{}, ok bool, err error) {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil || i == 0 {
+			continue
+		}
+
+		listKey = strings.Join(parts[:i], ".")
+		current, err := lookupKey(reflect.ValueOf(*c), listKey)
+		if err != nil {
+			return "", nil, false, err
+		}
+		if current.Kind() != reflect.Slice {
+			return "", nil, false, fmt.Errorf("unknown configuration key %q: %s is not a list", key, listKey)
+		}
+
+		// Change a copy, the current configuration must stay untouched
+		copied := reflect.MakeSlice(current.Type(), current.Len(), current.Len())
+		reflect.Copy(copied, current)
+
+		field, err := lookupKey(copied, strings.Join(parts[i:], "."))
+		if err != nil {
+			return "", nil, false, fmt.Errorf("unknown configuration key %q: %w", key, err)
+		}
+
+		newValue := reflect.ValueOf(value)
+		if !newValue.Type().ConvertibleTo(field.Type()) {
+			return "", nil, false, fmt.Errorf("invalid %s: %v is not a %s", key, value, field.Type())
+		}
+		field.Set(newValue.Convert(field.Type()))
+
+		return listKey, copied.Interface(), true, nil
+	}
+	return "", nil, false, nil
+}
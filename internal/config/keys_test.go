@@ -0,0 +1,137 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfig returns the default configuration with two relays
+func testConfig() *Config {
+	c := DefaultConfig()
+	c.Relay.Entries = []RelayEntry{
+		{ListenPort: 8000, RemoteHost: "10.0.0.5", RemotePort: 80, Protocol: "tcp"},
+		{ListenPort: 5353, RemoteHost: "10.0.0.53", RemotePort: 53, Protocol: "udp"},
+	}
+	return c
+}
+
+func TestParseValue(t *testing.T) {
+	previous := cfg
+	SetConfig(testConfig())
+	defer func() { cfg = previous }()
+
+	tests := []struct {
+		key     string
+		value   string
+		want    interface{}
+		wantErr string
+	}{
+		{key: "http.enabled", value: "yes", want: true},
+		{key: "http.enabled", value: "OFF", want: false},
+		{key: "http.enabled", value: "maybe", wantErr: "is not true or false"},
+		{key: "http.port", value: "3128", want: 3128},
+		{key: "http.port", value: "http", wantErr: "is not a number"},
+		{key: "server.name", value: "vps 1", want: "vps 1"},
+		{key: "shadowsocks.ports", value: "8389, 8390,", want: []int{8389, 8390}},
+		{key: "shadowsocks.ports", value: "8389,x", wantErr: "is not a number"},
+		{key: "security.allow", value: "10.0.0.0/8, 192.0.2.1", want: []string{"10.0.0.0/8", "192.0.2.1"}},
+		{key: "security.allow", value: "nope", wantErr: "not an IP address or CIDR"},
+		{key: "relay.entries.1.remote_port", value: "5354", want: 5354},
+		{key: "relay.entries.0.remote_host", value: "backend", want: "backend"},
+		{key: "relay.entries", value: "x", wantErr: "is a list"},
+		{key: "relay.entries.2.remote_port", value: "1", wantErr: "has 2 entries"},
+		{key: "relay.entries.x.remote_port", value: "1", wantErr: "is not an index"},
+		{key: "relay.entries.0.nope", value: "1", wantErr: "unknown configuration key"},
+		{key: "http", value: "x", wantErr: "is a section"},
+		{key: "http.nope", value: "x", wantErr: "valid keys under http"},
+		{key: "nope", value: "x", wantErr: "unknown configuration key"},
+		{key: "http.port.x", value: "1", wantErr: "has no keys"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			got, err := ParseValue(tt.key, tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListElementKey(t *testing.T) {
+	c := testConfig()
+
+	listKey, list, ok, err := listElementKey(c, "relay.entries.1.remote_port", 5354)
+	if err != nil || !ok {
+		t.Fatalf("listElementKey = %v, %v", ok, err)
+	}
+	if listKey != "relay.entries" {
+		t.Errorf("listKey = %q, want relay.entries", listKey)
+	}
+
+	entries := list.([]RelayEntry)
+	if entries[1].RemotePort != 5354 || entries[0].RemotePort != 80 {
+		t.Errorf("entries = %+v", entries)
+	}
+	if c.Relay.Entries[1].RemotePort != 53 {
+		t.Error("listElementKey changed the configuration it was given")
+	}
+
+	if _, _, ok, err := listElementKey(c, "http.port", 3128); ok || err != nil {
+		t.Errorf("plain key: ok = %v, err = %v", ok, err)
+	}
+	if _, _, _, err := listElementKey(c, "relay.entries.0.remote_port", "eighty"); err == nil {
+		t.Error("string accepted for an int field")
+	}
+	if _, _, _, err := listElementKey(c, "http.port.0.x", 1); err == nil {
+		t.Error("index into a non-list accepted")
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	v := reflect.ValueOf(*testConfig())
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"http.port", DefaultConfig().HTTP.Port},
+		{"relay.entries.1.protocol", "udp"},
+		{"shadowsocks.plugin.name", ""},
+	}
+	for _, tt := range tests {
+		got, err := lookupKey(v, tt.key)
+		if err != nil {
+			t.Errorf("lookupKey(%q): %v", tt.key, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Interface(), tt.want) {
+			t.Errorf("lookupKey(%q) = %#v, want %#v", tt.key, got.Interface(), tt.want)
+		}
+	}
+
+	for _, key := range []string{"", "nope", "http.nope", "relay.entries.-1.protocol", "relay.entries.9"} {
+		if _, err := lookupKey(v, key); err == nil {
+			t.Errorf("lookupKey(%q) succeeded", key)
+		}
+	}
+}
+
+func TestKeysCoversNestedAndListKeys(t *testing.T) {
+	keys := strings.Join(Keys(), " ")
+	for _, key := range []string{"http.port", "relay.bind_address", "relay.entries.<n>.remote_port", "shadowsocks.plugin.opts"} {
+		if !strings.Contains(" "+keys+" ", " "+key+" ") {
+			t.Errorf("Keys() lacks %s", key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ListKeys maps list-typed configuration keys to the validator applied to
+// each of their elements
+var ListKeys = map[string]func(string) error{
+	"security.allow":        ValidateIPOrCIDR,
+	"http.acl.allow":        ValidateIPOrCIDR,
+	"http.acl.deny":         ValidateIPOrCIDR,
+	"https.acl.allow":       ValidateIPOrCIDR,
+	"https.acl.deny":        ValidateIPOrCIDR,
+	"shadowsocks.acl.allow": ValidateIPOrCIDR,
+	"shadowsocks.acl.deny":  ValidateIPOrCIDR,
+}
+
+// hostnameLabel matches a single DNS label
+var hostnameLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// IsListKey reports whether key holds a list of values
+func IsListKey(key string) bool {
+	_, ok := ListKeys[key]
+	return ok
+}
+
+// ParseList splits a comma-separated value into trimmed, non-empty elements
+// and validates each of them for key
+func ParseList(key, value string) ([]string, error) {
+	validate, ok := ListKeys[key]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a list", key)
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if err := validate(item); err != nil {
+			return nil, fmt.Errorf("invalid %s entry: %w", key, err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// GetStringSlice returns the current value of a list-typed key
+func GetStringSlice(key string) []string {
+	return viper.GetStringSlice(key)
+}
+
+// ValidateIPOrCIDR checks that value is an IP address or a CIDR range
+func ValidateIPOrCIDR(value string) error {
+	if net.ParseIP(value) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		return fmt.Errorf("%q is not an IP address or CIDR", value)
+	}
+	return nil
+}
+
+// ValidateHostname checks that value is a valid DNS hostname
+func ValidateHostname(value string) error {
+	name := strings.TrimSuffix(value, ".")
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("%q is not a valid hostname", value)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return fmt.Errorf("%q is not a valid hostname", value)
+		}
+	}
+
+	return nil
+}
@@ -1,29 +1,53 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
+
+	"wte/internal/fsutil"
 )
 
+// EnvPrefix starts the environment variables that override configuration
+// keys, see EnvVar
+const EnvPrefix = "WTE"
+
 var (
 	// Global config instance
 	cfg *Config
 
 	// ConfigPath is the path to the config file
 	ConfigPath string
+
+	// loadedVersion is the version of the config file as read, before Init
+	// upgraded its settings in memory
+	loadedVersion = CurrentVersion
+
+	// protected is set when the config file exists but can't be read
+	protected bool
+
+	// stored holds the settings as they would be saved: the defaults, the
+	// config file and the values Set since, without environment overrides
+	stored = viper.New()
 )
 
+// ErrProtected is returned by Init when the current user may not read the
+// config file, which only root can read
+var ErrProtected = errors.New("permission denied, run with sudo to read the protected config")
+
 // Init initializes the configuration system
 func Init(configPath string) error {
 	ConfigPath = configPath
+	loadedVersion = CurrentVersion
 
 	// Set defaults
-	setDefaults()
+	setDefaults(viper.GetViper())
 
 	// Configure viper
 	if configPath != "" {
@@ -36,17 +60,34 @@ func Init(configPath string) error {
 	}
 
 	// Environment variables
-	viper.SetEnvPrefix("WTE")
+	viper.SetEnvPrefix(EnvPrefix)
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
+	bindEnv(viper.GetViper())
 
 	// Try to read config file
+	protected = false
+	storedPath := ""
 	if err := viper.ReadInConfig(); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			// Carry on with the defaults so commands can show what
+			// doesn't depend on the config
+			protected = true
+			cfg = DefaultConfig()
+			return fmt.Errorf("cannot read %s: %w", viper.ConfigFileUsed(), ErrProtected)
+		}
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			// Config file was found but another error was produced
 			return fmt.Errorf("error reading config file: %w", err)
 		}
 		// Config file not found; use defaults
+	} else {
+		loadedVersion = viper.GetInt("version")
+		storedPath = viper.ConfigFileUsed()
+	}
+
+	if err := loadStored(storedPath); err != nil {
+		return err
 	}
 
 	// Unmarshal into config struct
@@ -55,44 +96,197 @@ func Init(configPath string) error {
 		return fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	// Upgrade files written by older versions in memory only, so read-only
+	// commands leave the file alone. The next Save writes the upgrade after
+	// backing up the old file.
+	if NeedsMigration() {
+		if err := upgrade(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// setDefaults sets default values in viper
-func setDefaults() {
+// bindEnv binds every configuration key to its environment variable.
+// AutomaticEnv only applies to keys viper is asked for by name; the binding
+// makes Unmarshal see the variables of nested keys too.
+func bindEnv(v *viper.Viper) {
+	for _, key := range Keys() {
+		// Lists of entries can't be given as a single variable
+		if strings.Contains(key, "<n>") {
+			continue
+		}
+		_ = v.BindEnv(key, EnvVar(key))
+	}
+}
+
+// loadStored reads the config file at path into stored, upgraded like the
+// active settings. An empty path leaves only the defaults.
+func loadStored(path string) error {
+	stored = viper.New()
+	setDefaults(stored)
+	if path == "" {
+		return nil
+	}
+
+	stored.SetConfigFile(path)
+	if err := stored.ReadInConfig(); err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	return runMigrations(stored, loadedVersion)
+}
+
+// withoutEnv returns a copy of c in which the settings that still hold the
+// value of their environment variable hold the stored value instead, so a
+// Save doesn't persist overrides meant for the current process only
+func withoutEnv(c *Config) (Config, error) {
+	out := *c
+
+	effective := &Config{}
+	if err := viper.Unmarshal(effective); err != nil {
+		return out, fmt.Errorf("error reading config: %w", err)
+	}
+	saved := &Config{}
+	if err := stored.Unmarshal(saved); err != nil {
+		return out, fmt.Errorf("error reading config: %w", err)
+	}
+
+	for _, key := range Keys() {
+		if strings.Contains(key, "<n>") {
+			continue
+		}
+		if _, ok := os.LookupEnv(EnvVar(key)); !ok {
+			continue
+		}
+
+		field, err := lookupKey(reflect.ValueOf(&out).Elem(), key)
+		if err != nil {
+			continue
+		}
+		envValue, err := lookupKey(reflect.ValueOf(*effective), key)
+		if err != nil {
+			continue
+		}
+		savedValue, err := lookupKey(reflect.ValueOf(*saved), key)
+		if err != nil {
+			continue
+		}
+
+		// A value changed since Init is kept, even for an overridden key
+		if reflect.DeepEqual(field.Interface(), envValue.Interface()) {
+			field.Set(savedValue)
+		}
+	}
+
+	return out, nil
+}
+
+// EnvVar returns the environment variable that overrides key, e.g.
+// WTE_HTTP_AUTH_ENABLED for http.auth.enabled
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// setDefaults sets default values in v
+func setDefaults(v *viper.Viper) {
+	// Server defaults
+	v.SetDefault("server.name", DefaultServerName())
+
 	// GOST defaults
-	viper.SetDefault("gost.version", DefaultGOSTVersion)
-	viper.SetDefault("gost.binary_path", DefaultGOSTBinaryPath)
-	viper.SetDefault("gost.config_dir", DefaultGOSTConfigDir)
-	viper.SetDefault("gost.config_file", DefaultGOSTConfigFile)
+	v.SetDefault("gost.version", DefaultGOSTVersion)
+	v.SetDefault("gost.binary_path", DefaultGOSTBinaryPath)
+	v.SetDefault("gost.config_dir", DefaultGOSTConfigDir)
+	v.SetDefault("gost.config_file", DefaultGOSTConfigFile)
+	v.SetDefault("gost.run_as_user", DefaultGOSTUser)
+	v.SetDefault("gost.memory_max", "")
+	v.SetDefault("gost.cpu_quota", "")
+	v.SetDefault("gost.download_mirror", "")
+	v.SetDefault("gost.extra_services", "")
+	v.SetDefault("gost.raw_append", "")
 
 	// HTTP defaults
-	viper.SetDefault("http.enabled", true)
-	viper.SetDefault("http.port", DefaultHTTPPort)
-	viper.SetDefault("http.auth.enabled", true)
-	viper.SetDefault("http.auth.username", DefaultUsername)
-	viper.SetDefault("http.auth.password", "")
+	v.SetDefault("http.enabled", true)
+	v.SetDefault("http.port", DefaultHTTPPort)
+	v.SetDefault("http.bind_address", "")
+	v.SetDefault("http.auth.enabled", true)
+	v.SetDefault("http.auth.username", DefaultUsername)
+	v.SetDefault("http.auth.password", "")
+	v.SetDefault("http.limiter.in", "")
+	v.SetDefault("http.limiter.out", "")
+	v.SetDefault("http.limiter.max_connections", 0)
+	v.SetDefault("http.acl.allow", []string{})
+	v.SetDefault("http.acl.deny", []string{})
 
 	// HTTPS defaults
-	viper.SetDefault("https.enabled", false)
-	viper.SetDefault("https.port", DefaultHTTPSPort)
-	viper.SetDefault("https.cert_path", DefaultGOSTConfigDir+"/cert.pem")
-	viper.SetDefault("https.key_path", DefaultGOSTConfigDir+"/key.pem")
-	viper.SetDefault("https.auth.enabled", true)
-	viper.SetDefault("https.auth.username", DefaultUsername)
-	viper.SetDefault("https.auth.password", "")
+	v.SetDefault("https.enabled", false)
+	v.SetDefault("https.port", DefaultHTTPSPort)
+	v.SetDefault("https.bind_address", "")
+	v.SetDefault("https.cert_path", DefaultGOSTConfigDir+"/cert.pem")
+	v.SetDefault("https.key_path", DefaultGOSTConfigDir+"/key.pem")
+	v.SetDefault("https.domain", "")
+	v.SetDefault("https.acme", false)
+	v.SetDefault("https.acme_directory", "")
+	v.SetDefault("https.auth.enabled", true)
+	v.SetDefault("https.auth.username", DefaultUsername)
+	v.SetDefault("https.auth.password", "")
+	v.SetDefault("https.limiter.in", "")
+	v.SetDefault("https.limiter.out", "")
+	v.SetDefault("https.limiter.max_connections", 0)
+	v.SetDefault("https.acl.allow", []string{})
+	v.SetDefault("https.acl.deny", []string{})
 
 	// Shadowsocks defaults
-	viper.SetDefault("shadowsocks.enabled", true)
-	viper.SetDefault("shadowsocks.port", DefaultShadowsocksPort)
-	viper.SetDefault("shadowsocks.method", DefaultShadowsocksMethod)
-	viper.SetDefault("shadowsocks.password", "")
+	v.SetDefault("shadowsocks.enabled", true)
+	v.SetDefault("shadowsocks.port", DefaultShadowsocksPort)
+	v.SetDefault("shadowsocks.bind_address", "")
+	v.SetDefault("shadowsocks.method", DefaultShadowsocksMethod)
+	v.SetDefault("shadowsocks.password", "")
+	v.SetDefault("shadowsocks.udp", true)
+	v.SetDefault("shadowsocks.ports", []int{})
+	v.SetDefault("shadowsocks.plugin.name", "")
+	v.SetDefault("shadowsocks.plugin.opts", "")
+	v.SetDefault("shadowsocks.limiter.in", "")
+	v.SetDefault("shadowsocks.limiter.out", "")
+	v.SetDefault("shadowsocks.limiter.max_connections", 0)
+	v.SetDefault("shadowsocks.acl.allow", []string{})
+	v.SetDefault("shadowsocks.acl.deny", []string{})
+
+	// Relay defaults
+	v.SetDefault("relay.bind_address", "")
+	v.SetDefault("relay.entries", []RelayEntry{})
 
 	// Firewall defaults
-	viper.SetDefault("firewall.auto_configure", true)
+	v.SetDefault("firewall.auto_configure", true)
+	v.SetDefault("firewall.acl", false)
+
+	// Security defaults
+	v.SetDefault("security.allow", []string{})
+	v.SetDefault("security.allow_open_proxy", false)
+	v.SetDefault("security.auth_lockout.enabled", false)
+	v.SetDefault("security.auth_lockout.max_attempts", DefaultLockoutMaxAttempts)
+	v.SetDefault("security.auth_lockout.window", DefaultLockoutWindow)
+	v.SetDefault("security.auth_lockout.ban_duration", DefaultLockoutBanDuration)
+	v.SetDefault("security.password_charset", DefaultPasswordCharset)
+
+	// Watchdog defaults
+	v.SetDefault("watchdog.enabled", false)
+	v.SetDefault("watchdog.interval", DefaultWatchdogInterval)
+	v.SetDefault("watchdog.failures", DefaultWatchdogFailures)
+	v.SetDefault("watchdog.cooldown", DefaultWatchdogCooldown)
+
+	// Credentials defaults
+	v.SetDefault("credentials.path", CredentialsFile)
+	v.SetDefault("credentials.store", CredentialsStorePlaintext)
+
+	// Debug defaults
+	v.SetDefault("debug.pprof.enabled", false)
+	v.SetDefault("debug.pprof.port", DefaultPprofPort)
+	v.SetDefault("debug.pprof.bind_address", LocalhostBindAddress)
 
 	// Logging defaults
-	viper.SetDefault("logging.level", DefaultLogLevel)
+	v.SetDefault("logging.level", DefaultLogLevel)
+	v.SetDefault("logging.file", "")
 }
 
 // Get returns the current configuration
@@ -103,18 +297,94 @@ func Get() *Config {
 	return cfg
 }
 
-// Set updates a configuration value
+// SetConfig replaces the current configuration
+func SetConfig(c *Config) {
+	cfg = c
+}
+
+// Set updates a configuration value. Keys may address a field of a list
+// element, e.g. relay.entries.0.remote_port.
 func Set(key string, value interface{}) error {
+	listKey, list, ok, err := listElementKey(Get(), key, value)
+	if err != nil {
+		return err
+	}
+	if ok {
+		key, value = listKey, list
+	}
+
 	viper.Set(key, value)
+	stored.Set(key, value)
 
-	// Re-unmarshal to update the config struct
-	if err := viper.Unmarshal(cfg); err != nil {
+	// Re-unmarshal into a fresh struct, decoding into the existing one would
+	// keep stale trailing elements when a list shrinks
+	updated := &Config{}
+	if err := viper.Unmarshal(updated); err != nil {
 		return fmt.Errorf("error updating config: %w", err)
 	}
+	*Get() = *updated
 
 	return nil
 }
 
+// GetValue returns the current value of a configuration key
+func GetValue(key string) interface{} {
+	if value := viper.Get(key); value != nil {
+		return value
+	}
+
+	// Viper doesn't look into lists
+	if field, err := lookupKey(reflect.ValueOf(*Get()), key); err == nil {
+		return field.Interface()
+	}
+	return nil
+}
+
+// Change is a new value for a configuration key
+type Change struct {
+	Key   string
+	Value interface{}
+}
+
+// WithValue returns a copy of the current configuration with key set to
+// value, leaving the active configuration untouched
+func WithValue(key string, value interface{}) (*Config, error) {
+	return WithValues([]Change{{Key: key, Value: value}})
+}
+
+// WithValues returns a copy of the current configuration with the changes
+// made in order, leaving the active configuration untouched
+func WithValues(changes []Change) (*Config, error) {
+	v := viper.New()
+	if err := v.MergeConfigMap(viper.AllSettings()); err != nil {
+		return nil, fmt.Errorf("error copying config: %w", err)
+	}
+
+	candidate := &Config{}
+	if err := v.Unmarshal(candidate); err != nil {
+		return nil, fmt.Errorf("error copying config: %w", err)
+	}
+
+	for _, change := range changes {
+		key, value := change.Key, change.Value
+		listKey, list, ok, err := listElementKey(candidate, key, value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			key, value = listKey, list
+		}
+		v.Set(key, value)
+
+		candidate = &Config{}
+		if err := v.Unmarshal(candidate); err != nil {
+			return nil, fmt.Errorf("error updating config: %w", err)
+		}
+	}
+
+	return candidate, nil
+}
+
 // Save writes the current configuration to file
 func Save() error {
 	return SaveTo(WTEConfigFile)
@@ -122,24 +392,89 @@ func Save() error {
 
 // SaveTo writes the current configuration to a specific file
 func SaveTo(path string) error {
+	_, err := saveTo(path)
+	return err
+}
+
+// saveTo writes the current configuration to path. When path is the loaded
+// config file and was upgraded in memory, the old file is backed up first
+// and the backup path returned.
+func saveTo(path string) (string, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+		return "", fmt.Errorf("failed to create config directory: %w", err)
 	}
 
+	// Environment overrides apply to this process only, and the settings
+	// always have the current layout
+	current, err := withoutEnv(Get())
+	if err != nil {
+		return "", err
+	}
+	current.Version = CurrentVersion
+
 	// Marshal config to YAML
-	data, err := yaml.Marshal(cfg)
+	data, err := yaml.Marshal(&current)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return "", fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(path, data, 0600); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+	var backupPath string
+	upgraded := NeedsMigration() && path == viper.ConfigFileUsed()
+	if upgraded {
+		if backupPath, err = fsutil.Backup(path); err != nil {
+			return "", fmt.Errorf("failed to back up config file: %w", err)
+		}
 	}
 
-	return nil
+	// Write atomically, a truncated config would fail the next load
+	if err := fsutil.WriteFileAtomic(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	if upgraded {
+		loadedVersion = CurrentVersion
+	}
+
+	return backupPath, nil
+}
+
+// Check reads the config file at path and reports whether it parses,
+// without touching the active configuration
+func Check(path string) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	return v.Unmarshal(&Config{})
+}
+
+// LoadFile reads a complete configuration from the file at path, filling
+// in defaults for keys it lacks, without touching the active
+// configuration. Unknown keys are rejected, a typo would otherwise be
+// silently ignored.
+func LoadFile(path string) (*Config, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := runMigrations(v, v.GetInt("version")); err != nil {
+		return nil, err
+	}
+	v.Set("version", CurrentVersion)
+
+	loaded := &Config{}
+	if err := v.UnmarshalExact(loaded); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %w", err)
+	}
+
+	return loaded, nil
 }
 
 // Load reads configuration from the specified file
@@ -157,6 +492,12 @@ func Reset() {
 	cfg = DefaultConfig()
 }
 
+// Protected reports whether Init found a config file it was not allowed
+// to read. The active configuration then holds the defaults.
+func Protected() bool {
+	return protected
+}
+
 // Exists checks if the config file exists
 func Exists() bool {
 	_, err := os.Stat(WTEConfigFile)
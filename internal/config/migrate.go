@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// CurrentVersion is the config file layout written by this version of WTE.
+// Files without a version field are version 0.
+const CurrentVersion = 1
+
+// migrations upgrade a config file from the version they are keyed by to the
+// next one. Keys a file lacks are filled in from the defaults when it is
+// rewritten, so a migration only has to handle keys that were renamed or
+// changed meaning.
+var migrations = map[int]func(v *viper.Viper) error{
+	// Unversioned files predate relays, resource limits and the service
+	// user; writing them out with the defaults is all they need
+	0: func(v *viper.Viper) error { return nil },
+}
+
+// MigrationResult describes a config file upgrade
+type MigrationResult struct {
+	From       int
+	To         int
+	BackupPath string
+}
+
+// FileVersion returns the version of the loaded config file, which Init
+// may have upgraded in memory since
+func FileVersion() int {
+	return loadedVersion
+}
+
+// NeedsMigration reports whether the loaded config file has a layout older
+// than CurrentVersion and was not saved since
+func NeedsMigration() bool {
+	return loadedVersion < CurrentVersion
+}
+
+// Migrate writes the upgraded settings back to the loaded config file. The
+// file is backed up first and rewritten with the defaults of keys it lacks.
+// It returns nil if the file is already current.
+func Migrate() (*MigrationResult, error) {
+	if !NeedsMigration() {
+		return nil, nil
+	}
+
+	result := &MigrationResult{From: loadedVersion, To: CurrentVersion}
+
+	backupPath, err := saveTo(viper.ConfigFileUsed())
+	if err != nil {
+		return nil, err
+	}
+	result.BackupPath = backupPath
+
+	return result, nil
+}
+
+// upgrade applies the migrations from the loaded file's version to the
+// active settings, without writing the file
+func upgrade() error {
+	if err := runMigrations(viper.GetViper(), loadedVersion); err != nil {
+		return err
+	}
+
+	upgraded := &Config{}
+	if err := viper.Unmarshal(upgraded); err != nil {
+		return fmt.Errorf("error unmarshaling config: %w", err)
+	}
+	cfg = upgraded
+
+	return nil
+}
+
+// runMigrations upgrades the settings in v from version from to
+// CurrentVersion
+func runMigrations(v *viper.Viper, from int) error {
+	for version := from; version < CurrentVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from config version %d", version)
+		}
+		if err := migrate(v); err != nil {
+			return fmt.Errorf("config migration from version %d failed: %w", version, err)
+		}
+	}
+	return nil
+}
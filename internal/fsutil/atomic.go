@@ -0,0 +1,100 @@
+// Package fsutil provides file helpers shared by the packages that write
+// configuration
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// File is a file to be written by WriteFilesAtomic
+type File struct {
+	Path string
+	Data []byte
+	Perm os.FileMode
+}
+
+// WriteFileAtomic writes data to path through a temporary file in the same
+// directory that is renamed over path, so a crash leaves either the old or
+// the new content and never a truncated file. The file gets perm and keeps
+// the owner of the file it replaces.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return WriteFilesAtomic(File{Path: path, Data: data, Perm: perm})
+}
+
+// WriteFilesAtomic writes files that belong together, such as a certificate
+// and its key, like WriteFileAtomic. Every temporary file is written before
+// the first rename, so a failed write leaves all of them untouched.
+func WriteFilesAtomic(files ...File) (err error) {
+	tmpNames := make([]string, 0, len(files))
+	defer func() {
+		if err != nil {
+			for _, name := range tmpNames {
+				os.Remove(name)
+			}
+		}
+	}()
+
+	for _, f := range files {
+		name, err := writeTemp(f)
+		if err != nil {
+			return err
+		}
+		tmpNames = append(tmpNames, name)
+	}
+
+	for i, f := range files {
+		if err = os.Rename(tmpNames[i], f.Path); err != nil {
+			return err
+		}
+		syncDir(filepath.Dir(f.Path))
+	}
+
+	return nil
+}
+
+// writeTemp writes f to a temporary file next to f.Path and returns its name
+func writeTemp(f File) (name string, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), "."+filepath.Base(f.Path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(f.Data); err != nil {
+		return "", err
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err = tmp.Chmod(f.Perm); err != nil {
+		return "", err
+	}
+
+	// The service user may own the file being replaced
+	if info, statErr := os.Stat(f.Path); statErr == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			_ = tmp.Chown(int(st.Uid), int(st.Gid))
+		}
+	}
+
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// syncDir persists a rename in dir, best effort
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
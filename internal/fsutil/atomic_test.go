@@ -0,0 +1,119 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// assertNoTempFiles fails if a temporary file was left behind in dir
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+// assertContent fails unless path holds want
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), got, want)
+	}
+}
+
+func TestWriteFileAtomicReplaces(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+
+	assertContent(t, path, "new")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicFailedRename(t *testing.T) {
+	dir := t.TempDir()
+
+	// Renaming a file over a non-empty directory fails
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.MkdirAll(filepath.Join(path, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err == nil {
+		t.Fatal("WriteFileAtomic over a directory succeeded")
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "keep")); err != nil {
+		t.Errorf("original was changed: %v", err)
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err == nil {
+		t.Fatal("WriteFileAtomic in a read-only directory succeeded")
+	}
+
+	assertContent(t, path, "old")
+	assertNoTempFiles(t, dir)
+}
+
+func TestWriteFilesAtomicKeepsAllOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyPath, []byte("old key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate directory doesn't exist, so its temporary file can't
+	// be created and the key must not be replaced either
+	err := WriteFilesAtomic(
+		File{Path: keyPath, Data: []byte("new key"), Perm: 0600},
+		File{Path: filepath.Join(dir, "missing", "cert.pem"), Data: []byte("new cert"), Perm: 0644},
+	)
+	if err == nil {
+		t.Fatal("WriteFilesAtomic succeeded without the certificate directory")
+	}
+
+	assertContent(t, keyPath, "old key")
+	assertNoTempFiles(t, dir)
+}
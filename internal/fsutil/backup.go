@@ -0,0 +1,64 @@
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// BackupTimeFormat is the timestamp of backups named <file>.backup.<time>
+const BackupTimeFormat = "20060102_150405"
+
+// backupSuffix matches the suffix of a timestamped backup
+var backupSuffix = regexp.MustCompile(`^\.backup\.[0-9]{8}_[0-9]{6}$`)
+
+// Backups returns the timestamped backups of path, the files named
+// <path>.backup.<time> next to it. Other files are never matched.
+func Backups(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || len(name) <= len(base) || name[:len(base)] != base {
+			continue
+		}
+		if backupSuffix.MatchString(name[len(base):]) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+
+	return backups, nil
+}
+
+// Backup copies path to <path>.backup.<time> and returns the copy's path,
+// or "" if path does not exist. The copy is only readable by its owner.
+func Backup(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	backupPath := fmt.Sprintf("%s.backup.%s", path, time.Now().Format(BackupTimeFormat))
+	if err := os.WriteFile(backupPath, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	return backupPath, nil
+}
@@ -0,0 +1,322 @@
+package gost
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"wte/internal/config"
+	"wte/internal/fsutil"
+	"wte/internal/system"
+	"wte/internal/ui"
+)
+
+// DefaultApplyTimeout is how long ApplyTransaction waits for the service
+// to come up with a new configuration
+const DefaultApplyTimeout = 15 * time.Second
+
+// ErrRolledBack is returned by ApplyTransaction when the new configuration
+// was rejected and the previous one restored
+var ErrRolledBack = errors.New("apply rolled back")
+
+// reloadSettleTime is how long GOST gets to process a reload signal before
+// its log is checked for a rejected configuration
+const reloadSettleTime = 2 * time.Second
+
+// ApplyOptions tune ApplyTransactionWithOptions
+type ApplyOptions struct {
+	// Timeout is how long to wait for the service to come up
+	Timeout time.Duration
+	// Restart restarts the service even when a reload would do
+	Restart bool
+}
+
+// ApplyTransaction validates cfg, renders it to a temporary file, swaps it
+// in and reloads or restarts the service. The new configuration is only
+// kept if the service becomes active and every enabled proxy accepts
+// connections within timeout; otherwise the previous configuration is
+// restored and the service restarted with it.
+func ApplyTransaction(cfg *config.Config, timeout time.Duration) error {
+	return ApplyTransactionWithOptions(cfg, ApplyOptions{Timeout: timeout})
+}
+
+// ApplyTransactionWithOptions is ApplyTransaction with options. The service
+// is reloaded, which keeps client connections open, unless the listeners or
+// the service definition changed, the service isn't running or
+// opts.Restart is set. A reload GOST logs an error for is followed by a
+// restart, which fails on a configuration GOST can't load.
+func ApplyTransactionWithOptions(cfg *config.Config, opts ApplyOptions) error {
+	timeout := opts.Timeout
+	configGen := NewConfigGenerator(cfg)
+
+	// Validate
+	if err := configGen.Validate(); err != nil {
+		return fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	// Render
+	ui.Action("Generating GOST configuration...")
+
+	rendered, err := configGen.Render()
+	if err != nil {
+		return err
+	}
+
+	configFile := cfg.GOST.ConfigFile
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	pending := configFile + ".new"
+	if err := os.WriteFile(pending, rendered, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	defer os.Remove(pending)
+
+	previous, err := os.ReadFile(configFile)
+	hasPrevious := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read current config file: %w", err)
+	}
+
+	// Swap
+	if err := os.Rename(pending, configFile); err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+
+	ui.Success("Configuration file created: %s", configFile)
+
+	if err := configGen.finish(); err != nil {
+		return err
+	}
+
+	if err := PrepareServiceUser(cfg); err != nil {
+		return err
+	}
+
+	// Start and verify
+	service := system.NewServiceManager()
+
+	// The service definition carries settings from the WTE config too, keep
+	// it in sync
+	var definitionBefore []byte
+	definitionChanged := false
+	if service.IsInstalled() {
+		definitionBefore, _ = os.ReadFile(service.DefinitionPath())
+		if err := service.Create(cfg); err != nil {
+			ui.Warning("Could not update service definition: %v", err)
+		}
+		after, _ := os.ReadFile(service.DefinitionPath())
+		definitionChanged = !bytes.Equal(definitionBefore, after)
+	}
+
+	reload := !opts.Restart && hasPrevious && !definitionChanged && sameListeners(previous, rendered)
+	if reload {
+		status, statusErr := service.Status()
+		reload = statusErr == nil && status.IsActive
+	}
+
+	reloadedAt := time.Now()
+	if reload {
+		ui.Action("Reloading service...")
+		if err = service.Reload(); err != nil {
+			ui.Warning("Could not reload service, restarting it: %v", err)
+			reload = false
+		}
+	}
+	if reload {
+		// GOST keeps the previous configuration when it rejects a reload,
+		// so the service stays ready either way. Restart if it logged an
+		// error, or if that can't be checked.
+		time.Sleep(reloadSettleTime)
+		if reloadErr := checkReload(service, reloadedAt); reloadErr != nil {
+			ui.Warning("Could not confirm the reload, restarting the service: %v", reloadErr)
+			reload = false
+		}
+	}
+	if !reload {
+		ui.Action("Restarting service...")
+		err = service.Restart()
+	}
+	if err == nil {
+		err = WaitForReady(service, cfg, timeout)
+	}
+	if err == nil {
+		ui.Success("Service running with the new configuration")
+		return nil
+	}
+
+	// Restore
+	ui.Error("Service did not start with the new configuration: %v", err)
+
+	PrintRecentLogs(service, 20)
+
+	// The previous configuration needs the service definition it ran with
+	if definitionChanged {
+		ui.Action("Restoring previous service definition...")
+		if restoreErr := service.RestoreDefinition(definitionBefore); restoreErr != nil {
+			return fmt.Errorf("apply failed and the service definition could not be restored: %w", restoreErr)
+		}
+	}
+
+	if !hasPrevious {
+		_ = service.Stop()
+		if rmErr := os.Remove(configFile); rmErr != nil && !os.IsNotExist(rmErr) {
+			return fmt.Errorf("apply failed and the new config could not be removed: %w", rmErr)
+		}
+		return fmt.Errorf("service failed to start: %w", err)
+	}
+
+	ui.Action("Restoring previous GOST configuration...")
+
+	if writeErr := fsutil.WriteFileAtomic(configFile, previous, 0600); writeErr != nil {
+		return fmt.Errorf("apply failed and rollback failed: %w", writeErr)
+	}
+
+	if restartErr := service.Restart(); restartErr != nil {
+		return fmt.Errorf("apply failed and the service did not restart after rollback: %w", restartErr)
+	}
+
+	ui.Warning("Change rolled back, the service runs the previous configuration")
+
+	return fmt.Errorf("%w: %v", ErrRolledBack, err)
+}
+
+// checkReload returns an error if the service logged an error since a
+// reload, or its log can't be read
+func checkReload(service system.ServiceManager, since time.Time) error {
+	logs, err := service.Logs(system.LogOptions{Lines: 1, Level: "error", Since: since})
+	if err != nil {
+		return fmt.Errorf("failed to read the service log: %w", err)
+	}
+	if line := strings.TrimSpace(logs); line != "" {
+		return fmt.Errorf("GOST logged an error: %s", line)
+	}
+	return nil
+}
+
+// PrintRecentLogs shows the last lines of the service log, to explain why
+// the service did not start
+func PrintRecentLogs(service system.ServiceManager, lines int) {
+	logs, err := service.Logs(system.LogOptions{Lines: lines})
+	if err != nil || strings.TrimSpace(logs) == "" {
+		return
+	}
+	ui.Println()
+	ui.Info("Recent service logs:")
+	ui.Println(logs)
+}
+
+// sameListeners reports whether two rendered GOST configurations listen on
+// the same addresses the same way, so one can replace the other by a reload
+func sameListeners(previous, rendered []byte) bool {
+	a, err := listeners(previous)
+	if err != nil {
+		return false
+	}
+	b, err := listeners(rendered)
+	if err != nil {
+		return false
+	}
+	return a == b
+}
+
+// listeners returns a fingerprint of the listeners in a GOST configuration
+func listeners(data []byte) (string, error) {
+	var doc struct {
+		Services []struct {
+			Name     string         `yaml:"name"`
+			Addr     string         `yaml:"addr"`
+			Listener map[string]any `yaml:"listener"`
+		} `yaml:"services"`
+		Profiling map[string]any `yaml:"profiling"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, svc := range doc.Services {
+		lines = append(lines, fmt.Sprintf("%s %s %v", svc.Name, svc.Addr, svc.Listener))
+	}
+	sort.Strings(lines)
+	lines = append(lines, fmt.Sprintf("profiling %v", doc.Profiling))
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// WaitForReady waits until the service is active and every enabled proxy
+// accepts TCP connections, or timeout passes
+func WaitForReady(service system.ServiceManager, cfg *config.Config, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := checkReady(service, cfg)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// checkReady checks once that the service is active and listening
+func checkReady(service system.ServiceManager, cfg *config.Config) error {
+	status, err := service.Status()
+	if err != nil {
+		return err
+	}
+	if !status.IsActive {
+		return fmt.Errorf("service is %s", status.ActiveState)
+	}
+
+	for _, port := range cfg.GetRequiredPorts() {
+		if port.Protocol != "tcp" {
+			continue
+		}
+		if err := system.TestTCPPort(config.DialHost(port.BindAddress), port.Port, time.Second); err != nil {
+			return fmt.Errorf("%s port %d is not listening", port.Service, port.Port)
+		}
+	}
+
+	return nil
+}
+
+// PrepareServiceUser creates the user the service runs as and gives it the
+// GOST config directory and the HTTPS certificate. Nothing is done when the
+// service runs as root.
+func PrepareServiceUser(cfg *config.Config) error {
+	if cfg.GOST.RunsAsRoot() {
+		return nil
+	}
+
+	name := cfg.GOST.RunAsUser
+	if err := system.EnsureServiceUser(name); err != nil {
+		return err
+	}
+
+	paths := []string{cfg.GOST.ConfigDir}
+	if cfg.HTTPS.Enabled {
+		paths = append(paths, cfg.HTTPS.CertPath, cfg.HTTPS.KeyPath)
+	}
+
+	for _, path := range paths {
+		if !system.FileExists(path) {
+			continue
+		}
+		if err := system.ChownToUser(path, name); err != nil {
+			return fmt.Errorf("failed to give %s to user %s: %w", path, name, err)
+		}
+	}
+
+	return nil
+}
@@ -1,12 +1,19 @@
 package gost
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
 	"wte/internal/config"
+	"wte/internal/security"
 )
 
 const credentialsTemplate = `╔══════════════════════════════════════════════════════════════════════════════╗
@@ -14,33 +21,48 @@ const credentialsTemplate = `╔════════════════
 ╠══════════════════════════════════════════════════════════════════════════════╣
 ║                                                                               ║
 ║  Generated: {{.GeneratedAt}}
+║  Server:    {{.ServerName}}
 ║  Server IP: {{.ServerIP}}
 ║  Generator: WTE
 ║                                                                               ║
 ╚══════════════════════════════════════════════════════════════════════════════╝
-{{if .HTTP.Enabled}}
+{{if .LocalhostOnly}}
+┌──────────────────────────────────────────────────────────────────────────────┐
+│ SSH TUNNEL                                                                    │
+├──────────────────────────────────────────────────────────────────────────────┤
+│                                                                               │
+│  Services listen on localhost only and are accessible via SSH tunnel.        │
+│  Open the tunnel on your machine, then connect to 127.0.0.1:                  │
+│                                                                               │
+│  {{.TunnelCommand}}
+│                                                                               │
+│  Note: SSH forwards TCP only, Shadowsocks UDP relay is not available.        │
+│  Forward to 127.0.0.1, not localhost or [::1]: IPv6 loopback is not bound.   │
+│                                                                               │
+└──────────────────────────────────────────────────────────────────────────────┘
+{{end}}{{if .HTTP.Enabled}}
 ┌──────────────────────────────────────────────────────────────────────────────┐
 │ HTTP PROXY                                                                    │
 ├──────────────────────────────────────────────────────────────────────────────┤
 │                                                                               │
-│  Host:     {{.ServerIP}}
+│  Host:     {{.Host}}
 │  Port:     {{.HTTP.Port}}
 {{- if .HTTP.Auth.Enabled}}
 │  Username: {{.HTTP.Auth.Username}}
 │  Password: {{.HTTP.Auth.Password}}
 │                                                                               │
-│  Full URL: http://{{.HTTP.Auth.Username}}:{{.HTTP.Auth.Password}}@{{.ServerIP}}:{{.HTTP.Port}}
+│  Full URL: http://{{userInfo .HTTP.Auth.Username .HTTP.Auth.Password}}@{{hostPort .Host .HTTP.Port}}
 {{- else}}
 │  Authentication: Disabled
 │                                                                               │
-│  Full URL: http://{{.ServerIP}}:{{.HTTP.Port}}
+│  Full URL: http://{{hostPort .Host .HTTP.Port}}
 {{- end}}
 │                                                                               │
 │  Test command:                                                                │
 {{- if .HTTP.Auth.Enabled}}
-│  curl -x http://{{.HTTP.Auth.Username}}:{{.HTTP.Auth.Password}}@{{.ServerIP}}:{{.HTTP.Port}} https://ifconfig.me
+│  curl -x 'http://{{userInfo .HTTP.Auth.Username .HTTP.Auth.Password}}@{{hostPort .Host .HTTP.Port}}' https://ifconfig.me
 {{- else}}
-│  curl -x http://{{.ServerIP}}:{{.HTTP.Port}} https://ifconfig.me
+│  curl -x http://{{hostPort .Host .HTTP.Port}} https://ifconfig.me
 {{- end}}
 │                                                                               │
 └──────────────────────────────────────────────────────────────────────────────┘
@@ -50,15 +72,19 @@ const credentialsTemplate = `╔════════════════
 │ HTTPS PROXY (TLS encrypted)                                                  │
 ├──────────────────────────────────────────────────────────────────────────────┤
 │                                                                               │
-│  Host:     {{.ServerIP}}
+│  Host:     {{.HTTPSHost}}
 │  Port:     {{.HTTPS.Port}}
 {{- if .HTTPS.Auth.Enabled}}
 │  Username: {{.HTTPS.Auth.Username}}
 │  Password: {{.HTTPS.Auth.Password}}
 {{- end}}
 │                                                                               │
+{{- if .CertTrusted}}
+│  Certificate: {{.HTTPS.CertPath}} (issued for {{.HTTPS.Domain}})
+{{- else}}
 │  Note: Uses self-signed certificate. Browser may show security warning.      │
 │  Certificate: {{.HTTPS.CertPath}}
+{{- end}}
 │                                                                               │
 └──────────────────────────────────────────────────────────────────────────────┘
 {{end}}
@@ -67,13 +93,19 @@ const credentialsTemplate = `╔════════════════
 │ SHADOWSOCKS                                                                   │
 ├──────────────────────────────────────────────────────────────────────────────┤
 │                                                                               │
-│  Server:   {{.ServerIP}}
-│  Port:     {{.Shadowsocks.Port}}
+│  Server:   {{.Host}}
+│  Port:     {{.Shadowsocks.PortList}}
 │  Password: {{.Shadowsocks.Password}}
 │  Method:   {{.Shadowsocks.Method}}
+{{- with .Shadowsocks.Plugin.Name}}
+│  Plugin:   {{$.Shadowsocks.Plugin}}
+{{- end}}
+│  UDP:      {{if .ShadowsocksUDP}}enabled{{else}}disabled{{end}}{{if and .ShadowsocksUDP .Shadowsocks.Plugin.Name}} (without the plugin){{end}}
 │                                                                               │
 │  SS URI (for import):                                                         │
-│  {{.ShadowsocksURI}}
+{{- range .ShadowsocksURIs}}
+│  {{.}}
+{{- end}}
 │                                                                               │
 │  Compatible clients:                                                          │
 │  - iOS: Shadowrocket, Surge, Quantumult                                       │
@@ -99,43 +131,90 @@ const credentialsTemplate = `╔════════════════
 
 `
 
+// Credentials output formats
+const (
+	CredentialsFormatBox   = "box"
+	CredentialsFormatPlain = "plain"
+	CredentialsFormatEnv   = "env"
+	CredentialsFormatJSON  = "json"
+)
+
+// CredentialsFormats lists the supported credentials output formats
+var CredentialsFormats = []string{CredentialsFormatBox, CredentialsFormatPlain, CredentialsFormatEnv, CredentialsFormatJSON}
+
 // CredentialsManager manages credentials file
 type CredentialsManager struct {
 	cfg      *config.Config
 	serverIP string
+	store    CredentialsStore
 }
 
-// NewCredentialsManager creates a new CredentialsManager
+// NewCredentialsManager creates a new CredentialsManager saving to the
+// store selected by credentials.store
 func NewCredentialsManager(cfg *config.Config, serverIP string) *CredentialsManager {
+	return NewCredentialsManagerWithStore(cfg, serverIP, NewCredentialsStore(cfg))
+}
+
+// NewCredentialsManagerWithStore creates a new CredentialsManager saving to
+// store
+func NewCredentialsManagerWithStore(cfg *config.Config, serverIP string, store CredentialsStore) *CredentialsManager {
 	return &CredentialsManager{
 		cfg:      cfg,
 		serverIP: serverIP,
+		store:    store,
 	}
 }
 
-// Save saves credentials to file
-func (m *CredentialsManager) Save() error {
-	tmpl, err := template.New("credentials").Parse(credentialsTemplate)
-	if err != nil {
-		return fmt.Errorf("failed to parse credentials template: %w", err)
-	}
+// credentialsData is the data rendered into the credentials template
+type credentialsData struct {
+	GeneratedAt    string
+	ServerName     string
+	ServerIP       string
+	Host           string
+	HTTPSHost      string
+	CertTrusted    bool
+	LocalhostOnly  bool
+	TunnelCommand  string
+	HTTP           config.HTTPConfig
+	HTTPS          config.HTTPSConfig
+	Shadowsocks    config.ShadowsocksConfig
+	ShadowsocksURI string
+	ShadowsocksUDP bool
+
+	// ShadowsocksURIs has one URI per Shadowsocks port
+	ShadowsocksURIs []string
+}
+
+// templateData prepares the credentials template data. When the proxy only
+// listens on localhost, clients connect to the local end of an SSH tunnel.
+func (m *CredentialsManager) templateData() credentialsData {
+	host := ClientHost(m.cfg, m.serverIP)
 
 	configGen := NewConfigGenerator(m.cfg)
 
-	data := struct {
-		GeneratedAt    string
-		ServerIP       string
-		HTTP           config.HTTPConfig
-		HTTPS          config.HTTPSConfig
-		Shadowsocks    config.ShadowsocksConfig
-		ShadowsocksURI string
-	}{
+	data := credentialsData{
 		GeneratedAt:    time.Now().Format("2006-01-02 15:04:05"),
+		ServerName:     m.cfg.ServerName(),
 		ServerIP:       m.serverIP,
+		Host:           host,
+		HTTPSHost:      HTTPSHost(m.cfg, m.serverIP),
+		CertTrusted:    CertificateTrusted(m.cfg),
+		LocalhostOnly:  m.cfg.LocalhostOnly(),
+		TunnelCommand:  SSHTunnelCommand(m.cfg, m.serverIP),
 		HTTP:           m.cfg.HTTP,
 		HTTPS:          m.cfg.HTTPS,
 		Shadowsocks:    m.cfg.Shadowsocks,
-		ShadowsocksURI: configGen.GetShadowsocksURI(m.serverIP),
+		ShadowsocksURI: configGen.GetShadowsocksURI(host),
+		// SSH tunnels carry TCP only
+		ShadowsocksUDP: m.cfg.Shadowsocks.UDP && !m.cfg.LocalhostOnly(),
+	}
+
+	// The SSH tunnel only forwards the main Shadowsocks port
+	if data.LocalhostOnly {
+		data.Shadowsocks.Ports = nil
+		data.ShadowsocksURIs = []string{data.ShadowsocksURI}
+	} else {
+		data.ShadowsocksURIs = configGen.GetShadowsocksURIs(host)
 	}
 
 	// Use same password for HTTPS if not set
@@ -143,72 +222,331 @@ func (m *CredentialsManager) Save() error {
 		data.HTTPS.Auth = m.cfg.HTTP.Auth
 	}
 
-	file, err := os.Create(config.CredentialsFile)
+	return data
+}
+
+// CredentialsInfo is the machine-readable form of the credentials
+type CredentialsInfo struct {
+	Server      string                  `json:"server"`
+	ServerIP    string                  `json:"server_ip"`
+	SSHTunnel   string                  `json:"ssh_tunnel,omitempty"`
+	HTTP        *ProxyCredentials       `json:"http,omitempty"`
+	HTTPS       *ProxyCredentials       `json:"https,omitempty"`
+	Shadowsocks *ShadowsocksCredentials `json:"shadowsocks,omitempty"`
+}
+
+// ProxyCredentials holds the connection details of an HTTP or HTTPS proxy
+type ProxyCredentials struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	URL      string `json:"url"`
+}
+
+// ShadowsocksCredentials holds the Shadowsocks connection details
+type ShadowsocksCredentials struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Method   string `json:"method"`
+	Password string `json:"password"`
+	UDP      bool   `json:"udp"`
+	URI      string `json:"uri"`
+
+	// Plugin is the client plugin with its options, as "name;opts"
+	Plugin string `json:"plugin,omitempty"`
+
+	// Ports and URIs list every port when extra ports are configured
+	Ports []int    `json:"ports,omitempty"`
+	URIs  []string `json:"uris,omitempty"`
+}
+
+// Info returns the credentials of the enabled services
+func (m *CredentialsManager) Info() CredentialsInfo {
+	data := m.templateData()
+
+	info := CredentialsInfo{
+		Server:   data.ServerName,
+		ServerIP: data.ServerIP,
+	}
+
+	if data.LocalhostOnly {
+		info.SSHTunnel = data.TunnelCommand
+	}
+
+	proxy := func(scheme, host string, port int, auth config.AuthConfig) *ProxyCredentials {
+		creds := &ProxyCredentials{Host: host, Port: port}
+		u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}
+		if auth.Enabled {
+			creds.Username = auth.Username
+			creds.Password = auth.Password
+			u.User = url.UserPassword(auth.Username, auth.Password)
+		}
+		creds.URL = u.String()
+		return creds
+	}
+
+	if data.HTTP.Enabled {
+		info.HTTP = proxy("http", data.Host, data.HTTP.Port, data.HTTP.Auth)
+	}
+
+	if data.HTTPS.Enabled {
+		info.HTTPS = proxy("https", data.HTTPSHost, data.HTTPS.Port, data.HTTPS.Auth)
+	}
+
+	if data.Shadowsocks.Enabled {
+		info.Shadowsocks = &ShadowsocksCredentials{
+			Host:     data.Host,
+			Port:     data.Shadowsocks.Port,
+			Method:   data.Shadowsocks.Method,
+			Password: data.Shadowsocks.Password,
+			UDP:      data.ShadowsocksUDP,
+			URI:      data.ShadowsocksURI,
+		}
+		if data.Shadowsocks.Plugin.Name != "" {
+			info.Shadowsocks.Plugin = data.Shadowsocks.Plugin.String()
+		}
+		if len(data.ShadowsocksURIs) > 1 {
+			info.Shadowsocks.Ports = data.Shadowsocks.AllPorts()
+			info.Shadowsocks.URIs = data.ShadowsocksURIs
+		}
+	}
+
+	return info
+}
+
+// Env returns the credentials as shell variable assignments, one per line,
+// quoted so the output can be passed to eval
+func (i CredentialsInfo) Env() string {
+	var b strings.Builder
+	set := func(name string, value any) {
+		fmt.Fprintf(&b, "%s=%s\n", name, shellQuote(fmt.Sprint(value)))
+	}
+
+	set("WTE_SERVER", i.Server)
+	set("WTE_SERVER_IP", i.ServerIP)
+	if i.SSHTunnel != "" {
+		set("WTE_SSH_TUNNEL", i.SSHTunnel)
+	}
+
+	proxy := func(prefix string, creds *ProxyCredentials) {
+		set(prefix+"_HOST", creds.Host)
+		set(prefix+"_PORT", creds.Port)
+		if creds.Username != "" {
+			set(prefix+"_USER", creds.Username)
+			set(prefix+"_PASS", creds.Password)
+		}
+		set(prefix+"_URL", creds.URL)
+	}
+	if i.HTTP != nil {
+		proxy("WTE_HTTP", i.HTTP)
+	}
+	if i.HTTPS != nil {
+		proxy("WTE_HTTPS", i.HTTPS)
+	}
+
+	if ss := i.Shadowsocks; ss != nil {
+		set("WTE_SS_HOST", ss.Host)
+		set("WTE_SS_PORT", ss.Port)
+		set("WTE_SS_METHOD", ss.Method)
+		set("WTE_SS_PASSWORD", ss.Password)
+		set("WTE_SS_UDP", ss.UDP)
+		set("WTE_SS_URI", ss.URI)
+		if ss.Plugin != "" {
+			set("WTE_SS_PLUGIN", ss.Plugin)
+		}
+		if len(ss.Ports) > 0 {
+			ports := make([]string, 0, len(ss.Ports))
+			for _, port := range ss.Ports {
+				ports = append(ports, strconv.Itoa(port))
+			}
+			set("WTE_SS_PORTS", strings.Join(ports, ","))
+		}
+	}
+
+	return b.String()
+}
+
+// Plain returns the client URL of each enabled service, one per line
+func (i CredentialsInfo) Plain() string {
+	var b strings.Builder
+	if i.HTTP != nil {
+		fmt.Fprintf(&b, "http %s\n", i.HTTP.URL)
+	}
+	if i.HTTPS != nil {
+		fmt.Fprintf(&b, "https %s\n", i.HTTPS.URL)
+	}
+	if ss := i.Shadowsocks; ss != nil {
+		if len(ss.URIs) == 0 {
+			fmt.Fprintf(&b, "shadowsocks %s\n", ss.URI)
+		}
+		for _, uri := range ss.URIs {
+			fmt.Fprintf(&b, "shadowsocks %s\n", uri)
+		}
+	}
+	return b.String()
+}
+
+// shellQuote quotes value for POSIX shells
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// ClientHost returns the host clients connect to: the server IP, or the
+// local end of the SSH tunnel when the proxy only listens on localhost
+func ClientHost(cfg *config.Config, serverIP string) string {
+	if cfg.LocalhostOnly() {
+		return config.LocalhostBindAddress
+	}
+	return serverIP
+}
+
+// HTTPSHost returns the host clients use for the HTTPS proxy: its domain
+// if one is configured, the client host otherwise
+func HTTPSHost(cfg *config.Config, serverIP string) string {
+	if cfg.HTTPS.Domain != "" && !cfg.LocalhostOnly() {
+		return cfg.HTTPS.Domain
+	}
+	return ClientHost(cfg, serverIP)
+}
+
+// CertificateTrusted reports whether the HTTPS certificate was issued by a
+// CA rather than self-signed, so clients can verify it
+func CertificateTrusted(cfg *config.Config) bool {
+	info, err := security.GetCertificateInfo(cfg.HTTPS.CertPath)
 	if err != nil {
-		return fmt.Errorf("failed to create credentials file: %w", err)
+		return false
+	}
+	return info.Issuer != info.Subject
+}
+
+// SSHTunnelCommand returns the ssh command that forwards every enabled
+// service's port from the client machine to the server
+func SSHTunnelCommand(cfg *config.Config, serverIP string) string {
+	args := []string{"ssh", "-N"}
+
+	forward := func(bindAddress string, port int) {
+		if bindAddress == "" {
+			bindAddress = config.LocalhostBindAddress
+		}
+		args = append(args, "-L", fmt.Sprintf("%d:%s", port, config.ListenAddr(bindAddress, port)))
 	}
-	defer file.Close()
 
-	if err := tmpl.Execute(file, data); err != nil {
-		return fmt.Errorf("failed to write credentials: %w", err)
+	if cfg.HTTP.Enabled {
+		forward(cfg.HTTP.BindAddress, cfg.HTTP.Port)
 	}
 
-	// Set restricted permissions
-	if err := os.Chmod(config.CredentialsFile, 0600); err != nil {
-		return fmt.Errorf("failed to set credentials file permissions: %w", err)
+	if cfg.HTTPS.Enabled {
+		forward(cfg.HTTPS.BindAddress, cfg.HTTPS.Port)
 	}
 
-	return nil
+	if cfg.Shadowsocks.Enabled {
+		forward(cfg.Shadowsocks.BindAddress, cfg.Shadowsocks.Port)
+	}
+
+	for _, relay := range cfg.Relay.Entries {
+		if relay.Protocol == "tcp" {
+			forward(cfg.Relay.BindAddress, relay.ListenPort)
+		}
+	}
+
+	args = append(args, "root@"+serverIP)
+
+	return strings.Join(args, " ")
 }
 
-// Print prints credentials to stdout
-func (m *CredentialsManager) Print() error {
-	tmpl, err := template.New("credentials").Parse(credentialsTemplate)
+// parseCredentialsTemplate parses the credentials template. hostPort
+// brackets IPv6 literals in URLs.
+func parseCredentialsTemplate() (*template.Template, error) {
+	tmpl, err := template.New("credentials").Funcs(template.FuncMap{
+		"hostPort": func(host string, port int) string {
+			return net.JoinHostPort(host, strconv.Itoa(port))
+		},
+		"userInfo": userInfo,
+	}).Parse(credentialsTemplate)
 	if err != nil {
-		return fmt.Errorf("failed to parse credentials template: %w", err)
+		return nil, fmt.Errorf("failed to parse credentials template: %w", err)
 	}
+	return tmpl, nil
+}
 
-	configGen := NewConfigGenerator(m.cfg)
+// userInfo returns the URL-encoded user information part of a proxy URL,
+// so passwords with symbols stay valid in URLs
+func userInfo(username, password string) string {
+	return url.UserPassword(username, password).String()
+}
 
-	data := struct {
-		GeneratedAt    string
-		ServerIP       string
-		HTTP           config.HTTPConfig
-		HTTPS          config.HTTPSConfig
-		Shadowsocks    config.ShadowsocksConfig
-		ShadowsocksURI string
-	}{
-		GeneratedAt:    time.Now().Format("2006-01-02 15:04:05"),
-		ServerIP:       m.serverIP,
-		HTTP:           m.cfg.HTTP,
-		HTTPS:          m.cfg.HTTPS,
-		Shadowsocks:    m.cfg.Shadowsocks,
-		ShadowsocksURI: configGen.GetShadowsocksURI(m.serverIP),
+// Save saves credentials to the store. A copy left in another store by an
+// earlier credentials.store is removed.
+func (m *CredentialsManager) Save() error {
+	tmpl, err := parseCredentialsTemplate()
+	if err != nil {
+		return err
 	}
 
-	// Use same password for HTTPS if not set
-	if m.cfg.HTTPS.Enabled && m.cfg.HTTPS.Auth.Password == "" {
-		data.HTTPS.Auth = m.cfg.HTTP.Auth
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, m.templateData()); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
 	}
 
+	if err := checkCredentialsDir(filepath.Dir(m.store.Path())); err != nil {
+		return err
+	}
+	if err := m.store.Save(buf.Bytes()); err != nil {
+		return err
+	}
+
+	for _, other := range builtinCredentialsStores(m.cfg.CredentialsPath()) {
+		if other.Path() != m.store.Path() {
+			if err := other.Remove(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Load returns the saved credentials file
+func (m *CredentialsManager) Load() ([]byte, error) {
+	if !m.store.Exists() {
+		return nil, fmt.Errorf("no credentials saved at %s", m.store.Path())
+	}
+	return m.store.Load()
+}
+
+// Print prints credentials to stdout
+func (m *CredentialsManager) Print() error {
+	tmpl, err := parseCredentialsTemplate()
+	if err != nil {
+		return err
+	}
+
+	data := m.templateData()
+
 	return tmpl.Execute(os.Stdout, data)
 }
 
-// Remove removes the credentials file
+// Remove removes the saved credentials, from every store they may have
+// been saved in
 func (m *CredentialsManager) Remove() error {
-	if err := os.Remove(config.CredentialsFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove credentials file: %w", err)
+	if err := m.store.Remove(); err != nil {
+		return err
+	}
+	for _, other := range builtinCredentialsStores(m.cfg.CredentialsPath()) {
+		if err := other.Remove(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Exists checks if credentials file exists
+// Exists checks if credentials are saved
 func (m *CredentialsManager) Exists() bool {
-	_, err := os.Stat(config.CredentialsFile)
-	return err == nil
+	return m.store.Exists()
 }
 
 // GetPath returns the credentials file path
 func (m *CredentialsManager) GetPath() string {
-	return config.CredentialsFile
+	return m.store.Path()
 }
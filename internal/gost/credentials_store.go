@@ -0,0 +1,285 @@
+package gost
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"wte/internal/config"
+	"wte/internal/fsutil"
+	"wte/internal/ui"
+)
+
+// CredentialsPassphraseEnv holds the passphrase of encrypted credentials,
+// for scripts that cannot answer the prompt
+const CredentialsPassphraseEnv = "WTE_CREDENTIALS_PASSPHRASE"
+
+// EncryptedCredentialsExt is appended to the credentials file path by the
+// encrypted store
+const EncryptedCredentialsExt = ".gpg"
+
+// CredentialsStore keeps the rendered credentials file
+type CredentialsStore interface {
+	// Save replaces the stored credentials with data
+	Save(data []byte) error
+	// Load returns the stored credentials
+	Load() ([]byte, error)
+	// Remove deletes the stored credentials, if any
+	Remove() error
+	// Exists reports whether credentials are stored
+	Exists() bool
+	// Path returns the file the credentials are stored in
+	Path() string
+}
+
+// NewCredentialsStore returns the store selected by credentials.store,
+// writing to credentials.path
+func NewCredentialsStore(cfg *config.Config) CredentialsStore {
+	path := cfg.CredentialsPath()
+	if cfg.Credentials.Store == config.CredentialsStoreEncrypted {
+		return NewEncryptedStore(path + EncryptedCredentialsExt)
+	}
+	return NewPlaintextStore(path)
+}
+
+// builtinCredentialsStores returns every store the credentials at path may
+// have been saved in, so switching stores leaves no copy behind
+func builtinCredentialsStores(path string) []CredentialsStore {
+	return []CredentialsStore{
+		NewPlaintextStore(path),
+		NewEncryptedStore(path + EncryptedCredentialsExt),
+	}
+}
+
+// PlaintextStore writes the credentials as a plain text file that only
+// root can read
+type PlaintextStore struct {
+	path string
+}
+
+// NewPlaintextStore creates a PlaintextStore writing to path
+func NewPlaintextStore(path string) *PlaintextStore {
+	return &PlaintextStore{path: path}
+}
+
+// Save writes data to the credentials file
+func (s *PlaintextStore) Save(data []byte) error {
+	if err := fsutil.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	return nil
+}
+
+// Load reads the credentials file
+func (s *PlaintextStore) Load() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	return data, nil
+}
+
+// Remove removes the credentials file
+func (s *PlaintextStore) Remove() error {
+	return removeCredentialsFile(s.path)
+}
+
+// Exists checks if the credentials file exists
+func (s *PlaintextStore) Exists() bool {
+	_, err := os.Stat(s.path)
+	return err == nil
+}
+
+// Path returns the credentials file path
+func (s *PlaintextStore) Path() string {
+	return s.path
+}
+
+// EncryptedStore writes the credentials encrypted with a passphrase, using
+// gpg's symmetric AES-256 encryption. The passphrase is taken from
+// WTE_CREDENTIALS_PASSPHRASE or asked for on the terminal.
+type EncryptedStore struct {
+	path string
+
+	// Passphrase returns the passphrase, asking twice when confirm is set
+	Passphrase func(confirm bool) (string, error)
+}
+
+// NewEncryptedStore creates an EncryptedStore writing to path
+func NewEncryptedStore(path string) *EncryptedStore {
+	return &EncryptedStore{path: path, Passphrase: credentialsPassphrase}
+}
+
+// Save encrypts data into the credentials file
+func (s *EncryptedStore) Save(data []byte) error {
+	passphrase, err := s.Passphrase(true)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := runGPG(passphrase, data, "--symmetric", "--cipher-algo", "AES256")
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(s.path, encrypted, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials file: %w", err)
+	}
+	return nil
+}
+
+// Load decrypts the credentials file
+func (s *EncryptedStore) Load() ([]byte, error) {
+	encrypted, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	passphrase, err := s.Passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := runGPG(passphrase, encrypted, "--decrypt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials (wrong passphrase?): %w", err)
+	}
+	return data, nil
+}
+
+// Remove removes the credentials file
+func (s *EncryptedStore) Remove() error {
+	return removeCredentialsFile(s.path)
+}
+
+// Exists checks if the credentials file exists
+func (s *EncryptedStore) Exists() bool {
+	_, err := os.Stat(s.path)
+	return err == nil
+}
+
+// Path returns the credentials file path
+func (s *EncryptedStore) Path() string {
+	return s.path
+}
+
+// CheckCredentialsStore checks that store can be used on this system
+func CheckCredentialsStore(store string) error {
+	if err := config.ValidateCredentialsStore(store); err != nil {
+		return err
+	}
+	if store == config.CredentialsStoreEncrypted {
+		if _, err := exec.LookPath("gpg"); err != nil {
+			return fmt.Errorf("encrypted credentials need gpg, install the gnupg package")
+		}
+	}
+	return nil
+}
+
+// credentialsPassphrase reads the passphrase from the environment or the
+// terminal
+func credentialsPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(CredentialsPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := ui.PromptSecret("Credentials passphrase")
+	if err != nil {
+		return "", fmt.Errorf("%w, set %s", err, CredentialsPassphraseEnv)
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("the passphrase must not be empty")
+	}
+
+	if confirm {
+		again, err := ui.PromptSecret("Repeat the passphrase")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", fmt.Errorf("the passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+// runGPG runs gpg in batch mode on input. The passphrase is passed on a
+// pipe rather than the command line, where other users could see it.
+func runGPG(passphrase string, input []byte, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("gpg")
+	if err != nil {
+		return nil, fmt.Errorf("gpg not found, install the gnupg package")
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// The passphrase fits in the pipe buffer, so this does not block
+	_, err = writer.WriteString(passphrase + "\n")
+	writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// gpg needs a home directory even for symmetric encryption; use a
+	// throwaway one so root's keyring is not touched
+	home, err := os.MkdirTemp("", "wte-gpg-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(home)
+
+	cmdArgs := append([]string{
+		"--homedir", home, "--batch", "--yes", "--quiet",
+		"--pinentry-mode", "loopback", "--passphrase-fd", "3",
+	}, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, cmdArgs...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.ExtraFiles = []*os.File{reader}
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// checkCredentialsDir checks that the credentials file can be created in
+// dir, so a bad credentials.path is reported before anything is written
+func checkCredentialsDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("credentials directory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("credentials directory %s is not a directory", dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".wte-write-test-")
+	if err != nil {
+		return fmt.Errorf("credentials directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// removeCredentialsFile removes a credentials file, if it exists
+func removeCredentialsFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove credentials file: %w", err)
+	}
+	return nil
+}
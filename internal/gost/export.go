@@ -0,0 +1,301 @@
+package gost
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"wte/internal/config"
+)
+
+// Export formats
+const (
+	ExportFormatClash     = "clash"
+	ExportFormatClashMeta = "clash-meta"
+	ExportFormatBase64Sub = "base64-sub"
+)
+
+// ExportFormats lists the supported export formats
+var ExportFormats = []string{ExportFormatClash, ExportFormatClashMeta, ExportFormatBase64Sub}
+
+// clashProxy is a single entry of a Clash "proxies" list
+type clashProxy struct {
+	Name           string                 `yaml:"name"`
+	Type           string                 `yaml:"type"`
+	Server         string                 `yaml:"server"`
+	Port           int                    `yaml:"port"`
+	Cipher         string                 `yaml:"cipher,omitempty"`
+	Username       string                 `yaml:"username,omitempty"`
+	Password       string                 `yaml:"password,omitempty"`
+	UDP            bool                   `yaml:"udp,omitempty"`
+	TLS            bool                   `yaml:"tls,omitempty"`
+	SkipCertVerify bool                   `yaml:"skip-cert-verify,omitempty"`
+	Plugin         string                 `yaml:"plugin,omitempty"`
+	PluginOpts     map[string]interface{} `yaml:"plugin-opts,omitempty"`
+}
+
+// clashProxyGroup is a single entry of a Clash "proxy-groups" list
+type clashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+// clashConfig is the subset of a Clash configuration WTE exports
+type clashConfig struct {
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+}
+
+// ImportURI is a client import link for a single service. URI is empty for
+// services without a standard URI scheme.
+type ImportURI struct {
+	Service string
+	URI     string
+}
+
+// Exporter renders client configurations from the WTE configuration
+type Exporter struct {
+	cfg      *config.Config
+	serverIP string
+}
+
+// NewExporter creates a new Exporter
+func NewExporter(cfg *config.Config, serverIP string) *Exporter {
+	return &Exporter{
+		cfg:      cfg,
+		serverIP: serverIP,
+	}
+}
+
+// Export renders the configuration in the given format
+func (e *Exporter) Export(format string) ([]byte, error) {
+	switch format {
+	case ExportFormatClash:
+		return e.exportClash(false)
+	case ExportFormatClashMeta:
+		return e.exportClash(true)
+	case ExportFormatBase64Sub:
+		return e.exportSubscription()
+	default:
+		return nil, fmt.Errorf("unknown export format %q (supported: %s)",
+			format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// exportClash renders a Clash configuration. Clash.Meta additionally
+// supports Shadowsocks 2022 ciphers.
+func (e *Exporter) exportClash(meta bool) ([]byte, error) {
+	proxies, err := e.clashProxies(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no enabled services to export")
+	}
+
+	names := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		names = append(names, proxy.Name)
+	}
+
+	doc := clashConfig{
+		Proxies: proxies,
+		ProxyGroups: []clashProxyGroup{
+			{Name: e.cfg.ServerName(), Type: "select", Proxies: names},
+		},
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal clash config: %w", err)
+	}
+	data := buf.Bytes()
+
+	// Make sure what we hand to clients parses back
+	var check clashConfig
+	if err := yaml.Unmarshal(data, &check); err != nil {
+		return nil, fmt.Errorf("generated clash config is not valid YAML: %w", err)
+	}
+
+	return data, nil
+}
+
+// exportSubscription renders a v2rayN-style subscription: the import URIs
+// of the enabled services, one per line, base64-encoded as a whole
+func (e *Exporter) exportSubscription() ([]byte, error) {
+	var lines []string
+	for _, uri := range e.ImportURIs() {
+		if uri.URI != "" {
+			lines = append(lines, uri.URI)
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no enabled services to export")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\n")))
+	return []byte(encoded + "\n"), nil
+}
+
+// clashProxies builds the Clash proxy entries for all enabled services
+func (e *Exporter) clashProxies(meta bool) ([]clashProxy, error) {
+	var proxies []clashProxy
+
+	if e.cfg.HTTP.Enabled {
+		proxy := clashProxy{
+			Name:   e.cfg.ServerName() + " HTTP",
+			Type:   "http",
+			Server: e.serverIP,
+			Port:   e.cfg.HTTP.Port,
+		}
+		if e.cfg.HTTP.Auth.Enabled {
+			proxy.Username = e.cfg.HTTP.Auth.Username
+			proxy.Password = e.cfg.HTTP.Auth.Password
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	if e.cfg.HTTPS.Enabled {
+		auth := e.cfg.HTTPS.Auth
+		if auth.Password == "" {
+			auth = e.cfg.HTTP.Auth
+		}
+
+		proxy := clashProxy{
+			Name:           e.cfg.ServerName() + " HTTPS",
+			Type:           "http",
+			Server:         HTTPSHost(e.cfg, e.serverIP),
+			Port:           e.cfg.HTTPS.Port,
+			TLS:            true,
+			SkipCertVerify: !CertificateTrusted(e.cfg),
+		}
+		if auth.Enabled {
+			proxy.Username = auth.Username
+			proxy.Password = auth.Password
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	if e.cfg.Shadowsocks.Enabled {
+		method := e.cfg.Shadowsocks.Method
+		if strings.HasPrefix(method, "2022-") && !meta {
+			return nil, fmt.Errorf("cipher %s is not supported by Clash, use --format %s",
+				method, ExportFormatClashMeta)
+		}
+
+		for i, port := range e.cfg.Shadowsocks.AllPorts() {
+			name := e.cfg.ServerName() + " SS"
+			if i > 0 {
+				name = fmt.Sprintf("%s :%d", name, port)
+			}
+			plugin, pluginOpts := e.clashPlugin()
+			proxies = append(proxies, clashProxy{
+				Name:       name,
+				Type:       "ss",
+				Server:     e.serverIP,
+				Port:       port,
+				Cipher:     method,
+				Password:   e.cfg.Shadowsocks.Password,
+				UDP:        e.cfg.Shadowsocks.UDP,
+				Plugin:     plugin,
+				PluginOpts: pluginOpts,
+			})
+		}
+	}
+
+	return proxies, nil
+}
+
+// clashPlugin returns the Clash plugin and plugin-opts of the Shadowsocks
+// plugin, or "" if there is none
+func (e *Exporter) clashPlugin() (string, map[string]interface{}) {
+	plugin := e.cfg.Shadowsocks.Plugin
+	options := plugin.Options()
+
+	switch plugin.Name {
+	case config.ShadowsocksPluginV2Ray:
+		opts := map[string]interface{}{"mode": "websocket", "mux": false}
+		if _, ok := options["tls"]; ok {
+			opts["tls"] = true
+			opts["skip-cert-verify"] = !CertificateTrusted(e.cfg)
+		}
+		if host := options["host"]; host != "" {
+			opts["host"] = host
+		}
+		if path := options["path"]; path != "" {
+			opts["path"] = path
+		}
+		return "v2ray-plugin", opts
+	case config.ShadowsocksPluginObfs:
+		mode := options["obfs"]
+		if mode == "" {
+			mode = "http"
+		}
+		opts := map[string]interface{}{"mode": mode}
+		if host := options["obfs-host"]; host != "" {
+			opts["host"] = host
+		}
+		return "obfs", opts
+	}
+
+	return "", nil
+}
+
+// ImportURIs returns the import links of all enabled services
+func (e *Exporter) ImportURIs() []ImportURI {
+	var uris []ImportURI
+
+	if e.cfg.HTTP.Enabled {
+		uris = append(uris, ImportURI{
+			Service: "HTTP Proxy",
+			URI:     proxyURL("http", e.cfg.HTTP.Auth, e.serverIP, e.cfg.HTTP.Port),
+		})
+	}
+
+	if e.cfg.HTTPS.Enabled {
+		auth := e.cfg.HTTPS.Auth
+		if auth.Password == "" {
+			auth = e.cfg.HTTP.Auth
+		}
+		uris = append(uris, ImportURI{
+			Service: "HTTPS Proxy",
+			URI:     proxyURL("https", auth, HTTPSHost(e.cfg, e.serverIP), e.cfg.HTTPS.Port),
+		})
+	}
+
+	if e.cfg.Shadowsocks.Enabled {
+		configGen := NewConfigGenerator(e.cfg)
+		for i, uri := range configGen.GetShadowsocksURIs(e.serverIP) {
+			service := "Shadowsocks"
+			if i > 0 {
+				service = fmt.Sprintf("Shadowsocks :%d", e.cfg.Shadowsocks.Ports[i-1])
+			}
+			uris = append(uris, ImportURI{Service: service, URI: uri})
+		}
+	}
+
+	return uris
+}
+
+// proxyURL builds a proxy URL with escaped credentials
+func proxyURL(scheme string, auth config.AuthConfig, host string, port int) string {
+	u := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	if auth.Enabled {
+		u.User = url.UserPassword(auth.Username, auth.Password)
+	}
+	return u.String()
+}
@@ -3,6 +3,9 @@ package gost
 import (
 	"archive/tar"
 	"compress/gzip"
+	"debug/elf"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,8 +13,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"wte/internal/config"
+	"wte/internal/httputil"
+	"wte/internal/security"
+	"wte/internal/state"
 	"wte/internal/system"
 	"wte/internal/ui"
 )
@@ -19,12 +26,52 @@ import (
 const (
 	// GOSTGitHubURL is the base URL for GOST releases
 	GOSTGitHubURL = "https://github.com/go-gost/gost/releases/download"
+
+	// GOSTReleasesAPI is the GitHub API URL for GOST releases
+	GOSTReleasesAPI = "https://api.github.com/repos/go-gost/gost/releases"
+
+	// latestVersionCacheTTL is how long a resolved latest version is reused
+	latestVersionCacheTTL = 10 * time.Minute
+
+	// installerUserAgent identifies GOST downloads and release lookups
+	installerUserAgent = "wte-installer"
+
+	// DefaultDownloadTimeout bounds a GOST download, so a stalled
+	// connection fails instead of hanging the install
+	DefaultDownloadTimeout = 10 * time.Minute
 )
 
+// ErrChecksumsUnavailable is returned by Install when the checksums of a
+// downloaded release can't be fetched and SetSkipChecksum was not set
+var ErrChecksumsUnavailable = errors.New("GOST checksums unavailable")
+
+// Release represents a GOST release on GitHub
+type Release struct {
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
 // Installer handles GOST installation
 type Installer struct {
-	cfg    *config.Config
-	osInfo *system.OSInfo
+	cfg               *config.Config
+	osInfo            *system.OSInfo
+	includePrerelease bool
+
+	// client downloads the release archives
+	client *http.Client
+
+	// localArchive and localBinary replace the download for air-gapped
+	// servers
+	localArchive string
+	localBinary  string
+
+	// skipChecksum installs a download whose checksums can't be fetched
+	skipChecksum bool
+
+	// noStateWrites keeps GetLatestVersion from caching in the state file
+	noStateWrites bool
 }
 
 // NewInstaller creates a new Installer
@@ -32,21 +79,24 @@ func NewInstaller(cfg *config.Config, osInfo *system.OSInfo) *Installer {
 	return &Installer{
 		cfg:    cfg,
 		osInfo: osInfo,
+		client: httputil.Client(httputil.Options{
+			Timeout:   DefaultDownloadTimeout,
+			UserAgent: installerUserAgent,
+		}),
 	}
 }
 
-// Install downloads and installs GOST
-func (i *Installer) Install() error {
-	version := i.cfg.GOST.Version
-	arch := i.osInfo.GOSTArch
-
-	ui.Action("Downloading GOST v%s for %s...", version, arch)
-
-	// Construct download URL
-	downloadURL := fmt.Sprintf("%s/v%s/gost_%s_linux_%s.tar.gz",
-		GOSTGitHubURL, version, version, arch)
+// SetHTTPClient replaces the client that downloads the release archives
+func (i *Installer) SetHTTPClient(client *http.Client) {
+	i.client = client
+}
 
-	ui.Detail("URL: %s", downloadURL)
+// Install downloads and installs GOST, or installs it from the local
+// archive or binary set with SetLocalArchive or SetLocalBinary
+func (i *Installer) Install() error {
+	if i.localBinary != "" {
+		return i.installLocalBinary()
+	}
 
 	// Create temp directory
 	tempDir, err := os.MkdirTemp("", "gost_install_")
@@ -55,14 +105,38 @@ func (i *Installer) Install() error {
 	}
 	defer os.RemoveAll(tempDir)
 
-	archivePath := filepath.Join(tempDir, "gost.tar.gz")
+	var archivePath string
+	if i.localArchive != "" {
+		archivePath = i.localArchive
+		ui.Action("Using local archive %s...", archivePath)
 
-	// Download archive
-	if err := i.downloadFile(archivePath, downloadURL); err != nil {
-		return fmt.Errorf("failed to download GOST: %w", err)
-	}
+		if err := i.verifyLocalArchive(archivePath); err != nil {
+			return err
+		}
+	} else {
+		version := i.cfg.GOST.Version
+		arch := i.osInfo.GOSTArch
+
+		ui.Action("Downloading GOST v%s for %s...", version, arch)
+
+		archiveName := i.archiveName()
+		downloadURL := i.DownloadURL()
+
+		ui.Detail("URL: %s", downloadURL)
 
-	ui.Success("Download completed")
+		archivePath = filepath.Join(tempDir, "gost.tar.gz")
+
+		// Download archive
+		if err := i.downloadFile(archivePath, downloadURL); err != nil {
+			return fmt.Errorf("failed to download GOST: %w", err)
+		}
+
+		ui.Success("Download completed")
+
+		if err := i.verifyArchive(archivePath, archiveName, version); err != nil {
+			return err
+		}
+	}
 
 	// Extract archive
 	ui.Action("Extracting archive...")
@@ -78,7 +152,58 @@ func (i *Installer) Install() error {
 		return fmt.Errorf("gost binary not found in archive")
 	}
 
-	// Install binary
+	if err := i.checkBinaryArch(gostBinary); err != nil {
+		return err
+	}
+
+	return i.installBinary(gostBinary)
+}
+
+// SetLocalArchive makes Install use a release archive on disk instead of
+// downloading one
+func (i *Installer) SetLocalArchive(path string) {
+	i.localArchive = path
+}
+
+// SetLocalBinary makes Install copy a GOST binary on disk instead of
+// downloading a release
+func (i *Installer) SetLocalBinary(path string) {
+	i.localBinary = path
+}
+
+// Source returns where Install takes GOST from: a local file or the
+// download URL
+func (i *Installer) Source() string {
+	switch {
+	case i.localBinary != "":
+		return i.localBinary
+	case i.localArchive != "":
+		return i.localArchive
+	}
+	return i.DownloadURL()
+}
+
+// installLocalBinary installs the binary set with SetLocalBinary after
+// checking that it runs on this server
+func (i *Installer) installLocalBinary() error {
+	ui.Action("Using local binary %s...", i.localBinary)
+
+	if err := i.checkBinaryArch(i.localBinary); err != nil {
+		return err
+	}
+
+	version, err := binaryVersion(i.localBinary)
+	if err != nil {
+		return fmt.Errorf("%s does not run: %w", i.localBinary, err)
+	}
+	ui.Detail("Version: %s", version)
+
+	return i.installBinary(i.localBinary)
+}
+
+// installBinary copies a GOST binary to the configured path and checks
+// that it runs
+func (i *Installer) installBinary(gostBinary string) error {
 	ui.Action("Installing GOST binary to %s...", i.cfg.GOST.BinaryPath)
 
 	// Ensure target directory exists
@@ -101,7 +226,7 @@ func (i *Installer) Install() error {
 
 	// Verify installation
 	ui.Action("Verifying installation...")
-	version, err = i.GetVersion()
+	version, err := i.GetVersion()
 	if err != nil {
 		return fmt.Errorf("failed to verify installation: %w", err)
 	}
@@ -112,14 +237,96 @@ func (i *Installer) Install() error {
 	return nil
 }
 
+// elfMachines maps GOST architecture names to the ELF machine type of their
+// binaries
+var elfMachines = map[string]elf.Machine{
+	"amd64":              elf.EM_X86_64,
+	"386":                elf.EM_386,
+	"arm64":              elf.EM_AARCH64,
+	"armv5":              elf.EM_ARM,
+	"armv6":              elf.EM_ARM,
+	"armv7":              elf.EM_ARM,
+	"mips_hardfloat":     elf.EM_MIPS,
+	"mips_softfloat":     elf.EM_MIPS,
+	"mipsle_hardfloat":   elf.EM_MIPS,
+	"mipsle_softfloat":   elf.EM_MIPS,
+	"mips64_hardfloat":   elf.EM_MIPS,
+	"mips64_softfloat":   elf.EM_MIPS,
+	"mips64le_hardfloat": elf.EM_MIPS,
+	"mips64le_softfloat": elf.EM_MIPS,
+	"riscv64":            elf.EM_RISCV,
+	"s390x":              elf.EM_S390,
+}
+
+// checkBinaryArch checks that path is a Linux binary for the architecture
+// of this server
+func (i *Installer) checkBinaryArch(path string) error {
+	file, err := elf.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s is not a Linux binary", path)
+	}
+	defer file.Close()
+
+	want, ok := elfMachines[i.osInfo.GOSTArch]
+	if !ok || file.Machine == want {
+		return nil
+	}
+
+	// Name the GOST architecture when only one has this machine type, ARM
+	// and MIPS variants are told apart by their ELF machine name only
+	built := file.Machine.String()
+	var matches []string
+	for arch, machine := range elfMachines {
+		if machine == file.Machine {
+			matches = append(matches, arch)
+		}
+	}
+	if len(matches) == 1 {
+		built = matches[0]
+	}
+	return fmt.Errorf("%s is built for %s, but this server needs %s (use the gost_<version>_linux_%s archive)",
+		path, built, i.osInfo.GOSTArch, i.osInfo.GOSTArch)
+}
+
+// archiveName returns the name of the release archive for the configured
+// version and the host architecture
+func (i *Installer) archiveName() string {
+	return fmt.Sprintf("gost_%s_linux_%s.tar.gz", i.cfg.GOST.Version, i.osInfo.GOSTArch)
+}
+
+// DownloadURL returns the URL Install downloads GOST from
+func (i *Installer) DownloadURL() string {
+	return fmt.Sprintf("%s/v%s/%s", i.downloadBase(), i.cfg.GOST.Version, i.archiveName())
+}
+
+// downloadBase returns the base URL of the release downloads, the
+// configured mirror or GitHub
+func (i *Installer) downloadBase() string {
+	if mirror := strings.TrimRight(i.cfg.GOST.DownloadMirror, "/"); mirror != "" {
+		return mirror
+	}
+	return GOSTGitHubURL
+}
+
+// mirrorNotFound explains a 404 from the download mirror, which most
+// likely doesn't follow the GitHub layout
+func (i *Installer) mirrorNotFound(url string) error {
+	return fmt.Errorf("mirror returned 404 for %s: gost.download_mirror must serve the GitHub release layout "+
+		"<mirror>/v<version>/<file>, e.g. %s/v%s/%s", url, i.downloadBase(), i.cfg.GOST.Version, i.archiveName())
+}
+
 // downloadFile downloads a file with progress
 func (i *Installer) downloadFile(filepath string, url string) error {
-	resp, err := http.Get(url)
+	resp, err := i.client.Get(url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound && i.cfg.GOST.DownloadMirror != "" {
+		return i.mirrorNotFound(url)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("HTTP error: %s", resp.Status)
 	}
@@ -139,6 +346,88 @@ func (i *Installer) downloadFile(filepath string, url string) error {
 	return err
 }
 
+// verifyArchive checks the downloaded archive against the checksums.txt of
+// the release. The checksums always come from GitHub, so a mirror can't
+// serve a tampered archive together with matching checksums. Without them
+// the install fails unless SetSkipChecksum allowed it.
+func (i *Installer) verifyArchive(archivePath, archiveName, version string) error {
+	ui.Action("Verifying checksum...")
+
+	data, err := fetchChecksums(version)
+	if err != nil {
+		if !i.skipChecksum {
+			return fmt.Errorf("%w: %v", ErrChecksumsUnavailable, err)
+		}
+		ui.Warning("%v, skipping checksum verification", err)
+		return nil
+	}
+
+	return verifyChecksums(archivePath, archiveName, version, data)
+}
+
+// fetchChecksums downloads the checksums.txt of a GOST release from GitHub
+func fetchChecksums(version string) ([]byte, error) {
+	url := fmt.Sprintf("%s/v%s/%s", GOSTGitHubURL, version, security.ChecksumsFile)
+
+	client := httputil.Client(httputil.Options{
+		Timeout:   30 * time.Second,
+		UserAgent: installerUserAgent,
+	})
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download GOST checksums from GitHub: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("GOST v%s publishes no %s", version, security.ChecksumsFile)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download GOST checksums from GitHub: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GOST checksums: %w", err)
+	}
+
+	return data, nil
+}
+
+// verifyLocalArchive checks a local archive against a checksums.txt next
+// to it. Archives without one are accepted with a warning.
+func (i *Installer) verifyLocalArchive(archivePath string) error {
+	ui.Action("Verifying checksum...")
+
+	checksumsPath := filepath.Join(filepath.Dir(archivePath), security.ChecksumsFile)
+	data, err := os.ReadFile(checksumsPath)
+	if os.IsNotExist(err) {
+		ui.Warning("No %s next to %s, skipping checksum verification", security.ChecksumsFile, archivePath)
+		ui.Detail("Copy %s from the GOST release to verify the archive", security.ChecksumsFile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read GOST checksums: %w", err)
+	}
+
+	return verifyChecksums(archivePath, filepath.Base(archivePath), i.cfg.GOST.Version, data)
+}
+
+// verifyChecksums checks an archive against its entry in a checksums.txt
+func verifyChecksums(archivePath, archiveName, version string, data []byte) error {
+	expected, ok := security.ParseChecksums(data)[archiveName]
+	if !ok {
+		return fmt.Errorf("%s of GOST v%s does not list %s", security.ChecksumsFile, version, archiveName)
+	}
+
+	if err := security.VerifyChecksum(archivePath, expected); err != nil {
+		return fmt.Errorf("GOST download is corrupt or was tampered with: %w", err)
+	}
+
+	ui.Success("Checksum verified")
+	return nil
+}
+
 // extractTarGz extracts a tar.gz archive
 func (i *Installer) extractTarGz(archive, dest string) error {
 	file, err := os.Open(archive)
@@ -216,8 +505,12 @@ func (i *Installer) GetVersion() (string, error) {
 		return "", fmt.Errorf("GOST is not installed")
 	}
 
-	cmd := exec.Command(i.cfg.GOST.BinaryPath, "-V")
-	output, err := cmd.Output()
+	return binaryVersion(i.cfg.GOST.BinaryPath)
+}
+
+// binaryVersion runs a GOST binary with -V and returns its output
+func binaryVersion(path string) (string, error) {
+	output, err := exec.Command(path, "-V").Output()
 	if err != nil {
 		return "", err
 	}
@@ -225,6 +518,27 @@ func (i *Installer) GetVersion() (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+// GetInstalledVersion returns the bare version number of the installed GOST
+// binary (e.g. "3.0.0-rc10"), parsed from the output of GetVersion
+func (i *Installer) GetInstalledVersion() (string, error) {
+	output, err := i.GetVersion()
+	if err != nil {
+		return "", err
+	}
+	return parseVersionOutput(output), nil
+}
+
+// parseVersionOutput extracts the version from "gost v3.0.0 (go1.21 linux/amd64)"
+func parseVersionOutput(output string) string {
+	for _, field := range strings.Fields(output) {
+		field = strings.TrimPrefix(field, "v")
+		if len(field) > 0 && field[0] >= '0' && field[0] <= '9' {
+			return field
+		}
+	}
+	return strings.TrimSpace(output)
+}
+
 // IsInstalled checks if GOST is installed
 func (i *Installer) IsInstalled() bool {
 	return system.FileExists(i.cfg.GOST.BinaryPath)
@@ -243,11 +557,135 @@ func (i *Installer) Uninstall() error {
 	return nil
 }
 
-// GetLatestVersion fetches the latest GOST version from GitHub
+// SetSkipChecksum allows installing a downloaded release when its
+// checksums.txt can't be fetched from GitHub
+func (i *Installer) SetSkipChecksum(skip bool) {
+	i.skipChecksum = skip
+}
+
+// SetNoStateWrites makes GetLatestVersion use a cached version but not
+// cache a new one, for dry runs
+func (i *Installer) SetNoStateWrites(noWrites bool) {
+	i.noStateWrites = noWrites
+}
+
+// SetIncludePrerelease controls whether prereleases are considered when
+// resolving the latest version
+func (i *Installer) SetIncludePrerelease(include bool) {
+	i.includePrerelease = include
+}
+
+// GetLatestVersion fetches the latest GOST version from GitHub. The result
+// is kept in the state file for latestVersionCacheTTL, so repeated
+// commands do not run into the API rate limit.
 func (i *Installer) GetLatestVersion() (string, error) {
-	// This would require GitHub API call
-	// For now, return the configured version
-	return i.cfg.GOST.Version, nil
+	if s, err := state.Load(); err == nil {
+		if cached := i.cachedLatestVersion(s); cached != nil && time.Since(cached.CheckedAt) < latestVersionCacheTTL {
+			return cached.Version, nil
+		}
+	}
+
+	var release *Release
+	var err error
+
+	if i.includePrerelease {
+		release, err = i.fetchNewestRelease()
+	} else {
+		release, err = i.fetchRelease(GOSTReleasesAPI + "/latest")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	version := strings.TrimPrefix(release.TagName, "v")
+
+	if i.noStateWrites {
+		return version, nil
+	}
+
+	// Only root can write the state file, the lookup itself still counts
+	check := &state.VersionCheck{Version: version, CheckedAt: time.Now()}
+	_ = state.Update(func(s *state.State) {
+		if i.includePrerelease {
+			s.LatestGOSTPrerelease = check
+		} else {
+			s.LatestGOST = check
+		}
+	})
+
+	return version, nil
+}
+
+// cachedLatestVersion returns the cached latest version matching the
+// prerelease mode, if any
+func (i *Installer) cachedLatestVersion(s *state.State) *state.VersionCheck {
+	if i.includePrerelease {
+		return s.LatestGOSTPrerelease
+	}
+	return s.LatestGOST
+}
+
+// fetchNewestRelease returns the newest non-draft release, including prereleases
+func (i *Installer) fetchNewestRelease() (*Release, error) {
+	var releases []Release
+	if err := i.getJSON(GOSTReleasesAPI+"?per_page=20", &releases); err != nil {
+		return nil, err
+	}
+
+	for _, release := range releases {
+		if !release.Draft {
+			return &release, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no GOST releases found")
+}
+
+// fetchRelease fetches a single release from the GitHub API
+func (i *Installer) fetchRelease(url string) (*Release, error) {
+	var release Release
+	if err := i.getJSON(url, &release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// getJSON performs a GitHub API request and decodes the JSON response
+func (i *Installer) getJSON(url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	httputil.SetGitHubHeaders(req)
+
+	client := httputil.Client(httputil.Options{
+		Timeout:   30 * time.Second,
+		UserAgent: installerUserAgent,
+	})
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch GOST releases: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("no GOST releases found")
+	}
+
+	if httputil.IsGitHubRateLimited(resp) {
+		return httputil.GitHubRateLimitError(resp)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API error: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse GOST release: %w", err)
+	}
+
+	return nil
 }
 
 // NeedsUpdate checks if GOST needs to be updated
@@ -256,7 +694,7 @@ func (i *Installer) NeedsUpdate() (bool, string, error) {
 		return true, i.cfg.GOST.Version, nil
 	}
 
-	currentVersion, err := i.GetVersion()
+	currentVersion, err := i.GetInstalledVersion()
 	if err != nil {
 		return false, "", err
 	}
@@ -0,0 +1,265 @@
+package gost
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"wte/internal/config"
+)
+
+// LockoutFileName is the admission file GOST reads blocked clients from
+const LockoutFileName = "lockout.txt"
+
+// authFailurePattern matches GOST log lines reporting failed authentication.
+// A 407 status only counts next to a status field or its reason phrase, so
+// ports, byte counts and durations containing those digits don't match.
+var authFailurePattern = regexp.MustCompile(`(?i)auth\w*\s*(failed|failure|required|invalid|denied)|unauthorized|proxy authentication required|\b(status|code)"?\s*[:=]\s*"?407\b|\b407\s+proxy`)
+
+// remoteAddrPattern extracts a client address from an unstructured log line
+var remoteAddrPattern = regexp.MustCompile(`(\d{1,3}(?:\.\d{1,3}){3}|\[[0-9a-fA-F:]+\]):\d+`)
+
+// LockoutSettings holds parsed auth lockout settings
+type LockoutSettings struct {
+	MaxAttempts int
+	Window      time.Duration
+	BanDuration time.Duration
+}
+
+// ParseLockoutSettings validates and parses the auth lockout configuration
+func ParseLockoutSettings(cfg config.AuthLockoutConfig) (*LockoutSettings, error) {
+	if cfg.MaxAttempts < 1 {
+		return nil, fmt.Errorf("security.auth_lockout.max_attempts must be at least 1")
+	}
+
+	window, err := time.ParseDuration(cfg.Window)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid security.auth_lockout.window: %q", cfg.Window)
+	}
+
+	banDuration, err := time.ParseDuration(cfg.BanDuration)
+	if err != nil || banDuration <= 0 {
+		return nil, fmt.Errorf("invalid security.auth_lockout.ban_duration: %q", cfg.BanDuration)
+	}
+
+	return &LockoutSettings{
+		MaxAttempts: cfg.MaxAttempts,
+		Window:      window,
+		BanDuration: banDuration,
+	}, nil
+}
+
+// LockoutFilePath returns the path of the admission file for blocked clients
+func LockoutFilePath(cfg *config.Config) string {
+	return filepath.Join(cfg.GOST.ConfigDir, LockoutFileName)
+}
+
+// EnsureLockoutFile creates an empty admission file if it doesn't exist
+func EnsureLockoutFile(cfg *config.Config) error {
+	path := LockoutFilePath(cfg)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create lockout directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, []byte("# Managed by WTE - clients blocked after failed authentication\n"), 0644); err != nil {
+		return fmt.Errorf("failed to create lockout file: %w", err)
+	}
+
+	return nil
+}
+
+// Ban represents a blocked client
+type Ban struct {
+	IP    string
+	Until time.Time
+}
+
+// Lockout tracks failed authentication attempts and resulting bans
+type Lockout struct {
+	settings *LockoutSettings
+	path     string
+	failures map[string][]time.Time
+	bans     map[string]time.Time
+}
+
+// NewLockout creates a new Lockout that maintains the admission file at path
+func NewLockout(settings *LockoutSettings, path string) *Lockout {
+	return &Lockout{
+		settings: settings,
+		path:     path,
+		failures: make(map[string][]time.Time),
+		bans:     make(map[string]time.Time),
+	}
+}
+
+// Load restores active bans from the admission file
+func (l *Lockout) Load() error {
+	bans, err := ReadBans(l.path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, ban := range bans {
+		if ban.Until.After(now) {
+			l.bans[ban.IP] = ban.Until
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure records a failed authentication attempt and reports whether
+// it caused the client to be banned
+func (l *Lockout) RecordFailure(ip string, at time.Time) bool {
+	if _, banned := l.bans[ip]; banned {
+		return false
+	}
+
+	cutoff := at.Add(-l.settings.Window)
+	var recent []time.Time
+	for _, t := range l.failures[ip] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, at)
+
+	if len(recent) < l.settings.MaxAttempts {
+		l.failures[ip] = recent
+		return false
+	}
+
+	delete(l.failures, ip)
+	l.bans[ip] = at.Add(l.settings.BanDuration)
+	return true
+}
+
+// Expire lifts bans that have run out and returns the released clients
+func (l *Lockout) Expire(now time.Time) []string {
+	var released []string
+	for ip, until := range l.bans {
+		if !until.After(now) {
+			delete(l.bans, ip)
+			released = append(released, ip)
+		}
+	}
+	sort.Strings(released)
+	return released
+}
+
+// Bans returns the currently active bans sorted by IP
+func (l *Lockout) Bans() []Ban {
+	bans := make([]Ban, 0, len(l.bans))
+	for ip, until := range l.bans {
+		bans = append(bans, Ban{IP: ip, Until: until})
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
+
+// Save writes the active bans to the admission file
+func (l *Lockout) Save() error {
+	return WriteBans(l.path, l.Bans())
+}
+
+// ReadBans reads bans from an admission file. Each line holds an IP with its
+// expiry in a trailing comment, which GOST ignores.
+func ReadBans(path string) ([]Ban, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open lockout file: %w", err)
+	}
+	defer file.Close()
+
+	var bans []Ban
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ban := Ban{}
+		parts := strings.SplitN(line, "#", 2)
+		ban.IP = strings.TrimSpace(parts[0])
+		if len(parts) == 2 {
+			until := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(parts[1]), "until"))
+			ban.Until, _ = time.Parse(time.RFC3339, until)
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, scanner.Err()
+}
+
+// WriteBans writes bans to an admission file
+func WriteBans(path string, bans []Ban) error {
+	var b strings.Builder
+	b.WriteString("# Managed by WTE - clients blocked after failed authentication\n")
+	for _, ban := range bans {
+		fmt.Fprintf(&b, "%s # until %s\n", ban.IP, ban.Until.UTC().Format(time.RFC3339))
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write lockout file: %w", err)
+	}
+
+	return nil
+}
+
+// ParseAuthFailure extracts the client IP from a GOST log line reporting a
+// failed authentication. GOST logs JSON by default; plain text lines are
+// matched on the first address found.
+func ParseAuthFailure(line string) (string, bool) {
+	if !authFailurePattern.MatchString(line) {
+		return "", false
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err == nil {
+		for _, field := range []string{"remote", "client", "src"} {
+			if addr, ok := entry[field].(string); ok {
+				if ip := hostIP(addr); ip != "" {
+					return ip, true
+				}
+			}
+		}
+	}
+
+	if match := remoteAddrPattern.FindStringSubmatch(line); match != nil {
+		if ip := hostIP(match[0]); ip != "" {
+			return ip, true
+		}
+	}
+
+	return "", false
+}
+
+// hostIP returns the IP part of a host:port address
+func hostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
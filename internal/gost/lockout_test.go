@@ -0,0 +1,58 @@
+package gost
+
+import "testing"
+
+func TestParseAuthFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		wantIP string
+		wantOK bool
+	}{
+		{
+			name:   "json auth failed",
+			line:   `{"level":"warn","msg":"auth failed","remote":"203.0.113.5:51234"}`,
+			wantIP: "203.0.113.5",
+			wantOK: true,
+		},
+		{
+			name:   "json status 407",
+			line:   `{"level":"info","status":407,"remote":"198.51.100.7:40000"}`,
+			wantIP: "198.51.100.7",
+			wantOK: true,
+		},
+		{
+			name:   "text reason phrase",
+			line:   `2024/01/01 HTTP/1.1 407 Proxy Authentication Required 192.0.2.1:3000`,
+			wantIP: "192.0.2.1",
+			wantOK: true,
+		},
+		{
+			name:   "ipv6 client",
+			line:   `unauthorized request from [2001:db8::1]:8080`,
+			wantIP: "2001:db8::1",
+			wantOK: true,
+		},
+		{
+			name: "port containing 407",
+			line: `{"level":"info","msg":"connected","remote":"203.0.113.5:14070"}`,
+		},
+		{
+			name: "byte count containing 407",
+			line: `{"level":"info","msg":"done","remote":"203.0.113.5:5000","bytes":24071}`,
+		},
+		{
+			name: "status 200",
+			line: `{"level":"info","status":200,"remote":"203.0.113.5:5000"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ok := ParseAuthFailure(tt.line)
+			if ok != tt.wantOK || ip != tt.wantIP {
+				t.Errorf("ParseAuthFailure(%q) = %q, %v; want %q, %v", tt.line, ip, ok, tt.wantIP, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package gost
+
+import (
+	"fmt"
+	"time"
+
+	"wte/internal/config"
+)
+
+// WatchdogSettings holds parsed watchdog settings
+type WatchdogSettings struct {
+	Interval time.Duration
+	Failures int
+	Cooldown time.Duration
+}
+
+// ParseWatchdogSettings validates and parses the watchdog configuration
+func ParseWatchdogSettings(cfg config.WatchdogConfig) (*WatchdogSettings, error) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid watchdog.interval: %q", cfg.Interval)
+	}
+
+	if cfg.Failures < 1 {
+		return nil, fmt.Errorf("watchdog.failures must be at least 1")
+	}
+
+	cooldown, err := time.ParseDuration(cfg.Cooldown)
+	if err != nil || cooldown < 0 {
+		return nil, fmt.Errorf("invalid watchdog.cooldown: %q", cfg.Cooldown)
+	}
+
+	return &WatchdogSettings{
+		Interval: interval,
+		Failures: cfg.Failures,
+		Cooldown: cooldown,
+	}, nil
+}
+
+// Watchdog counts consecutive failed health checks and decides when the
+// service is restarted. Restarts are at least Cooldown apart, so a service
+// that does not recover is not restarted in a loop.
+type Watchdog struct {
+	settings    *WatchdogSettings
+	failures    int
+	lastRestart time.Time
+}
+
+// NewWatchdog creates a new Watchdog
+func NewWatchdog(settings *WatchdogSettings) *Watchdog {
+	return &Watchdog{settings: settings}
+}
+
+// RecordCheck records the outcome of a health check made at now and
+// reports whether the service should be restarted
+func (w *Watchdog) RecordCheck(healthy bool, now time.Time) bool {
+	if healthy {
+		w.failures = 0
+		return false
+	}
+
+	w.failures++
+	if w.failures < w.settings.Failures || w.CoolingDown(now) {
+		return false
+	}
+
+	w.failures = 0
+	w.lastRestart = now
+	return true
+}
+
+// Reset forgets the failed checks counted so far
+func (w *Watchdog) Reset() {
+	w.failures = 0
+}
+
+// Failures returns the number of consecutive failed checks
+func (w *Watchdog) Failures() int {
+	return w.failures
+}
+
+// CoolingDown reports whether the last restart is too recent for another
+func (w *Watchdog) CoolingDown(now time.Time) bool {
+	return !w.lastRestart.IsZero() && now.Sub(w.lastRestart) < w.settings.Cooldown
+}
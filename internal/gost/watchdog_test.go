@@ -0,0 +1,86 @@
+package gost
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdogRecordCheck(t *testing.T) {
+	type check struct {
+		at          time.Duration // since the first check
+		healthy     bool
+		wantRestart bool
+	}
+
+	tests := []struct {
+		name   string
+		checks []check
+	}{
+		{
+			name: "restart at the threshold",
+			checks: []check{
+				{at: 0, healthy: false},
+				{at: time.Minute, healthy: false},
+				{at: 2 * time.Minute, healthy: false, wantRestart: true},
+			},
+		},
+		{
+			name: "success resets the count",
+			checks: []check{
+				{at: 0, healthy: false},
+				{at: time.Minute, healthy: false},
+				{at: 2 * time.Minute, healthy: true},
+				{at: 3 * time.Minute, healthy: false},
+				{at: 4 * time.Minute, healthy: false},
+				{at: 5 * time.Minute, healthy: false, wantRestart: true},
+			},
+		},
+		{
+			name: "no restart during the cooldown",
+			checks: []check{
+				{at: 0, healthy: false},
+				{at: time.Minute, healthy: false},
+				{at: 2 * time.Minute, healthy: false, wantRestart: true},
+				{at: 3 * time.Minute, healthy: false},
+				{at: 4 * time.Minute, healthy: false},
+				{at: 5 * time.Minute, healthy: false},
+				{at: 6 * time.Minute, healthy: false},
+			},
+		},
+		{
+			name: "restart again after the cooldown",
+			checks: []check{
+				{at: 0, healthy: false},
+				{at: time.Minute, healthy: false},
+				{at: 2 * time.Minute, healthy: false, wantRestart: true},
+				{at: 10 * time.Minute, healthy: false},
+				{at: 11 * time.Minute, healthy: false},
+				{at: 12 * time.Minute, healthy: false, wantRestart: true},
+			},
+		},
+		{
+			name: "failures during the cooldown count once it ends",
+			checks: []check{
+				{at: 0, healthy: false},
+				{at: time.Minute, healthy: false},
+				{at: 2 * time.Minute, healthy: false, wantRestart: true},
+				{at: 9 * time.Minute, healthy: false},
+				{at: 10 * time.Minute, healthy: false},
+				{at: 11 * time.Minute, healthy: false},
+				{at: 12 * time.Minute, healthy: false, wantRestart: true},
+			},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watchdog := NewWatchdog(&WatchdogSettings{Interval: time.Minute, Failures: 3, Cooldown: 10 * time.Minute})
+			for i, c := range tt.checks {
+				if got := watchdog.RecordCheck(c.healthy, start.Add(c.at)); got != c.wantRestart {
+					t.Fatalf("check %d at %s: restart = %v, want %v", i, c.at, got, c.wantRestart)
+				}
+			}
+		})
+	}
+}
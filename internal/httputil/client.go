@@ -0,0 +1,157 @@
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"wte/internal/ui"
+)
+
+const (
+	// DefaultUserAgent is sent when neither the caller nor WTE_USER_AGENT sets one
+	DefaultUserAgent = "wte"
+
+	// UserAgentEnv overrides the User-Agent of every outbound request
+	UserAgentEnv = "WTE_USER_AGENT"
+
+	// CABundleEnv points to a PEM file with extra CA certificates to trust
+	CABundleEnv = "WTE_CA_BUNDLE"
+
+	// InsecureSkipVerifyEnv disables TLS certificate verification when true
+	InsecureSkipVerifyEnv = "WTE_INSECURE_SKIP_VERIFY"
+
+	// ProxyEnv is the proxy URL for outbound requests, overriding
+	// HTTPS_PROXY and HTTP_PROXY
+	ProxyEnv = "WTE_PROXY"
+)
+
+var (
+	insecureWarning sync.Once
+	caBundleWarning sync.Once
+	proxyWarning    sync.Once
+)
+
+// Options configures an outbound HTTP client
+type Options struct {
+	// Timeout is the overall request timeout, zero means no timeout
+	Timeout time.Duration
+
+	// UserAgent is the default User-Agent, WTE_USER_AGENT takes precedence
+	UserAgent string
+
+	// Network restricts connections to "tcp4" or "tcp6", empty allows both
+	Network string
+}
+
+// Client returns an HTTP client for outbound requests. It goes through the
+// proxy in WTE_PROXY or the standard proxy environment variables, adds the
+// CA certificates from WTE_CA_BUNDLE and skips TLS verification if
+// WTE_INSECURE_SKIP_VERIFY is set, for networks with TLS-inspecting proxies.
+func Client(opts Options) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig()
+
+	if proxy := os.Getenv(ProxyEnv); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			proxyWarning.Do(func() {
+				ui.Warning("Ignoring %s: %q is not a proxy URL", ProxyEnv, proxy)
+			})
+		} else {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	if opts.Network != "" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, opts.Network, addr)
+		}
+	}
+
+	userAgent := os.Getenv(UserAgentEnv)
+	if userAgent == "" {
+		userAgent = opts.UserAgent
+	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &userAgentTransport{
+			userAgent: userAgent,
+			base:      transport,
+		},
+	}
+}
+
+// tlsConfig builds the TLS settings from the environment
+func tlsConfig() *tls.Config {
+	config := &tls.Config{}
+
+	if insecure, _ := strconv.ParseBool(os.Getenv(InsecureSkipVerifyEnv)); insecure {
+		insecureWarning.Do(func() {
+			ui.Warning("TLS certificate verification is DISABLED (%s)", InsecureSkipVerifyEnv)
+			ui.Detail("Downloads can be tampered with by anyone on the network path")
+		})
+		config.InsecureSkipVerify = true
+		return config
+	}
+
+	if path := os.Getenv(CABundleEnv); path != "" {
+		pool, err := loadCABundle(path)
+		if err != nil {
+			caBundleWarning.Do(func() {
+				ui.Warning("Ignoring %s: %v", CABundleEnv, err)
+			})
+		} else {
+			config.RootCAs = pool
+		}
+	}
+
+	return config
+}
+
+// loadCABundle returns the system cert pool extended with the certificates in path
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// userAgentTransport sets the User-Agent on requests that don't have one
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
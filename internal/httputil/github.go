@@ -0,0 +1,48 @@
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GitHubTokenEnv holds a GitHub token for API requests, which raises the
+// rate limit for unauthenticated requests of 60 per hour
+const GitHubTokenEnv = "GITHUB_TOKEN"
+
+// SetGitHubHeaders prepares a GitHub API request, authenticating it with
+// GITHUB_TOKEN if it is set
+func SetGitHubHeaders(req *http.Request) {
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if token := os.Getenv(GitHubTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// IsGitHubRateLimited reports whether a GitHub API response is a rate-limit
+// rejection
+func IsGitHubRateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode == http.StatusForbidden &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0"
+}
+
+// GitHubRateLimitError builds an actionable error for a rate-limited response
+func GitHubRateLimitError(resp *http.Response) error {
+	hint := "set " + GitHubTokenEnv + " to raise the limit"
+	if os.Getenv(GitHubTokenEnv) != "" {
+		hint = "try again later"
+	}
+
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		resetAt := time.Unix(reset, 0)
+		return fmt.Errorf("GitHub API rate limit exceeded, resets at %s (%s)",
+			resetAt.Format("15:04:05"), hint)
+	}
+
+	return fmt.Errorf("GitHub API rate limit exceeded (%s)", hint)
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Levels lists the accepted logging levels
+var Levels = []string{"debug", "info", "warn", "error"}
+
+// Logger is the structured log of the actions WTE takes. It discards
+// everything until Setup opens a log file.
+var Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// enabled is set once Setup has opened a log file
+var enabled bool
+
+// ParseLevel parses a logging level name
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (valid: %s)", level, strings.Join(Levels, ", "))
+}
+
+// Setup makes Logger append to the file at path, dropping records below
+// level. An empty path keeps logging disabled.
+func Setup(path, level string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	Logger = slog.New(slog.NewTextHandler(file, &slog.HandlerOptions{Level: lvl}))
+	enabled = true
+
+	return nil
+}
+
+// Enabled reports whether records are written anywhere
+func Enabled() bool {
+	return enabled
+}
@@ -0,0 +1,544 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wte/internal/fsutil"
+	"wte/internal/httputil"
+)
+
+const (
+	// LetsEncryptDirectory is the Let's Encrypt production ACME directory
+	LetsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// LetsEncryptStagingDirectory issues untrusted certificates with
+	// relaxed rate limits, for testing
+	LetsEncryptStagingDirectory = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	// acmeChallengePath is where HTTP-01 challenge responses are served
+	acmeChallengePath = "/.well-known/acme-challenge/"
+)
+
+// ACMEOptions holds options for obtaining a certificate via ACME
+type ACMEOptions struct {
+	Domain         string
+	DirectoryURL   string
+	AccountKeyPath string
+	// ChallengeAddr is where the HTTP-01 challenge server listens. The CA
+	// connects to port 80 of the domain, so this must be reachable there.
+	ChallengeAddr string
+	CertPath      string
+	KeyPath       string
+	Timeout       time.Duration
+}
+
+// DefaultACMEOptions returns ACME options for a domain using Let's Encrypt
+func DefaultACMEOptions(domain string) *ACMEOptions {
+	return &ACMEOptions{
+		Domain:        domain,
+		DirectoryURL:  LetsEncryptDirectory,
+		ChallengeAddr: ":80",
+		Timeout:       2 * time.Minute,
+	}
+}
+
+// acmeDirectory is the subset of the ACME directory WTE uses
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// acmeOrder is an ACME order object
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+// acmeAuthorization is an ACME authorization object
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+// acmeChallenge is an ACME challenge object
+type acmeChallenge struct {
+	Type   string       `json:"type"`
+	URL    string       `json:"url"`
+	Token  string       `json:"token"`
+	Status string       `json:"status"`
+	Error  *acmeProblem `json:"error,omitempty"`
+}
+
+// acmeProblem is an ACME error document
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string {
+	return fmt.Sprintf("%s (%s)", p.Detail, strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:"))
+}
+
+// acmeClient talks to an ACME server on behalf of one account
+type acmeClient struct {
+	http  *http.Client
+	dir   acmeDirectory
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+}
+
+// ObtainACMECertificate obtains a certificate for opts.Domain from an ACME
+// CA using the HTTP-01 challenge and writes it to opts.CertPath and
+// opts.KeyPath. Nothing is written unless issuance succeeds.
+func ObtainACMECertificate(opts *ACMEOptions) error {
+	if opts.DirectoryURL == "" {
+		opts.DirectoryURL = LetsEncryptDirectory
+	}
+
+	accountKey, err := loadOrCreateAccountKey(opts.AccountKeyPath)
+	if err != nil {
+		return err
+	}
+
+	client := &acmeClient{
+		http: httputil.Client(httputil.Options{Timeout: 30 * time.Second}),
+		key:  accountKey,
+	}
+
+	if err := client.getJSON(opts.DirectoryURL, &client.dir); err != nil {
+		return fmt.Errorf("failed to fetch ACME directory: %w", err)
+	}
+
+	if err := client.register(); err != nil {
+		return fmt.Errorf("failed to register ACME account: %w", err)
+	}
+
+	orderURL, order, err := client.newOrder(opts.Domain)
+	if err != nil {
+		return fmt.Errorf("failed to create order: %w", err)
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+
+	for _, authzURL := range order.Authorizations {
+		if err := client.authorize(authzURL, opts.ChallengeAddr, deadline); err != nil {
+			return fmt.Errorf("domain validation failed: %w", err)
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: opts.Domain},
+		DNSNames: []string{opts.Domain},
+	}, certKey)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	if _, err := client.post(order.Finalize, map[string]string{"csr": b64(csr)}, order); err != nil {
+		return fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	for order.Status != "valid" {
+		if order.Status == "invalid" {
+			return fmt.Errorf("order was rejected by the CA")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the certificate")
+		}
+		time.Sleep(2 * time.Second)
+		if _, err := client.post(orderURL, nil, order); err != nil {
+			return fmt.Errorf("failed to poll order: %w", err)
+		}
+	}
+
+	resp, err := client.post(order.Certificate, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download certificate: %w", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.CertPath), 0755); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+
+	// Replace the key and the certificate together, GOST must never load a
+	// certificate with the key of another one
+	err = fsutil.WriteFilesAtomic(
+		fsutil.File{Path: opts.KeyPath, Data: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), Perm: 0600},
+		fsutil.File{Path: opts.CertPath, Data: resp, Perm: 0644},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+
+	return nil
+}
+
+// register creates the ACME account, or looks up the existing one for the
+// account key
+func (c *acmeClient) register() error {
+	req := map[string]interface{}{"termsOfServiceAgreed": true}
+
+	headers, err := c.postWithHeaders(c.dir.NewAccount, req, nil)
+	if err != nil {
+		return err
+	}
+
+	c.kid = headers.Get("Location")
+	if c.kid == "" {
+		return fmt.Errorf("ACME server did not return an account URL")
+	}
+	return nil
+}
+
+// newOrder requests a certificate for domain
+func (c *acmeClient) newOrder(domain string) (string, *acmeOrder, error) {
+	req := map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": domain}},
+	}
+
+	order := &acmeOrder{}
+	headers, err := c.postWithHeaders(c.dir.NewOrder, req, order)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return headers.Get("Location"), order, nil
+}
+
+// authorize completes the HTTP-01 challenge of an authorization
+func (c *acmeClient) authorize(authzURL, challengeAddr string, deadline time.Time) error {
+	authz := &acmeAuthorization{}
+	if _, err := c.post(authzURL, nil, authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var challenge *acmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			challenge = &authz.Challenges[i]
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("CA offered no http-01 challenge")
+	}
+
+	thumbprint, err := jwkThumbprint(&c.key.PublicKey)
+	if err != nil {
+		return err
+	}
+	keyAuth := challenge.Token + "." + thumbprint
+
+	stop, err := serveHTTPChallenge(challengeAddr, challenge.Token, keyAuth)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	if _, err := c.post(challenge.URL, map[string]interface{}{}, nil); err != nil {
+		return fmt.Errorf("failed to start challenge: %w", err)
+	}
+
+	for {
+		time.Sleep(2 * time.Second)
+
+		if _, err := c.post(authzURL, nil, authz); err != nil {
+			return err
+		}
+
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "invalid":
+			for _, ch := range authz.Challenges {
+				if ch.Type == "http-01" && ch.Error != nil {
+					return ch.Error
+				}
+			}
+			return fmt.Errorf("authorization is invalid")
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the CA to validate the domain")
+		}
+	}
+}
+
+// serveHTTPChallenge answers the HTTP-01 challenge for token on addr until
+// the returned stop function is called
+func serveHTTPChallenge(addr, token, keyAuth string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s for the challenge (is a web server running?): %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(acmeChallengePath+token, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, keyAuth)
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = server.Serve(listener) }()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}, nil
+}
+
+// getJSON fetches an unauthenticated ACME resource
+func (c *acmeClient) getJSON(url string, out interface{}) error {
+	resp, err := c.http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// post sends a signed request and returns the response body. A nil payload
+// sends a POST-as-GET. If out is not nil the JSON response is decoded into it.
+func (c *acmeClient) post(url string, payload, out interface{}) ([]byte, error) {
+	body, _, err := c.send(url, payload, out, true)
+	return body, err
+}
+
+// postWithHeaders is post returning the response headers instead of the body
+func (c *acmeClient) postWithHeaders(url string, payload, out interface{}) (http.Header, error) {
+	_, headers, err := c.send(url, payload, out, true)
+	return headers, err
+}
+
+// send signs and sends a request, retrying once on a stale nonce
+func (c *acmeClient) send(url string, payload, out interface{}, retry bool) ([]byte, http.Header, error) {
+	if c.nonce == "" {
+		if err := c.fetchNonce(); err != nil {
+			return nil, nil, fmt.Errorf("failed to get nonce: %w", err)
+		}
+	}
+
+	jws, err := c.sign(url, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.nonce = ""
+
+	resp, err := c.http.Post(url, "application/jose+json", bytes.NewReader(jws))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	c.nonce = resp.Header.Get("Replay-Nonce")
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		problem := &acmeProblem{}
+		if json.Unmarshal(body, problem) != nil || problem.Type == "" {
+			return nil, nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		if retry && problem.Type == "urn:ietf:params:acme:error:badNonce" {
+			return c.send(url, payload, out, false)
+		}
+		return nil, nil, problem
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+
+	return body, resp.Header, nil
+}
+
+// fetchNonce gets a fresh anti-replay nonce
+func (c *acmeClient) fetchNonce() error {
+	resp, err := c.http.Head(c.dir.NewNonce)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	if c.nonce == "" {
+		return errors.New("ACME server returned no nonce")
+	}
+	return nil
+}
+
+// sign builds the flattened JWS for a request. Requests before the account
+// exists carry the public key, later ones the account URL.
+func (c *acmeClient) sign(url string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": c.nonce,
+		"url":   url,
+	}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = jwk(&c.key.PublicKey)
+	}
+
+	protectedJSON, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	payload64 := ""
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload64 = b64(payloadJSON)
+	}
+
+	signingInput := b64(protectedJSON) + "." + payload64
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	// ES256 signatures are the 32-byte big-endian r and s concatenated
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": b64(protectedJSON),
+		"payload":   payload64,
+		"signature": b64(signature),
+	})
+}
+
+// jwkKey is a P-256 public key as a JWK. The fields are in the order
+// required for the RFC 7638 thumbprint.
+type jwkKey struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwk returns the JWK of an ECDSA P-256 public key
+func jwk(pub *ecdsa.PublicKey) jwkKey {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+
+	return jwkKey{Crv: "P-256", Kty: "EC", X: b64(x), Y: b64(y)}
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of a public key
+func jwkThumbprint(pub *ecdsa.PublicKey) (string, error) {
+	data, err := json.Marshal(jwk(pub))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return b64(sum[:]), nil
+}
+
+// loadOrCreateAccountKey reads the ACME account key, creating it on first use
+func loadOrCreateAccountKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("failed to decode ACME account key %s", path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ACME account key: %w", err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read ACME account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key: %w", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ACME account key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ACME account key directory: %w", err)
+	}
+
+	if err := writePEM(path, "EC PRIVATE KEY", keyBytes, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// writePEM writes a single PEM block to path
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := fsutil.WriteFileAtomic(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// b64 is unpadded base64url as used by JOSE
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,65 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ChecksumsFile is the checksum list goreleaser publishes with a release
+const ChecksumsFile = "checksums.txt"
+
+// FileSHA256 returns the hex-encoded SHA-256 digest of a file
+func FileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ParseChecksums parses a list in sha256sum format ("<digest>  <name>" per
+// line) into a map from file name to digest. A binary-mode marker before
+// the name is ignored.
+func ParseChecksums(data []byte) map[string]string {
+	checksums := make(map[string]string)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		name := path.Base(strings.TrimPrefix(fields[1], "*"))
+		checksums[name] = strings.ToLower(fields[0])
+	}
+
+	return checksums
+}
+
+// VerifyChecksum checks that the SHA-256 digest of the file at filePath is
+// expected
+func VerifyChecksum(filePath, expected string) error {
+	actual, err := FileSHA256(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum: %w", err)
+	}
+
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s",
+			path.Base(filePath), expected, actual)
+	}
+
+	return nil
+}
@@ -21,36 +21,50 @@ const (
 	AllChars       = AlphanumChars + SpecialChars
 )
 
+// Password charsets for generated passwords
+const (
+	// PasswordCharsetAlphanumeric generates passwords from letters and digits
+	PasswordCharsetAlphanumeric = "alphanumeric"
+	// PasswordCharsetSymbols adds punctuation for more entropy per character
+	PasswordCharsetSymbols = "symbols"
+)
+
+// PasswordCharsets lists the valid password charsets
+var PasswordCharsets = []string{PasswordCharsetAlphanumeric, PasswordCharsetSymbols}
+
+// ValidatePasswordCharset checks that charset is a known password charset
+func ValidatePasswordCharset(charset string) error {
+	for _, valid := range PasswordCharsets {
+		if charset == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown password charset %q (valid: %s)", charset, strings.Join(PasswordCharsets, ", "))
+}
+
 // GeneratePassword generates a cryptographically secure random password
+// of letters and digits
 func GeneratePassword(length int) (string, error) {
 	if length <= 0 {
 		length = DefaultPasswordLength
 	}
+	return GenerateAlphanumericPassword(length)
+}
 
-	// Use base64 encoding of random bytes for simplicity
-	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+// GeneratePasswordWithCharset generates a password from the named charset
+func GeneratePasswordWithCharset(length int, charset string) (string, error) {
+	if length <= 0 {
+		length = DefaultPasswordLength
 	}
 
-	// Base64 encode and take first 'length' characters
-	encoded := base64.StdEncoding.EncodeToString(bytes)
-
-	// Remove characters that might cause issues in URLs or configs
-	encoded = strings.ReplaceAll(encoded, "+", "")
-	encoded = strings.ReplaceAll(encoded, "/", "")
-	encoded = strings.ReplaceAll(encoded, "=", "")
-
-	if len(encoded) < length {
-		// Recursively generate more if needed
-		additional, err := GeneratePassword(length - len(encoded))
-		if err != nil {
-			return "", err
-		}
-		encoded += additional
+	switch charset {
+	case PasswordCharsetAlphanumeric, "":
+		return GenerateAlphanumericPassword(length)
+	case PasswordCharsetSymbols:
+		return GenerateSecurePassword(length)
+	default:
+		return "", ValidatePasswordCharset(charset)
 	}
-
-	return encoded[:length], nil
 }
 
 // GenerateAlphanumericPassword generates a password with only alphanumeric characters
@@ -58,7 +72,8 @@ func GenerateAlphanumericPassword(length int) (string, error) {
 	return generateFromCharset(length, AlphanumChars)
 }
 
-// GenerateSecurePassword generates a password with mixed character types
+// GenerateSecurePassword generates a password with mixed character types,
+// including at least one symbol
 func GenerateSecurePassword(length int) (string, error) {
 	if length < 8 {
 		length = 8
@@ -86,10 +101,16 @@ func GenerateSecurePassword(length int) (string, error) {
 	}
 	password.WriteString(digit)
 
-	// Fill the rest with alphanumeric characters
-	remaining := length - 3
+	special, err := randomChar(SpecialChars)
+	if err != nil {
+		return "", err
+	}
+	password.WriteString(special)
+
+	// Fill the rest from every character type
+	remaining := length - 4
 	for i := 0; i < remaining; i++ {
-		char, err := randomChar(AlphanumChars)
+		char, err := randomChar(AllChars)
 		if err != nil {
 			return "", err
 		}
@@ -165,10 +186,15 @@ func GenerateURLSafeToken(byteLength int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// MinStrongPasswordLength is the shortest password IsStrongPassword accepts
+const MinStrongPasswordLength = 8
+
 // IsStrongPassword checks if a password meets minimum strength requirements
-func IsStrongPassword(password string) bool {
-	if len(password) < 8 {
-		return false
+// and returns the requirements it fails
+func IsStrongPassword(password string) (bool, []string) {
+	var failed []string
+	if len(password) < MinStrongPasswordLength {
+		failed = append(failed, fmt.Sprintf("shorter than %d characters", MinStrongPasswordLength))
 	}
 
 	hasLower := false
@@ -186,5 +212,15 @@ func IsStrongPassword(password string) bool {
 		}
 	}
 
-	return hasLower && hasUpper && hasDigit
+	if !hasLower {
+		failed = append(failed, "no lowercase letter")
+	}
+	if !hasUpper {
+		failed = append(failed, "no uppercase letter")
+	}
+	if !hasDigit {
+		failed = append(failed, "no digit")
+	}
+
+	return len(failed) == 0, failed
 }
@@ -0,0 +1,92 @@
+package security
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ss2022KeySizes maps Shadowsocks 2022 (AEAD-2022) methods to the size in
+// bytes of the base64-encoded key they require as password
+var ss2022KeySizes = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+	"2022-blake3-chacha8-poly1305":  32,
+}
+
+// SSPreset is a recommended Shadowsocks method for a common use case
+type SSPreset struct {
+	Method string
+	Reason string
+}
+
+// SSPresets maps preset names to the method they select
+var SSPresets = map[string]SSPreset{
+	"fast": {
+		Method: "aes-128-gcm",
+		Reason: "hardware-accelerated AES on most servers and clients, widely supported",
+	},
+	"secure": {
+		Method: "2022-blake3-aes-256-gcm",
+		Reason: "Shadowsocks 2022 with a 256-bit key and replay protection, needs a recent client",
+	},
+	"compatible": {
+		Method: "chacha20-ietf-poly1305",
+		Reason: "fast without AES hardware (phones, routers), supported by almost every client",
+	},
+}
+
+// SSPresetNames returns the preset names in a stable order
+func SSPresetNames() []string {
+	return []string{"fast", "secure", "compatible"}
+}
+
+// IsSS2022Method reports whether method is a Shadowsocks 2022 cipher
+func IsSS2022Method(method string) bool {
+	return strings.HasPrefix(method, "2022-")
+}
+
+// SSKeySize returns the key size in bytes required by a Shadowsocks 2022
+// method, or 0 for methods that accept any password
+func SSKeySize(method string) int {
+	return ss2022KeySizes[method]
+}
+
+// ValidateSSKey checks that password is a usable key for method. AEAD-2022
+// methods need a base64-encoded key of an exact length; multi-user keys
+// ("iPSK:uPSK") are checked part by part. Other methods accept any password.
+func ValidateSSKey(method, password string) error {
+	if !IsSS2022Method(method) {
+		return nil
+	}
+
+	size := SSKeySize(method)
+	if size == 0 {
+		return fmt.Errorf("unsupported Shadowsocks 2022 method: %s", method)
+	}
+
+	for _, key := range strings.Split(password, ":") {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("%s requires a base64-encoded %d-byte key as password (generate one with: openssl rand -base64 %d)",
+				method, size, size)
+		}
+		if len(decoded) != size {
+			return fmt.Errorf("%s requires a %d-byte key, the password decodes to %d bytes (generate one with: openssl rand -base64 %d)",
+				method, size, len(decoded), size)
+		}
+	}
+
+	return nil
+}
+
+// GenerateSSPassword generates a password suitable for method: a random key
+// of the right size for AEAD-2022 methods, a random password of length from
+// charset otherwise
+func GenerateSSPassword(method string, length int, charset string) (string, error) {
+	if size := SSKeySize(method); size > 0 {
+		return GenerateBase64Token(size)
+	}
+	return GeneratePasswordWithCharset(length, charset)
+}
@@ -4,8 +4,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"math/big"
@@ -170,6 +172,7 @@ func GetCertificateInfo(certPath string) (*CertificateInfo, error) {
 		DaysLeft:   int(time.Until(cert.NotAfter).Hours() / 24),
 		IPAddresses: make([]string, 0, len(cert.IPAddresses)),
 		DNSNames:   cert.DNSNames,
+		Fingerprint: CertificateFingerprint(cert),
 	}
 
 	for _, ip := range cert.IPAddresses {
@@ -189,6 +192,15 @@ type CertificateInfo struct {
 	DaysLeft    int
 	IPAddresses []string
 	DNSNames    []string
+	// Fingerprint is the SHA-256 digest of the certificate, see
+	// CertificateFingerprint
+	Fingerprint string
+}
+
+// CertificateFingerprint returns the hex SHA-256 digest of a certificate
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
 }
 
 // RemoveCertificates removes certificate and key files
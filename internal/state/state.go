@@ -0,0 +1,144 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"wte/internal/fsutil"
+)
+
+// DefaultPath is where WTE keeps its runtime state
+const DefaultPath = "/var/lib/wte/state.json"
+
+var (
+	// Path is the state file in use, set from --state-file
+	Path = DefaultPath
+
+	// mu serializes read-modify-write cycles within the process
+	mu sync.Mutex
+)
+
+// Port is a firewall port opened by WTE
+type Port struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Source restricts the rule to an IP or CIDR, empty for everyone
+	Source string `json:"source,omitempty"`
+}
+
+// State is WTE's persistent runtime state. Unlike the configuration it is
+// written by WTE itself and safe to discard.
+type State struct {
+	// InstalledAt is when 'wte install' last completed
+	InstalledAt *time.Time `json:"installed_at,omitempty"`
+
+	// AppliedAt and AppliedConfigHash describe the WTE config the current
+	// GOST config was generated from
+	AppliedAt         *time.Time `json:"applied_at,omitempty"`
+	AppliedConfigHash string     `json:"applied_config_hash,omitempty"`
+
+	// PublicIP and PublicIPv6 cache the detected public IPv4 and IPv6
+	// addresses
+	PublicIP           string     `json:"public_ip,omitempty"`
+	PublicIPv6         string     `json:"public_ipv6,omitempty"`
+	PublicIPDetectedAt *time.Time `json:"public_ip_detected_at,omitempty"`
+
+	// OpenedPorts are the firewall ports opened during install
+	OpenedPorts []Port `json:"opened_ports,omitempty"`
+
+	// PreviousGOSTVersion is the GOST version replaced by the last upgrade
+	PreviousGOSTVersion string `json:"previous_gost_version,omitempty"`
+
+	// LatestGOST and LatestGOSTPrerelease cache the newest GOST release,
+	// without and with prereleases, to spare the GitHub API rate limit
+	LatestGOST           *VersionCheck `json:"latest_gost,omitempty"`
+	LatestGOSTPrerelease *VersionCheck `json:"latest_gost_prerelease,omitempty"`
+}
+
+// VersionCheck is a release version looked up at CheckedAt
+type VersionCheck struct {
+	Version   string    `json:"version"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Now returns the current time for timestamp fields
+func Now() *time.Time {
+	now := time.Now()
+	return &now
+}
+
+// Load reads the state file. A missing file yields an empty state.
+func Load() (*State, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return load()
+}
+
+// Update loads the state, applies fn and writes the result atomically
+func Update(fn func(s *State)) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, err := load()
+	if err != nil {
+		return err
+	}
+
+	fn(s)
+
+	return save(s)
+}
+
+// Reset removes the state file
+func Reset() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.Remove(Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove state file: %w", err)
+	}
+	return nil
+}
+
+// load reads the state file without locking
+func load() (*State, error) {
+	s := &State{}
+
+	data, err := os.ReadFile(Path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", Path, err)
+	}
+
+	return s, nil
+}
+
+// save writes the state atomically, so readers never see a partially
+// written file
+func save(s *State) error {
+	if err := os.MkdirAll(filepath.Dir(Path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(Path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
@@ -1,8 +1,12 @@
 package system
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -16,17 +20,25 @@ const (
 	FirewallUFW       FirewallType = "ufw"
 	FirewallFirewalld FirewallType = "firewalld"
 	FirewallIPTables  FirewallType = "iptables"
+	FirewallNftables  FirewallType = "nftables"
 	FirewallNone      FirewallType = "none"
 )
 
 // FirewallManager manages firewall rules
 type FirewallManager struct {
 	firewallType FirewallType
+	runner       CommandRunner
 }
 
 // NewFirewallManager creates a new FirewallManager
 func NewFirewallManager() *FirewallManager {
-	fm := &FirewallManager{}
+	return NewFirewallManagerWithRunner(ExecRunner{})
+}
+
+// NewFirewallManagerWithRunner creates a FirewallManager that runs the
+// firewall commands, including the detection, through runner
+func NewFirewallManagerWithRunner(runner CommandRunner) *FirewallManager {
+	fm := &FirewallManager{runner: runner}
 	fm.detectFirewall()
 	return fm
 }
@@ -45,6 +57,14 @@ func (fm *FirewallManager) detectFirewall() {
 		return
 	}
 
+	// Check for nftables (Debian 11+, RHEL 9). Rules added through the
+	// legacy iptables backend live outside nftables, so keep using iptables
+	// when it is in legacy mode.
+	if fm.commandExists("nft") && !fm.iptablesIsLegacy() {
+		fm.firewallType = FirewallNftables
+		return
+	}
+
 	// Check for iptables (fallback)
 	if fm.commandExists("iptables") {
 		fm.firewallType = FirewallIPTables
@@ -61,10 +81,8 @@ func (fm *FirewallManager) GetType() FirewallType {
 
 // OpenPorts opens the required ports for the proxy
 func (fm *FirewallManager) OpenPorts(cfg *config.Config) error {
-	ports := cfg.GetRequiredPorts()
-
-	for _, port := range ports {
-		if err := fm.OpenPort(port.Port, port.Protocol); err != nil {
+	for _, port := range cfg.FirewallPorts() {
+		if err := fm.OpenPortFrom(port.Port, port.Protocol, port.Source); err != nil {
 			return fmt.Errorf("failed to open port %d/%s: %w", port.Port, port.Protocol, err)
 		}
 	}
@@ -74,13 +92,26 @@ func (fm *FirewallManager) OpenPorts(cfg *config.Config) error {
 
 // OpenPort opens a single port
 func (fm *FirewallManager) OpenPort(port int, protocol string) error {
+	return fm.OpenPortFrom(port, protocol, "")
+}
+
+// OpenPortFrom opens a single port to the clients in source, an IP address
+// or CIDR range. An empty source opens the port to everyone.
+func (fm *FirewallManager) OpenPortFrom(port int, protocol, source string) error {
+	source, err := firewallSource(source)
+	if err != nil {
+		return err
+	}
+
 	switch fm.firewallType {
 	case FirewallUFW:
-		return fm.openPortUFW(port, protocol)
+		return fm.runCommand("ufw", ufwRule("allow", port, protocol, source)...)
 	case FirewallFirewalld:
-		return fm.openPortFirewalld(port, protocol)
+		return fm.openPortFirewalld(port, protocol, source)
 	case FirewallIPTables:
-		return fm.openPortIPTables(port, protocol)
+		return fm.openPortIPTables(port, protocol, source)
+	case FirewallNftables:
+		return fm.openPortNftables(port, protocol, source)
 	case FirewallNone:
 		return nil
 	}
@@ -89,19 +120,99 @@ func (fm *FirewallManager) OpenPort(port int, protocol string) error {
 
 // ClosePort closes a single port
 func (fm *FirewallManager) ClosePort(port int, protocol string) error {
+	return fm.ClosePortFrom(port, protocol, "")
+}
+
+// ClosePortFrom removes the rule OpenPortFrom added for port and source
+func (fm *FirewallManager) ClosePortFrom(port int, protocol, source string) error {
+	source, err := firewallSource(source)
+	if err != nil {
+		return err
+	}
+
 	switch fm.firewallType {
 	case FirewallUFW:
-		return fm.closePortUFW(port, protocol)
+		return fm.runCommand("ufw", append([]string{"delete"}, ufwRule("allow", port, protocol, source)...)...)
 	case FirewallFirewalld:
-		return fm.closePortFirewalld(port, protocol)
+		return fm.closePortFirewalld(port, protocol, source)
 	case FirewallIPTables:
-		return fm.closePortIPTables(port, protocol)
+		return fm.closePortIPTables(port, protocol, source)
+	case FirewallNftables:
+		return fm.closePortNftables(port, protocol, source)
 	case FirewallNone:
 		return nil
 	}
 	return nil
 }
 
+// IsPortAllowed reports whether the firewall has a rule accepting a port.
+// Without a firewall every port is allowed.
+func (fm *FirewallManager) IsPortAllowed(port int, protocol string) bool {
+	return fm.IsPortAllowedFrom(port, protocol, "")
+}
+
+// IsPortAllowedFrom reports whether the firewall has the rule OpenPortFrom
+// adds for port and source
+func (fm *FirewallManager) IsPortAllowedFrom(port int, protocol, source string) bool {
+	source, err := firewallSource(source)
+	if err != nil {
+		return false
+	}
+	rule := fmt.Sprintf("%d/%s", port, protocol)
+
+	switch fm.firewallType {
+	case FirewallUFW:
+		from := "Anywhere"
+		if source != "" {
+			from = source
+		}
+		output, _ := fm.getCommandOutput("ufw", "status")
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[0] == rule && fields[1] == "ALLOW" && fields[2] == from {
+				return true
+			}
+		}
+		return false
+	case FirewallFirewalld:
+		if source != "" {
+			return fm.runCommand("firewall-cmd", "--query-rich-rule", firewalldRichRule(port, protocol, source)) == nil
+		}
+		return fm.runCommand("firewall-cmd", "--query-port", rule) == nil
+	case FirewallIPTables:
+		return fm.runCommand("iptables", iptablesRule("-C", port, protocol, source)...) == nil
+	case FirewallNftables:
+		// A drop policy in another table overrides the wte accept rule
+		handles, err := fm.nftablesRuleHandles(port, protocol, source)
+		return err == nil && len(handles) > 0 && fm.nftablesDroppingChain() == ""
+	}
+	return true
+}
+
+// firewallSource returns source in the form firewalls list it: an IP
+// address for a single host, a CIDR range otherwise
+func firewallSource(source string) (string, error) {
+	if source == "" {
+		return "", nil
+	}
+	if ip := net.ParseIP(source); ip != nil {
+		return ip.String(), nil
+	}
+	_, network, err := net.ParseCIDR(source)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an IP address or CIDR", source)
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return network.IP.String(), nil
+	}
+	return network.String(), nil
+}
+
+// isIPv6Source reports whether a firewall source is an IPv6 address or range
+func isIPv6Source(source string) bool {
+	return strings.Contains(source, ":")
+}
+
 // Apply applies firewall changes (reload)
 func (fm *FirewallManager) Apply() error {
 	switch fm.firewallType {
@@ -113,6 +224,8 @@ func (fm *FirewallManager) Apply() error {
 	case FirewallIPTables:
 		// Try to save rules
 		return fm.saveIPTables()
+	case FirewallNftables:
+		return fm.saveNftables()
 	case FirewallNone:
 		return nil
 	}
@@ -128,6 +241,8 @@ func (fm *FirewallManager) Status() (string, error) {
 		return fm.getCommandOutput("firewall-cmd", "--list-all")
 	case FirewallIPTables:
 		return fm.getCommandOutput("iptables", "-L", "-n")
+	case FirewallNftables:
+		return fm.getCommandOutput("nft", "list", "table", nftFamily, nftTable)
 	case FirewallNone:
 		return "No firewall detected", nil
 	}
@@ -135,30 +250,62 @@ func (fm *FirewallManager) Status() (string, error) {
 }
 
 // UFW methods
-func (fm *FirewallManager) openPortUFW(port int, protocol string) error {
-	return fm.runCommand("ufw", "allow", fmt.Sprintf("%d/%s", port, protocol))
-}
 
-func (fm *FirewallManager) closePortUFW(port int, protocol string) error {
-	return fm.runCommand("ufw", "delete", "allow", fmt.Sprintf("%d/%s", port, protocol))
+// ufwRule returns the ufw arguments of a rule for port and source
+func ufwRule(action string, port int, protocol, source string) []string {
+	if source == "" {
+		return []string{action, fmt.Sprintf("%d/%s", port, protocol)}
+	}
+	return []string{action, "from", source, "to", "any", "port", strconv.Itoa(port), "proto", protocol}
 }
 
 // Firewalld methods
-func (fm *FirewallManager) openPortFirewalld(port int, protocol string) error {
+func (fm *FirewallManager) openPortFirewalld(port int, protocol, source string) error {
+	if source != "" {
+		return fm.runCommand("firewall-cmd", "--permanent", "--add-rich-rule", firewalldRichRule(port, protocol, source))
+	}
 	return fm.runCommand("firewall-cmd", "--permanent", "--add-port", fmt.Sprintf("%d/%s", port, protocol))
 }
 
-func (fm *FirewallManager) closePortFirewalld(port int, protocol string) error {
+func (fm *FirewallManager) closePortFirewalld(port int, protocol, source string) error {
+	if source != "" {
+		return fm.runCommand("firewall-cmd", "--permanent", "--remove-rich-rule", firewalldRichRule(port, protocol, source))
+	}
 	return fm.runCommand("firewall-cmd", "--permanent", "--remove-port", fmt.Sprintf("%d/%s", port, protocol))
 }
 
+// firewalldRichRule returns the rich rule accepting port from source
+func firewalldRichRule(port int, protocol, source string) string {
+	family := "ipv4"
+	if isIPv6Source(source) {
+		family = "ipv6"
+	}
+	return fmt.Sprintf(`rule family="%s" source address="%s" port port="%d" protocol="%s" accept`, family, source, port, protocol)
+}
+
 // IPTables methods
-func (fm *FirewallManager) openPortIPTables(port int, protocol string) error {
-	return fm.runCommand("iptables", "-A", "INPUT", "-p", protocol, "--dport", strconv.Itoa(port), "-j", "ACCEPT")
+func (fm *FirewallManager) openPortIPTables(port int, protocol, source string) error {
+	if isIPv6Source(source) {
+		return fmt.Errorf("iptables only handles IPv4, open port %d/%s for %s with ip6tables", port, protocol, source)
+	}
+	return fm.runCommand("iptables", iptablesRule("-A", port, protocol, source)...)
+}
+
+func (fm *FirewallManager) closePortIPTables(port int, protocol, source string) error {
+	if isIPv6Source(source) {
+		return nil
+	}
+	return fm.runCommand("iptables", iptablesRule("-D", port, protocol, source)...)
 }
 
-func (fm *FirewallManager) closePortIPTables(port int, protocol string) error {
-	return fm.runCommand("iptables", "-D", "INPUT", "-p", protocol, "--dport", strconv.Itoa(port), "-j", "ACCEPT")
+// iptablesRule returns the iptables arguments of the INPUT rule accepting
+// port from source, for the command flag op (-A, -D, -C)
+func iptablesRule(op string, port int, protocol, source string) []string {
+	args := []string{op, "INPUT", "-p", protocol}
+	if source != "" {
+		args = append(args, "-s", source)
+	}
+	return append(args, "--dport", strconv.Itoa(port), "-j", "ACCEPT")
 }
 
 func (fm *FirewallManager) saveIPTables() error {
@@ -166,16 +313,191 @@ func (fm *FirewallManager) saveIPTables() error {
 	if fm.commandExists("netfilter-persistent") {
 		return fm.runCommand("netfilter-persistent", "save")
 	}
-	if FileExists("/etc/iptables") {
-		output, err := fm.getCommandOutput("iptables-save")
-		if err != nil {
+
+	// Save the raw output, iptables-restore needs the trailing newline
+	output, err := fm.runner.Output("iptables-save")
+	if err != nil {
+		return err
+	}
+	return writeFile("/etc/iptables/rules.v4", output, 0644)
+}
+
+// Nftables methods
+
+// Rules are kept in a dedicated table so they can be listed, persisted and
+// removed without touching the rest of the ruleset
+const (
+	nftFamily = "inet"
+	nftTable  = "wte"
+	nftChain  = "input"
+)
+
+// nftablesConfigFiles are the rulesets loaded by the nftables service at
+// boot (Debian, RHEL)
+var nftablesConfigFiles = []string{
+	"/etc/nftables.conf",
+	"/etc/sysconfig/nftables.conf",
+}
+
+func (fm *FirewallManager) openPortNftables(port int, protocol, source string) error {
+	// An accept in the wte table does not override a drop in another table,
+	// every input chain must accept the packet
+	if chain := fm.nftablesDroppingChain(); chain != "" {
+		return fmt.Errorf("nftables chain %s drops unmatched input, an accept rule in table %s %s would have no effect; "+
+			"allow %s port %d in %s yourself or set firewall.auto_configure to false", chain, nftFamily, nftTable, protocol, port, chain)
+	}
+
+	if err := fm.ensureNftablesChain(); err != nil {
+		return err
+	}
+
+	handles, err := fm.nftablesRuleHandles(port, protocol, source)
+	if err != nil {
+		return err
+	}
+	if len(handles) > 0 {
+		return nil
+	}
+
+	args := append([]string{"add", "rule", nftFamily, nftTable, nftChain}, strings.Fields(nftablesRule(port, protocol, source))...)
+	return fm.runCommand("nft", args...)
+}
+
+func (fm *FirewallManager) closePortNftables(port int, protocol, source string) error {
+	handles, err := fm.nftablesRuleHandles(port, protocol, source)
+	if err != nil {
+		return err
+	}
+
+	for _, handle := range handles {
+		if err := fm.runCommand("nft", "delete", "rule", nftFamily, nftTable, nftChain, "handle", handle); err != nil {
 			return err
 		}
-		return writeFile("/etc/iptables/rules.v4", []byte(output), 0644)
 	}
 	return nil
 }
 
+// ensureNftablesChain creates the wte table and its input chain. Both
+// commands are no-ops when they already exist.
+func (fm *FirewallManager) ensureNftablesChain() error {
+	if err := fm.runCommand("nft", "add", "table", nftFamily, nftTable); err != nil {
+		return err
+	}
+	return fm.runCommand("nft", "add", "chain", nftFamily, nftTable, nftChain,
+		"{ type filter hook input priority 0 ; policy accept ; }")
+}
+
+// nftablesDroppingChain returns the first input chain outside the wte table
+// whose policy is drop, as "family table chain", or "" if there is none
+func (fm *FirewallManager) nftablesDroppingChain() string {
+	output, err := fm.getCommandOutput("nft", "-j", "list", "chains")
+	if err != nil {
+		return ""
+	}
+
+	var ruleset struct {
+		Nftables []struct {
+			Chain *struct {
+				Family string `json:"family"`
+				Table  string `json:"table"`
+				Name   string `json:"name"`
+				Hook   string `json:"hook"`
+				Policy string `json:"policy"`
+			} `json:"chain"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal([]byte(output), &ruleset); err != nil {
+		return ""
+	}
+
+	for _, item := range ruleset.Nftables {
+		chain := item.Chain
+		if chain == nil || chain.Hook != "input" || chain.Policy != "drop" {
+			continue
+		}
+		if chain.Family == nftFamily && chain.Table == nftTable {
+			continue
+		}
+		return chain.Family + " " + chain.Table + " " + chain.Name
+	}
+	return ""
+}
+
+// nftablesRule returns the rule accepting port from source, as nft lists it
+func nftablesRule(port int, protocol, source string) string {
+	rule := fmt.Sprintf("%s dport %d accept", protocol, port)
+	switch {
+	case source == "":
+		return rule
+	case isIPv6Source(source):
+		return "ip6 saddr " + source + " " + rule
+	}
+	return "ip saddr " + source + " " + rule
+}
+
+// nftablesRuleHandles returns the handles of the rules accepting port from
+// source
+func (fm *FirewallManager) nftablesRuleHandles(port int, protocol, source string) ([]string, error) {
+	output, err := fm.getCommandOutput("nft", "-a", "list", "chain", nftFamily, nftTable, nftChain)
+	if err != nil {
+		// The chain does not exist yet, so there are no rules
+		return nil, nil
+	}
+
+	match := nftablesRule(port, protocol, source) + " # handle "
+
+	var handles []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, match) {
+			handles = append(handles, strings.TrimPrefix(line, match))
+		}
+	}
+	return handles, nil
+}
+
+// saveNftables writes the wte table to a file and includes it from the
+// ruleset the nftables service loads at boot
+func (fm *FirewallManager) saveNftables() error {
+	table, err := fm.getCommandOutput("nft", "list", "table", nftFamily, nftTable)
+	if err != nil {
+		// Nothing to persist
+		return nil
+	}
+
+	// Declaring and flushing the table first makes the file safe to load
+	// again over existing rules
+	rules := fmt.Sprintf("table %s %s\nflush table %s %s\n%s\n", nftFamily, nftTable, nftFamily, nftTable, table)
+	if err := writeFile(config.NftablesRulesFile, []byte(rules), 0644); err != nil {
+		return err
+	}
+
+	include := fmt.Sprintf("include \"%s\"", config.NftablesRulesFile)
+	for _, path := range nftablesConfigFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(data), include) {
+			return nil
+		}
+		content := strings.TrimRight(string(data), "\n") + "\n\n" + include + "\n"
+		return writeFile(path, []byte(content), 0644)
+	}
+
+	return nil
+}
+
+// iptablesIsLegacy reports whether iptables uses the legacy xtables
+// backend instead of the nf_tables one
+func (fm *FirewallManager) iptablesIsLegacy() bool {
+	if !fm.commandExists("iptables") {
+		return false
+	}
+	output, err := fm.getCommandOutput("iptables", "--version")
+	return err == nil && strings.Contains(output, "(legacy)")
+}
+
 // Helper methods
 func (fm *FirewallManager) commandExists(name string) bool {
 	_, err := exec.LookPath(name)
@@ -183,26 +505,27 @@ func (fm *FirewallManager) commandExists(name string) bool {
 }
 
 func (fm *FirewallManager) isServiceActive(name string) bool {
-	cmd := exec.Command("systemctl", "is-active", "--quiet", name)
-	return cmd.Run() == nil
+	return fm.runCommand("systemctl", "is-active", "--quiet", name) == nil
 }
 
 func (fm *FirewallManager) runCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	return cmd.Run()
+	return fm.runner.Run(name, args...)
 }
 
 func (fm *FirewallManager) getCommandOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.Output()
+	output, err := fm.runner.Output(name, args...)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
-func writeFile(path string, data []byte, perm uint32) error {
-	return exec.Command("sh", "-c", fmt.Sprintf("echo '%s' > %s", string(data), path)).Run()
+// writeFile writes data to path, creating the parent directory if needed
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
 }
 
 // Enable enables the firewall
@@ -0,0 +1,94 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileKeepsQuotesAndNewlines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iptables", "rules.v4")
+	data := []byte("*filter\n" +
+		"-A INPUT -p tcp --dport 8080 -m comment --comment \"it's 'quoted'\" -j ACCEPT\n" +
+		"-A INPUT -m comment --comment '$(touch /tmp/pwned); `id`' -j ACCEPT\n" +
+		"COMMIT\n\n")
+
+	if err := writeFile(path, data, 0640); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("content = %q, want %q", got, data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+}
+
+// nftablesManager returns a FirewallManager using nftables through runner,
+// bypassing the detection
+func nftablesManager(runner *FakeRunner) *FirewallManager {
+	return &FirewallManager{firewallType: FirewallNftables, runner: runner}
+}
+
+func TestOpenPortNftables(t *testing.T) {
+	runner := &FakeRunner{}
+	if err := nftablesManager(runner).OpenPortFrom(8080, "tcp", "10.0.0.0/8"); err != nil {
+		t.Fatalf("OpenPortFrom: %v", err)
+	}
+
+	for _, prefix := range []string{
+		"nft add table inet wte",
+		"nft add chain inet wte input",
+		"nft add rule inet wte input ip saddr 10.0.0.0/8 tcp dport 8080 accept",
+	} {
+		if !runner.ran(prefix) {
+			t.Errorf("%q not run, ran %q", prefix, runner.Commands)
+		}
+	}
+}
+
+func TestOpenPortNftablesExistingRule(t *testing.T) {
+	runner := &FakeRunner{Results: map[string]FakeResult{
+		"nft -a list chain inet wte input": {Output: "table inet wte {\n\tchain input {\n\t\ttcp dport 8080 accept # handle 4\n\t}\n}\n"},
+	}}
+	fm := nftablesManager(runner)
+
+	if err := fm.OpenPort(8080, "tcp"); err != nil {
+		t.Fatalf("OpenPort: %v", err)
+	}
+	if runner.ran("nft add rule") {
+		t.Error("rule added twice")
+	}
+	if !fm.IsPortAllowed(8080, "tcp") {
+		t.Error("IsPortAllowed = false for an existing rule")
+	}
+}
+
+func TestOpenPortNftablesDropPolicy(t *testing.T) {
+	runner := &FakeRunner{Results: map[string]FakeResult{
+		"nft -j list chains": {Output: `{"nftables": [{"metainfo": {"version": "1.0.6"}},` +
+			`{"chain": {"family": "inet", "table": "filter", "name": "input", "type": "filter", "hook": "input", "prio": 0, "policy": "drop"}}]}`},
+		"nft -a list chain inet wte input": {Output: "table inet wte {\n\tchain input {\n\t\ttcp dport 8080 accept # handle 4\n\t}\n}\n"},
+	}}
+	fm := nftablesManager(runner)
+
+	if err := fm.OpenPort(9090, "tcp"); err == nil {
+		t.Error("OpenPort succeeded behind a drop policy")
+	}
+	if runner.ran("nft add") {
+		t.Errorf("rules added behind a drop policy: %q", runner.Commands)
+	}
+	if fm.IsPortAllowed(8080, "tcp") {
+		t.Error("IsPortAllowed = true behind a drop policy")
+	}
+}
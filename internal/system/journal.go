@@ -0,0 +1,428 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevels maps the levels accepted by 'wte logs --level' to syslog
+// priorities. Lower priorities are more severe.
+var LogLevels = map[string]int{
+	"error": 3,
+	"warn":  4,
+	"info":  6,
+	"debug": 7,
+}
+
+// gostLevels maps the level field GOST writes in its JSON log lines to
+// syslog priorities
+var gostLevels = map[string]int{
+	"panic":   2,
+	"fatal":   2,
+	"error":   3,
+	"warn":    4,
+	"warning": 4,
+	"info":    6,
+	"debug":   7,
+	"trace":   7,
+}
+
+// LogLevelNames returns the accepted --level values, most severe first
+func LogLevelNames() []string {
+	names := make([]string, 0, len(LogLevels))
+	for name := range LogLevels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return LogLevels[names[i]] < LogLevels[names[j]] })
+	return names
+}
+
+// ParseLogLevel returns the syslog priority for a --level value
+func ParseLogLevel(level string) (int, error) {
+	priority, ok := LogLevels[strings.ToLower(level)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q (valid: %s)", level, strings.Join(LogLevelNames(), ", "))
+	}
+	return priority, nil
+}
+
+// LogOptions selects the log entries to show
+type LogOptions struct {
+	// Lines is the number of newest entries to show
+	Lines int
+	// Level keeps entries at or above this level (see LogLevels), empty
+	// keeps every entry
+	Level string
+	// Since keeps entries from this time on, zero keeps every entry
+	Since time.Time
+	// Grep keeps entries whose message matches this pattern
+	Grep *regexp.Regexp
+	// Output receives followed log lines, nil means stdout
+	Output io.Writer
+}
+
+// output returns where followed log lines are written
+func (o LogOptions) output() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// filtered reports whether entries have to be read and filtered by WTE
+// rather than by journalctl alone
+func (o LogOptions) filtered() bool {
+	return o.Level != "" || o.Grep != nil
+}
+
+// journalctlSince returns the journalctl arguments for opts.Since
+func (o LogOptions) journalctlSince() []string {
+	if o.Since.IsZero() {
+		return nil
+	}
+	return []string{"--since", o.Since.Format("2006-01-02 15:04:05")}
+}
+
+// logSinceLayouts are the absolute time formats accepted by ParseLogSince
+var logSinceLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseLogSince parses a 'wte logs --since' value relative to now. It
+// accepts the journalctl forms WTE can also apply to log files: "today",
+// "yesterday", "now", a duration such as "1h", "-1h" or "1 hour ago", and
+// local times such as "2024-05-01 13:00".
+func ParseLogSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(value) {
+	case "":
+		return time.Time{}, fmt.Errorf("empty --since value")
+	case "now":
+		return now, nil
+	case "today":
+		y, mo, d := now.Date()
+		return time.Date(y, mo, d, 0, 0, 0, 0, now.Location()), nil
+	case "yesterday":
+		y, mo, d := now.Date()
+		return time.Date(y, mo, d-1, 0, 0, 0, 0, now.Location()), nil
+	}
+
+	for _, layout := range logSinceLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	if d, ok := parseAgo(value); ok {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid --since value %q (use e.g. \"1 hour ago\", 30m, today or \"2006-01-02 15:04\")", value)
+}
+
+// agoUnits maps the unit words accepted in "<n> <unit> ago" to durations
+var agoUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour,
+}
+
+// parseAgo parses a time span into the past: "1h", "-1h30m", "2 days ago"
+func parseAgo(value string) (time.Duration, bool) {
+	value = strings.TrimPrefix(strings.TrimSuffix(value, " ago"), "-")
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, true
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	unit, ok := agoUnits[strings.TrimSuffix(strings.ToLower(fields[1]), "s")]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// CompileLogGrep compiles a 'wte logs --grep' pattern. Like journalctl, the
+// match is case-insensitive unless the pattern contains an upper-case letter.
+func CompileLogGrep(pattern string) (*regexp.Regexp, error) {
+	if strings.ToLower(pattern) == pattern {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --grep pattern: %w", err)
+	}
+	return re, nil
+}
+
+// Logs returns the last opts.Lines journal entries selected by opts. GOST
+// writes everything to stdout, which journald records at priority info, so
+// the level GOST puts in each line is used instead of the journal priority
+// where present.
+func (m *SystemdManager) Logs(opts LogOptions) (string, error) {
+	if !opts.filtered() {
+		args := append(m.logUnits(), "-n", strconv.Itoa(opts.Lines), "--no-pager")
+		args = append(args, opts.journalctlSince()...)
+		return m.getJournalctlOutput(args...)
+	}
+
+	args := append(m.logUnits(), "-o", "json", "--no-pager")
+	args = append(args, opts.journalctlSince()...)
+	cmd := exec.Command("journalctl", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	// Keep only the newest matching entries
+	filter := newLogFilter(opts)
+	var kept []string
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line, ok := filter.journalEntry(scanner.Bytes()); ok {
+			kept = append(kept, line)
+			if len(kept) > opts.Lines {
+				kept = kept[1:]
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", journalctlError(err, stderr.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if len(kept) == 0 {
+		return "", nil
+	}
+	return strings.Join(kept, "\n") + "\n", nil
+}
+
+// FollowLogs returns a command following the journal, starting with the
+// last opts.Lines entries selected by opts
+func (m *SystemdManager) FollowLogs(opts LogOptions) *exec.Cmd {
+	args := append(m.logUnits(), "-n", strconv.Itoa(opts.Lines), "-f", "--no-pager")
+	args = append(args, opts.journalctlSince()...)
+	if opts.filtered() {
+		args = append(args, "-o", "json")
+	}
+
+	cmd := exec.Command("journalctl", args...)
+	cmd.Stdout = opts.output()
+	if opts.filtered() {
+		cmd.Stdout = &logFilterWriter{out: opts.output(), filter: newLogFilter(opts).journalEntry}
+	}
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// PurgeLogs vacuums the archived journal files. journald can only delete
+// whole files, so the entries of every unit in them are removed, not only
+// the service's. The active journal files are left alone.
+func (m *SystemdManager) PurgeLogs() error {
+	_, err := m.getJournalctlOutput("--vacuum-time=1s", "--unit", "gost")
+	return err
+}
+
+// journalctlError adds what journalctl printed to stderr, such as a
+// rejected --since time, to its exit error
+func journalctlError(err error, stderr []byte) error {
+	if msg := strings.TrimSpace(string(stderr)); msg != "" {
+		return fmt.Errorf("journalctl: %s", msg)
+	}
+	return err
+}
+
+// logFilter selects log entries by level, time and pattern
+type logFilter struct {
+	priority int
+	since    time.Time
+	grep     *regexp.Regexp
+
+	// inRange tracks whether plain log lines without a timestamp follow
+	// an entry at or after since
+	inRange bool
+}
+
+// newLogFilter creates the filter for opts. The level has been validated
+// by the caller.
+func newLogFilter(opts LogOptions) *logFilter {
+	priority := -1
+	if opts.Level != "" {
+		if p, err := ParseLogLevel(opts.Level); err == nil {
+			priority = p
+		}
+	}
+
+	return &logFilter{
+		priority: priority,
+		since:    opts.Since,
+		grep:     opts.Grep,
+		inRange:  opts.Since.IsZero(),
+	}
+}
+
+// matches checks a message against the level and pattern
+func (f *logFilter) matches(message string, priority int) bool {
+	if f.priority >= 0 && priority > f.priority {
+		return false
+	}
+	return f.grep == nil || f.grep.MatchString(message)
+}
+
+// logFilterWriter receives log output line by line and writes the lines
+// filter accepts
+type logFilterWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	filter  func(data []byte) (string, bool)
+	pending []byte
+}
+
+// LineWriter returns a writer that passes each complete line written to it
+// through transform and writes the result to out, dropping lines transform
+// rejects
+func LineWriter(out io.Writer, transform func(line []byte) (string, bool)) io.Writer {
+	return &logFilterWriter{out: out, filter: transform}
+}
+
+func (w *logFilterWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if line, ok := w.filter(w.pending[:i]); ok {
+			if _, err := fmt.Fprintln(w.out, line); err != nil {
+				return 0, err
+			}
+		}
+		w.pending = w.pending[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// journalEntry parses a journalctl JSON entry and formats it like
+// journalctl's short output if the filter accepts it. journalctl applies
+// the time itself.
+func (f *logFilter) journalEntry(data []byte) (string, bool) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", false
+	}
+
+	// Non-UTF-8 messages are encoded as byte arrays, skip them
+	message, ok := entry["MESSAGE"].(string)
+	if !ok {
+		return "", false
+	}
+
+	entryPriority := 6
+	if value, ok := entry["PRIORITY"].(string); ok {
+		if p, err := strconv.Atoi(value); err == nil {
+			entryPriority = p
+		}
+	}
+	fields := gostFields(message)
+	if level, ok := gostLevels[strings.ToLower(fields.Level)]; ok {
+		entryPriority = level
+	}
+
+	if !f.matches(message, entryPriority) {
+		return "", false
+	}
+
+	timestamp := ""
+	if value, ok := entry["__REALTIME_TIMESTAMP"].(string); ok {
+		if usec, err := strconv.ParseInt(value, 10, 64); err == nil {
+			timestamp = time.UnixMicro(usec).Format("Jan 02 15:04:05")
+		}
+	}
+
+	source, _ := entry["SYSLOG_IDENTIFIER"].(string)
+	if pid, ok := entry["_PID"].(string); ok && source != "" {
+		source = fmt.Sprintf("%s[%s]", source, pid)
+	}
+
+	return fmt.Sprintf("%s %s: %s", timestamp, source, message), true
+}
+
+// logLine keeps a plain GOST log line if the filter accepts it. Lines
+// without a level count as info; lines without a timestamp belong to the
+// entry before them.
+func (f *logFilter) logLine(data []byte) (string, bool) {
+	line := string(data)
+
+	fields := gostFields(line)
+	if !f.since.IsZero() {
+		if t, err := time.Parse(time.RFC3339Nano, fields.Time); err == nil {
+			f.inRange = !t.Before(f.since)
+		}
+	}
+	if !f.inRange {
+		return "", false
+	}
+
+	linePriority := 6
+	if level, ok := gostLevels[strings.ToLower(fields.Level)]; ok {
+		linePriority = level
+	}
+
+	return line, f.matches(line, linePriority)
+}
+
+// gostLogFields are the fields of a GOST JSON log line WTE reads
+type gostLogFields struct {
+	Level string `json:"level"`
+	Time  string `json:"time"`
+}
+
+// gostFields extracts the level and time from a GOST JSON log line. Other
+// lines give empty fields.
+func gostFields(message string) gostLogFields {
+	var fields gostLogFields
+	if strings.HasPrefix(message, "{") {
+		_ = json.Unmarshal([]byte(message), &fields)
+	}
+	return fields
+}
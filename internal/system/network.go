@@ -1,13 +1,25 @@
 package system
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"regexp"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
 	"strings"
 	"time"
+
+	"wte/internal/httputil"
+	"wte/internal/state"
 )
 
 // IPServices is a list of services to query for public IP
@@ -19,36 +31,390 @@ var IPServices = []string{
 	"https://ipecho.net/plain",
 }
 
-// GetPublicIP attempts to determine the public IP address
+// PublicIPCacheTTL is how long a detected public IP is reused
+const PublicIPCacheTTL = time.Hour
+
+// PublicIPDetectTimeout bounds the lookup of one address family across all
+// IP services, retries included
+const PublicIPDetectTimeout = 30 * time.Second
+
+const (
+	// ipServiceAttempts is how often each IP service is asked
+	ipServiceAttempts = 2
+
+	// ipServiceBackoff is the wait before the first retry, doubled for
+	// every further one
+	ipServiceBackoff = 500 * time.Millisecond
+)
+
+// PublicIPs holds the server's public addresses by family. Either may be
+// empty, but not both.
+type PublicIPs struct {
+	IPv4 string
+	IPv6 string
+}
+
+// Preferred returns the IPv4 address if there is one, the IPv6 address
+// otherwise
+func (p *PublicIPs) Preferred() string {
+	if p.IPv4 != "" {
+		return p.IPv4
+	}
+	return p.IPv6
+}
+
+// GetPublicIP returns the public IP address, reusing one detected within
+// PublicIPCacheTTL. IPv4 is preferred when the server has both.
 func GetPublicIP() (string, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	ips, err := GetPublicIPs()
+	if err != nil {
+		return "", err
+	}
+	return ips.Preferred(), nil
+}
+
+// GetPublicIPs returns the public IPv4 and IPv6 addresses, reusing those
+// detected within PublicIPCacheTTL
+func GetPublicIPs() (*PublicIPs, error) {
+	if s, err := state.Load(); err == nil && (s.PublicIP != "" || s.PublicIPv6 != "") &&
+		s.PublicIPDetectedAt != nil && time.Since(*s.PublicIPDetectedAt) < PublicIPCacheTTL {
+		return &PublicIPs{IPv4: s.PublicIP, IPv6: s.PublicIPv6}, nil
+	}
+
+	return DetectPublicIPs()
+}
+
+// DetectPublicIP queries the IP services for the public IP address and
+// caches the result in the state file
+func DetectPublicIP() (string, error) {
+	ips, err := DetectPublicIPs()
+	if err != nil {
+		return "", err
+	}
+	return ips.Preferred(), nil
+}
+
+// DetectPublicIPs queries the IP services like LookupPublicIPs and caches
+// the addresses found in the state file
+func DetectPublicIPs() (*PublicIPs, error) {
+	ips, err := LookupPublicIPs()
+	if err != nil {
+		return nil, err
 	}
 
-	ipRegex := regexp.MustCompile(`^(\d{1,3}\.){3}\d{1,3}$`)
+	// Caching is best effort, non-root users can't write the state file
+	_ = state.Update(func(s *state.State) {
+		s.PublicIP = ips.IPv4
+		s.PublicIPv6 = ips.IPv6
+		s.PublicIPDetectedAt = state.Now()
+	})
 
+	return ips, nil
+}
+
+// LookupPublicIPs queries the IP services over IPv4 and IPv6 at the same
+// time, without touching the state file. IPv6 is only tried when an
+// interface has a global IPv6 address.
+func LookupPublicIPs() (*PublicIPs, error) {
+	ips := &PublicIPs{}
+
+	var err6 error
+	done6 := make(chan struct{})
+	go func() {
+		defer close(done6)
+		if hasGlobalIPv6() {
+			ips.IPv6, err6 = detectPublicIP("tcp6")
+		}
+	}()
+
+	ipv4, err := detectPublicIP("tcp4")
+	ips.IPv4 = ipv4
+	<-done6
+	if err == nil {
+		err = err6
+	}
+
+	if ips.IPv4 == "" && ips.IPv6 == "" {
+		return nil, fmt.Errorf("could not determine public IP address: %w", err)
+	}
+
+	return ips, nil
+}
+
+// detectPublicIP returns the address the IP services see over network
+// ("tcp4" or "tcp6"). All services are asked at once and the first valid
+// answer wins, so a slow service does not hold up the lookup. Each service
+// is retried with backoff, and the lookup gives up after
+// PublicIPDetectTimeout with the errors of every service.
+func detectPublicIP(network string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PublicIPDetectTimeout)
+	defer cancel()
+
+	client := httputil.Client(httputil.Options{Timeout: 10 * time.Second, Network: network})
+
+	type result struct {
+		ip  string
+		err error
+	}
+	results := make(chan result, len(IPServices))
 	for _, service := range IPServices {
-		resp, err := client.Get(service)
-		if err != nil {
-			continue
+		go func(service string) {
+			ip, err := askIPService(ctx, client, service, network)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", service, err)
+			}
+			results <- result{ip, err}
+		}(service)
+	}
+
+	var failures []string
+	for range IPServices {
+		r := <-results
+		if r.err == nil {
+			return r.ip, nil
 		}
+		failures = append(failures, r.err.Error())
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			continue
+	err := errors.New(strings.Join(failures, "; "))
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("gave up after %s: %w", PublicIPDetectTimeout, err)
+	}
+	return "", err
+}
+
+// askIPService queries one IP service, retrying with backoff until it
+// answers or ctx is done
+func askIPService(ctx context.Context, client *http.Client, service, network string) (string, error) {
+	var lastErr error
+	backoff := ipServiceBackoff
+	for attempt := 1; attempt <= ipServiceAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return "", lastErr
+			case <-time.After(backoff):
+			}
+			backoff *= 2
 		}
 
-		ip := strings.TrimSpace(string(body))
-		if ipRegex.MatchString(ip) {
+		ip, err := queryIPService(ctx, client, service, network)
+		if err == nil {
 			return ip, nil
 		}
+		lastErr = err
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return "", lastErr
+}
+
+// queryIPService asks one IP service for the address it sees over network
+func queryIPService(ctx context.Context, client *http.Client, service, network string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, service, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+
+	answer := strings.TrimSpace(string(body))
+	ip := net.ParseIP(answer)
+	if ip == nil || (ip.To4() != nil) != (network == "tcp4") {
+		return "", fmt.Errorf("unexpected answer %q", answer)
+	}
+	return ip.String(), nil
+}
+
+// hasGlobalIPv6 reports whether any interface has a global unicast IPv6
+// address, so IPv6 lookups are skipped quickly on IPv4-only servers
+func hasGlobalIPv6() bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() == nil && ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsPrivate() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProxyProbeTarget is the destination requested when probing a proxy
+const ProxyProbeTarget = "example.com:443"
+
+// ProbeHTTPProxy sends an unauthenticated CONNECT request to an HTTP proxy
+// and returns the status code it answers with. A 200 means the proxy relays
+// traffic for anyone who can reach it.
+func ProbeHTTPProxy(host string, port int, useTLS bool) (int, error) {
+	return connectHTTPProxy(host, port, useTLS, "", "", 10*time.Second)
+}
+
+// TestHTTPProxy opens a tunnel to ProxyProbeTarget through an HTTP proxy
+// with the given credentials. An empty username sends no credentials.
+func TestHTTPProxy(host string, port int, useTLS bool, username, password string, timeout time.Duration) error {
+	status, err := connectHTTPProxy(host, port, useTLS, username, password, timeout)
+	if err != nil {
+		return err
 	}
 
-	return "", fmt.Errorf("could not determine public IP address")
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusProxyAuthRequired:
+		return fmt.Errorf("proxy rejected the credentials (407)")
+	default:
+		return fmt.Errorf("proxy refused the tunnel (%d)", status)
+	}
 }
 
-// GetLocalIPs returns a list of local IP addresses
+// EgressIPURL is fetched through a proxy to learn the address its traffic
+// leaves from
+const EgressIPURL = "https://ifconfig.me/ip"
+
+// TestHTTPProxyEgress fetches EgressIPURL through an HTTP proxy with the
+// given credentials and returns the IP address it reports. An empty
+// username sends no credentials.
+func TestHTTPProxyEgress(host string, port int, useTLS bool, username, password string, timeout time.Duration) (string, error) {
+	proxyURL := &url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	if useTLS {
+		proxyURL.Scheme = "https"
+	}
+	if username != "" {
+		proxyURL.User = url.UserPassword(username, password)
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		Proxy: http.ProxyURL(proxyURL),
+		// Only the connection to the proxy skips verification, WTE generates
+		// self-signed certificates. The target is still verified.
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, network, addr, &tls.Config{InsecureSkipVerify: true})
+		},
+		DialContext: dialer.DialContext,
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{Timeout: timeout, Transport: transport}
+
+	resp, err := client.Get(EgressIPURL)
+	if err != nil {
+		return "", fmt.Errorf("request through proxy failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", EgressIPURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("unexpected response from %s: %q", EgressIPURL, ip)
+	}
+
+	return ip, nil
+}
+
+// TestTCPPort checks that a TCP connection to host:port can be established
+func TestTCPPort(host string, port int, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+	conn.Close()
+	return nil
+}
+
+// TLSCertificate performs a TLS handshake with host:port and returns the
+// certificate the server presents. The certificate is not verified.
+func TLSCertificate(host string, port int, timeout time.Duration) (*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, strconv.Itoa(port)), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate presented")
+	}
+	return certs[0], nil
+}
+
+// connectHTTPProxy sends a CONNECT request for ProxyProbeTarget and returns
+// the status code the proxy answers with
+func connectHTTPProxy(host string, port int, useTLS bool, username, password string, timeout time.Duration) (int, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+	if useTLS {
+		// WTE generates self-signed certificates
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("connection failed: %w", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", ProxyProbeTarget, ProxyProbeTarget)
+	if username != "" {
+		token := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		request += "Proxy-Authorization: Basic " + token + "\r\n"
+	}
+	request += "\r\n"
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return 0, fmt.Errorf("no proxy response: %w", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// GetLocalIPs returns the addresses of the local interfaces, except
+// loopback and IPv6 link-local addresses, which can't be bound without a
+// zone
 func GetLocalIPs() ([]string, error) {
 	var ips []string
 
@@ -58,16 +424,43 @@ func GetLocalIPs() ([]string, error) {
 	}
 
 	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				ips = append(ips, ipnet.IP.String())
-			}
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipnet.IP.String())
 		}
 	}
 
 	return ips, nil
 }
 
+// CheckBindAddress checks that a service can listen on address: it must
+// be empty (all interfaces), a wildcard, a loopback address or the
+// address of a local interface
+func CheckBindAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("%q is not an IP address", address)
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() {
+		return nil
+	}
+
+	local, err := GetLocalIPs()
+	if err != nil {
+		return fmt.Errorf("failed to list local addresses: %w", err)
+	}
+	for _, addr := range local {
+		if net.ParseIP(addr).Equal(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no interface on this server has the address %s (local addresses: %s)", address, strings.Join(local, ", "))
+}
+
 // IsPortOpen checks if a port is listening
 func IsPortOpen(port int) bool {
 	address := fmt.Sprintf("127.0.0.1:%d", port)
@@ -79,6 +472,92 @@ func IsPortOpen(port int) bool {
 	return true
 }
 
+// CountConnections returns the number of established TCP connections to a
+// local port. It asks ss and falls back to /proc/net/tcp and
+// /proc/net/tcp6 where ss isn't installed.
+func CountConnections(port int) (int, error) {
+	if commandExists("ss") {
+		out, err := exec.Command("ss", "-tn", "state", "established", fmt.Sprintf("( sport = :%d )", port)).Output()
+		if err == nil {
+			return countSSLines(string(out)), nil
+		}
+	}
+
+	count := 0
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		n, err := countProcNetTCP(path, port)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// countSSLines counts the sockets in ss output, skipping the header
+func countSSLines(out string) int {
+	count := 0
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "Recv-Q") {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// tcpEstablished is the state of an established socket in /proc/net/tcp
+const tcpEstablished = "01"
+
+// countProcNetTCP counts the established sockets with local port port in a
+// /proc/net/tcp style table
+func countProcNetTCP(path string, port int) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	suffix := fmt.Sprintf(":%04X", port)
+	count := 0
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], suffix) && fields[3] == tcpEstablished {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// udpUnconnected is the state of a bound, unconnected socket in
+// /proc/net/udp
+const udpUnconnected = "07"
+
+// IsUDPPortListening reports whether a local UDP socket is bound to port.
+// UDP has no handshake to test, so the kernel's socket table is checked.
+func IsUDPPortListening(port int) bool {
+	suffix := fmt.Sprintf(":%04X", port)
+	for _, path := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && strings.HasSuffix(fields[1], suffix) && fields[3] == udpUnconnected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // IsPortAvailable checks if a port is available for binding
 func IsPortAvailable(port int) bool {
 	address := fmt.Sprintf(":%d", port)
@@ -90,6 +569,17 @@ func IsPortAvailable(port int) bool {
 	return true
 }
 
+// IsUDPPortAvailable checks if a UDP port is available for binding
+func IsUDPPortAvailable(port int) bool {
+	address := fmt.Sprintf(":%d", port)
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
 // GetListeningPorts returns a map of ports to process names
 func GetListeningPorts() map[int]string {
 	// This is a simplified version - in production you'd parse /proc/net/tcp
@@ -99,9 +589,7 @@ func GetListeningPorts() map[int]string {
 
 // CheckConnectivity verifies internet connectivity
 func CheckConnectivity() bool {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
+	client := httputil.Client(httputil.Options{Timeout: 5 * time.Second})
 
 	resp, err := client.Get("https://www.google.com")
 	if err != nil {
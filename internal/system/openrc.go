@@ -0,0 +1,342 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"wte/internal/config"
+)
+
+const openrcServiceTemplate = `#!/sbin/openrc-run
+# ============================================================================
+# GOST Proxy Server - OpenRC Init Script
+# ============================================================================
+# Managed by WTE
+# Do not edit manually - changes may be overwritten
+` + ServiceMarker + `
+# ============================================================================
+
+name="gost"
+description="GOST Proxy Server (WTE: {{.ServerName}})"
+
+supervisor="supervise-daemon"
+command="{{.BinaryPath}}"
+command_args="-C {{.ConfigFile}}"
+output_log="{{.LogFile}}"
+error_log="{{.LogFile}}"
+respawn_delay=5
+extra_started_commands="reload"
+rc_ulimit="-n 65535"
+{{- if .User}}
+command_user="{{.User}}:{{.User}}"
+{{- if .BindPrivileged}}
+capabilities="^cap_net_bind_service"
+{{- end}}
+{{- end}}
+
+depend() {
+	need net
+	after firewall
+}
+
+start_pre() {
+	checkpath --directory --mode 0755 "{{.LogDir}}"
+}
+
+reload() {
+	ebegin "Reloading ${name}"
+	supervise-daemon "${RC_SVCNAME}" --signal HUP
+	eend $?
+}
+`
+
+// OpenRCManager manages the GOST service on OpenRC hosts such as Alpine
+type OpenRCManager struct{}
+
+// NewOpenRCManager creates a new OpenRCManager
+func NewOpenRCManager() *OpenRCManager {
+	return &OpenRCManager{}
+}
+
+// Create writes the OpenRC init script
+func (m *OpenRCManager) Create(cfg *config.Config) error {
+	tmpl, err := template.New("service").Parse(openrcServiceTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse service template: %w", err)
+	}
+
+	data := struct {
+		ServerName     string
+		BinaryPath     string
+		ConfigFile     string
+		LogFile        string
+		LogDir         string
+		User           string
+		BindPrivileged bool
+	}{
+		ServerName:     cfg.ServerName(),
+		BinaryPath:     cfg.GOST.BinaryPath,
+		ConfigFile:     cfg.GOST.ConfigFile,
+		LogFile:        config.OpenRCLogFile,
+		LogDir:         filepath.Dir(config.OpenRCLogFile),
+		BindPrivileged: cfg.NeedsPrivilegedPorts(),
+	}
+	if !cfg.GOST.RunsAsRoot() {
+		data.User = cfg.GOST.RunAsUser
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to execute service template: %w", err)
+	}
+
+	if err := os.WriteFile(config.OpenRCServiceFile, buf.Bytes(), 0755); err != nil {
+		return fmt.Errorf("failed to write service file: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreDefinition writes back an init script read before Create, or
+// removes the script if data is nil
+func (m *OpenRCManager) RestoreDefinition(data []byte) error {
+	if data == nil {
+		if err := os.Remove(config.OpenRCServiceFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove service file: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(config.OpenRCServiceFile, data, 0755); err != nil {
+		return fmt.Errorf("failed to write service file: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the init script
+func (m *OpenRCManager) Remove() error {
+	if !m.IsInstalled() {
+		return nil
+	}
+
+	// Stop and disable first (ignore errors as service might not be running)
+	_ = m.Stop()
+	_ = m.Disable()
+
+	if err := os.Remove(config.OpenRCServiceFile); err != nil {
+		return fmt.Errorf("failed to remove service file: %w", err)
+	}
+
+	return nil
+}
+
+// IsInstalled checks if the init script is installed
+func (m *OpenRCManager) IsInstalled() bool {
+	return FileExists(config.OpenRCServiceFile)
+}
+
+// DefinitionPath returns the path of the init script
+func (m *OpenRCManager) DefinitionPath() string {
+	return config.OpenRCServiceFile
+}
+
+// ForeignDefinition returns the path of the gost init script if WTE did
+// not write it
+func (m *OpenRCManager) ForeignDefinition() string {
+	if m.IsInstalled() && !isManagedDefinition(config.OpenRCServiceFile) {
+		return config.OpenRCServiceFile
+	}
+	return ""
+}
+
+// Enable adds the service to the default runlevel
+func (m *OpenRCManager) Enable() error {
+	return exec.Command("rc-update", "add", "gost", "default").Run()
+}
+
+// Disable removes the service from the default runlevel
+func (m *OpenRCManager) Disable() error {
+	return exec.Command("rc-update", "del", "gost", "default").Run()
+}
+
+// Start starts the service
+func (m *OpenRCManager) Start() error {
+	return m.runRCService("start")
+}
+
+// Stop stops the service
+func (m *OpenRCManager) Stop() error {
+	return m.runRCService("stop")
+}
+
+// Restart restarts the service
+func (m *OpenRCManager) Restart() error {
+	return m.runRCService("restart")
+}
+
+// Reload makes GOST reread its configuration by sending it SIGHUP
+func (m *OpenRCManager) Reload() error {
+	return m.runRCService("reload")
+}
+
+// Status returns the service status. The states are mapped onto the
+// systemd names so callers can treat both init systems alike.
+func (m *OpenRCManager) Status() (*ServiceStatus, error) {
+	status := &ServiceStatus{
+		Name:        "gost",
+		ActiveState: "inactive",
+		SubState:    "stopped",
+		LoadState:   "not-found",
+	}
+
+	if m.IsInstalled() {
+		status.LoadState = "loaded"
+	}
+
+	// rc-service prints " * status: started"
+	output, err := exec.Command("rc-service", "gost", "status").CombinedOutput()
+	if err == nil {
+		status.IsActive = true
+		status.ActiveState = "active"
+	}
+	if i := strings.LastIndex(string(output), "status:"); i >= 0 {
+		status.SubState = strings.TrimSpace(string(output[i+len("status:"):]))
+	}
+	if status.SubState == "crashed" {
+		status.ActiveState = "failed"
+	}
+
+	// Check if enabled
+	if output, err := exec.Command("rc-update", "show", "default").Output(); err == nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "gost" {
+				status.IsEnabled = true
+				break
+			}
+		}
+	}
+
+	if status.IsActive {
+		if output, err := exec.Command("pidof", "gost").Output(); err == nil {
+			if fields := strings.Fields(string(output)); len(fields) > 0 {
+				status.MainPID = fields[0]
+				if bytes := processMemory(status.MainPID); bytes > 0 {
+					status.MemoryBytes = bytes
+					status.MemoryUsage = fmt.Sprintf("%dMB", bytes/1024/1024)
+				}
+			}
+		}
+	}
+
+	return status, nil
+}
+
+// WaitForActive polls the service status until the service is started
+func (m *OpenRCManager) WaitForActive(timeout time.Duration) error {
+	return waitForActive(timeout, func() string {
+		status, err := m.Status()
+		if err != nil {
+			return "unknown"
+		}
+		return status.ActiveState
+	})
+}
+
+// StatusReport returns the output of rc-service status, which fails for a
+// stopped service, so output is returned regardless
+func (m *OpenRCManager) StatusReport() (string, error) {
+	output, err := exec.Command("rc-service", "gost", "status").CombinedOutput()
+	if len(output) > 0 {
+		return string(output), nil
+	}
+	return "", err
+}
+
+// Logs returns the last opts.Lines lines of the service log file selected
+// by opts
+func (m *OpenRCManager) Logs(opts LogOptions) (string, error) {
+	file, err := os.Open(config.OpenRCLogFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	// Keep only the newest matching lines
+	filter := newLogFilter(opts)
+	var kept []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line, ok := filter.logLine(scanner.Bytes()); ok {
+			kept = append(kept, line)
+			if len(kept) > opts.Lines {
+				kept = kept[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if len(kept) == 0 {
+		return "", nil
+	}
+	return strings.Join(kept, "\n") + "\n", nil
+}
+
+// FollowLogs follows the service log file, starting with the last
+// opts.Lines lines. The initial lines are read before filtering, so fewer
+// may be shown when opts filters.
+func (m *OpenRCManager) FollowLogs(opts LogOptions) *exec.Cmd {
+	cmd := exec.Command("tail", "-n", fmt.Sprintf("%d", opts.Lines), "-F", config.OpenRCLogFile)
+	cmd.Stdout = opts.output()
+	if opts.filtered() || !opts.Since.IsZero() {
+		cmd.Stdout = &logFilterWriter{out: opts.output(), filter: newLogFilter(opts).logLine}
+	}
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// PurgeLogs removes the service log file
+func (m *OpenRCManager) PurgeLogs() error {
+	if err := os.Remove(config.OpenRCLogFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// runRCService runs an rc-service action on the gost service
+func (m *OpenRCManager) runRCService(action string) error {
+	return exec.Command("rc-service", "gost", action).Run()
+}
+
+// processMemory returns the resident memory of a process in bytes, read
+// from /proc since there is no cgroup accounting to ask
+func processMemory(pid string) int64 {
+	data, err := os.ReadFile(filepath.Join("/proc", pid, "status"))
+	if err != nil {
+		return 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		var kb int64
+		if _, err := fmt.Sscanf(strings.TrimPrefix(line, "VmRSS:"), "%d", &kb); err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+
+	return 0
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -13,10 +15,11 @@ type OSInfo struct {
 	OS           string // ubuntu, debian, centos, etc.
 	Version      string // 22.04, 11, 8, etc.
 	PrettyName   string // Ubuntu 22.04.3 LTS
-	Arch         string // x86_64, aarch64, armv7l
-	GOSTArch     string // amd64, arm64, armv7
+	Arch         string // x86_64, aarch64, armv7l, mips
+	GOSTArch     string // amd64, arm64, armv7, mipsle_softfloat
 	IsSupported  bool
 	PackageManager string // apt, yum, dnf, pacman
+	Fallback     bool   // no OS metadata found, generic Linux assumed
 }
 
 // DetectOS detects the operating system and architecture
@@ -26,7 +29,7 @@ func DetectOS() (*OSInfo, error) {
 	}
 
 	// Detect architecture
-	if err := detectArch(info); err != nil {
+	if err := detectArch(info, runtime.GOARCH, hostMachine()); err != nil {
 		return nil, err
 	}
 
@@ -34,7 +37,8 @@ func DetectOS() (*OSInfo, error) {
 	if err := detectOSRelease(info); err != nil {
 		// Try fallback for older systems
 		if err := detectRedHatRelease(info); err != nil {
-			return nil, fmt.Errorf("unable to detect operating system: %w", err)
+			// Minimal containers ship without OS metadata
+			detectGenericLinux(info)
 		}
 	}
 
@@ -47,28 +51,99 @@ func DetectOS() (*OSInfo, error) {
 	return info, nil
 }
 
-// detectArch detects the system architecture
-func detectArch(info *OSInfo) error {
-	// Use uname -m equivalent
-	arch := runtime.GOARCH
+// gostGoArches are the Go architectures GOST publishes Linux releases for
+var gostGoArches = []string{"amd64", "386", "arm64", "arm", "mips", "mipsle", "mips64", "mips64le", "riscv64", "s390x"}
 
-	switch arch {
+// detectArch sets the system architecture and the matching GOST release
+// name. goarch is the Go architecture WTE runs as, which fixes the word
+// size and byte order; machine is the host's uname -m, which tells the ARM
+// variant. WTE may be built for an older ARM than the host runs.
+func detectArch(info *OSInfo, goarch, machine string) error {
+	switch goarch {
 	case "amd64":
 		info.Arch = "x86_64"
 		info.GOSTArch = "amd64"
+	case "386":
+		info.Arch = "i686"
+		info.GOSTArch = "386"
 	case "arm64":
 		info.Arch = "aarch64"
 		info.GOSTArch = "arm64"
 	case "arm":
-		info.Arch = "armv7l"
-		info.GOSTArch = "armv7"
+		version, err := armVersion(machine)
+		if err != nil {
+			return err
+		}
+		info.Arch = "armv" + version + "l"
+		info.GOSTArch = "armv" + version
+	case "mips", "mipsle":
+		// The host's FPU can't be told from uname, and the softfloat
+		// release runs with or without one
+		info.Arch = "mips"
+		info.GOSTArch = goarch + "_softfloat"
+	case "mips64", "mips64le":
+		info.Arch = "mips64"
+		info.GOSTArch = goarch + "_softfloat"
+	case "riscv64", "s390x":
+		info.Arch = goarch
+		info.GOSTArch = goarch
 	default:
-		return fmt.Errorf("unsupported architecture: %s", arch)
+		return fmt.Errorf("unsupported architecture: %s (GOST is released for %s)", goarch, strings.Join(gostGoArches, ", "))
 	}
 
 	return nil
 }
 
+// armVersion returns the ARM release GOST has for machine, such as 6 for
+// armv6l. 64-bit hosts running 32-bit WTE, and hosts the version can't be
+// read on, get armv7.
+func armVersion(machine string) (string, error) {
+	if !strings.HasPrefix(machine, "armv") {
+		return "7", nil
+	}
+
+	digits := strings.TrimPrefix(machine, "armv")
+	if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+		digits = digits[:end]
+	}
+	version, err := strconv.Atoi(digits)
+	if err != nil {
+		return "7", nil
+	}
+	switch {
+	case version < 5:
+		return "", fmt.Errorf("unsupported architecture: %s (GOST is released for armv5 and later)", machine)
+	case version > 7:
+		return "7", nil
+	}
+	return strconv.Itoa(version), nil
+}
+
+// hostMachine returns the host's machine hardware name as uname -m prints
+// it. Without uname, an ARM host's version is read from /proc/cpuinfo.
+func hostMachine() string {
+	if out, err := exec.Command("uname", "-m").Output(); err == nil {
+		if machine := strings.TrimSpace(string(out)); machine != "" {
+			return machine
+		}
+	}
+
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "CPU architecture" {
+			return "armv" + strings.TrimSpace(value) + "l"
+		}
+	}
+	return ""
+}
+
 // detectOSRelease reads /etc/os-release
 func detectOSRelease(info *OSInfo) error {
 	file, err := os.Open("/etc/os-release")
@@ -128,6 +203,14 @@ func detectRedHatRelease(info *OSInfo) error {
 	return nil
 }
 
+// detectGenericLinux is the last resort when no release file exists
+func detectGenericLinux(info *OSInfo) {
+	info.OS = "linux"
+	info.Version = "unknown"
+	info.PrettyName = "Linux (no /etc/os-release)"
+	info.Fallback = true
+}
+
 // setPackageManager determines the package manager based on OS
 func setPackageManager(info *OSInfo) {
 	switch info.OS {
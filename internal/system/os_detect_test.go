@@ -0,0 +1,52 @@
+package system
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectArch(t *testing.T) {
+	tests := []struct {
+		goarch   string
+		machine  string
+		arch     string
+		gostArch string
+		wantErr  string
+	}{
+		{goarch: "amd64", machine: "x86_64", arch: "x86_64", gostArch: "amd64"},
+		{goarch: "386", machine: "i686", arch: "i686", gostArch: "386"},
+		{goarch: "arm64", machine: "aarch64", arch: "aarch64", gostArch: "arm64"},
+		{goarch: "arm", machine: "armv7l", arch: "armv7l", gostArch: "armv7"},
+		{goarch: "arm", machine: "armv6l", arch: "armv6l", gostArch: "armv6"},
+		{goarch: "arm", machine: "armv5tel", arch: "armv5l", gostArch: "armv5"},
+		{goarch: "arm", machine: "armv8l", arch: "armv7l", gostArch: "armv7"},
+		{goarch: "arm", machine: "aarch64", arch: "armv7l", gostArch: "armv7"},
+		{goarch: "arm", machine: "", arch: "armv7l", gostArch: "armv7"},
+		{goarch: "arm", machine: "armv4l", wantErr: "armv5 and later"},
+		{goarch: "mips", machine: "mips", arch: "mips", gostArch: "mips_softfloat"},
+		{goarch: "mipsle", machine: "mips", arch: "mips", gostArch: "mipsle_softfloat"},
+		{goarch: "mips64le", machine: "mips64", arch: "mips64", gostArch: "mips64le_softfloat"},
+		{goarch: "riscv64", machine: "riscv64", arch: "riscv64", gostArch: "riscv64"},
+		{goarch: "s390x", machine: "s390x", arch: "s390x", gostArch: "s390x"},
+		{goarch: "ppc64le", machine: "ppc64le", wantErr: "GOST is released for amd64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goarch+"/"+tt.machine, func(t *testing.T) {
+			info := &OSInfo{}
+			err := detectArch(info, tt.goarch, tt.machine)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Arch != tt.arch || info.GOSTArch != tt.gostArch {
+				t.Errorf("got %s (%s), want %s (%s)", info.Arch, info.GOSTArch, tt.arch, tt.gostArch)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package system
+
+import "os/exec"
+
+// CommandRunner runs external commands. SystemdManager and FirewallManager
+// issue their systemctl, journalctl and firewall commands through one, so
+// tests can record the commands instead of running them.
+type CommandRunner interface {
+	// Run runs a command and waits for it to finish
+	Run(name string, args ...string) error
+	// Output runs a command and returns its standard output. As with
+	// exec.Cmd.Output, the standard error of a failed command is in the
+	// returned *exec.ExitError.
+	Output(name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands on the host
+type ExecRunner struct{}
+
+// Run runs a command and waits for it to finish
+func (ExecRunner) Run(name string, args ...string) error {
+	return exec.Command(name, args...).Run()
+}
+
+// Output runs a command and returns its standard output
+func (ExecRunner) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
@@ -0,0 +1,43 @@
+package system
+
+import "strings"
+
+// FakeRunner records commands instead of running them
+type FakeRunner struct {
+	// Commands are the command lines run so far, e.g. "systemctl start gost"
+	Commands []string
+	// Results are the outputs and errors of command lines; other commands
+	// succeed without output
+	Results map[string]FakeResult
+}
+
+// FakeResult is what a FakeRunner returns for a command line
+type FakeResult struct {
+	Output string
+	Err    error
+}
+
+// Run records a command and returns its error from Results
+func (f *FakeRunner) Run(name string, args ...string) error {
+	_, err := f.Output(name, args...)
+	return err
+}
+
+// Output records a command and returns its output and error from Results
+func (f *FakeRunner) Output(name string, args ...string) ([]byte, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.Commands = append(f.Commands, line)
+
+	result := f.Results[line]
+	return []byte(result.Output), result.Err
+}
+
+// ran reports whether a command line starting with prefix was run
+func (f *FakeRunner) ran(prefix string) bool {
+	for _, line := range f.Commands {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
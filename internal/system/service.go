@@ -0,0 +1,119 @@
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"wte/internal/config"
+)
+
+// ServiceManager manages the GOST service through the host's init system
+type ServiceManager interface {
+	// Create writes the service definition and makes the init system load it
+	Create(cfg *config.Config) error
+	// RestoreDefinition writes back a service definition read before Create
+	// and makes the init system load it. Nil data removes the definition.
+	RestoreDefinition(data []byte) error
+	// Remove stops, disables and deletes the service definition
+	Remove() error
+	// IsInstalled checks if the service definition exists
+	IsInstalled() bool
+	// DefinitionPath returns the path of the service definition
+	DefinitionPath() string
+	// ForeignDefinition returns the path of a gost service definition that
+	// WTE did not write, such as one set up by hand, or "" if there is none
+	ForeignDefinition() string
+
+	Enable() error
+	Disable() error
+	Start() error
+	Stop() error
+	Restart() error
+	// Reload makes GOST reread its configuration without dropping client
+	// connections
+	Reload() error
+	Status() (*ServiceStatus, error)
+	// StatusReport returns the init system's own description of the
+	// service, such as the output of systemctl status
+	StatusReport() (string, error)
+	// WaitForActive waits until a started service is running. It fails
+	// when the service failed or is still not running after timeout.
+	WaitForActive(timeout time.Duration) error
+
+	// Logs returns the last opts.Lines service log entries selected by opts
+	Logs(opts LogOptions) (string, error)
+	// FollowLogs returns a command streaming the log entries selected by
+	// opts to stdout, starting with the last opts.Lines entries
+	FollowLogs(opts LogOptions) *exec.Cmd
+	// PurgeLogs deletes the recorded service logs
+	PurgeLogs() error
+}
+
+// NewServiceManager returns the ServiceManager for the host's init system.
+// systemd is assumed unless the host runs OpenRC.
+func NewServiceManager() ServiceManager {
+	if !IsSystemd() && IsOpenRC() {
+		return NewOpenRCManager()
+	}
+	return NewSystemdManager()
+}
+
+// ServiceMarker is written into the service definitions WTE generates, to
+// tell them apart from a gost service set up by hand or by a package
+const ServiceMarker = "# wte-managed-service"
+
+// legacyServiceMarker is in the header of the service definitions written
+// before ServiceMarker was added
+const legacyServiceMarker = "# Managed by WTE"
+
+// isManagedDefinition reports whether the service definition at path was
+// written by WTE
+func isManagedDefinition(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte(ServiceMarker)) || bytes.Contains(data, []byte(legacyServiceMarker))
+}
+
+// serviceActivePoll is the interval at which WaitForActive checks the
+// service
+const serviceActivePoll = 250 * time.Millisecond
+
+// waitForActive polls state until it reports "active", fails on "failed"
+// and gives up after timeout. state returns the systemd name of the
+// service state.
+func waitForActive(timeout time.Duration, state func() string) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		current := state()
+		switch current {
+		case "active":
+			return nil
+		case "failed":
+			return fmt.Errorf("service failed to start")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service is still %s after %s", current, timeout)
+		}
+		time.Sleep(serviceActivePoll)
+	}
+}
+
+// IsOpenRC checks if the system uses OpenRC
+func IsOpenRC() bool {
+	if _, err := os.Stat("/run/openrc"); err == nil {
+		return true
+	}
+	_, err := exec.LookPath("openrc-run")
+	return err == nil
+}
+
+var (
+	_ ServiceManager = (*SystemdManager)(nil)
+	_ ServiceManager = (*OpenRCManager)(nil)
+)
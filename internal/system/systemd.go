@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"strings"
 	"text/template"
+	"time"
 
 	"wte/internal/config"
 )
@@ -16,10 +17,11 @@ const systemdServiceTemplate = `# ==============================================
 # ============================================================================
 # Managed by WTE
 # Do not edit manually - changes may be overwritten
+` + ServiceMarker + `
 # ============================================================================
 
 [Unit]
-Description=GOST Proxy Server (WTE)
+Description=GOST Proxy Server (WTE: {{.ServerName}})
 Documentation=https://gost.run/
 After=network.target network-online.target
 Wants=network-online.target
@@ -27,9 +29,30 @@ Wants=network-online.target
 [Service]
 Type=simple
 ExecStart={{.BinaryPath}} -C {{.ConfigFile}}
+ExecReload=/bin/kill -HUP $MAINPID
 Restart=always
 RestartSec=5
 LimitNOFILE=65535
+{{- if .User}}
+User={{.User}}
+Group={{.User}}
+{{- if .BindPrivileged}}
+
+# Listen on ports below 1024 without root
+AmbientCapabilities=CAP_NET_BIND_SERVICE
+CapabilityBoundingSet=CAP_NET_BIND_SERVICE
+{{- end}}
+{{- end}}
+{{- if or .MemoryMax .CPUQuota}}
+
+# Resource Limits
+{{- if .MemoryMax}}
+MemoryMax={{.MemoryMax}}
+{{- end}}
+{{- if .CPUQuota}}
+CPUQuota={{.CPUQuota}}
+{{- end}}
+{{- end}}
 
 # Security Hardening
 NoNewPrivileges=true
@@ -46,24 +69,106 @@ ProtectControlGroups=true
 WantedBy=multi-user.target
 `
 
-// ServiceStatus represents the status of a systemd service
+const lockoutServiceTemplate = `# ============================================================================
+# WTE Auth Lockout Watcher - Systemd Service Unit
+# ============================================================================
+# Managed by WTE
+# Do not edit manually - changes may be overwritten
+# ============================================================================
+
+[Unit]
+Description=WTE auth lockout watcher for GOST
+After=gost.service
+PartOf=gost.service
+
+[Service]
+Type=simple
+ExecStart={{.Executable}} lockout watch
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// LockoutServiceName is the systemd unit name of the auth lockout watcher
+const LockoutServiceName = "wte-lockout"
+
+// The watchdog restarts gost itself, so unlike the lockout watcher it is not
+// PartOf gost.service: a restart of gost would take the watchdog down with it.
+const watchdogServiceTemplate = `# ============================================================================
+# WTE Health Watchdog - Systemd Service Unit
+# ============================================================================
+# Managed by WTE
+# Do not edit manually - changes may be overwritten
+# ============================================================================
+
+[Unit]
+Description=WTE health watchdog for GOST
+After=gost.service
+
+[Service]
+Type=simple
+ExecStart={{.Executable}} watchdog
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// WatchdogServiceName is the systemd unit name of the health watchdog
+const WatchdogServiceName = "wte-watchdog"
+
+// ServiceStatus represents the status of the proxy service
 type ServiceStatus struct {
 	Name        string
 	IsActive    bool
 	IsEnabled   bool
 	MainPID     string
 	MemoryUsage string
+	MemoryBytes int64
 	ActiveState string
 	SubState    string
 	LoadState   string
 }
 
 // SystemdManager manages systemd services
-type SystemdManager struct{}
+type SystemdManager struct {
+	runner CommandRunner
+}
 
 // NewSystemdManager creates a new SystemdManager
 func NewSystemdManager() *SystemdManager {
-	return &SystemdManager{}
+	return NewSystemdManagerWithRunner(ExecRunner{})
+}
+
+// NewSystemdManagerWithRunner creates a SystemdManager that runs systemctl
+// and journalctl through runner. Following the logs and filtering them
+// still run journalctl directly, they stream its output.
+func NewSystemdManagerWithRunner(runner CommandRunner) *SystemdManager {
+	return &SystemdManager{runner: runner}
+}
+
+// Create creates the systemd service file and reloads the daemon
+func (m *SystemdManager) Create(cfg *config.Config) error {
+	if err := m.CreateService(cfg); err != nil {
+		return err
+	}
+	return m.DaemonReload()
+}
+
+// RestoreDefinition writes back a unit file read before Create, or
+// removes the unit if data is nil, and reloads systemd
+func (m *SystemdManager) RestoreDefinition(data []byte) error {
+	if data == nil {
+		if err := os.Remove(config.SystemdServiceFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove service file: %w", err)
+		}
+	} else if err := os.WriteFile(config.SystemdServiceFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write service file: %w", err)
+	}
+	return m.DaemonReload()
 }
 
 // CreateService creates the systemd service file
@@ -74,13 +179,25 @@ func (m *SystemdManager) CreateService(cfg *config.Config) error {
 	}
 
 	data := struct {
-		BinaryPath string
-		ConfigFile string
-		ConfigDir  string
+		ServerName     string
+		BinaryPath     string
+		ConfigFile     string
+		ConfigDir      string
+		User           string
+		BindPrivileged bool
+		MemoryMax      string
+		CPUQuota       string
 	}{
-		BinaryPath: cfg.GOST.BinaryPath,
-		ConfigFile: cfg.GOST.ConfigFile,
-		ConfigDir:  cfg.GOST.ConfigDir,
+		ServerName:     cfg.ServerName(),
+		BinaryPath:     cfg.GOST.BinaryPath,
+		ConfigFile:     cfg.GOST.ConfigFile,
+		ConfigDir:      cfg.GOST.ConfigDir,
+		BindPrivileged: cfg.NeedsPrivilegedPorts(),
+		MemoryMax:      cfg.GOST.MemoryMax,
+		CPUQuota:       cfg.GOST.CPUQuota,
+	}
+	if !cfg.GOST.RunsAsRoot() {
+		data.User = cfg.GOST.RunAsUser
 	}
 
 	var buf bytes.Buffer
@@ -95,6 +212,86 @@ func (m *SystemdManager) CreateService(cfg *config.Config) error {
 	return nil
 }
 
+// CreateLockoutService creates the systemd unit running the auth lockout watcher
+func (m *SystemdManager) CreateLockoutService(executable string) error {
+	return m.createHelperService(LockoutServiceName, config.LockoutServiceFile, lockoutServiceTemplate, executable)
+}
+
+// RemoveLockoutService stops and removes the auth lockout watcher unit
+func (m *SystemdManager) RemoveLockoutService() error {
+	return m.removeHelperService(LockoutServiceName, config.LockoutServiceFile)
+}
+
+// IsLockoutInstalled checks if the auth lockout watcher unit is installed
+func (m *SystemdManager) IsLockoutInstalled() bool {
+	return FileExists(config.LockoutServiceFile)
+}
+
+// CreateWatchdogService creates the systemd unit running the health watchdog
+func (m *SystemdManager) CreateWatchdogService(executable string) error {
+	return m.createHelperService(WatchdogServiceName, config.WatchdogServiceFile, watchdogServiceTemplate, executable)
+}
+
+// RemoveWatchdogService stops and removes the health watchdog unit
+func (m *SystemdManager) RemoveWatchdogService() error {
+	return m.removeHelperService(WatchdogServiceName, config.WatchdogServiceFile)
+}
+
+// IsWatchdogInstalled checks if the health watchdog unit is installed
+func (m *SystemdManager) IsWatchdogInstalled() bool {
+	return FileExists(config.WatchdogServiceFile)
+}
+
+// createHelperService writes the unit of a WTE service running executable
+// at path, enables it and (re)starts it so it runs with the current
+// configuration
+func (m *SystemdManager) createHelperService(name, path, text, executable string) error {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s service template: %w", name, err)
+	}
+
+	data := struct {
+		Executable string
+	}{
+		Executable: executable,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to execute %s service template: %w", name, err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s service file: %w", name, err)
+	}
+
+	if err := m.DaemonReload(); err != nil {
+		return err
+	}
+
+	if err := m.runSystemctl("enable", name); err != nil {
+		return err
+	}
+
+	return m.runSystemctl("restart", name)
+}
+
+// removeHelperService stops and removes the unit of a WTE service
+func (m *SystemdManager) removeHelperService(name, path string) error {
+	if !FileExists(path) {
+		return nil
+	}
+
+	_ = m.runSystemctl("disable", "--now", name)
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s service file: %w", name, err)
+	}
+
+	return m.DaemonReload()
+}
+
 // DaemonReload reloads the systemd daemon
 func (m *SystemdManager) DaemonReload() error {
 	return m.runSystemctl("daemon-reload")
@@ -125,7 +322,7 @@ func (m *SystemdManager) Restart() error {
 	return m.runSystemctl("restart", "gost")
 }
 
-// Reload reloads the service configuration
+// Reload makes GOST reread its configuration, see ExecReload
 func (m *SystemdManager) Reload() error {
 	return m.runSystemctl("reload", "gost")
 }
@@ -169,6 +366,7 @@ func (m *SystemdManager) Status() (*ServiceStatus, error) {
 					// Convert bytes to MB
 					var bytes int64
 					_, _ = fmt.Sscanf(parts[1], "%d", &bytes)
+					status.MemoryBytes = bytes
 					status.MemoryUsage = fmt.Sprintf("%dMB", bytes/1024/1024)
 				}
 			}
@@ -178,11 +376,65 @@ func (m *SystemdManager) Status() (*ServiceStatus, error) {
 	return status, nil
 }
 
+// WaitForActive polls systemctl is-active until the service is active. A
+// service that is still binding its ports reports "activating".
+func (m *SystemdManager) WaitForActive(timeout time.Duration) error {
+	return waitForActive(timeout, func() string {
+		// is-active exits non-zero for every state but active, and still
+		// prints the state
+		output, _ := m.runner.Output("systemctl", "is-active", "gost")
+		if state := strings.TrimSpace(string(output)); state != "" {
+			return state
+		}
+		return "unknown"
+	})
+}
+
+// StatusReport returns the output of systemctl status. systemctl exits
+// with an error for a stopped service, so output is returned regardless.
+func (m *SystemdManager) StatusReport() (string, error) {
+	output, err := m.runner.Output("systemctl", "status", "gost", "--no-pager", "--full")
+	if len(output) > 0 {
+		return string(output), nil
+	}
+	return "", err
+}
+
 // IsInstalled checks if the service is installed
 func (m *SystemdManager) IsInstalled() bool {
 	return FileExists(config.SystemdServiceFile)
 }
 
+// DefinitionPath returns the path of the unit file
+func (m *SystemdManager) DefinitionPath() string {
+	return config.SystemdServiceFile
+}
+
+// systemdVendorServiceFiles are where packages install gost.service. A
+// unit in /etc/systemd/system takes precedence over them.
+var systemdVendorServiceFiles = []string{
+	"/usr/lib/systemd/system/gost.service",
+	"/lib/systemd/system/gost.service",
+}
+
+// ForeignDefinition returns the path of a gost.service unit WTE did not
+// write. A packaged unit overridden by WTE's own is not reported.
+func (m *SystemdManager) ForeignDefinition() string {
+	if FileExists(config.SystemdServiceFile) {
+		if isManagedDefinition(config.SystemdServiceFile) {
+			return ""
+		}
+		return config.SystemdServiceFile
+	}
+
+	for _, path := range systemdVendorServiceFiles {
+		if FileExists(path) && !isManagedDefinition(path) {
+			return path
+		}
+	}
+	return ""
+}
+
 // Remove removes the service file
 func (m *SystemdManager) Remove() error {
 	if !m.IsInstalled() {
@@ -202,30 +454,26 @@ func (m *SystemdManager) Remove() error {
 	return m.DaemonReload()
 }
 
-// GetLogs returns recent service logs
-func (m *SystemdManager) GetLogs(lines int) (string, error) {
-	args := []string{"-u", "gost", "-n", fmt.Sprintf("%d", lines), "--no-pager"}
-	return m.getJournalctlOutput(args...)
-}
-
-// FollowLogs starts following logs and returns a command that can be waited on
-func (m *SystemdManager) FollowLogs() *exec.Cmd {
-	cmd := exec.Command("journalctl", "-u", "gost", "-f", "--no-pager")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd
+// logUnits returns the journalctl unit arguments for WTE-managed services
+func (m *SystemdManager) logUnits() []string {
+	units := []string{"-u", "gost"}
+	if m.IsLockoutInstalled() {
+		units = append(units, "-u", LockoutServiceName)
+	}
+	if m.IsWatchdogInstalled() {
+		units = append(units, "-u", WatchdogServiceName)
+	}
+	return units
 }
 
 // runSystemctl runs a systemctl command
 func (m *SystemdManager) runSystemctl(args ...string) error {
-	cmd := exec.Command("systemctl", args...)
-	return cmd.Run()
+	return m.runner.Run("systemctl", args...)
 }
 
 // getSystemctlOutput runs a systemctl command and returns output
 func (m *SystemdManager) getSystemctlOutput(args ...string) (string, error) {
-	cmd := exec.Command("systemctl", args...)
-	output, err := cmd.Output()
+	output, err := m.runner.Output("systemctl", args...)
 	if err != nil {
 		return "", err
 	}
@@ -234,9 +482,11 @@ func (m *SystemdManager) getSystemctlOutput(args ...string) (string, error) {
 
 // getJournalctlOutput runs a journalctl command and returns output
 func (m *SystemdManager) getJournalctlOutput(args ...string) (string, error) {
-	cmd := exec.Command("journalctl", args...)
-	output, err := cmd.Output()
+	output, err := m.runner.Output("journalctl", args...)
 	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", journalctlError(err, exitErr.Stderr)
+		}
 		return "", err
 	}
 	return string(output), nil
@@ -0,0 +1,65 @@
+package system
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSystemdStatus(t *testing.T) {
+	runner := &FakeRunner{Results: map[string]FakeResult{
+		"systemctl is-enabled --quiet gost": {Err: errors.New("exit status 1")},
+		"systemctl show gost --property=ActiveState,SubState,LoadState,MainPID,MemoryCurrent": {
+			Output: "ActiveState=active\nSubState=running\nLoadState=loaded\nMainPID=1234\nMemoryCurrent=10485760\n",
+		},
+	}}
+
+	status, err := NewSystemdManagerWithRunner(runner).Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	if !status.IsActive || status.IsEnabled {
+		t.Errorf("IsActive = %v, IsEnabled = %v; want true, false", status.IsActive, status.IsEnabled)
+	}
+	if status.SubState != "running" || status.MainPID != "1234" {
+		t.Errorf("SubState = %q, MainPID = %q", status.SubState, status.MainPID)
+	}
+	if status.MemoryUsage != "10MB" {
+		t.Errorf("MemoryUsage = %q, want 10MB", status.MemoryUsage)
+	}
+}
+
+func TestSystemdServiceCommands(t *testing.T) {
+	runner := &FakeRunner{}
+	manager := NewSystemdManagerWithRunner(runner)
+
+	for _, action := range []func() error{manager.Start, manager.Reload, manager.Restart, manager.Stop} {
+		if err := action(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"systemctl start gost", "systemctl reload gost", "systemctl restart gost", "systemctl stop gost"}
+	if len(runner.Commands) != len(want) {
+		t.Fatalf("commands = %q, want %q", runner.Commands, want)
+	}
+	for i := range want {
+		if runner.Commands[i] != want[i] {
+			t.Errorf("command %d = %q, want %q", i, runner.Commands[i], want[i])
+		}
+	}
+}
+
+func TestSystemdPurgeLogsDoesNotRotate(t *testing.T) {
+	runner := &FakeRunner{}
+	if err := NewSystemdManagerWithRunner(runner).PurgeLogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if runner.ran("journalctl --rotate") {
+		t.Error("PurgeLogs rotated the journal")
+	}
+	if !runner.ran("journalctl --vacuum-time=1s") {
+		t.Errorf("PurgeLogs did not vacuum the journal, ran %q", runner.Commands)
+	}
+}
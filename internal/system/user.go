@@ -0,0 +1,73 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// EnsureServiceUser creates a system user and group called name for
+// running a service, unless the user already exists
+func EnsureServiceUser(name string) error {
+	if _, err := user.Lookup(name); err == nil {
+		return nil
+	}
+
+	shell := "/usr/sbin/nologin"
+	if !FileExists(shell) {
+		shell = "/sbin/nologin"
+	}
+
+	var err error
+	switch {
+	case commandExists("useradd"):
+		err = exec.Command("useradd", "--system", "--user-group", "--no-create-home",
+			"--home-dir", "/nonexistent", "--shell", shell, name).Run()
+	case commandExists("adduser"):
+		// BusyBox (Alpine)
+		_ = exec.Command("addgroup", "-S", name).Run()
+		err = exec.Command("adduser", "-S", "-D", "-H", "-h", "/nonexistent",
+			"-s", shell, "-G", name, name).Run()
+	default:
+		return fmt.Errorf("failed to create user %s: neither useradd nor adduser is available", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// ChownToUser gives path, and everything below it if it is a directory,
+// to the user and its primary group
+func ChownToUser(path, name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid for %s: %w", name, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid for %s: %w", name, err)
+	}
+
+	return filepath.Walk(path, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
+
+// commandExists checks if a command is on the PATH
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
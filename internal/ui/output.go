@@ -1,10 +1,16 @@
 package ui
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/fatih/color"
+	"golang.org/x/term"
+
+	"wte/internal/logging"
 )
 
 // Colors
@@ -40,6 +46,13 @@ var Quiet = false
 // Verbose mode enables additional output
 var Verbose = false
 
+// JSON mode makes commands that support it print a JSON document instead of
+// decorated output
+var JSON = false
+
+// AssumeYes answers every confirmation with yes, for unattended runs
+var AssumeYes = false
+
 // SetNoColor sets color mode
 func SetNoColor(noColor bool) {
 	NoColor = noColor
@@ -56,6 +69,34 @@ func SetVerbose(verbose bool) {
 	Verbose = verbose
 }
 
+// SetJSON sets JSON mode. It implies quiet mode so that only the JSON
+// document is written to stdout.
+func SetJSON(enabled bool) {
+	JSON = enabled
+	if enabled {
+		Quiet = true
+	}
+}
+
+// PrintJSON writes v to stdout as indented JSON
+func PrintJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// logMessage copies a message to the structured log, whether or not it is
+// shown
+func logMessage(level slog.Level, kind, format string, args ...interface{}) {
+	if !logging.Enabled() {
+		return
+	}
+	logging.Logger.Log(context.Background(), level, fmt.Sprintf(format, args...), "ui", kind)
+}
+
 // Print outputs a message
 func Print(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
@@ -73,6 +114,7 @@ func Printf(format string, args ...interface{}) {
 
 // Success prints a success message
 func Success(format string, args ...interface{}) {
+	logMessage(slog.LevelInfo, "success", format, args...)
 	if Quiet {
 		return
 	}
@@ -82,12 +124,14 @@ func Success(format string, args ...interface{}) {
 
 // Error prints an error message
 func Error(format string, args ...interface{}) {
-	Red.Printf("  %s  ", SymbolFailed)
+	logMessage(slog.LevelError, "error", format, args...)
+	Red.Fprintf(os.Stderr, "  %s  ", SymbolFailed)
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 }
 
 // Warning prints a warning message
 func Warning(format string, args ...interface{}) {
+	logMessage(slog.LevelWarn, "warning", format, args...)
 	if Quiet {
 		return
 	}
@@ -97,6 +141,7 @@ func Warning(format string, args ...interface{}) {
 
 // Info prints an info message
 func Info(format string, args ...interface{}) {
+	logMessage(slog.LevelInfo, "info", format, args...)
 	if Quiet {
 		return
 	}
@@ -106,6 +151,7 @@ func Info(format string, args ...interface{}) {
 
 // Action prints an action message
 func Action(format string, args ...interface{}) {
+	logMessage(slog.LevelInfo, "action", format, args...)
 	if Quiet {
 		return
 	}
@@ -115,6 +161,7 @@ func Action(format string, args ...interface{}) {
 
 // Detail prints a detail message (indented)
 func Detail(format string, args ...interface{}) {
+	logMessage(slog.LevelInfo, "detail", format, args...)
 	if Quiet {
 		return
 	}
@@ -124,7 +171,8 @@ func Detail(format string, args ...interface{}) {
 
 // Debug prints a debug message (only in verbose mode)
 func Debug(format string, args ...interface{}) {
-	if !Verbose {
+	logMessage(slog.LevelDebug, "debug", format, args...)
+	if !Verbose || JSON {
 		return
 	}
 	Magenta.Printf("  [DEBUG] ")
@@ -133,6 +181,7 @@ func Debug(format string, args ...interface{}) {
 
 // Header prints a section header
 func Header(title string) {
+	logMessage(slog.LevelInfo, "header", "%s", title)
 	if Quiet {
 		return
 	}
@@ -144,6 +193,7 @@ func Header(title string) {
 
 // Step prints a step indicator with progress
 func Step(current, total int, title string) {
+	logMessage(slog.LevelInfo, "step", "step %d/%d: %s", current, total, title)
 	if Quiet {
 		return
 	}
@@ -275,8 +325,36 @@ func FatalErr(err error) {
 
 // Confirm asks for user confirmation
 func Confirm(prompt string) bool {
+	if AssumeYes {
+		fmt.Printf("%s [y/N]: y\n", prompt)
+		return true
+	}
 	fmt.Printf("%s [y/N]: ", prompt)
 	var response string
 	_, _ = fmt.Scanln(&response)
 	return response == "y" || response == "Y" || response == "yes" || response == "Yes"
 }
+
+// IsTerminal reports whether stdout is a terminal rather than a pipe or a
+// file
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// PromptSecret asks for a secret without echoing it. The prompt goes to
+// stderr so it does not end up in redirected output.
+func PromptSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("cannot prompt for a secret, stdin is not a terminal")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	return string(secret), nil
+}
@@ -0,0 +1,115 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/term"
+)
+
+// ANSI sequences for rendering QR modules as black on white
+const (
+	qrBlackOnWhite = "\033[30;107m"
+	qrWhiteOnBlack = "\033[97;40m"
+	qrWhiteOnWhite = "\033[97;107m"
+	qrBlackOnBlack = "\033[30;40m"
+	qrReset        = "\033[0m"
+)
+
+// GenerateQRCode renders content as a QR code for the terminal. Two module
+// rows are packed into each line using half-block characters, with explicit
+// colors so the code scans on both dark and light terminal themes.
+func GenerateQRCode(content string) (string, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate QR code: %w", err)
+	}
+
+	bitmap := qr.Bitmap()
+	var b strings.Builder
+
+	for y := 0; y < len(bitmap); y += 2 {
+		last := ""
+		for x := 0; x < len(bitmap[y]); x++ {
+			top := bitmap[y][x]
+			bottom := false
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+
+			var style string
+			switch {
+			case top && bottom:
+				style = qrBlackOnBlack
+			case top && !bottom:
+				style = qrBlackOnWhite
+			case !top && bottom:
+				style = qrWhiteOnBlack
+			default:
+				style = qrWhiteOnWhite
+			}
+
+			if style != last {
+				b.WriteString(style)
+				last = style
+			}
+			b.WriteString("▀")
+		}
+		b.WriteString(qrReset + "\n")
+	}
+
+	return b.String(), nil
+}
+
+// QRCodeWidth returns the number of terminal columns a QR code for content needs
+func QRCodeWidth(content string) (int, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate QR code: %w", err)
+	}
+	return len(qr.Bitmap()), nil
+}
+
+// PrintQRCode prints a labelled QR code followed by its content. It falls
+// back to printing only the content when colors are disabled, in quiet mode,
+// or when the terminal is too narrow to display the code.
+func PrintQRCode(label, content string) {
+	if Quiet {
+		fmt.Println(content)
+		return
+	}
+
+	fmt.Println()
+	White.Println(label)
+
+	if NoColor {
+		Detail("QR code disabled with --no-color")
+		fmt.Println(content)
+		return
+	}
+
+	width, err := QRCodeWidth(content)
+	if err != nil {
+		Warning("%v", err)
+		fmt.Println(content)
+		return
+	}
+
+	if cols, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && cols < width {
+		Detail("Terminal too narrow for QR code (%d columns needed, %d available)", width, cols)
+		fmt.Println(content)
+		return
+	}
+
+	code, err := GenerateQRCode(content)
+	if err != nil {
+		Warning("%v", err)
+		fmt.Println(content)
+		return
+	}
+
+	fmt.Print(code)
+	fmt.Println(content)
+}
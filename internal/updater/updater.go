@@ -3,16 +3,22 @@ package updater
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
+	"wte/internal/httputil"
+	"wte/internal/security"
 	"wte/internal/ui"
 )
 
@@ -25,8 +31,24 @@ const (
 
 	// ReleasesURL is the URL for releases
 	ReleasesURL = GitHubAPIURL + "/repos/" + GitHubRepo + "/releases"
+
+	// GitHubURL is the GitHub web base URL, used when the API is unavailable
+	GitHubURL = "https://github.com"
+)
+
+const (
+	// apiAttempts is how often a GitHub API request failing with a network
+	// or server error is tried
+	apiAttempts = 3
+
+	// apiBackoff is the wait before the first retry, doubled for every
+	// further one
+	apiBackoff = 2 * time.Second
 )
 
+// errNoReleases is returned when the repository has no releases
+var errNoReleases = errors.New("no releases found")
+
 // Release represents a GitHub release
 type Release struct {
 	TagName     string    `json:"tag_name"`
@@ -59,9 +81,10 @@ func NewUpdater(currentVersion string) *Updater {
 	return &Updater{
 		currentVersion: currentVersion,
 		repoURL:        GitHubRepo,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		httpClient: httputil.Client(httputil.Options{
+			Timeout:   30 * time.Second,
+			UserAgent: "wte-updater",
+		}),
 	}
 }
 
@@ -70,38 +93,124 @@ func (u *Updater) SetRepoURL(repo string) {
 	u.repoURL = repo
 }
 
-// GetLatestRelease fetches the latest release from GitHub
+// GetLatestRelease fetches the latest release from the GitHub API. Network
+// and server errors are retried with backoff. When the API stays
+// unavailable or is rate limited, the latest release is looked up on the
+// GitHub release page instead, which has no rate limit.
 func (u *Updater) GetLatestRelease() (*Release, error) {
+	release, err := u.fetchLatestRelease()
+	if err == nil || errors.Is(err, errNoReleases) {
+		return release, err
+	}
+
+	fallback, fallbackErr := u.latestReleaseFromPage()
+	if fallbackErr != nil {
+		return nil, err
+	}
+
+	ui.Warning("%v, using the GitHub release page instead", err)
+	return fallback, nil
+}
+
+// fetchLatestRelease fetches the latest release from the GitHub API
+func (u *Updater) fetchLatestRelease() (*Release, error) {
 	url := fmt.Sprintf("%s/repos/%s/releases/latest", GitHubAPIURL, u.repoURL)
 
+	backoff := apiBackoff
+	var lastErr error
+	for attempt := 1; attempt <= apiAttempts; attempt++ {
+		if attempt > 1 {
+			ui.Debug("Retrying in %s: %v", backoff, lastErr)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		release, retry, err := u.getRelease(url)
+		if err == nil {
+			return release, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("%w (gave up after %d attempts)", lastErr, apiAttempts)
+}
+
+// getRelease makes one GitHub API request for a release and reports
+// whether a failed request is worth retrying
+func (u *Updater) getRelease(url string) (*Release, bool, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "wte-updater")
+	httputil.SetGitHubHeaders(req)
 
 	resp, err := u.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch release: %w", err)
+		return nil, true, fmt.Errorf("failed to fetch release: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("no releases found")
+		return nil, false, fmt.Errorf("%w for %s", errNoReleases, u.repoURL)
+	}
+
+	if httputil.IsGitHubRateLimited(resp) {
+		return nil, false, httputil.GitHubRateLimitError(resp)
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, true, fmt.Errorf("GitHub API error: %s", resp.Status)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API error: %s", resp.Status)
+		return nil, false, fmt.Errorf("GitHub API error: %s", resp.Status)
 	}
 
 	var release Release
 	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return nil, fmt.Errorf("failed to parse release: %w", err)
+		return nil, false, fmt.Errorf("failed to parse release: %w", err)
+	}
+
+	return &release, false, nil
+}
+
+// latestReleaseFromPage finds the latest release through the redirect of
+// the GitHub "releases/latest" page. The page lists neither the release
+// notes nor the assets, so the release carries the download URLs of the
+// platform archive and the checksums, as goreleaser names them.
+func (u *Updater) latestReleaseFromPage() (*Release, error) {
+	client := *u.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s/%s/releases/latest", GitHubURL, u.repoURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch release page: %w", err)
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.Contains(location, "/releases/tag/") {
+		return nil, fmt.Errorf("release page did not redirect to a release: %s", resp.Status)
 	}
 
-	return &release, nil
+	tag := path.Base(location)
+	download := fmt.Sprintf("%s/%s/releases/download/%s/", GitHubURL, u.repoURL, tag)
+	archive := fmt.Sprintf("wte-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+
+	return &Release{
+		TagName: tag,
+		HTMLURL: location,
+		Assets: []Asset{
+			{Name: archive, BrowserDownloadURL: download + archive, Size: -1},
+			{Name: security.ChecksumsFile, BrowserDownloadURL: download + security.ChecksumsFile, Size: -1},
+		},
+	}, nil
 }
 
 // CheckForUpdate checks if an update is available
@@ -111,11 +220,7 @@ func (u *Updater) CheckForUpdate() (*Release, bool, error) {
 		return nil, false, err
 	}
 
-	latestVersion := strings.TrimPrefix(release.TagName, "v")
-	currentVersion := strings.TrimPrefix(u.currentVersion, "v")
-
-	// Simple version comparison (works for semver)
-	hasUpdate := latestVersion != currentVersion && latestVersion > currentVersion
+	hasUpdate := compareVersions(release.TagName, u.currentVersion) > 0
 
 	return release, hasUpdate, nil
 }
@@ -160,8 +265,12 @@ func (u *Updater) DownloadAsset(asset *Asset, destPath string) error {
 		return fmt.Errorf("download failed: %s", resp.Status)
 	}
 
-	// Create progress bar
-	bar := ui.DownloadProgressBar(asset.Size, asset.Name)
+	// Create progress bar, the size is unknown for releases from the page
+	size := asset.Size
+	if size <= 0 {
+		size = resp.ContentLength
+	}
+	bar := ui.DownloadProgressBar(size, asset.Name)
 
 	out, err := os.Create(destPath)
 	if err != nil {
@@ -175,6 +284,54 @@ func (u *Updater) DownloadAsset(asset *Asset, destPath string) error {
 	return err
 }
 
+// checksumFor returns the SHA-256 digest of asset published with the
+// release, from checksums.txt or <asset>.sha256. It returns an empty string
+// if the release publishes neither.
+func (u *Updater) checksumFor(release *Release, asset *Asset) (string, error) {
+	for _, candidate := range release.Assets {
+		switch candidate.Name {
+		case asset.Name + ".sha256":
+			data, err := u.fetchSmallAsset(&candidate)
+			if err != nil {
+				return "", err
+			}
+			fields := strings.Fields(string(data))
+			if len(fields) == 0 {
+				return "", fmt.Errorf("%s is empty", candidate.Name)
+			}
+			return fields[0], nil
+
+		case security.ChecksumsFile:
+			data, err := u.fetchSmallAsset(&candidate)
+			if err != nil {
+				return "", err
+			}
+			sum, ok := security.ParseChecksums(data)[asset.Name]
+			if !ok {
+				return "", fmt.Errorf("%s does not list %s", candidate.Name, asset.Name)
+			}
+			return sum, nil
+		}
+	}
+
+	return "", nil
+}
+
+// fetchSmallAsset downloads a small release asset such as a checksum file
+func (u *Updater) fetchSmallAsset(asset *Asset) ([]byte, error) {
+	resp, err := u.httpClient.Get(asset.BrowserDownloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", asset.Name, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
 // Update performs the self-update
 func (u *Updater) Update(release *Release) error {
 	asset, err := u.GetAssetForPlatform(release)
@@ -200,6 +357,19 @@ func (u *Updater) Update(release *Release) error {
 
 	ui.Success("Download completed")
 
+	expected, err := u.checksumFor(release, asset)
+	if err != nil {
+		return err
+	}
+	if expected == "" {
+		ui.Warning("Release %s publishes no checksums, skipping verification", release.TagName)
+	} else {
+		if err := security.VerifyChecksum(downloadPath, expected); err != nil {
+			return fmt.Errorf("download is corrupt or was tampered with: %w", err)
+		}
+		ui.Success("Checksum verified")
+	}
+
 	// Extract if it's a tarball
 	var binaryPath string
 	if strings.HasSuffix(asset.Name, ".tar.gz") || strings.HasSuffix(asset.Name, ".tgz") {
@@ -248,6 +418,16 @@ func (u *Updater) Update(release *Release) error {
 		return fmt.Errorf("failed to set permissions: %w", err)
 	}
 
+	// Make sure the new binary runs before dropping the backup
+	ui.Action("Verifying new version...")
+	if err := u.verifyBinary(execPath, release.TagName); err != nil {
+		_ = os.Remove(execPath)
+		if restoreErr := os.Rename(backupPath, execPath); restoreErr != nil {
+			return fmt.Errorf("%v, and restoring the previous binary from %s failed: %w", err, backupPath, restoreErr)
+		}
+		return fmt.Errorf("%w, previous version restored", err)
+	}
+
 	// Remove backup
 	_ = os.Remove(backupPath)
 
@@ -256,6 +436,30 @@ func (u *Updater) Update(release *Release) error {
 	return nil
 }
 
+// verifyBinary runs the version command of the binary at path and checks
+// that it reports version
+func (u *Updater) verifyBinary(path, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "version", "--no-color").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new binary failed to run: %w", err)
+	}
+
+	// Output is "WTE v<version>"
+	fields := strings.Fields(string(output))
+	if len(fields) < 2 || fields[0] != "WTE" {
+		return fmt.Errorf("new binary printed unexpected version output: %q", strings.TrimSpace(string(output)))
+	}
+
+	if compareVersions(fields[1], version) != 0 {
+		return fmt.Errorf("new binary reports version %s, expected %s", fields[1], version)
+	}
+
+	return nil
+}
+
 // extractTarGz extracts a tar.gz archive and returns the path to the binary
 func (u *Updater) extractTarGz(archive, dest string) (string, error) {
 	file, err := os.Open(archive)
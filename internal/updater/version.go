@@ -0,0 +1,124 @@
+package updater
+
+import (
+	"strconv"
+	"strings"
+)
+
+// compareVersions compares two semantic versions and returns -1, 0 or 1.
+// A leading "v" and build metadata are ignored. A prerelease sorts before
+// its release, and prerelease identifiers compare their numeric parts as
+// numbers, so 3.0.0-rc9 < 3.0.0-rc10 < 3.0.0.
+func compareVersions(a, b string) int {
+	aCore, aPre := splitVersion(a)
+	bCore, bPre := splitVersion(b)
+
+	for i := 0; i < 3; i++ {
+		if c := compareInts(aCore[i], bCore[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case aPre == "" && bPre == "":
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+
+	aIDs := strings.Split(aPre, ".")
+	bIDs := strings.Split(bPre, ".")
+	for i := 0; i < len(aIDs) && i < len(bIDs); i++ {
+		if c := comparePrereleaseID(aIDs[i], bIDs[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A longer prerelease with an equal prefix sorts later
+	return compareInts(len(aIDs), len(bIDs))
+}
+
+// splitVersion returns the major, minor and patch numbers and the
+// prerelease of a version. Missing or malformed numbers count as 0.
+func splitVersion(version string) ([3]int, string) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+
+	prerelease := ""
+	if i := strings.Index(version, "-"); i >= 0 {
+		prerelease = version[i+1:]
+		version = version[:i]
+	}
+
+	var core [3]int
+	for i, part := range strings.SplitN(version, ".", 3) {
+		core[i], _ = strconv.Atoi(part)
+	}
+
+	return core, prerelease
+}
+
+// comparePrereleaseID compares a single prerelease identifier. Identifiers
+// are split into a text prefix and a numeric suffix ("rc10" is "rc" and 10)
+// so that the numbers compare by value.
+func comparePrereleaseID(a, b string) int {
+	aText, aNum, aHasNum := splitIdentifier(a)
+	bText, bNum, bHasNum := splitIdentifier(b)
+
+	// Purely numeric identifiers sort before alphanumeric ones
+	if aText == "" && bText != "" {
+		return -1
+	}
+	if aText != "" && bText == "" {
+		return 1
+	}
+
+	if c := strings.Compare(aText, bText); c != 0 {
+		return c
+	}
+
+	switch {
+	case aHasNum && bHasNum:
+		return compareInts(aNum, bNum)
+	case aHasNum:
+		return 1
+	case bHasNum:
+		return -1
+	}
+	return 0
+}
+
+// splitIdentifier splits a prerelease identifier into its text prefix and
+// trailing number
+func splitIdentifier(id string) (string, int, bool) {
+	i := len(id)
+	for i > 0 && id[i-1] >= '0' && id[i-1] <= '9' {
+		i--
+	}
+
+	if i == len(id) {
+		return id, 0, false
+	}
+
+	num, err := strconv.Atoi(id[i:])
+	if err != nil {
+		return id, 0, false
+	}
+	return id[:i], num, true
+}
+
+// compareInts returns -1, 0 or 1
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
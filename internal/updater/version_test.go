@@ -0,0 +1,34 @@
+package updater
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.10.0", "0.9.0", 1},
+		{"1.2.3", "1.2.4", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"3.0.0-rc9", "3.0.0-rc10", -1},
+		{"3.0.0-rc10", "3.0.0", -1},
+		{"3.0.0-rc9", "3.0.0", -1},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"1.2.3-rc1+linux", "1.2.3-rc1+darwin", 0},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		// The order must not depend on the argument order
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}